@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcopeereboom/toyz80/asm"
+	"github.com/marcopeereboom/toyz80/z80"
+)
+
+// asmMain assembles a source file; it is invoked as toyz80 asm.
+func asmMain(args []string) error {
+	fs := flag.NewFlagSet("asm", flag.ExitOnError)
+	var (
+		cpuFlag = fs.String("cpu", "z80", "cpu {z80|8080}")
+		outFlag = fs.String("o", "", "output file, Intel HEX if it "+
+			"ends in .hex or .ihx (default source with .bin)")
+		listFlag = fs.String("l", "", "listing file")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v asm [flags] source\n",
+			os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("no source file")
+	}
+	source := fs.Arg(0)
+
+	mode, err := z80.ParseCPUMode(*cpuFlag)
+	if err != nil {
+		return err
+	}
+	p, err := asm.Assemble(source, mode)
+	if err != nil {
+		return err
+	}
+
+	out := *outFlag
+	if out == "" {
+		out = strings.TrimSuffix(source, filepath.Ext(source)) + ".bin"
+	}
+	switch strings.ToLower(filepath.Ext(out)) {
+	case ".hex", ".ihx":
+		err = writeFile(out, p.WriteIntelHex)
+	default:
+		_, image := p.Binary()
+		err = ioutil.WriteFile(out, image, 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *listFlag != "" {
+		return writeFile(*listFlag, p.WriteListing)
+	}
+	return nil
+}
+
+// writeFile creates filename and fills it with write.
+func writeFile(filename string, write func(io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
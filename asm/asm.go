@@ -0,0 +1,413 @@
+// Package asm assembles Z80 and 8080 instructions and source files.  The
+// instruction forms and their encodings come from the opcode tables of the
+// z80 package so that the assembler accepts what the disassembler prints.
+package asm
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/marcopeereboom/toyz80/z80"
+)
+
+var (
+	ErrUnknownInstruction = errors.New("unknown instruction")
+	ErrInvalidOperands    = errors.New("invalid operands")
+	ErrRange              = errors.New("value out of range")
+	ErrSyntax             = errors.New("syntax error")
+	ErrDivideByZero       = errors.New("division by zero")
+	ErrRedefined          = errors.New("symbol redefined")
+)
+
+// field is the way an operand value is stored in an instruction.
+type field int
+
+const (
+	fieldByte     field = iota // 8 bit value, signed or unsigned
+	fieldOffset                // Signed 8 bit index register offset
+	fieldWord                  // 16 bit value, low byte first
+	fieldRelative              // Jump target relative to the next instruction
+)
+
+// size returns the number of bytes f occupies.
+func (f field) size() int {
+	if f == fieldWord {
+		return 2
+	}
+	return 1
+}
+
+// encode returns value v stored as f in the instruction at address.
+func (f field) encode(v int, address uint16) ([]byte, error) {
+	switch f {
+	case fieldByte:
+		if v < -128 || v > 255 {
+			return nil, ErrRange
+		}
+	case fieldOffset:
+		if v < -128 || v > 127 {
+			return nil, ErrRange
+		}
+	case fieldWord:
+		if v < -32768 || v > 65535 {
+			return nil, ErrRange
+		}
+		return []byte{byte(v), byte(v >> 8)}, nil
+	case fieldRelative:
+		// Relative jumps are two bytes long.
+		v = int(int16(uint16(v) - address - 2))
+		if v < -128 || v > 127 {
+			return nil, ErrRange
+		}
+	}
+	return []byte{byte(v)}, nil
+}
+
+// Fixup is an operand that uses a symbol that was not defined when the
+// instruction was encoded.  It was encoded as 0.
+type Fixup struct {
+	Address uint16 // Address of the instruction
+	Offset  int    // Offset of the operand in the instruction
+	Expr    string // Operand expression
+	Symbol  string // First undefined symbol in Expr
+	field   field
+}
+
+// Encode returns the operand bytes of f.  They belong at Address+Offset.
+func (f Fixup) Encode(lookup Lookup) ([]byte, error) {
+	v, err := Eval(f.Expr, f.Address, lookup)
+	if err != nil {
+		return nil, err
+	}
+	return f.field.encode(v, f.Address)
+}
+
+// argument is an operand expression and the way it is encoded.
+type argument struct {
+	expr  string
+	field field
+}
+
+// Encoder encodes instructions for one CPU mode.
+type Encoder struct {
+	forms     map[string][]z80.Instruction // Instruction forms by mnemonic
+	registers map[string]bool              // Literal operands that are names
+	numbers   map[string]int               // Literal operands that are numbers
+	parens    bool                         // Parentheses denote memory
+}
+
+// NewEncoder returns an encoder for the instructions of the CPU in mode.
+func NewEncoder(mode z80.CPUMode) *Encoder {
+	e := &Encoder{
+		forms:     make(map[string][]z80.Instruction),
+		registers: make(map[string]bool),
+		numbers:   make(map[string]int),
+		parens:    mode != z80.Mode8080,
+	}
+	for _, in := range z80.Instructions(mode) {
+		e.forms[in.Mnemonic] = append(e.forms[in.Mnemonic], in)
+		for _, o := range in.Operands {
+			if o.Kind != z80.OperandLiteral {
+				continue
+			}
+			if v, err := Eval(o.Text, 0, nil); err == nil {
+				e.numbers[o.Text] = v
+			} else {
+				e.registers[o.Text] = true
+			}
+		}
+	}
+	return e
+}
+
+// IsInstruction returns true if mnemonic is an instruction.
+func (e *Encoder) IsInstruction(mnemonic string) bool {
+	_, ok := e.forms[strings.ToLower(mnemonic)]
+	return ok
+}
+
+// Encode returns the encoding of mnemonic with operands at address.  Operands
+// that use symbols unknown to lookup are encoded as 0 and returned as fixups.
+func (e *Encoder) Encode(mnemonic string, operands []string, address uint16, lookup Lookup) ([]byte, []Fixup, error) {
+	forms, ok := e.forms[strings.ToLower(mnemonic)]
+	if !ok {
+		return nil, nil, ErrUnknownInstruction
+	}
+	texts := make([]string, 0, len(operands))
+	for _, o := range operands {
+		texts = append(texts, normalize(o))
+	}
+	alternatives := [][]string{texts}
+	if e.parens && accumulator[strings.ToLower(mnemonic)] {
+		// The accumulator operand is optional.
+		if len(texts) == 2 && strings.EqualFold(texts[0], "a") {
+			alternatives = append(alternatives, texts[1:])
+		} else if len(texts) == 1 {
+			alternatives = append(alternatives,
+				[]string{"a", texts[0]})
+		}
+	}
+	for _, texts := range alternatives {
+		for _, in := range forms {
+			if len(in.Operands) != len(texts) {
+				continue
+			}
+			args, ok := e.match(in, texts, address, lookup)
+			if !ok {
+				continue
+			}
+			return encode(in, args, address, lookup)
+		}
+	}
+	return nil, nil, ErrInvalidOperands
+}
+
+// accumulator are the Z80 8 bit arithmetic instructions.  Assemblers accept
+// them with and without the a operand.
+var accumulator = map[string]bool{
+	"add": true,
+	"adc": true,
+	"sub": true,
+	"sbc": true,
+	"and": true,
+	"xor": true,
+	"or":  true,
+	"cp":  true,
+}
+
+// match returns the arguments of operands if they match instruction form in.
+func (e *Encoder) match(in z80.Instruction, operands []string, address uint16, lookup Lookup) ([]argument, bool) {
+	var args []argument
+	for i, o := range in.Operands {
+		text := operands[i]
+		lower := strings.ToLower(text)
+		switch o.Kind {
+		case z80.OperandLiteral:
+			v, ok := e.numbers[o.Text]
+			if !ok {
+				if lower != o.Text {
+					return nil, false
+				}
+				continue
+			}
+			if !e.expression(text) {
+				return nil, false
+			}
+			n, err := Eval(text, address, lookup)
+			if err != nil || n != v {
+				return nil, false
+			}
+		case z80.OperandImmediate, z80.OperandImmediateExtended,
+			z80.OperandDisplacement:
+			if !e.expression(text) {
+				return nil, false
+			}
+			f := fieldByte
+			switch o.Kind {
+			case z80.OperandImmediateExtended:
+				f = fieldWord
+			case z80.OperandDisplacement:
+				f = fieldRelative
+			}
+			args = append(args, argument{expr: text, field: f})
+		case z80.OperandExtended, z80.OperandIndirect:
+			inner, ok := enclosed(text)
+			if !ok || e.register(inner) {
+				return nil, false
+			}
+			f := fieldByte
+			if o.Kind == z80.OperandExtended {
+				f = fieldWord
+			}
+			args = append(args, argument{expr: inner, field: f})
+		case z80.OperandIndexed:
+			prefix := "(" + o.Text
+			if !strings.HasPrefix(lower, prefix) ||
+				!strings.HasSuffix(lower, ")") {
+				return nil, false
+			}
+			offset := text[len(prefix) : len(text)-1]
+			switch {
+			case offset == "":
+				offset = "0"
+			case offset[0] != '+' && offset[0] != '-':
+				return nil, false
+			}
+			args = append(args, argument{expr: offset, field: fieldOffset})
+		}
+	}
+	return args, true
+}
+
+// expression returns true if operand text is a value rather than a register
+// or a memory reference.
+func (e *Encoder) expression(text string) bool {
+	if text == "" || e.register(text) {
+		return false
+	}
+	if _, ok := enclosed(text); ok && e.parens {
+		return false
+	}
+	return true
+}
+
+// register returns true if text is a register or uses one.
+func (e *Encoder) register(text string) bool {
+	if e.registers[strings.ToLower(text)] {
+		return true
+	}
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\'' || text[i] == '"':
+			i = skipQuote(text, i)
+		case isIdentifierStart(text[i]) &&
+			(i == 0 || !isIdentifier(text[i-1])):
+			j := i
+			for j < len(text) && isIdentifier(text[j]) {
+				j++
+			}
+			if e.registers[strings.ToLower(text[i:j])] {
+				return true
+			}
+			i = j - 1
+		}
+	}
+	return false
+}
+
+// encode returns the encoding of instruction form in with args at address.
+func encode(in z80.Instruction, args []argument, address uint16, lookup Lookup) ([]byte, []Fixup, error) {
+	data := make([]byte, in.Size)
+	n := copy(data, in.Opcode)
+	if len(in.Opcode) == 3 {
+		// dd cb d op
+		data[3] = in.Opcode[2]
+		n = 2
+	}
+
+	var fixups []Fixup
+	for _, arg := range args {
+		offset := n
+		n += arg.field.size()
+		if n > len(data) {
+			return nil, nil, ErrInvalidOperands
+		}
+		v, err := Eval(arg.expr, address, lookup)
+		if ue, ok := err.(UndefinedError); ok {
+			fixups = append(fixups, Fixup{
+				Address: address,
+				Offset:  offset,
+				Expr:    arg.expr,
+				Symbol:  ue.Symbol,
+				field:   arg.field,
+			})
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		b, err := arg.field.encode(v, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		copy(data[offset:], b)
+	}
+	return data, fixups, nil
+}
+
+// enclosed returns the inside of text if it is entirely enclosed in
+// parentheses.
+func enclosed(text string) (string, bool) {
+	if !strings.HasPrefix(text, "(") || !strings.HasSuffix(text, ")") {
+		return "", false
+	}
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\'', '"':
+			i = skipQuote(text, i)
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i != len(text)-1 {
+				return "", false
+			}
+		}
+	}
+	return text[1 : len(text)-1], true
+}
+
+// skipQuote returns the position of the closing quote if s has a character
+// constant or string at i.  The quote in af' is not one.
+func skipQuote(s string, i int) int {
+	if s[i] == '\'' && i > 0 && isIdentifier(s[i-1]) {
+		return i
+	}
+	if j := strings.IndexByte(s[i+1:], s[i]); j >= 0 {
+		return i + 1 + j
+	}
+	return i
+}
+
+// normalize removes the white space outside of character constants and
+// strings.
+func normalize(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t':
+			continue
+		case '\'', '"':
+			j := skipQuote(s, i)
+			b.WriteString(s[i : j+1])
+			i = j
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// splitOperands splits s at the commas outside of parentheses, character
+// constants and strings.
+func splitOperands(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	var (
+		operands []string
+		depth    int
+		start    int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"':
+			i = skipQuote(s, i)
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				operands = append(operands,
+					strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(operands, strings.TrimSpace(s[start:]))
+}
+
+// stripComment returns s without a ; comment.
+func stripComment(s string) string {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '"':
+			i = skipQuote(s, i)
+		case ';':
+			return s[:i]
+		}
+	}
+	return s
+}
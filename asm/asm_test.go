@@ -0,0 +1,482 @@
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/marcopeereboom/toyz80/bus"
+	"github.com/marcopeereboom/toyz80/loader"
+	"github.com/marcopeereboom/toyz80/symbol"
+	"github.com/marcopeereboom/toyz80/z80"
+)
+
+var testSymbols = map[string]int{
+	"buffer": 0x8000,
+	"five":   5,
+}
+
+func testLookup(name string) (int, bool) {
+	v, ok := testSymbols[name]
+	return v, ok
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected int
+		err      error
+	}{
+		{"42", 42, nil},
+		{"$ff", 0xff, nil},
+		{"0x1F", 0x1f, nil},
+		{"0ffh", 0xff, nil},
+		{"%1010", 10, nil},
+		{"1010b", 10, nil},
+		{"17o", 15, nil},
+		{"'A'", 0x41, nil},
+		{"$", 0x1000, nil},
+		{"$+3", 0x1003, nil},
+		{"1+2*3", 7, nil},
+		{"(1+2)*3", 9, nil},
+		{"-five", -5, nil},
+		{"~0&$ff", 0xff, nil},
+		{"1<<4|1", 0x11, nil},
+		{"buffer>>8", 0x80, nil},
+		{"17%5", 2, nil},
+		{"buffer + five", 0x8005, nil},
+		{"1/0", 0, ErrDivideByZero},
+		{"1+", 0, ErrSyntax},
+		{"(1", 0, ErrSyntax},
+		{"0xfg", 0, ErrSyntax},
+		{"later+1", 0, UndefinedError{Symbol: "later"}},
+	}
+	for _, test := range tests {
+		v, err := Eval(test.expr, 0x1000, testLookup)
+		if err != test.err {
+			t.Fatalf("%v: got error %v expected %v", test.expr, err,
+				test.err)
+		}
+		if v != test.expected {
+			t.Fatalf("%v: got %v expected %v", test.expr, v,
+				test.expected)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		mode     z80.CPUMode
+		text     string
+		expected []byte
+		err      error
+	}{
+		{z80.ModeZ80, "nop", []byte{0x00}, nil},
+		{z80.ModeZ80, "LD A, B", []byte{0x78}, nil},
+		{z80.ModeZ80, "ld a,(hl)", []byte{0x7e}, nil},
+		{z80.ModeZ80, "ld a,(buffer)", []byte{0x3a, 0x00, 0x80}, nil},
+		{z80.ModeZ80, "ld hl,(1+2)*3", []byte{0x21, 0x09, 0x00}, nil},
+		{z80.ModeZ80, "ld hl,(buffer)", []byte{0x2a, 0x00, 0x80}, nil},
+		{z80.ModeZ80, "ld a,' '", []byte{0x3e, 0x20}, nil},
+		{z80.ModeZ80, "ld a,-1", []byte{0x3e, 0xff}, nil},
+		{z80.ModeZ80, "ld a,(ix+$05)", []byte{0xdd, 0x7e, 0x05}, nil},
+		{z80.ModeZ80, "ld (iy-2),$ff", []byte{0xfd, 0x36, 0xfe, 0xff},
+			nil},
+		{z80.ModeZ80, "inc (ix)", []byte{0xdd, 0x34, 0x00}, nil},
+		{z80.ModeZ80, "ld ixh,five", []byte{0xdd, 0x26, 0x05}, nil},
+		{z80.ModeZ80, "bit 7,(ix+1)", []byte{0xdd, 0xcb, 0x01, 0x7e},
+			nil},
+		{z80.ModeZ80, "res 0,(iy+3),b", []byte{0xfd, 0xcb, 0x03, 0x80},
+			nil},
+		{z80.ModeZ80, "rlc (ix+$10)", []byte{0xdd, 0xcb, 0x10, 0x06},
+			nil},
+		{z80.ModeZ80, "set 1+2,a", []byte{0xcb, 0xdf}, nil},
+		{z80.ModeZ80, "jr $1000", []byte{0x18, 0xfe}, nil},
+		{z80.ModeZ80, "djnz $+$12", []byte{0x10, 0x10}, nil},
+		{z80.ModeZ80, "jr nz,$0f82", []byte{0x20, 0x80}, nil},
+		{z80.ModeZ80, "jp (hl)", []byte{0xe9}, nil},
+		{z80.ModeZ80, "jp (iy)", []byte{0xfd, 0xe9}, nil},
+		{z80.ModeZ80, "jp m,buffer", []byte{0xfa, 0x00, 0x80}, nil},
+		{z80.ModeZ80, "rst $38", []byte{0xff}, nil},
+		{z80.ModeZ80, "rst 08h", []byte{0xcf}, nil},
+		{z80.ModeZ80, "im 2", []byte{0xed, 0x5e}, nil},
+		{z80.ModeZ80, "in a,($10)", []byte{0xdb, 0x10}, nil},
+		{z80.ModeZ80, "in b,(c)", []byte{0xed, 0x40}, nil},
+		{z80.ModeZ80, "out (c),0", []byte{0xed, 0x71}, nil},
+		{z80.ModeZ80, "ex af,af'", []byte{0x08}, nil},
+		{z80.ModeZ80, "ex (sp),ix", []byte{0xdd, 0xe3}, nil},
+		{z80.ModeZ80, "ld (buffer),de", []byte{0xed, 0x53, 0x00, 0x80},
+			nil},
+		{z80.ModeZ80, "neg", []byte{0xed, 0x44}, nil},
+		{z80.ModeZ80, "ld a,$100", nil, ErrRange},
+		{z80.ModeZ80, "ld (ix+128),a", nil, ErrRange},
+		{z80.ModeZ80, "jr $1100", nil, ErrRange},
+		{z80.ModeZ80, "ld a,hl", nil, ErrInvalidOperands},
+		{z80.ModeZ80, "bit 8,a", nil, ErrInvalidOperands},
+		{z80.ModeZ80, "mov a,b", nil, ErrUnknownInstruction},
+		{z80.ModeZ80, "ld a,1+", nil, ErrSyntax},
+		{z80.Mode8080, "mov a,m", []byte{0x7e}, nil},
+		{z80.Mode8080, "mvi a,(1+2)", []byte{0x3e, 0x03}, nil},
+		{z80.Mode8080, "lxi sp,$1234", []byte{0x31, 0x34, 0x12}, nil},
+		{z80.Mode8080, "lda buffer", []byte{0x3a, 0x00, 0x80}, nil},
+		{z80.Mode8080, "jnz $1234", []byte{0xc2, 0x34, 0x12}, nil},
+		{z80.Mode8080, "push psw", []byte{0xf5}, nil},
+		{z80.Mode8080, "rst 7", []byte{0xff}, nil},
+		{z80.Mode8080, "in $10", []byte{0xdb, 0x10}, nil},
+		{z80.Mode8080, "ld a,b", nil, ErrUnknownInstruction},
+	}
+	encoders := map[z80.CPUMode]*Encoder{
+		z80.ModeZ80:  NewEncoder(z80.ModeZ80),
+		z80.Mode8080: NewEncoder(z80.Mode8080),
+	}
+	for _, test := range tests {
+		mnemonic, operands := test.text, ""
+		if i := strings.IndexByte(test.text, ' '); i > 0 {
+			mnemonic, operands = test.text[:i], test.text[i+1:]
+		}
+		data, fixups, err := encoders[test.mode].Encode(mnemonic,
+			splitOperands(operands), 0x1000, testLookup)
+		if err != test.err {
+			t.Fatalf("%v: got error %v expected %v", test.text, err,
+				test.err)
+		}
+		if !bytes.Equal(data, test.expected) {
+			t.Fatalf("%v: got % x expected % x", test.text, data,
+				test.expected)
+		}
+		if len(fixups) != 0 {
+			t.Fatalf("%v: unexpected fixups %v", test.text, fixups)
+		}
+	}
+}
+
+// TestRoundTrip assembles the disassembly of every instruction form and
+// verifies that it disassembles the same.
+func TestRoundTrip(t *testing.T) {
+	const address = 0x1000
+	for _, mode := range []z80.CPUMode{z80.ModeZ80, z80.Mode8080} {
+		b, err := bus.New([]bus.Device{{
+			Name:  "RAM",
+			Start: 0x0000,
+			Size:  65536,
+			Type:  bus.DeviceRAM,
+		}}, make(chan string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := z80.New(mode, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := NewEncoder(mode)
+
+		disassemble := func() (string, int) {
+			s, _, n, err := z.Disassemble(address, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return strings.TrimSpace(s), n
+		}
+
+		forms := z80.Instructions(mode)
+		if len(forms) == 0 {
+			t.Fatalf("%v: no instructions", mode)
+		}
+		for _, in := range forms {
+			data := bytes.Repeat([]byte{0x05}, in.Size)
+			copy(data, in.Opcode)
+			if len(in.Opcode) == 3 {
+				data[2], data[3] = 0x05, in.Opcode[2]
+			}
+			b.WriteMemory(address, data)
+			expected, _ := disassemble()
+
+			a := strings.SplitN(expected, " ", 2)
+			if len(a) == 1 {
+				a = append(a, "")
+			}
+			got, _, err := e.Encode(a[0], splitOperands(a[1]), address,
+				nil)
+			if err != nil {
+				t.Fatalf("%v %v: % x: %v", mode, expected, data, err)
+			}
+			b.WriteMemory(address, got)
+			s, n := disassemble()
+			if s != expected || n != len(got) {
+				t.Fatalf("%v %v: % x assembled to % x: %v", mode,
+					expected, data, got, s)
+			}
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	memory := make([]byte, 0x10000)
+	write := func(address uint16, data []byte) error {
+		copy(memory[address:], data)
+		return nil
+	}
+	s := NewSession(z80.ModeZ80, 0x0100, write, testLookup)
+
+	lines := []string{
+		"start:  ld b,five   ; count",
+		"loop:   djnz loop",
+		"        jp end",
+		"        call later",
+		"        ld a,(table+1)",
+		"        nop",
+		"end:    jr start",
+		"later:",
+		"        ret",
+		"table:  ld (buffer),a",
+	}
+	for _, line := range lines {
+		_, err := s.Assemble(line)
+		if err != nil {
+			t.Fatalf("%v: %v", line, err)
+		}
+	}
+	if len(s.Unresolved()) != 0 {
+		t.Fatalf("unresolved %v", s.Unresolved())
+	}
+	expected := []byte{
+		0x06, 0x05, // 0100 ld b,5
+		0x10, 0xfe, // 0102 djnz $0102
+		0xc3, 0x0e, 0x01, // 0104 jp $010e
+		0xcd, 0x10, 0x01, // 0107 call $0110
+		0x3a, 0x12, 0x01, // 010a ld a,($0112)
+		0x00,       // 010d nop
+		0x18, 0xf0, // 010e jr $0100
+		0xc9,             // 0110 ret
+		0x32, 0x00, 0x80, // 0111 ld ($8000),a
+	}
+	if got := memory[0x0100 : 0x0100+len(expected)]; !bytes.Equal(got,
+		expected) {
+		t.Fatalf("got % x expected % x", got, expected)
+	}
+	if s.PC != 0x0100+uint16(len(expected)) {
+		t.Fatalf("got PC %04x", s.PC)
+	}
+
+	// Errors
+	_, err := s.Assemble("start: nop")
+	if err != ErrRedefined {
+		t.Fatalf("got %v expected %v", err, ErrRedefined)
+	}
+	_, err = s.Assemble("jr far")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := s.Unresolved(); len(u) != 1 || u[0].Symbol != "far" {
+		t.Fatalf("unexpected unresolved %v", u)
+	}
+	s.PC = 0x0400
+	_, err = s.Assemble("far:")
+	if err == nil {
+		t.Fatalf("expected range error")
+	}
+	if len(s.Unresolved()) != 0 {
+		t.Fatalf("unresolved %v", s.Unresolved())
+	}
+}
+
+// writeSource writes the source files in dir.
+func writeSource(t *testing.T, dir string, files map[string]string) {
+	for name, source := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name),
+			[]byte(source), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeSource(t, dir, map[string]string{
+		"main.asm": `; test program
+size	equ	end-start	; forward reference
+	org	$100
+start:	ld	hl,message
+	ld	bc,size
+.loop:	djnz	.loop
+	jr	next
+next	ld	a,(ix-1)
+.loop:	jp	.loop		; local to next
+	include	"data.asm"
+	org	$200
+	ds	2
+	ds	3,$ff
+	dw	start,$1234
+end:	end	start
+	nop			; not assembled
+`,
+		"data.asm": `message: db "Hi, there;",0,"'"
+	defm	'ab',13+1
+`,
+	})
+
+	p, err := Assemble(filepath.Join(dir, "main.asm"), z80.ModeZ80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []loader.Chunk{
+		{Address: 0x0100, Data: []byte{
+			0x21, 0x10, 0x01, // ld hl,message
+			0x01, 0x09, 0x01, // ld bc,size
+			0x10, 0xfe, // djnz .loop
+			0x18, 0x00, // jr next
+			0xdd, 0x7e, 0xff, // ld a,(ix-1)
+			0xc3, 0x0d, 0x01, // jp .loop
+			'H', 'i', ',', ' ', 't', 'h', 'e', 'r', 'e', ';', 0x00,
+			'\'', 'a', 'b', 0x0e,
+		}},
+		{Address: 0x0202, Data: []byte{
+			0xff, 0xff, 0xff,
+			0x00, 0x01, 0x34, 0x12,
+		}},
+	}
+	if !reflect.DeepEqual(p.Chunks, expected) {
+		t.Fatalf("got %x expected %x", p.Chunks, expected)
+	}
+	if p.Start == nil || *p.Start != 0x0100 {
+		t.Fatalf("invalid start %v", p.Start)
+	}
+	symbols := []Symbol{
+		{Name: "end", Value: 0x0209},
+		{Name: "message", Value: 0x0110},
+		{Name: "next", Value: 0x010a},
+		{Name: "next.loop", Value: 0x010d},
+		{Name: "size", Value: 0x0109},
+		{Name: "start", Value: 0x0100},
+		{Name: "start.loop", Value: 0x0106},
+	}
+	if !reflect.DeepEqual(p.Symbols, symbols) {
+		t.Fatalf("got %v expected %v", p.Symbols, symbols)
+	}
+
+	origin, image := p.Binary()
+	if origin != 0x0100 || len(image) != 0x0209-0x0100 ||
+		!bytes.Equal(image[:len(expected[0].Data)], expected[0].Data) ||
+		!bytes.Equal(image[0x0102:], expected[1].Data) {
+		t.Fatalf("invalid binary %04x: % x", origin, image)
+	}
+
+	// The Intel HEX file loads the same chunks.
+	var hex bytes.Buffer
+	err = p.WriteIntelHex(&hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := loader.ReadIntelHex(hex.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Start == nil || *img.Start != 0x0100 {
+		t.Fatalf("invalid start %v", img.Start)
+	}
+	var data []byte
+	for _, c := range img.Chunks {
+		data = append(data, c.Data...)
+	}
+	if !bytes.Equal(data, append(expected[0].Data, expected[1].Data...)) {
+		t.Fatalf("got % x", data)
+	}
+
+	// The labels and equates in the listing can be loaded as symbols.
+	filename := filepath.Join(dir, "main.lst")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.WriteListing(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := symbol.New()
+	err = st.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []Symbol{symbols[0], symbols[1], symbols[4],
+		symbols[5]} {
+		address, ok := st.Lookup(s.Name)
+		if !ok || int(address) != s.Value {
+			t.Fatalf("%v: got %04x %v expected %04x", s.Name,
+				address, ok, s.Value)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		mode   z80.CPUMode
+		source string
+		line   int
+		err    error
+	}{
+		{"undefined", z80.ModeZ80, "\tnop\n\tjp nowhere\n", 2,
+			UndefinedError{Symbol: "nowhere"}},
+		{"redefined", z80.ModeZ80, "a1:\tnop\na1:\tnop\n", 2,
+			ErrRedefined},
+		{"unknown", z80.ModeZ80, "\tmov a,b\n", 1,
+			ErrUnknownInstruction},
+		{"8080", z80.Mode8080, "\tmov a,b\n\tld a,b\n", 2,
+			ErrUnknownInstruction},
+		{"range", z80.ModeZ80, "\tjr far\n\tds 200\nfar:\n", 1,
+			ErrRange},
+		{"org", z80.ModeZ80, "\torg later\nlater:\n", 1,
+			UndefinedError{Symbol: "later"}},
+		{"equ", z80.ModeZ80, "\tequ 5\n", 1, ErrMissingLabel},
+		{"wrap", z80.ModeZ80, "\torg $ffff\n\tdw 0\n", 2, ErrRange},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(dir, test.name+".asm")
+		writeSource(t, dir, map[string]string{
+			test.name + ".asm": test.source,
+		})
+		_, err := Assemble(filename, test.mode)
+		le, ok := err.(LineError)
+		if !ok {
+			t.Fatalf("%v: unexpected error %v", test.name, err)
+		}
+		if le.File != filename || le.Line != test.line ||
+			le.Err != test.err {
+			t.Fatalf("%v: got %v expected line %v %v", test.name,
+				err, test.line, test.err)
+		}
+	}
+}
+
+// TestCpuville assembles the cpuville ROM monitor.
+func TestCpuville(t *testing.T) {
+	p, err := Assemble("../src/cpuville/2K_ROM_8.asm", z80.ModeZ80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("../src/cpuville/2K_ROM_8.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin, image := p.Binary()
+	if origin != 0 || !bytes.Equal(image, expected) {
+		t.Fatalf("image differs from 2K_ROM_8.rom")
+	}
+}
@@ -0,0 +1,477 @@
+package asm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/marcopeereboom/toyz80/loader"
+	"github.com/marcopeereboom/toyz80/z80"
+)
+
+const (
+	// maxInclude is the deepest include nesting allowed.
+	maxInclude = 16
+)
+
+var (
+	ErrPhase         = errors.New("label moved between passes")
+	ErrMissingLabel  = errors.New("missing label")
+	ErrIncludeDepth  = errors.New("includes nested too deep")
+	ErrMissingString = errors.New("missing file name")
+)
+
+// LineError is an error on a line of a source file.
+type LineError struct {
+	File string
+	Line int // Line number, starting at 1
+	Err  error
+}
+
+func (le LineError) Error() string {
+	return fmt.Sprintf("%v:%v: %v", le.File, le.Line, le.Err)
+}
+
+// Symbol is a label or equate.
+type Symbol struct {
+	Name  string
+	Value int
+}
+
+// listing is a source line and what it assembled to.
+type listing struct {
+	line    int
+	address int // Address or value, -1 if there is none
+	data    []byte
+	text    string
+}
+
+// Program is an assembled source file.
+type Program struct {
+	Chunks  []loader.Chunk // Assembled bytes in source order
+	Start   *uint16        // Start address given to end
+	Symbols []Symbol       // Symbols sorted by name
+
+	listing []listing
+}
+
+// assembler holds the state of a pass over the source.
+type assembler struct {
+	encoder  *Encoder
+	pass     int
+	pc       uint16
+	symbols  map[string]int
+	scope    string // Last label that is not local
+	files    map[string][]string
+	filename string // File being assembled
+	depth    int    // Include nesting
+	ended    bool   // end was seen
+	program  Program
+}
+
+// Assemble assembles source file filename for the CPU in mode.  Every source
+// line is a [label[:]] [operation [operands]] [; comment] where label must
+// start in the first column if it has no colon.  Labels that start with a
+// period are local to the label before them.  The operations are the
+// instructions of the CPU and the directives org, equ, db, dw, ds, include
+// and end.  db is also known as defb, defm and dm, dw as defw and ds as defs.
+func Assemble(filename string, mode z80.CPUMode) (*Program, error) {
+	a := &assembler{
+		encoder: NewEncoder(mode),
+		symbols: make(map[string]int),
+		files:   make(map[string][]string),
+	}
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.pc = 0
+		a.scope = ""
+		a.ended = false
+		a.program = Program{}
+		err := a.file(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, value := range a.symbols {
+		a.program.Symbols = append(a.program.Symbols,
+			Symbol{Name: name, Value: value})
+	}
+	sort.Slice(a.program.Symbols, func(i, j int) bool {
+		return a.program.Symbols[i].Name < a.program.Symbols[j].Name
+	})
+	return &a.program, nil
+}
+
+// read returns the lines of filename.  Files are read once.
+func (a *assembler) read(filename string) ([]string, error) {
+	if lines, ok := a.files[filename]; ok {
+		return lines, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, strings.TrimRight(s.Text(), "\r"))
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	a.files[filename] = lines
+	return lines, nil
+}
+
+// file assembles the lines of filename.
+func (a *assembler) file(filename string) error {
+	lines, err := a.read(filename)
+	if err != nil {
+		return err
+	}
+	parent := a.filename
+	a.filename = filename
+	defer func() { a.filename = parent }()
+	for i, text := range lines {
+		if a.ended {
+			break
+		}
+		l := listing{line: i + 1, address: -1, text: text}
+		err := a.line(text, &l)
+		if err != nil {
+			if _, ok := err.(LineError); ok {
+				return err
+			}
+			return LineError{File: filename, Line: i + 1, Err: err}
+		}
+	}
+	return nil
+}
+
+// qualify returns the full name of a local label.
+func (a *assembler) qualify(name string) string {
+	if strings.HasPrefix(name, ".") {
+		return a.scope + name
+	}
+	return name
+}
+
+// lookup returns the value of a symbol.
+func (a *assembler) lookup(name string) (int, bool) {
+	v, ok := a.symbols[a.qualify(name)]
+	return v, ok
+}
+
+// eval returns the value of expression s.  Undefined symbols are only an
+// error in the second pass; in the first pass their value is 0.
+func (a *assembler) eval(s string) (int, error) {
+	v, err := Eval(s, a.pc, a.lookup)
+	if _, ok := err.(UndefinedError); ok && a.pass == 1 {
+		return 0, nil
+	}
+	return v, err
+}
+
+// define sets label to value.
+func (a *assembler) define(label string, value int) error {
+	name := a.qualify(label)
+	old, ok := a.symbols[name]
+	switch {
+	case !ok:
+	case a.pass == 1:
+		return ErrRedefined
+	case old != value:
+		return ErrPhase
+	}
+	a.symbols[name] = value
+	return nil
+}
+
+// operation returns true if s is an instruction or directive.
+func (a *assembler) operation(s string) bool {
+	_, ok := directives[strings.ToLower(s)]
+	return ok || a.encoder.IsInstruction(s)
+}
+
+// line assembles a single source line.
+func (a *assembler) line(text string, l *listing) error {
+	label, op, operands := splitLine(stripComment(text))
+	if label == "" && op != "" && !a.operation(op) {
+		// A label without colon starts in the first column or is
+		// followed by equ.
+		next, rest := splitWord(operands)
+		if text[0] != ' ' && text[0] != '\t' ||
+			strings.EqualFold(next, "equ") {
+			label, op, operands = op, next, rest
+		}
+	}
+	d := strings.ToLower(op)
+
+	if label != "" && d != "equ" {
+		if !strings.HasPrefix(label, ".") {
+			a.scope = label
+		}
+		err := a.define(label, int(a.pc))
+		if err != nil {
+			return err
+		}
+		l.address = int(a.pc)
+	}
+
+	// Reserve the listing line before an include lists its lines.
+	n := len(a.program.listing)
+	if a.pass == 2 {
+		a.program.listing = append(a.program.listing, listing{})
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if f, ok := directives[d]; ok {
+		data, err = f(a, label, operands, l)
+	} else if op != "" {
+		data, err = a.instruction(op, operands)
+	}
+	if err != nil {
+		return err
+	}
+	if int(a.pc)+len(data) > 0x10000 {
+		return ErrRange
+	}
+
+	if data != nil {
+		l.address = int(a.pc)
+		l.data = data
+	}
+	if a.pass == 2 {
+		a.program.listing[n] = *l
+		a.emit(data)
+	}
+	a.pc += uint16(len(data))
+	return nil
+}
+
+// instruction encodes an instruction.
+func (a *assembler) instruction(op, operands string) ([]byte, error) {
+	data, fixups, err := a.encoder.Encode(op, splitOperands(operands),
+		a.pc, a.lookup)
+	if err != nil {
+		return nil, err
+	}
+	if len(fixups) != 0 && a.pass == 2 {
+		return nil, UndefinedError{Symbol: fixups[0].Symbol}
+	}
+	return data, nil
+}
+
+// emit appends data at pc to the program.
+func (a *assembler) emit(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	chunks := a.program.Chunks
+	if n := len(chunks); n != 0 &&
+		int(chunks[n-1].Address)+len(chunks[n-1].Data) == int(a.pc) {
+		chunks[n-1].Data = append(chunks[n-1].Data, data...)
+		return
+	}
+	a.program.Chunks = append(chunks, loader.Chunk{
+		Address: a.pc,
+		Data:    append([]byte{}, data...),
+	})
+}
+
+// include assembles the file named by operands.  It is looked up relative to
+// the including file.
+func include(a *assembler, label, operands string, l *listing) ([]byte, error) {
+	name := strings.TrimSpace(operands)
+	if len(name) > 1 && (name[0] == '"' || name[0] == '\'') &&
+		name[len(name)-1] == name[0] {
+		name = name[1 : len(name)-1]
+	}
+	if name == "" {
+		return nil, ErrMissingString
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(a.filename), name)
+	}
+	if a.depth == maxInclude {
+		return nil, ErrIncludeDepth
+	}
+	a.depth++
+	defer func() { a.depth-- }()
+	return nil, a.file(name)
+}
+
+// directive assembles a directive with an optional label and its operands.
+type directive func(a *assembler, label, operands string, l *listing) ([]byte, error)
+
+var directives map[string]directive
+
+func init() {
+	directives = map[string]directive{
+		"org":     org,
+		"equ":     equ,
+		"db":      db,
+		"defb":    db,
+		"defm":    db,
+		"dm":      db,
+		"dw":      dw,
+		"defw":    dw,
+		"ds":      ds,
+		"defs":    ds,
+		"end":     end,
+		"include": include,
+	}
+}
+
+// org sets the address of the code that follows.
+func org(a *assembler, label, operands string, l *listing) ([]byte, error) {
+	v, err := Eval(operands, a.pc, a.lookup)
+	if err != nil {
+		return nil, err
+	}
+	if v < 0 || v > 0xffff {
+		return nil, ErrRange
+	}
+	a.pc = uint16(v)
+	l.address = v
+	return nil, nil
+}
+
+// equ sets label to the value of an expression.
+func equ(a *assembler, label, operands string, l *listing) ([]byte, error) {
+	if label == "" {
+		return nil, ErrMissingLabel
+	}
+	v, err := Eval(operands, a.pc, a.lookup)
+	if _, ok := err.(UndefinedError); ok && a.pass == 1 {
+		// Defined in the second pass.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if a.pass == 2 {
+		// The first pass may have skipped it.
+		delete(a.symbols, a.qualify(label))
+	}
+	l.address = v
+	return nil, a.define(label, v)
+}
+
+// db stores bytes and strings.
+func db(a *assembler, label, operands string, l *listing) ([]byte, error) {
+	data := []byte{}
+	for _, o := range splitOperands(operands) {
+		if len(o) > 1 && (o[0] == '"' || o[0] == '\'') &&
+			o[len(o)-1] == o[0] && len(o) != 3 {
+			data = append(data, o[1:len(o)-1]...)
+			continue
+		}
+		v, err := a.eval(o)
+		if err != nil {
+			return nil, err
+		}
+		b, err := fieldByte.encode(v, a.pc)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+// dw stores words.
+func dw(a *assembler, label, operands string, l *listing) ([]byte, error) {
+	data := []byte{}
+	for _, o := range splitOperands(operands) {
+		v, err := a.eval(o)
+		if err != nil {
+			return nil, err
+		}
+		b, err := fieldWord.encode(v, a.pc)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+// ds reserves space and fills it if a fill byte is provided.
+func ds(a *assembler, label, operands string, l *listing) ([]byte, error) {
+	o := splitOperands(operands)
+	if len(o) == 0 || len(o) > 2 {
+		return nil, ErrInvalidOperands
+	}
+	n, err := Eval(o[0], a.pc, a.lookup)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || int(a.pc)+n > 0x10000 {
+		return nil, ErrRange
+	}
+	l.address = int(a.pc)
+	if len(o) == 1 {
+		a.pc += uint16(n)
+		return nil, nil
+	}
+	v, err := a.eval(o[1])
+	if err != nil {
+		return nil, err
+	}
+	b, err := fieldByte.encode(v, a.pc)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = b[0]
+	}
+	return data, nil
+}
+
+// end ends the source and sets the start address if one is provided.
+func end(a *assembler, label, operands string, l *listing) ([]byte, error) {
+	a.ended = true
+	if strings.TrimSpace(operands) == "" {
+		return nil, nil
+	}
+	v, err := a.eval(operands)
+	if err != nil {
+		return nil, err
+	}
+	if v < 0 || v > 0xffff {
+		return nil, ErrRange
+	}
+	start := uint16(v)
+	a.program.Start = &start
+	return nil, nil
+}
+
+// splitLine splits a source line without comment into its label, operation
+// and operands.  Only labels that end in a colon are recognized.
+func splitLine(line string) (label, operation, operands string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexByte(line, ':'); i > 0 && isLabel(line[:i]) {
+		label, line = line[:i], line[i+1:]
+	}
+	operation, operands = splitWord(line)
+	return
+}
+
+// splitWord returns the first word of s and the rest of s.
+func splitWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i > 0 {
+		return s[:i], strings.TrimSpace(s[i+1:])
+	}
+	return s, ""
+}
@@ -0,0 +1,254 @@
+package asm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Lookup returns the value of a symbol.
+type Lookup func(name string) (int, bool)
+
+// UndefinedError is returned when an expression uses a symbol that has not
+// been defined.
+type UndefinedError struct {
+	Symbol string
+}
+
+func (ue UndefinedError) Error() string {
+	return "undefined symbol: " + ue.Symbol
+}
+
+// Binary operators by precedence, lowest first.
+var operators = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parser evaluates an expression while it is being parsed.
+type parser struct {
+	s         string
+	pos       int
+	pc        uint16
+	lookup    Lookup
+	undefined string // First undefined symbol
+}
+
+// Eval returns the value of expression s.  Numbers are decimal or hexadecimal
+// written as $ff, 0xff or 0ffh, binary written as %1010 or 1010b, octal
+// written as 17o or 17q, or a character in quotes.  $ on its own is
+// pc, the address of the current instruction.  The operators are those of C
+// without comparisons.  If a symbol is not known to lookup an UndefinedError
+// is returned.
+func Eval(s string, pc uint16, lookup Lookup) (int, error) {
+	p := parser{s: s, pc: pc, lookup: lookup}
+	v, err := p.expression(0)
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return 0, ErrSyntax
+	}
+	if p.undefined != "" {
+		return 0, UndefinedError{Symbol: p.undefined}
+	}
+	return v, nil
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// operator returns the operator at the current position if it has
+// precedence level.
+func (p *parser) operator(level int) (string, bool) {
+	p.skipSpace()
+	for _, op := range operators[level] {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// expression parses the binary operators of precedence level and higher.
+func (p *parser) expression(level int) (int, error) {
+	if level == len(operators) {
+		return p.unary()
+	}
+	v, err := p.expression(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := p.operator(level)
+		if !ok {
+			return v, nil
+		}
+		p.pos += len(op)
+		w, err := p.expression(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "|":
+			v |= w
+		case "^":
+			v ^= w
+		case "&":
+			v &= w
+		case "<<":
+			v <<= uint(w)
+		case ">>":
+			v >>= uint(w)
+		case "+":
+			v += w
+		case "-":
+			v -= w
+		case "*":
+			v *= w
+		case "/", "%":
+			if w == 0 {
+				if p.undefined != "" {
+					// Undefined symbols evaluate to 0.
+					v = 0
+					continue
+				}
+				return 0, ErrDivideByZero
+			}
+			if op == "/" {
+				v /= w
+			} else {
+				v %= w
+			}
+		}
+	}
+}
+
+func (p *parser) unary() (int, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return 0, ErrSyntax
+	}
+	switch p.s[p.pos] {
+	case '-':
+		p.pos++
+		v, err := p.unary()
+		return -v, err
+	case '+':
+		p.pos++
+		return p.unary()
+	case '~':
+		p.pos++
+		v, err := p.unary()
+		return ^v, err
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (int, error) {
+	c := p.s[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		v, err := p.expression(0)
+		if err != nil {
+			return 0, err
+		}
+		p.skipSpace()
+		if p.pos == len(p.s) || p.s[p.pos] != ')' {
+			return 0, ErrSyntax
+		}
+		p.pos++
+		return v, nil
+	case c == '\'' || c == '"':
+		if p.pos+2 >= len(p.s) || p.s[p.pos+2] != c {
+			return 0, ErrSyntax
+		}
+		v := int(p.s[p.pos+1])
+		p.pos += 3
+		return v, nil
+	case c == '$':
+		p.pos++
+		word := p.word()
+		if word == "" {
+			return int(p.pc), nil
+		}
+		return parseNumber(word, 16)
+	case c == '%' && p.pos+1 < len(p.s) &&
+		(p.s[p.pos+1] == '0' || p.s[p.pos+1] == '1'):
+		p.pos++
+		return parseNumber(p.word(), 2)
+	case c >= '0' && c <= '9':
+		return parseNumber(p.word(), 0)
+	case isIdentifierStart(c):
+		name := p.word()
+		if p.lookup != nil {
+			if v, ok := p.lookup(name); ok {
+				return v, nil
+			}
+		}
+		if p.undefined == "" {
+			p.undefined = name
+		}
+		return 0, nil
+	}
+	return 0, ErrSyntax
+}
+
+// word returns the identifier or number at the current position.
+func (p *parser) word() string {
+	start := p.pos
+	for p.pos < len(p.s) && isIdentifier(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// parseNumber parses s in base.  Base 0 means the base is determined by a 0x
+// prefix or a h, b, o or q suffix and is 10 otherwise.
+func parseNumber(s string, base int) (int, error) {
+	if base == 0 {
+		l := strings.ToLower(s)
+		base = 10
+		switch {
+		case strings.HasPrefix(l, "0x"):
+			s, base = s[2:], 16
+		case strings.HasSuffix(l, "h"):
+			s, base = s[:len(s)-1], 16
+		case strings.HasSuffix(l, "b") && isBinary(s[:len(s)-1]):
+			s, base = s[:len(s)-1], 2
+		case strings.HasSuffix(l, "o"), strings.HasSuffix(l, "q"):
+			s, base = s[:len(s)-1], 8
+		}
+	}
+	n, err := strconv.ParseUint(s, base, 32)
+	if err != nil {
+		return 0, ErrSyntax
+	}
+	return int(n), nil
+}
+
+func isBinary(s string) bool {
+	for _, c := range s {
+		if c != '0' && c != '1' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isIdentifierStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c == '_' || c == '.' || c == '?' || c == '@'
+}
+
+func isIdentifier(c byte) bool {
+	return isIdentifierStart(c) || c >= '0' && c <= '9' || c == '$'
+}
@@ -0,0 +1,110 @@
+package asm
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	ihexRecordSize = 16 // Data bytes per Intel HEX record
+	listingBytes   = 4  // Bytes per listing line
+)
+
+// Binary returns the program as a memory image from its lowest to its highest
+// address and the address the image starts at.  Gaps are filled with zeros.
+func (p *Program) Binary() (uint16, []byte) {
+	if len(p.Chunks) == 0 {
+		return 0, nil
+	}
+	low, high := 0x10000, 0
+	for _, c := range p.Chunks {
+		if int(c.Address) < low {
+			low = int(c.Address)
+		}
+		if end := int(c.Address) + len(c.Data); end > high {
+			high = end
+		}
+	}
+	image := make([]byte, high-low)
+	for _, c := range p.Chunks {
+		copy(image[int(c.Address)-low:], c.Data)
+	}
+	return uint16(low), image
+}
+
+// ihexRecord returns an Intel HEX record.
+func ihexRecord(address uint16, recordType byte, data []byte) string {
+	record := []byte{byte(len(data)), byte(address >> 8), byte(address),
+		recordType}
+	record = append(record, data...)
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	record = append(record, -sum)
+	return ":" + strings.ToUpper(hex.EncodeToString(record))
+}
+
+// WriteIntelHex writes the program as an Intel HEX file.  The start address
+// is written as a start linear address record.
+func (p *Program) WriteIntelHex(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, c := range p.Chunks {
+		for i := 0; i < len(c.Data); i += ihexRecordSize {
+			end := i + ihexRecordSize
+			if end > len(c.Data) {
+				end = len(c.Data)
+			}
+			fmt.Fprintln(bw, ihexRecord(c.Address+uint16(i), 0x00,
+				c.Data[i:end]))
+		}
+	}
+	if p.Start != nil {
+		fmt.Fprintln(bw, ihexRecord(0, 0x05,
+			[]byte{0, 0, byte(*p.Start >> 8), byte(*p.Start)}))
+	}
+	fmt.Fprintln(bw, ihexRecord(0, 0x01, nil))
+	return bw.Flush()
+}
+
+// hexBytes returns data as hexadecimal bytes separated by spaces.
+func hexBytes(data []byte) string {
+	return fmt.Sprintf("% x", data)
+}
+
+// WriteListing writes every source line with its line number, address and
+// the bytes it assembled to followed by the symbol table.  Labels can be
+// loaded from the listing by the symbol package.
+func (p *Program) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, l := range p.listing {
+		address := "    "
+		if l.address >= 0 {
+			address = fmt.Sprintf("%04x", uint16(l.address))
+		}
+		n := len(l.data)
+		if n > listingBytes {
+			n = listingBytes
+		}
+		line := fmt.Sprintf("%5d: %v  %-11v  %v", l.line, address,
+			hexBytes(l.data[:n]), l.text)
+		fmt.Fprintln(bw, strings.TrimRight(line, " \t"))
+		for i := n; i < len(l.data); i += listingBytes {
+			end := i + listingBytes
+			if end > len(l.data) {
+				end = len(l.data)
+			}
+			fmt.Fprintf(bw, "       %04x  %v\n",
+				uint16(l.address+i), hexBytes(l.data[i:end]))
+		}
+	}
+
+	fmt.Fprintf(bw, "\nSymbols:\n")
+	for _, s := range p.Symbols {
+		fmt.Fprintf(bw, "%-32v %04x\n", s.Name, uint16(s.Value))
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,119 @@
+package asm
+
+import (
+	"fmt"
+
+	"github.com/marcopeereboom/toyz80/z80"
+)
+
+// Session assembles one line at a time straight into memory.  Labels may be
+// used before they are defined; the instructions that use them are patched
+// once they are.
+type Session struct {
+	PC uint16 // Address of the next instruction
+
+	encoder *Encoder
+	write   func(address uint16, data []byte) error
+	symbols Lookup            // Symbols defined outside the session
+	labels  map[string]uint16 // Labels defined in the session
+	fixups  []Fixup           // Operands waiting for a label
+}
+
+// NewSession returns a session that assembles instructions for the CPU in
+// mode starting at address.  Instructions are stored with write.  Symbols
+// that are not defined in the session are looked up in symbols, which may be
+// nil.
+func NewSession(mode z80.CPUMode, address uint16, write func(uint16, []byte) error, symbols Lookup) *Session {
+	return &Session{
+		PC:      address,
+		encoder: NewEncoder(mode),
+		write:   write,
+		symbols: symbols,
+		labels:  make(map[string]uint16),
+	}
+}
+
+// lookup returns the value of a label or outside symbol.
+func (s *Session) lookup(name string) (int, bool) {
+	if address, ok := s.labels[name]; ok {
+		return int(address), true
+	}
+	if s.symbols != nil {
+		return s.symbols(name)
+	}
+	return 0, false
+}
+
+// Assemble assembles a line of the form [label:] [mnemonic [operands]]
+// [; comment] at PC and returns the instruction.  A label is set to PC.
+func (s *Session) Assemble(line string) ([]byte, error) {
+	label, mnemonic, operands := splitLine(stripComment(line))
+	if label != "" {
+		err := s.define(label)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mnemonic == "" {
+		return nil, nil
+	}
+
+	data, fixups, err := s.encoder.Encode(mnemonic,
+		splitOperands(operands), s.PC, s.lookup)
+	if err != nil {
+		return nil, err
+	}
+	err = s.write(s.PC, data)
+	if err != nil {
+		return nil, err
+	}
+	s.fixups = append(s.fixups, fixups...)
+	s.PC += uint16(len(data))
+	return data, nil
+}
+
+// define sets label to PC and patches the operands that were waiting for it.
+func (s *Session) define(label string) error {
+	if _, ok := s.labels[label]; ok {
+		return ErrRedefined
+	}
+	s.labels[label] = s.PC
+
+	var (
+		pending []Fixup
+		rerr    error
+	)
+	for _, f := range s.fixups {
+		data, err := f.Encode(s.lookup)
+		if _, ok := err.(UndefinedError); ok {
+			pending = append(pending, f)
+			continue
+		}
+		if err == nil {
+			err = s.write(f.Address+uint16(f.Offset), data)
+		}
+		if err != nil && rerr == nil {
+			rerr = fmt.Errorf("$%04x: %v", f.Address, err)
+		}
+	}
+	s.fixups = pending
+	return rerr
+}
+
+// Unresolved returns the operands that use symbols that are still undefined.
+func (s *Session) Unresolved() []Fixup {
+	return s.fixups
+}
+
+// isLabel returns true if s is a valid label name.
+func isLabel(s string) bool {
+	if s == "" || !isIdentifierStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentifier(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package bus
+
+import "errors"
+
+var (
+	ErrInvalidPageSize = errors.New("invalid page size")
+	ErrInvalidBanks    = errors.New("invalid banks")
+)
+
+// Bank is one physical bank behind a banked memory window.  A bank is as
+// large as the window it belongs to.
+type Bank struct {
+	Type  BusDeviceType // DeviceRAM or DeviceROM
+	Image []byte
+}
+
+// bank is the memory and the access flags of a physical bank.
+type bank struct {
+	memory []byte
+	flags  byte
+}
+
+// window is a range of the address space that is split into pages.  Every
+// logical page maps a physical page of one of the banks.  Physical page p is
+// page p%pagesPerBank of bank p/pagesPerBank.
+type window struct {
+	start    uint16 // First address of the window
+	pageSize int    // Size of a page, a multiple of MemoryUnit
+	banks    []bank
+	pages    []int // Physical page mapped at each logical page
+}
+
+// physicalPages returns the number of physical pages behind w.
+func (w *window) physicalPages() int {
+	return len(w.banks) * len(w.pages)
+}
+
+func (b *Bus) newWindow(d Device) error {
+	pageSize := d.PageSize
+	if pageSize == 0 {
+		pageSize = d.Size
+	}
+	if pageSize <= 0 || pageSize%MemoryUnit != 0 || d.Size%pageSize != 0 {
+		return ErrInvalidPageSize
+	}
+	if int(d.Start)%MemoryUnit != 0 || int(d.Start)+d.Size > MemoryMax {
+		return ErrInvalidSize
+	}
+	if len(d.Banks) == 0 {
+		return ErrInvalidBanks
+	}
+
+	w := &window{
+		start:    d.Start,
+		pageSize: pageSize,
+		pages:    make([]int, d.Size/pageSize),
+	}
+	for _, bk := range d.Banks {
+		if len(bk.Image) > d.Size {
+			return ErrInvalidImageSize
+		}
+		var flags byte
+		switch bk.Type {
+		case DeviceRAM:
+			flags = memoryFlagRead | memoryFlagWrite
+		case DeviceROM:
+			flags = memoryFlagRead
+		default:
+			return ErrInvalidMemoryType
+		}
+		m := make([]byte, d.Size)
+		copy(m, bk.Image)
+		w.banks = append(w.banks, bank{memory: m, flags: flags})
+	}
+
+	// Start out with the first bank mapped.
+	b.windows = append(b.windows, w)
+	for i := range w.pages {
+		b.mapPage(w, i, i)
+	}
+	return nil
+}
+
+// mapPage maps physical page p of w at logical page page.  Out of range
+// pages wrap around, as they would on hardware that ignores the upper bits
+// of the page register.
+func (b *Bus) mapPage(w *window, page, p int) {
+	p %= w.physicalPages()
+	w.pages[page] = p
+
+	bk := w.banks[p/len(w.pages)]
+	offset := p % len(w.pages) * w.pageSize
+	unit := (int(w.start) + page*w.pageSize) >> MemoryShift
+	for i := 0; i < w.pageSize/MemoryUnit; i++ {
+		o := offset + i*MemoryUnit
+		b.banked[unit+i] = bk.memory[o : o+MemoryUnit]
+		b.memoryFlags[unit+i] = bk.flags | memoryFlagBanked |
+			b.memoryFlags[unit+i]&memoryFlagKeep
+	}
+}
+
+// mmu is an I/O device that remaps the pages of the banked memory windows.
+// Logical pages are numbered in the order the windows were declared.
+//
+// With page registers the byte written to port offset n selects the physical
+// page that is mapped at logical page n and reading the port returns it.  As
+// a latch any write to port offset n maps bank n into every window and the
+// data is ignored.
+type mmu struct {
+	bus   *Bus
+	latch bool
+}
+
+// page returns the window and logical page of MMU page n.
+func (m *mmu) page(n int) (*window, int, bool) {
+	for _, w := range m.bus.windows {
+		if n < len(w.pages) {
+			return w, n, true
+		}
+		n -= len(w.pages)
+	}
+	return nil, 0, false
+}
+
+func (m *mmu) Write(address, data byte) {
+	if m.latch {
+		for _, w := range m.bus.windows {
+			n := int(address) % len(w.banks)
+			for i := range w.pages {
+				m.bus.mapPage(w, i, n*len(w.pages)+i)
+			}
+		}
+		return
+	}
+	w, page, ok := m.page(int(address))
+	if !ok {
+		return
+	}
+	m.bus.mapPage(w, page, int(data))
+}
+
+func (m *mmu) Read(address byte) byte {
+	if m.latch {
+		return 0xff
+	}
+	w, page, ok := m.page(int(address))
+	if !ok {
+		return 0xff
+	}
+	return byte(w.pages[page])
+}
+
+func (m *mmu) Reset() {
+}
+
+func (m *mmu) Shutdown() {
+}
@@ -3,22 +3,33 @@ package bus
 import (
 	"errors"
 	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
 
 	"github.com/marcopeereboom/toyz80/device"
-	"github.com/marcopeereboom/toyz80/device/console"
-	"github.com/marcopeereboom/toyz80/device/dummy"
 )
 
 const (
 	MemoryMax   = 65536
 	MemoryUnit  = 1024
 	MemoryShift = 10
-	IOMax       = 255
+	IOMax       = 256 // Ports decoded on the lower 8 bits
 )
 
 const (
-	memoryFlagRead  = 1 << 1
-	memoryFlagWrite = 1 << 2
+	memoryFlagRead   = 1 << 1
+	memoryFlagWrite  = 1 << 2
+	memoryFlagBanked = 1 << 3 // Memory lives in a bank, see banked
+	memoryFlagDevice = 1 << 4 // Unit is shared with a mapped device
+	memoryFlagWatch  = 1 << 5 // Unit has a watchpoint
+
+	// Units with these flags are not accessed through memory directly.
+	memoryFlagIndirect = memoryFlagBanked | memoryFlagDevice |
+		memoryFlagWatch
+
+	// Flags that are kept when memory is remapped.
+	memoryFlagKeep = memoryFlagDevice | memoryFlagWatch
 )
 
 var (
@@ -27,24 +38,121 @@ var (
 	ErrInvalidSize       = errors.New("invalid memory size")
 	ErrInvalidMemoryType = errors.New("invalid memory type")
 	ErrInvalidImageSize  = errors.New("invalid image size")
+	ErrInvalidPolicy     = errors.New("invalid fault policy")
+	ErrInvalidState      = errors.New("invalid bus state")
+	ErrInvalidSpace      = errors.New("device can't be memory mapped")
+	ErrInterrupter       = errors.New("device can't interrupt")
+)
+
+// Access is the kind of bus cycle.
+type Access int
+
+const (
+	AccessRead Access = iota
+	AccessWrite
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	}
+	return "invalid access"
+}
+
+// BusFaultError is reported when unmapped memory is read or when memory that
+// is not writable is written.
+type BusFaultError struct {
+	PC      uint16 // Instruction that caused the fault, set by the CPU
+	Address uint16
+	Access  Access
+}
+
+func (bf BusFaultError) Error() string {
+	return fmt.Sprintf("bus fault: %v $%04x", bf.Access, bf.Address)
+}
+
+// UnmappedIOError is reported when an I/O port without a device is accessed.
+type UnmappedIOError struct {
+	PC     uint16 // Instruction that caused the fault, set by the CPU
+	Port   uint16
+	Access Access
+}
+
+func (ue UnmappedIOError) Error() string {
+	return fmt.Sprintf("unmapped I/O: %v port $%04x", ue.Access, ue.Port)
+}
+
+// FaultPolicy determines what the bus does when a fault occurs.  In all cases
+// reads return $ff and writes are dropped.
+type FaultPolicy int
+
+const (
+	FaultTrap   FaultPolicy = iota // Report the fault to the CPU
+	FaultLog                       // Log the fault and continue
+	FaultIgnore                    // Silently continue
 )
 
+var faultPolicies = []string{"trap", "log", "ignore"}
+
+func (fp FaultPolicy) String() string {
+	if fp < 0 || int(fp) >= len(faultPolicies) {
+		return "invalid policy"
+	}
+	return faultPolicies[fp]
+}
+
+// ParseFaultPolicy returns the fault policy called s.
+func ParseFaultPolicy(s string) (FaultPolicy, error) {
+	for i, name := range faultPolicies {
+		if s == name {
+			return FaultPolicy(i), nil
+		}
+	}
+	return 0, ErrInvalidPolicy
+}
+
 type BusDeviceType int
 
 const (
 	DeviceInvalid = iota
 	DeviceRAM
 	DeviceROM
-	DeviceSerialConsole
-	DeviceDummy
+	DevicePeripheral // Registered device called Peripheral
+	DeviceBanked     // Memory window in front of several banks
+	DeviceMMU        // Remaps the pages of the banked memory windows
 )
 
-// Bus glues the memory map and devices.
+// Bus glues the memory map and devices.  Read and Write check memoryFlags
+// without locking, so AddWatchpoint, DelWatchpoint and RestoreState, which
+// change them, may only be called while the CPU is paused.
 type Bus struct {
-	memoryFlags []byte        // Memory attributes array
-	memory      []byte        // Memory space
-	io          []interface{} // I/O device lookup array
-	ioStart     []byte        // I/O device start location
+	memoryFlags []byte            // Memory attributes array
+	memory      []byte            // Memory space
+	banked      [][]byte          // Memory of banked units
+	windows     []*window         // Banked memory windows
+	mapped      []*mappedDevice   // Memory mapped devices
+	mappedUnits [][]*mappedDevice // Memory mapped devices by unit
+	io          [][]*ioPort       // I/O devices by lower 8 bits of port
+	ports       []*ioPort         // I/O devices
+
+	interrupters []device.Interrupter // Devices wired to INT
+
+	faultPolicy FaultPolicy // What to do when a fault occurs
+	faulted     int32       // Set atomically when fault is set
+
+	sync.Mutex
+	fault        error              // First fault since the last call to Fault
+	intAsserted  bool               // INT asserted by RaiseInterrupt
+	intData      byte               // Data bus contents for RaiseInterrupt
+	nmiPending   bool               // NMI edge seen but not yet accepted
+	acknowledged device.Interrupter // Device that supplies the IM 0 instruction
+
+	watches   []Watchpoint // Memory watchpoints
+	ioWatches []Watchpoint // I/O watchpoints
+	watchID   int          // ID of the last watchpoint added
 }
 
 type Device struct {
@@ -53,6 +161,15 @@ type Device struct {
 	Size  int
 	Type  BusDeviceType
 	Image []byte
+
+	Banks    []Bank // Banks behind a DeviceBanked window
+	PageSize int    // DeviceBanked page size, the whole window if 0
+	Latch    bool   // DeviceMMU ports select a bank instead of a page
+	Wide     bool   // Decode all 16 bits of the port address
+
+	Peripheral string            // Registered device type
+	Options    map[string]string // Peripheral configuration options
+	Memory     bool              // Decode the peripheral in memory space
 }
 
 func New(devices []Device, shutdown chan string) (*Bus, error) {
@@ -60,8 +177,9 @@ func New(devices []Device, shutdown chan string) (*Bus, error) {
 	bus := &Bus{
 		memory:      make([]byte, MemoryMax),
 		memoryFlags: make([]byte, MemoryMax/MemoryUnit),
-		io:          make([]interface{}, IOMax),
-		ioStart:     make([]byte, IOMax),
+		banked:      make([][]byte, MemoryMax/MemoryUnit),
+		mappedUnits: make([][]*mappedDevice, MemoryMax/MemoryUnit),
+		io:          make([][]*ioPort, IOMax),
 	}
 
 	for _, d := range devices {
@@ -72,27 +190,22 @@ func New(devices []Device, shutdown chan string) (*Bus, error) {
 			if err != nil {
 				return nil, err
 			}
-		case DeviceSerialConsole:
-			// Console device uses 2 ports
-			if int(d.Start)+d.Size+2 > IOMax {
-				return nil, ErrInvalidSize
+		case DevicePeripheral:
+			err := bus.newPeripheral(d, shutdown)
+			if err != nil {
+				return nil, err
 			}
-			cons, err := console.New(shutdown)
+		case DeviceBanked:
+			err := bus.newWindow(d)
 			if err != nil {
 				return nil, err
 			}
-			// XXX rethink this
-			bus.io[d.Start] = cons
-			bus.io[d.Start+1] = cons
-			bus.ioStart[d.Start] = byte(d.Start)
-			bus.ioStart[d.Start+1] = byte(d.Start)
-		case DeviceDummy:
-			dummyDev, err := dummy.New()
+		case DeviceMMU:
+			m := &mmu{bus: bus, latch: d.Latch}
+			err := bus.attachIO(m, d.Start, d.Size, d.Wide)
 			if err != nil {
 				return nil, err
 			}
-			bus.io[d.Start] = dummyDev
-			bus.ioStart[d.Start] = byte(d.Start)
 		default:
 			return nil, ErrInvalidDeviceType
 		}
@@ -101,6 +214,88 @@ func New(devices []Device, shutdown chan string) (*Bus, error) {
 	return bus, nil
 }
 
+// addInterrupter wires dev to the INT line if it is capable of raising
+// interrupts.
+func (b *Bus) addInterrupter(dev interface{}) {
+	i, ok := dev.(device.Interrupter)
+	if !ok {
+		return
+	}
+	b.interrupters = append(b.interrupters, i)
+}
+
+// newPeripheral creates the registered device d describes and decodes it in
+// I/O or memory space.  An I/O device of size 0 decodes all of its ports.
+func (b *Bus) newPeripheral(d Device, shutdown chan string) error {
+	desc, ok := device.Lookup(d.Peripheral)
+	if !ok {
+		return ErrInvalidDeviceType
+	}
+	config := device.Config{
+		Space:    device.SpaceIO,
+		Start:    d.Start,
+		Size:     d.Size,
+		Shutdown: shutdown,
+	}
+	// Check the device fits before creating it, the console waits for a
+	// connection.
+	var err error
+	if d.Memory {
+		if !desc.Memory {
+			return ErrInvalidSpace
+		}
+		err = b.checkMapped(d.Start, d.Size)
+		config.Space = device.SpaceMemory
+	} else {
+		if config.Size == 0 {
+			config.Size = desc.Ports
+		}
+		if config.Size != desc.Ports {
+			return ErrInvalidSize
+		}
+		err = b.checkIO(d.Start, config.Size, d.Wide)
+	}
+	if err != nil {
+		return err
+	}
+	options, err := desc.OptionValues(d.Options)
+	if err != nil {
+		return err
+	}
+	config.Options = options
+
+	dev, err := desc.New(config)
+	if err != nil {
+		return err
+	}
+	var i device.Interrupter
+	if desc.Interrupts {
+		i, ok = dev.(device.Interrupter)
+		if !ok {
+			return ErrInterrupter
+		}
+	}
+	if d.Memory {
+		m, ok := dev.(device.MemoryMapper)
+		if !ok {
+			return ErrInvalidSpace
+		}
+		err = b.mapDevice(d.Start, d.Size, m)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = b.attachIO(dev, d.Start, config.Size, d.Wide)
+		if err != nil {
+			return err
+		}
+	}
+	if i != nil {
+		b.interrupters = append(b.interrupters, i)
+	}
+	return nil
+}
+
 func (b *Bus) newMemoryRegion(d Device) error {
 	// Make sure we have a proper sized unit
 	if d.Size%MemoryUnit != 0 {
@@ -127,7 +322,8 @@ func (b *Bus) newMemoryRegion(d Device) error {
 	a := d.Start >> MemoryShift
 	c := uint16(d.Size / MemoryUnit)
 	for i := a; i < a+c; i++ {
-		b.memoryFlags[i] = flags
+		b.memoryFlags[i] = flags | b.memoryFlags[i]&memoryFlagKeep
+		b.banked[i] = nil
 	}
 
 	// Fill memory with provided image.
@@ -136,34 +332,196 @@ func (b *Bus) newMemoryRegion(d Device) error {
 	return nil
 }
 
+// SetFaultPolicy sets what happens on reads of unmapped memory, writes to ROM
+// and accesses to I/O ports without a device.  The default is FaultTrap.
+func (b *Bus) SetFaultPolicy(policy FaultPolicy) {
+	b.faultPolicy = policy
+}
+
+// setFault handles err according to the fault policy.  A trapped fault is
+// recorded unless an earlier fault has not been collected yet.
+func (b *Bus) setFault(err error) {
+	switch b.faultPolicy {
+	case FaultIgnore:
+		return
+	case FaultLog:
+		log.Printf("%v", err)
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.fault == nil {
+		b.fault = err
+		atomic.StoreInt32(&b.faulted, 1)
+	}
+}
+
+// Fault returns the first fault or triggered watchpoint since the previous
+// call and clears it.  It returns nil if nothing happened.
+func (b *Bus) Fault() error {
+	// Fault is called for every instruction, only lock when needed.
+	if atomic.LoadInt32(&b.faulted) == 0 {
+		return nil
+	}
+	b.Lock()
+	defer b.Unlock()
+	err := b.fault
+	b.fault = nil
+	atomic.StoreInt32(&b.faulted, 0)
+	return err
+}
+
+// Read reads a byte from memory.  Reading unmapped memory is a BusFaultError
+// and returns the floating bus value $ff.
 func (b *Bus) Read(address uint16) byte {
 	idx := address >> MemoryShift
-	if b.memoryFlags[idx]&memoryFlagRead == 0 {
-		panic(fmt.Sprintf("invalid read location: 0x%04x", address))
+	if b.memoryFlags[idx]&(memoryFlagRead|memoryFlagIndirect) !=
+		memoryFlagRead {
+		return b.read(address)
 	}
 	return b.memory[address]
 }
 
+// Write writes a byte to memory.  Writing memory that is not writable is a
+// BusFaultError and leaves memory untouched.
 func (b *Bus) Write(address uint16, data byte) {
 	idx := address >> MemoryShift
-	if b.memoryFlags[idx]&memoryFlagWrite == 0 {
-		panic(fmt.Sprintf("invalid write location: 0x%04x", address))
+	if b.memoryFlags[idx]&(memoryFlagWrite|memoryFlagIndirect) !=
+		memoryFlagWrite {
+		b.write(address, data)
+		return
 	}
 	b.memory[address] = data
 }
 
-func (b *Bus) IORead(address byte) byte {
-	x := b.io[address].(device.Device).Read(address - b.ioStart[address])
-	return x
+// unit returns the memory that is currently mapped at the memory unit that
+// contains address.
+func (b *Bus) unit(address uint16) []byte {
+	idx := address >> MemoryShift
+	if b.memoryFlags[idx]&memoryFlagBanked != 0 {
+		return b.banked[idx]
+	}
+	start := int(idx) << MemoryShift
+	return b.memory[start : start+MemoryUnit]
+}
+
+// Interrupt returns true if the maskable interrupt line is asserted.
+func (b *Bus) Interrupt() bool {
+	b.Lock()
+	asserted := b.intAsserted
+	b.Unlock()
+	if asserted {
+		return true
+	}
+	for _, i := range b.interrupters {
+		if i.Interrupt() {
+			return true
+		}
+	}
+	return false
+}
+
+// InterruptAck acknowledges the maskable interrupt and returns the byte the
+// interrupting device places on the data bus.  A floating bus reads $ff.
+func (b *Bus) InterruptAck() byte {
+	b.Lock()
+	b.acknowledged = nil
+	if b.intAsserted {
+		data := b.intData
+		b.Unlock()
+		return data
+	}
+	b.Unlock()
+	for _, i := range b.interrupters {
+		if i.Interrupt() {
+			b.Lock()
+			b.acknowledged = i
+			b.Unlock()
+			return i.InterruptAck()
+		}
+	}
+	return 0xff
+}
+
+// InterruptData returns the next byte of a multi byte instruction supplied in
+// interrupt mode 0.  The device that InterruptAck acknowledged is acknowledged
+// again for every byte.  Other sources leave the bus floating at $ff.
+func (b *Bus) InterruptData() byte {
+	b.Lock()
+	i := b.acknowledged
+	b.Unlock()
+	if i == nil {
+		return 0xff
+	}
+	return i.InterruptAck()
+}
+
+// RaiseInterrupt asserts the maskable interrupt line on behalf of a source
+// that is not a bus device.  The line stays asserted until ClearInterrupt is
+// called.
+func (b *Bus) RaiseInterrupt(data byte) {
+	b.Lock()
+	defer b.Unlock()
+	b.intAsserted = true
+	b.intData = data
+}
+
+// ClearInterrupt releases the maskable interrupt line asserted by
+// RaiseInterrupt.
+func (b *Bus) ClearInterrupt() {
+	b.Lock()
+	defer b.Unlock()
+	b.intAsserted = false
 }
 
-func (b *Bus) IOWrite(address, data byte) {
-	b.io[address].(device.Device).Write(address-b.ioStart[address], data)
+// RaiseNMI pulses the non-maskable interrupt line.  NMI is edge triggered so
+// multiple pulses before the CPU accepts the interrupt are seen as one.
+func (b *Bus) RaiseNMI() {
+	b.Lock()
+	defer b.Unlock()
+	b.nmiPending = true
+}
+
+// NMI returns true if a non-maskable interrupt edge is pending and
+// acknowledges it.
+func (b *Bus) NMI() bool {
+	b.Lock()
+	defer b.Unlock()
+	pending := b.nmiPending
+	b.nmiPending = false
+	return pending
+}
+
+// Reset pulls the reset line.  Devices return to their power on state and the
+// first bank of every banked memory window is mapped.  Memory is untouched.
+func (b *Bus) Reset() {
+	for _, p := range b.ports {
+		p.dev.Reset()
+	}
+	for _, m := range b.mapped {
+		dev, ok := m.dev.(device.Device)
+		if !ok {
+			continue
+		}
+		dev.Reset()
+	}
+	for _, w := range b.windows {
+		for i := range w.pages {
+			b.mapPage(w, i, i)
+		}
+	}
+
+	b.Lock()
+	b.nmiPending = false
+	b.Unlock()
 }
 
 func (b *Bus) Shutdown() {
-	for i := range b.io {
-		dev, ok := b.io[i].(device.Device)
+	for _, p := range b.ports {
+		p.dev.Shutdown()
+	}
+	for _, m := range b.mapped {
+		dev, ok := m.dev.(device.Device)
 		if !ok {
 			continue
 		}
@@ -171,17 +529,210 @@ func (b *Bus) Shutdown() {
 	}
 }
 
+// WriteMemory copies data to the memory that is currently mapped at address,
+// ROM included.  Memory mapped devices are bypassed.
 func (b *Bus) WriteMemory(address uint16, data []byte) error {
 	if int(address)+len(data) > len(b.memory) {
 		return ErrInvalidImageSize
 	}
-	copy(b.memory[address:], data[:])
+	for i := 0; i < len(data); {
+		a := address + uint16(i)
+		i += copy(b.unit(a)[a&(MemoryUnit-1):], data[i:])
+	}
+	return nil
+}
+
+// State is the saved state of memory, the devices and the interrupt lines.
+type State struct {
+	Memory      []byte        // Memory space
+	MemoryFlags []byte        // Memory attributes array
+	Windows     []WindowState // Banked memory windows
+	Devices     []DeviceState // Devices that have internal state
+	Mapped      []MappedState // Memory mapped devices with internal state
+	IntAsserted bool          // INT asserted by RaiseInterrupt
+	IntData     byte          // Data bus contents for RaiseInterrupt
+	NMIPending  bool          // NMI edge seen but not yet accepted
+}
+
+// WindowState is the saved state of a banked memory window.
+type WindowState struct {
+	Pages []int    // Physical page mapped at each logical page
+	Banks [][]byte // Memory of every bank
+}
+
+// DeviceState is the saved state of the device that decodes Port and up.
+type DeviceState struct {
+	Port  uint16
+	State []byte
+}
+
+// MappedState is the saved state of the memory mapped device that decodes
+// Address and up.
+type MappedState struct {
+	Address uint16
+	State   []byte
+}
+
+// mappedSnapshotter returns the memory mapped device that starts at address
+// if it has internal state.
+func (b *Bus) mappedSnapshotter(address uint16) (device.Snapshotter, bool) {
+	m, ok := b.mappedDevice(address)
+	if !ok || m.start != address {
+		return nil, false
+	}
+	dev, ok := m.dev.(device.Snapshotter)
+	return dev, ok
+}
+
+// snapshotter returns the device that starts at port if it has internal
+// state.
+func (b *Bus) snapshotter(port uint16) (device.Snapshotter, bool) {
+	for _, p := range b.ports {
+		if p.start == port {
+			dev, ok := p.dev.(device.Snapshotter)
+			return dev, ok
+		}
+	}
+	return nil, false
+}
+
+// SaveState returns a copy of the bus state.
+func (b *Bus) SaveState() State {
+	s := State{
+		Memory:      make([]byte, len(b.memory)),
+		MemoryFlags: make([]byte, len(b.memoryFlags)),
+	}
+	copy(s.Memory, b.memory)
+	copy(s.MemoryFlags, b.memoryFlags)
+	for _, w := range b.windows {
+		ws := WindowState{Pages: make([]int, len(w.pages))}
+		copy(ws.Pages, w.pages)
+		for _, bk := range w.banks {
+			ws.Banks = append(ws.Banks, append([]byte(nil),
+				bk.memory...))
+		}
+		s.Windows = append(s.Windows, ws)
+	}
+	for _, p := range b.ports {
+		dev, ok := p.dev.(device.Snapshotter)
+		if !ok {
+			continue
+		}
+		s.Devices = append(s.Devices, DeviceState{
+			Port:  p.start,
+			State: dev.Snapshot(),
+		})
+	}
+	for _, m := range b.mapped {
+		dev, ok := m.dev.(device.Snapshotter)
+		if !ok {
+			continue
+		}
+		s.Mapped = append(s.Mapped, MappedState{
+			Address: m.start,
+			State:   dev.Snapshot(),
+		})
+	}
+
+	b.Lock()
+	s.IntAsserted = b.intAsserted
+	s.IntData = b.intData
+	s.NMIPending = b.nmiPending
+	b.Unlock()
+
+	return s
+}
+
+// RestoreState replaces the bus state with one returned by SaveState.  The
+// bus must have the same devices at the same ports as the one that was
+// saved.  The state is checked before anything is replaced, on error the bus
+// is left as it was.  The CPU must be paused.
+func (b *Bus) RestoreState(s State) error {
+	if len(s.Memory) != len(b.memory) ||
+		len(s.MemoryFlags) != len(b.memoryFlags) {
+		return ErrInvalidState
+	}
+	if len(s.Windows) != len(b.windows) {
+		return ErrInvalidState
+	}
+	for i, w := range b.windows {
+		ws := s.Windows[i]
+		if len(ws.Pages) != len(w.pages) ||
+			len(ws.Banks) != len(w.banks) {
+			return ErrInvalidState
+		}
+		for _, p := range ws.Pages {
+			if p < 0 || p >= w.physicalPages() {
+				return ErrInvalidState
+			}
+		}
+		for j, bk := range w.banks {
+			if len(ws.Banks[j]) != len(bk.memory) {
+				return ErrInvalidState
+			}
+		}
+	}
+	devs := make([]device.Snapshotter, 0, len(s.Devices)+len(s.Mapped))
+	states := make([][]byte, 0, cap(devs))
+	for _, d := range s.Devices {
+		dev, ok := b.snapshotter(d.Port)
+		if !ok {
+			return ErrInvalidState
+		}
+		devs = append(devs, dev)
+		states = append(states, d.State)
+	}
+	for _, d := range s.Mapped {
+		dev, ok := b.mappedSnapshotter(d.Address)
+		if !ok {
+			return ErrInvalidState
+		}
+		devs = append(devs, dev)
+		states = append(states, d.State)
+	}
+
+	// Only the devices can reject their state.  The old state of every
+	// device that took the new one is kept to put it back when a later
+	// device fails.
+	for i, dev := range devs {
+		old := dev.Snapshot()
+		if err := dev.Restore(states[i]); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				devs[j].Restore(states[j])
+			}
+			return err
+		}
+		states[i] = old
+	}
+	copy(b.memory, s.Memory)
+	copy(b.memoryFlags, s.MemoryFlags)
+	for i, w := range b.windows {
+		for j, bk := range w.banks {
+			copy(bk.memory, s.Windows[i].Banks[j])
+		}
+		for page, p := range s.Windows[i].Pages {
+			b.mapPage(w, page, p)
+		}
+	}
+	b.Lock()
+	b.watchUnits()
+	b.fault = nil
+	atomic.StoreInt32(&b.faulted, 0)
+	b.intAsserted = s.IntAsserted
+	b.intData = s.IntData
+	b.nmiPending = s.NMIPending
+	b.Unlock()
+
 	return nil
 }
 
 // Dump returns a dump of memory starting at the provided address and length.
+// Memory mapped devices are not read.
 func (b *Bus) Dump(addr, count uint16) []byte {
 	buf := make([]byte, count)
-	copy(buf, b.memory[addr:addr+count])
+	for i := range buf {
+		a := addr + uint16(i)
+		buf[i] = b.unit(a)[a&(MemoryUnit-1)]
+	}
 	return buf
 }
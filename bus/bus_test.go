@@ -1,8 +1,16 @@
 package bus
 
 import (
+	"bytes"
 	"crypto/rand"
+	"log"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/marcopeereboom/toyz80/device"
+	"github.com/marcopeereboom/toyz80/device/dummy"
 )
 
 func fakeBus(start uint16, size int, image []byte) (*Bus, error) {
@@ -52,14 +60,12 @@ func TestRam(t *testing.T) {
 	}
 }
 
-func assertPanic(t *testing.T, name string, f func()) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Fatalf("%v: did not panic", name)
-		}
-	}()
-	f()
+func assertFault(t *testing.T, name string, b *Bus, expected error) {
+	if err := b.Fault(); err != expected {
+		t.Fatalf("%v: got fault %v, expected %v", name, err, expected)
+	}
 }
+
 func TestRamBounds(t *testing.T) {
 	start := uint16(0x1000)
 	size := 0x1000
@@ -68,16 +74,127 @@ func TestRamBounds(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 	// lower bounds
-	assertPanic(t, "lower bounds", func() {
-		b.Read(start - 1)
+	if x := b.Read(start - 1); x != 0xff {
+		t.Fatalf("lower bounds: got %02x, expected ff", x)
+	}
+	assertFault(t, "lower bounds", b, BusFaultError{
+		Address: start - 1,
+		Access:  AccessRead,
 	})
 	b.Read(start)
+	assertFault(t, "start", b, nil)
 
 	// upper bounds
-	assertPanic(t, "upper bounds", func() {
-		b.Read(start + uint16(size-1) + 1)
+	if x := b.Read(start + uint16(size-1) + 1); x != 0xff {
+		t.Fatalf("upper bounds: got %02x, expected ff", x)
+	}
+	assertFault(t, "upper bounds", b, BusFaultError{
+		Address: start + uint16(size-1) + 1,
+		Access:  AccessRead,
 	})
 	b.Read(start + uint16(size-1))
+	assertFault(t, "end", b, nil)
+
+	b.Write(start+uint16(size-1)+1, 0xaa)
+	assertFault(t, "upper bounds write", b, BusFaultError{
+		Address: start + uint16(size-1) + 1,
+		Access:  AccessWrite,
+	})
+}
+
+func TestFault(t *testing.T) {
+	devices := []Device{
+		{
+			Name:  "ROM",
+			Start: 0x0000,
+			Size:  0x1000,
+			Type:  DeviceROM,
+			Image: []byte{0x55},
+		},
+		{
+			Name:  "RAM",
+			Start: 0x1000,
+			Size:  0x1000,
+			Type:  DeviceRAM,
+		},
+		{
+			Name:       "dummy",
+			Start:      0x10,
+			Type:       DevicePeripheral,
+			Peripheral: "dummy",
+		},
+	}
+	b, err := New(devices, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		access   func(b *Bus)
+		expected error
+	}{
+		{
+			name:   "read rom",
+			access: func(b *Bus) { b.Read(0x0000) },
+		},
+		{
+			name:   "write rom",
+			access: func(b *Bus) { b.Write(0x0000, 0xaa) },
+			expected: BusFaultError{
+				Address: 0x0000,
+				Access:  AccessWrite,
+			},
+		},
+		{
+			name:   "read unmapped",
+			access: func(b *Bus) { b.Read(0x2000) },
+			expected: BusFaultError{
+				Address: 0x2000,
+				Access:  AccessRead,
+			},
+		},
+		{
+			name: "first fault wins",
+			access: func(b *Bus) {
+				b.Read(0x2000)
+				b.Write(0x3000, 0)
+			},
+			expected: BusFaultError{
+				Address: 0x2000,
+				Access:  AccessRead,
+			},
+		},
+		{
+			name:   "read port",
+			access: func(b *Bus) { b.IORead(0x0010) },
+		},
+		{
+			name:   "read unmapped port",
+			access: func(b *Bus) { b.IORead(0x1220) },
+			expected: UnmappedIOError{
+				Port:   0x1220,
+				Access: AccessRead,
+			},
+		},
+		{
+			name:   "write unmapped port",
+			access: func(b *Bus) { b.IOWrite(0x00fe, 0) },
+			expected: UnmappedIOError{
+				Port:   0x00fe,
+				Access: AccessWrite,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.access(b)
+		assertFault(t, test.name, b, test.expected)
+	}
+
+	if x := b.Read(0x0000); x != 0x55 {
+		t.Fatalf("rom was written: got %02x, expected 55", x)
+	}
 }
 
 func TestRamImage(t *testing.T) {
@@ -135,9 +252,934 @@ func TestRamImage(t *testing.T) {
 		for addr := test.start; int(addr) < test.size; addr++ {
 			if x := b.Read(uint16(addr)); x != b.memory[addr] {
 				t.Fatalf("%v: memory corruption at: %04x "+
-					"got %02x, expected %02x\n",
+					"got %02x, expected %02x\n", test.name,
 					uint16(addr), x, b.memory[addr])
 			}
 		}
 	}
 }
+
+func TestFaultPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		logged   bool
+		expected error
+	}{
+		{
+			name:   "trap",
+			policy: "trap",
+			expected: BusFaultError{
+				Address: 0x2000,
+				Access:  AccessWrite,
+			},
+		},
+		{
+			name:   "log",
+			policy: "log",
+			logged: true,
+		},
+		{
+			name:   "ignore",
+			policy: "ignore",
+		},
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	for _, test := range tests {
+		b, err := fakeBus(0x0000, 0x1000, []byte{})
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		policy, err := ParseFaultPolicy(test.policy)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		b.SetFaultPolicy(policy)
+
+		buf.Reset()
+		b.Write(0x2000, 0xaa)
+		assertFault(t, test.name, b, test.expected)
+		if logged := strings.Contains(buf.String(),
+			"bus fault: write $2000"); logged != test.logged {
+			t.Fatalf("%v: logged %v, expected %v", test.name,
+				logged, test.logged)
+		}
+		if x := b.Read(0x2000); x != 0xff {
+			t.Fatalf("%v: got %02x, expected ff", test.name, x)
+		}
+	}
+
+	if _, err := ParseFaultPolicy("panic"); err != ErrInvalidPolicy {
+		t.Fatalf("invalid policy: got %v, expected %v", err,
+			ErrInvalidPolicy)
+	}
+}
+
+func TestState(t *testing.T) {
+	devices := []Device{
+		{Name: "ROM", Start: 0x0000, Size: 0x1000, Type: DeviceROM,
+			Image: []byte{0x01, 0x02, 0x03}},
+		{Name: "RAM", Start: 0x1000, Size: 0x1000, Type: DeviceRAM},
+		{Name: "dummy", Start: 0x10, Type: DevicePeripheral,
+			Peripheral: "dummy"},
+		{Name: "dummy", Start: 0x20, Type: DevicePeripheral,
+			Peripheral: "dummy"},
+	}
+	b, err := New(devices, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Write(0x1000, 0xaa)
+	b.IOWrite(0x10, 0x55)
+	b.RaiseInterrupt(0xcf)
+	b.RaiseNMI()
+	s := b.SaveState()
+
+	// Restore into a machine with the same devices but no ROM image.
+	devices[0].Image = nil
+	r, err := New(devices, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.RestoreState(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.memory, b.memory) {
+		t.Fatalf("memory not restored")
+	}
+	if !bytes.Equal(r.memoryFlags, b.memoryFlags) {
+		t.Fatalf("memory flags not restored")
+	}
+	if x := r.IORead(0x10); x != 0x55 {
+		t.Fatalf("device: got %02x, expected 55", x)
+	}
+	if !r.Interrupt() || r.InterruptAck() != 0xcf {
+		t.Fatalf("interrupt not restored")
+	}
+	if !r.NMI() {
+		t.Fatalf("nmi not restored")
+	}
+
+	// A device that rejects its state leaves the machine as it was.
+	bad := s
+	bad.Memory = make([]byte, len(s.Memory))
+	bad.Devices = []DeviceState{
+		{Port: 0x10, State: []byte{0x66}},
+		{Port: 0x20, State: nil},
+	}
+	if err = r.RestoreState(bad); err != dummy.ErrInvalidState {
+		t.Fatalf("got %v, expected %v", err, dummy.ErrInvalidState)
+	}
+	if x := r.IORead(0x10); x != 0x55 {
+		t.Fatalf("device: got %02x, expected 55", x)
+	}
+	if x := r.Read(0x1000); x != 0xaa {
+		t.Fatalf("memory: got %02x, expected aa", x)
+	}
+
+	// A machine without the device can't be restored.
+	r, err = fakeBus(0x0000, 0x2000, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.RestoreState(s); err != ErrInvalidState {
+		t.Fatalf("got %v, expected %v", err, ErrInvalidState)
+	}
+}
+
+// cpuvilleBus returns a bus with the cpuville memory expansion board: output
+// to port 0 maps the 2K ROM at $0000 and output to port 1 maps all RAM.
+func cpuvilleBus(rom []byte) (*Bus, error) {
+	devices := []Device{
+		{Name: "RAM", Start: 0x0800, Size: 0xf800, Type: DeviceRAM},
+		{Name: "ROM/RAM", Start: 0x0000, Size: 0x0800,
+			Type: DeviceBanked, Banks: []Bank{
+				{Type: DeviceROM, Image: rom},
+				{Type: DeviceRAM},
+			}},
+		{Name: "latch", Start: 0x00, Size: 2, Type: DeviceMMU,
+			Latch: true},
+	}
+	return New(devices, make(chan string))
+}
+
+func TestBankLatch(t *testing.T) {
+	b, err := cpuvilleBus([]byte{0xc3, 0x00, 0x08})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ROM is mapped after reset.
+	if x := b.Dump(0x0000, 3); !bytes.Equal(x, []byte{0xc3, 0x00, 0x08}) {
+		t.Fatalf("rom: got %x", x)
+	}
+	b.Write(0x0000, 0x00)
+	assertFault(t, "rom write", b, BusFaultError{
+		Address: 0x0000,
+		Access:  AccessWrite,
+	})
+
+	// Copy the ROM to high memory, switch to RAM and copy it back the way
+	// a CP/M loader would.
+	rom := b.Dump(0x0000, 0x0800)
+	b.WriteMemory(0x8000, rom)
+	b.IOWrite(0x01, 0x00)
+	if x := b.Read(0x0000); x != 0x00 {
+		t.Fatalf("ram: got %02x, expected 00", x)
+	}
+	for i := 0; i < len(rom); i++ {
+		b.Write(uint16(i), b.Read(0x8000+uint16(i)))
+	}
+	b.Write(0x07ff, 0x55)
+	assertFault(t, "ram", b, nil)
+	if x := b.Read(0x0000); x != 0xc3 {
+		t.Fatalf("ram copy: got %02x, expected c3", x)
+	}
+
+	// Switching back maps the untouched ROM and RAM keeps its contents.
+	b.IOWrite(0x00, 0xff)
+	if x := b.Read(0x07ff); x != 0x00 {
+		t.Fatalf("rom: got %02x, expected 00", x)
+	}
+	b.IOWrite(0x01, 0xff)
+	if x := b.Read(0x07ff); x != 0x55 {
+		t.Fatalf("ram: got %02x, expected 55", x)
+	}
+	if x := b.IORead(0x01); x != 0xff {
+		t.Fatalf("latch read: got %02x, expected ff", x)
+	}
+}
+
+func TestMMU(t *testing.T) {
+	devices := []Device{
+		{Name: "RAM", Start: 0x0000, Size: 0x8000, Type: DeviceRAM},
+		{Name: "banked", Start: 0x8000, Size: 0x8000, PageSize: 0x4000,
+			Type: DeviceBanked, Banks: []Bank{
+				{Type: DeviceRAM},
+				{Type: DeviceRAM},
+				{Type: DeviceROM, Image: []byte{0xaa}},
+			}},
+		{Name: "mmu", Start: 0x78, Size: 4, Type: DeviceMMU},
+	}
+	b, err := New(devices, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Physical pages 0 and 1 are mapped at $8000 and $c000.
+	for page := 0; page < 2; page++ {
+		if x := b.IORead(0x78 + uint16(page)); x != byte(page) {
+			t.Fatalf("page %v: got %02x", page, x)
+		}
+	}
+	for p := 0; p < 4; p++ {
+		b.IOWrite(0x78, byte(p))
+		b.Write(0x8000, byte(p))
+	}
+	for p := 0; p < 4; p++ {
+		b.IOWrite(0x79, byte(p))
+		if x := b.Read(0xc000); x != byte(p) {
+			t.Fatalf("physical page %v: got %02x", p, x)
+		}
+	}
+
+	// The same page may be mapped twice.
+	b.IOWrite(0x78, 2)
+	b.IOWrite(0x79, 2)
+	b.Write(0xc001, 0x11)
+	if x := b.Read(0x8001); x != 0x11 {
+		t.Fatalf("alias: got %02x, expected 11", x)
+	}
+
+	// ROM pages.
+	b.IOWrite(0x79, 4)
+	if x := b.Read(0xc000); x != 0xaa {
+		t.Fatalf("rom: got %02x, expected aa", x)
+	}
+	b.Write(0xc000, 0x00)
+	assertFault(t, "rom", b, BusFaultError{
+		Address: 0xc000,
+		Access:  AccessWrite,
+	})
+
+	// Page registers beyond the windows are ignored.
+	b.IOWrite(0x7a, 0x01)
+	if x := b.IORead(0x7a); x != 0xff {
+		t.Fatalf("unused register: got %02x, expected ff", x)
+	}
+	assertFault(t, "unused register", b, nil)
+}
+
+func TestBankErrors(t *testing.T) {
+	ram := []Bank{{Type: DeviceRAM}}
+	tests := []struct {
+		name     string
+		device   Device
+		expected error
+	}{
+		{"page size", Device{Start: 0x0000, Size: 0x2000,
+			PageSize: 0x0c00, Type: DeviceBanked, Banks: ram},
+			ErrInvalidPageSize},
+		{"unit", Device{Start: 0x0000, Size: 0x2000,
+			PageSize: 0x0200, Type: DeviceBanked, Banks: ram},
+			ErrInvalidPageSize},
+		{"start", Device{Start: 0x0100, Size: 0x0400,
+			Type: DeviceBanked, Banks: ram}, ErrInvalidSize},
+		{"size", Device{Start: 0xf000, Size: 0x2000,
+			Type: DeviceBanked, Banks: ram}, ErrInvalidSize},
+		{"no banks", Device{Start: 0x0000, Size: 0x0400,
+			Type: DeviceBanked}, ErrInvalidBanks},
+		{"bank type", Device{Start: 0x0000, Size: 0x0400,
+			Type:  DeviceBanked,
+			Banks: []Bank{{Type: DevicePeripheral}}},
+			ErrInvalidMemoryType},
+		{"image", Device{Start: 0x0000, Size: 0x0400,
+			Type: DeviceBanked, Banks: []Bank{{Type: DeviceROM,
+				Image: make([]byte, 0x0401)}}},
+			ErrInvalidImageSize},
+		{"mmu", Device{Start: 0xfe, Size: 4, Type: DeviceMMU},
+			ErrInvalidSize},
+	}
+	for _, test := range tests {
+		_, err := New([]Device{test.device}, make(chan string))
+		if err != test.expected {
+			t.Fatalf("%v: got %v, expected %v", test.name, err,
+				test.expected)
+		}
+	}
+}
+
+func TestBankState(t *testing.T) {
+	b, err := cpuvilleBus([]byte{0xc3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.IOWrite(0x01, 0x00)
+	b.Write(0x0000, 0x3e)
+	s := b.SaveState()
+	b.Write(0x0000, 0x00)
+	b.IOWrite(0x00, 0x00)
+
+	r, err := cpuvilleBus(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*Bus{b, r} {
+		if err = m.RestoreState(s); err != nil {
+			t.Fatal(err)
+		}
+		if x := m.Read(0x0000); x != 0x3e {
+			t.Fatalf("ram: got %02x, expected 3e", x)
+		}
+		m.IOWrite(0x00, 0x00)
+		if x := m.Read(0x0000); x != 0xc3 {
+			t.Fatalf("rom: got %02x, expected c3", x)
+		}
+	}
+
+	// A machine without the banked window can't be restored.
+	r, err = fakeBus(0x0000, 0x10000, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.RestoreState(s); err != ErrInvalidState {
+		t.Fatalf("got %v, expected %v", err, ErrInvalidState)
+	}
+
+	// Pages outside the window are rejected before anything changes.
+	for _, p := range []int{-1, len(b.windows[0].banks) *
+		len(b.windows[0].pages)} {
+		bad := s
+		bad.Memory = make([]byte, len(s.Memory))
+		bad.Windows = []WindowState{{
+			Pages: append([]int(nil), s.Windows[0].Pages...),
+			Banks: s.Windows[0].Banks,
+		}}
+		bad.Windows[0].Pages[0] = p
+		if err = b.RestoreState(bad); err != ErrInvalidState {
+			t.Fatalf("page %v: got %v, expected %v", p, err,
+				ErrInvalidState)
+		}
+		if x := b.Read(0x0000); x != 0xc3 {
+			t.Fatalf("page %v: got %02x, expected c3", p, x)
+		}
+	}
+}
+
+// videoRAM is a memory mapped device that counts the accesses to it.
+type videoRAM struct {
+	memory [0x100]byte
+	reads  int
+	writes int
+}
+
+func (v *videoRAM) ReadMemory(address uint16) byte {
+	v.reads++
+	return v.memory[address]
+}
+
+func (v *videoRAM) WriteMemory(address uint16, data byte) {
+	v.writes++
+	v.memory[address] = data
+}
+
+func TestMapDevice(t *testing.T) {
+	devices := []Device{
+		{Name: "RAM", Start: 0x0000, Size: 0x8000, Type: DeviceRAM},
+		{Name: "dummy", Start: 0x9000, Size: 0x0010,
+			Type: DevicePeripheral, Peripheral: "dummy", Memory: true},
+	}
+	b, err := New(devices, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &videoRAM{}
+	if err = b.MapDevice(0x7f00, len(v.memory), v); err != nil {
+		t.Fatal(err)
+	}
+
+	// Memory sharing a unit with the device is still RAM.
+	b.Write(0x7eff, 0x12)
+	b.Write(0x7f00, 0x34)
+	b.Write(0x7fff, 0x56)
+	if v.writes != 2 || v.memory[0x00] != 0x34 || v.memory[0xff] != 0x56 {
+		t.Fatalf("device writes: %v %x", v.writes, v.memory)
+	}
+	if x := b.Read(0x7f00); x != 0x34 || v.reads != 1 {
+		t.Fatalf("device read: got %02x, expected 34", x)
+	}
+	if x := b.Read(0x7eff); x != 0x12 || v.reads != 1 {
+		t.Fatalf("ram read: got %02x, expected 12", x)
+	}
+	if x := b.Dump(0x7f00, 1); x[0] != 0x00 || v.reads != 1 {
+		t.Fatalf("dump read the device: %x", x)
+	}
+	assertFault(t, "video", b, nil)
+
+	// Devices without memory behind them.
+	b.Write(0x900f, 0x77)
+	if x := b.Read(0x9000); x != 0x77 {
+		t.Fatalf("dummy: got %02x, expected 77", x)
+	}
+	assertFault(t, "dummy", b, nil)
+	b.Read(0x9010)
+	assertFault(t, "unmapped", b, BusFaultError{
+		Address: 0x9010,
+		Access:  AccessRead,
+	})
+	if x := b.IORead(0x00); x != 0xff {
+		t.Fatalf("dummy in I/O space: got %02x", x)
+	}
+	assertFault(t, "I/O", b, UnmappedIOError{
+		Port:   0x00,
+		Access: AccessRead,
+	})
+
+	// State of memory mapped devices is saved.
+	s := b.SaveState()
+	b.Write(0x9000, 0x00)
+	if err = b.RestoreState(s); err != nil {
+		t.Fatal(err)
+	}
+	if x := b.Read(0x9000); x != 0x77 {
+		t.Fatalf("restored dummy: got %02x, expected 77", x)
+	}
+
+	tests := []struct {
+		name     string
+		start    uint16
+		size     int
+		expected error
+	}{
+		{"overlap start", 0x7e00, 0x0101, ErrOverlap},
+		{"overlap end", 0x7fff, 0x0010, ErrOverlap},
+		{"overlap inside", 0x9004, 0x0001, ErrOverlap},
+		{"size", 0xff00, 0x0101, ErrInvalidSize},
+		{"empty", 0x8000, 0, ErrInvalidSize},
+		{"adjacent", 0x7e00, 0x0100, nil},
+	}
+	for _, test := range tests {
+		err := b.MapDevice(test.start, test.size, &videoRAM{})
+		if err != test.expected {
+			t.Fatalf("%v: got %v, expected %v", test.name, err,
+				test.expected)
+		}
+	}
+}
+
+func TestMapDeviceBanked(t *testing.T) {
+	b, err := cpuvilleBus([]byte{0x01, 0x02})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &videoRAM{}
+	if err = b.MapDevice(0x0001, 1, v); err != nil {
+		t.Fatal(err)
+	}
+	for _, port := range []uint16{0x00, 0x01} {
+		b.IOWrite(port, 0x00)
+		b.Write(0x0001, byte(port))
+		if x := b.Read(0x0001); x != byte(port) {
+			t.Fatalf("device: got %02x, expected %02x", x, port)
+		}
+		if x := b.Dump(0x0000, 1); x[0] != 0x01-byte(port) {
+			t.Fatalf("bank %v: got %02x", port, x[0])
+		}
+	}
+	if v.writes != 2 {
+		t.Fatalf("device writes: got %v, expected 2", v.writes)
+	}
+}
+
+// mute is a registered device that claims to interrupt but can't.
+type mute struct{}
+
+func (m mute) Write(address, data byte) {}
+func (m mute) Read(address byte) byte   { return 0xff }
+func (m mute) Reset()                   {}
+func (m mute) Shutdown()                {}
+
+// registers is a registered device that remembers the last byte written to
+// each of its ports and can interrupt.
+type registers struct {
+	ports     []byte
+	config    device.Config
+	resets    int
+	interrupt bool
+}
+
+func (r *registers) Write(address, data byte) { r.ports[address] = data }
+func (r *registers) Read(address byte) byte   { return r.ports[address] }
+func (r *registers) Reset()                   { r.resets++ }
+func (r *registers) Shutdown()                {}
+func (r *registers) Interrupt() bool          { return r.interrupt }
+func (r *registers) InterruptAck() byte       { return 0xff }
+
+var registerFiles []*registers
+
+func init() {
+	newRegisters := func(config device.Config) (device.Device, error) {
+		r := &registers{ports: make([]byte, 4), config: config}
+		registerFiles = append(registerFiles, r)
+		return r, nil
+	}
+	device.Register(device.Description{
+		Name:       "registers",
+		Ports:      4,
+		Interrupts: true,
+		Options:    []device.Option{{Name: "mode", Default: "a"}},
+		New:        newRegisters,
+	})
+	device.Register(device.Description{
+		Name:       "mute",
+		Ports:      1,
+		Interrupts: true,
+		New: func(config device.Config) (device.Device, error) {
+			return mute{}, nil
+		},
+	})
+}
+
+func TestPeripheral(t *testing.T) {
+	registerFiles = nil
+	devices := []Device{
+		{Name: "RAM", Start: 0x0000, Size: 0x0400, Type: DeviceRAM},
+		{Name: "a", Start: 0x40, Type: DevicePeripheral,
+			Peripheral: "registers"},
+		{Name: "b", Start: 0x80, Size: 4, Type: DevicePeripheral,
+			Peripheral: "registers", Options: map[string]string{
+				"mode": "b",
+			}},
+		{Name: "banked", Start: 0x0400, Size: 0x0400,
+			Type: DeviceBanked, Banks: []Bank{
+				{Type: DeviceRAM},
+				{Type: DeviceRAM},
+			}},
+		{Name: "mmu", Start: 0xf0, Size: 1, Type: DeviceMMU},
+	}
+	b, err := New(devices, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registerFiles) != 2 {
+		t.Fatalf("got %v registerFiles, expected 2", len(registerFiles))
+	}
+	a, r := registerFiles[0], registerFiles[1]
+	if a.config.Options["mode"] != "a" || r.config.Options["mode"] != "b" {
+		t.Fatalf("options: got %v and %v", a.config.Options,
+			r.config.Options)
+	}
+	if r.config.Space != device.SpaceIO || r.config.Start != 0x80 ||
+		r.config.Size != 4 {
+		t.Fatalf("config: got %+v", r.config)
+	}
+
+	for port := uint16(0x80); port < 0x84; port++ {
+		b.IOWrite(port, byte(port))
+	}
+	for port := uint16(0x80); port < 0x84; port++ {
+		if x := b.IORead(port); x != byte(port) {
+			t.Fatalf("port %02x: got %02x", port, x)
+		}
+	}
+	b.IORead(0x84)
+	assertFault(t, "unmapped", b, UnmappedIOError{
+		Port:   0x84,
+		Access: AccessRead,
+	})
+
+	r.interrupt = true
+	if !b.Interrupt() {
+		t.Fatalf("registers did not interrupt")
+	}
+
+	// Reset resets every device once and maps the first bank.
+	b.IOWrite(0xf0, 1)
+	b.Write(0x0400, 0x11)
+	b.RaiseNMI()
+	b.Reset()
+	if a.resets != 1 || r.resets != 1 {
+		t.Fatalf("resets: got %v and %v", a.resets, r.resets)
+	}
+	if x := b.Read(0x0400); x != 0x00 {
+		t.Fatalf("bank after reset: got %02x, expected 00", x)
+	}
+	if b.NMI() {
+		t.Fatalf("nmi after reset")
+	}
+
+	tests := []struct {
+		name     string
+		device   Device
+		expected error
+	}{
+		{"unknown", Device{Peripheral: "tape"}, ErrInvalidDeviceType},
+		{"size", Device{Start: 0x40, Size: 2, Peripheral: "registers"},
+			ErrInvalidSize},
+		{"ports", Device{Start: 0xfd, Peripheral: "registers"},
+			ErrInvalidSize},
+		{"option", Device{Peripheral: "registers",
+			Options: map[string]string{"speed": "1"}},
+			device.ErrInvalidOption},
+		{"memory", Device{Size: 4, Peripheral: "registers", Memory: true},
+			ErrInvalidSpace},
+		{"interrupt", Device{Peripheral: "mute"}, ErrInterrupter},
+	}
+	for _, test := range tests {
+		test.device.Type = DevicePeripheral
+		_, err := New([]Device{test.device}, make(chan string))
+		if err != test.expected {
+			t.Fatalf("%v: got %v, expected %v", test.name, err,
+				test.expected)
+		}
+	}
+}
+
+// keyboard is a wide device that reads the upper 8 bits of the port address
+// like the keyboard matrix of a home computer.
+type keyboard struct {
+	rows    [8]byte
+	written uint16
+}
+
+func (k *keyboard) Write(address, data byte) {}
+func (k *keyboard) Read(address byte) byte   { return 0x00 }
+func (k *keyboard) Reset()                   {}
+func (k *keyboard) Shutdown()                {}
+
+func (k *keyboard) ReadPort(address uint16) byte {
+	data := byte(0xff)
+	for row := uint(0); row < 8; row++ {
+		if address&(0x100<<row) == 0 {
+			data &= k.rows[row]
+		}
+	}
+	return data
+}
+
+func (k *keyboard) WritePort(address uint16, data byte) {
+	k.written = address
+}
+
+func TestIO16(t *testing.T) {
+	b, err := New([]Device{
+		{Name: "low", Start: 0xff, Type: DevicePeripheral,
+			Peripheral: "dummy"},
+		{Name: "paging", Start: 0x7ffd, Type: DevicePeripheral,
+			Peripheral: "dummy", Wide: true},
+		{Name: "mmu", Start: 0x1ffd, Size: 1, Type: DeviceMMU,
+			Wide: true},
+		{Name: "banked", Start: 0x0000, Size: 0x0400,
+			Type: DeviceBanked, Banks: []Bank{
+				{Type: DeviceRAM},
+				{Type: DeviceRAM},
+			}},
+	}, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Port $ff decodes on 8 bits and answers every upper byte.
+	b.IOWrite(0x12ff, 0x34)
+	if x := b.IORead(0xabff); x != 0x34 {
+		t.Fatalf("port ff: got %02x, expected 34", x)
+	}
+
+	// Wide devices only answer their own port address.
+	b.IOWrite(0x7ffd, 0x56)
+	if x := b.IORead(0x7ffd); x != 0x56 {
+		t.Fatalf("port 7ffd: got %02x, expected 56", x)
+	}
+	assertFault(t, "7ffd", b, nil)
+	b.IOWrite(0x1ffd, 0x01)
+	if x := b.IORead(0x1ffd); x != 0x01 {
+		t.Fatalf("port 1ffd: got %02x, expected 01", x)
+	}
+	b.IORead(0x00fd)
+	assertFault(t, "fd", b, UnmappedIOError{
+		Port:   0x00fd,
+		Access: AccessRead,
+	})
+	b.IOWrite(0x3ffd, 0x78)
+	err = b.Fault()
+	if err == nil || err.Error() != "unmapped I/O: write port $3ffd" {
+		t.Fatalf("3ffd: got fault %v", err)
+	}
+
+	// Devices that want the upper 8 bits get the whole address.
+	k := &keyboard{}
+	k.rows[1] = 0xfe
+	k.rows[3] = 0xef
+	if err = b.attachIO(k, 0xfe, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		port     uint16
+		expected byte
+	}{
+		{0xfffe, 0xff},
+		{0xfdfe, 0xfe},
+		{0xf7fe, 0xef},
+		{0xf5fe, 0xee},
+	}
+	for _, test := range tests {
+		if x := b.IORead(test.port); x != test.expected {
+			t.Fatalf("%04x: got %02x, expected %02x", test.port, x,
+				test.expected)
+		}
+	}
+	b.IOWrite(0x12fe, 0x00)
+	if k.written != 0x12fe {
+		t.Fatalf("write: got %04x, expected 12fe", k.written)
+	}
+
+	overlaps := []struct {
+		name  string
+		start uint16
+		size  int
+		wide  bool
+	}{
+		{"8 bit", 0xff, 1, false},
+		{"8 bit over wide", 0xfc, 2, false},
+		{"wide over 8 bit", 0x33ff, 1, true},
+		{"wide", 0x7ff0, 0x10, true},
+	}
+	for _, test := range overlaps {
+		err := b.attachIO(&keyboard{}, test.start, test.size, test.wide)
+		if err != ErrPortOverlap {
+			t.Fatalf("%v: got %v, expected %v", test.name, err,
+				ErrPortOverlap)
+		}
+	}
+	if err = b.attachIO(&keyboard{}, 0x7ffc, 1, true); err != nil {
+		t.Fatalf("adjacent: %v", err)
+	}
+	if err = b.attachIO(&keyboard{}, 0xffff, 2, true); err != ErrInvalidSize {
+		t.Fatalf("size: got %v, expected %v", err, ErrInvalidSize)
+	}
+}
+
+func TestWatchpoint(t *testing.T) {
+	b, err := New([]Device{
+		{Name: "RAM", Start: 0x0000, Size: 0x8000, Type: DeviceRAM},
+		{Name: "dummy", Start: 0x10, Type: DevicePeripheral,
+			Peripheral: "dummy"},
+	}, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Faults are ignored but watchpoints are not.
+	b.SetFaultPolicy(FaultIgnore)
+
+	watches := []Watchpoint{
+		{Start: 0x1000, End: 0x10ff, Write: true},
+		{Start: 0x2000, End: 0x2000, Read: true, Write: true,
+			HasValue: true, Value: 0x55},
+		{IO: true, Start: 0x10, End: 0x10, Read: true},
+		{Start: 0x8000, End: 0x8000, Read: true},
+	}
+	for i, w := range watches {
+		id, err := b.AddWatchpoint(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != i+1 {
+			t.Fatalf("id: got %v, expected %v", id, i+1)
+		}
+		watches[i].ID = id
+	}
+
+	tests := []struct {
+		name     string
+		access   func()
+		expected error
+	}{
+		{"read", func() { b.Read(0x1000) }, nil},
+		{"write", func() { b.Write(0x10ff, 0x12) }, WatchpointError{
+			Watchpoint: watches[0],
+			Address:    0x10ff,
+			Access:     AccessWrite,
+			Value:      0x12,
+		}},
+		{"outside", func() { b.Write(0x1100, 0x12) }, nil},
+		{"value", func() { b.Write(0x2000, 0x54) }, nil},
+		{"value write", func() { b.Write(0x2000, 0x55) },
+			WatchpointError{
+				Watchpoint: watches[1],
+				Address:    0x2000,
+				Access:     AccessWrite,
+				Value:      0x55,
+			}},
+		{"value read", func() { b.Read(0x2000) }, WatchpointError{
+			Watchpoint: watches[1],
+			Address:    0x2000,
+			Access:     AccessRead,
+			Value:      0x55,
+		}},
+		{"io write", func() { b.IOWrite(0x1210, 0x34) }, nil},
+		{"io read", func() { b.IORead(0x1210) }, WatchpointError{
+			Watchpoint: watches[2],
+			Address:    0x1210,
+			Access:     AccessRead,
+			Value:      0x34,
+		}},
+		{"unmapped", func() { b.Read(0x8000) }, WatchpointError{
+			Watchpoint: watches[3],
+			Address:    0x8000,
+			Access:     AccessRead,
+			Value:      0xff,
+		}},
+		{"first", func() {
+			b.Write(0x1000, 0x01)
+			b.Write(0x1001, 0x02)
+		}, WatchpointError{
+			Watchpoint: watches[0],
+			Address:    0x1000,
+			Access:     AccessWrite,
+			Value:      0x01,
+		}},
+	}
+	for _, test := range tests {
+		test.access()
+		assertFault(t, test.name, b, test.expected)
+	}
+	if x := b.Read(0x1001); x != 0x02 {
+		t.Fatalf("watched write: got %02x, expected 02", x)
+	}
+
+	// Watchpoints survive remapping memory and restoring state.
+	err = b.newMemoryRegion(Device{Start: 0x1000, Size: 0x0400,
+		Type: DeviceRAM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := b.SaveState()
+	if err = b.DelWatchpoint(1); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.RestoreState(s); err != nil {
+		t.Fatal(err)
+	}
+	b.Write(0x1000, 0x00)
+	assertFault(t, "deleted", b, nil)
+	if err = b.DelWatchpoint(1); err != ErrNoWatchpoint {
+		t.Fatalf("got %v, expected %v", err, ErrNoWatchpoint)
+	}
+	b.Write(0x2000, 0x55)
+	assertFault(t, "restored", b, WatchpointError{
+		Watchpoint: watches[1],
+		Address:    0x2000,
+		Access:     AccessWrite,
+		Value:      0x55,
+	})
+	if !reflect.DeepEqual(b.Watchpoints(), watches[1:]) {
+		t.Fatalf("watchpoints: got %v", b.Watchpoints())
+	}
+	for _, w := range []Watchpoint{
+		{Start: 0x2000, End: 0x1000, Read: true},
+		{Start: 0x2000, End: 0x2000},
+	} {
+		if _, err := b.AddWatchpoint(w); err != ErrInvalidWatchpoint {
+			t.Fatalf("%v: got %v, expected %v", w, err,
+				ErrInvalidWatchpoint)
+		}
+	}
+
+	if s := watches[1].String(); s != "2: memory $2000 rw = $55" {
+		t.Fatalf("string: got %v", s)
+	}
+	if s := watches[0].String(); s != "1: memory $1000-$10ff w" {
+		t.Fatalf("string: got %v", s)
+	}
+	err = WatchpointError{Watchpoint: watches[2], Address: 0x1210,
+		Access: AccessRead, Value: 0x34}
+	if s := err.Error(); s != "watchpoint 3: read port $1210 = $34" {
+		t.Fatalf("error: got %v", s)
+	}
+}
+
+// TestWatchpointConcurrent lists watchpoints and collects faults while
+// another goroutine accesses the bus, as the control window does while the
+// CPU runs.  Run it with -race.
+func TestWatchpointConcurrent(t *testing.T) {
+	b, err := fakeBus(0x1000, 0x1000, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.AddWatchpoint(Watchpoint{Start: 0x1000, End: 0x1000,
+		Read: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.AddWatchpoint(Watchpoint{IO: true, Start: 0x10, End: 0x10,
+		Write: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			b.Read(0x1000)
+			b.Read(0x8000)
+			b.IOWrite(0x10, byte(i))
+		}
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if len(b.Watchpoints()) != 2 {
+			t.Fatalf("watchpoints: got %v", b.Watchpoints())
+		}
+		b.Fault()
+	}
+}
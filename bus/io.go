@@ -0,0 +1,137 @@
+package bus
+
+import (
+	"errors"
+
+	"github.com/marcopeereboom/toyz80/device"
+)
+
+var ErrPortOverlap = errors.New("overlapping I/O devices")
+
+// ioPort is a device that decodes the ports start through start+size-1.  A
+// device that is not wide only decodes the lower 8 bits of the port address
+// and answers regardless of the upper 8 bits.
+type ioPort struct {
+	dev   device.Device
+	port  device.PortDevice // dev if it wants the full port address
+	start uint16
+	size  int
+	wide  bool
+}
+
+// decodes returns true if p decodes the port address.
+func (p *ioPort) decodes(address uint16) bool {
+	port := int(address)
+	if !p.wide {
+		port = int(byte(address))
+	}
+	return port >= int(p.start) && port < int(p.start)+p.size
+}
+
+// overlaps returns true if p and o both decode some port address.
+func (p *ioPort) overlaps(o *ioPort) bool {
+	if !p.wide && o.wide {
+		return o.overlaps(p)
+	}
+	for i := 0; i < p.size; i++ {
+		if o.decodes(p.start + uint16(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIO returns an error if size ports starting at start can't be decoded.
+// Devices that are not wide must fit in the 256 ports of the lower 8 bits.
+func (b *Bus) checkIO(start uint16, size int, wide bool) error {
+	max := IOMax
+	if wide {
+		max = 0x10000
+	}
+	if size <= 0 || int(start)+size > max {
+		return ErrInvalidSize
+	}
+	p := &ioPort{start: start, size: size, wide: wide}
+	for _, o := range b.ports {
+		if p.overlaps(o) {
+			return ErrPortOverlap
+		}
+	}
+	return nil
+}
+
+// attachIO decodes dev at size ports starting at start.
+func (b *Bus) attachIO(dev device.Device, start uint16, size int,
+	wide bool) error {
+	err := b.checkIO(start, size, wide)
+	if err != nil {
+		return err
+	}
+	p := &ioPort{
+		dev:   dev,
+		start: start,
+		size:  size,
+		wide:  wide,
+	}
+	p.port, _ = dev.(device.PortDevice)
+
+	b.ports = append(b.ports, p)
+	if size > IOMax {
+		size = IOMax
+	}
+	for i := 0; i < size; i++ {
+		port := byte(start + uint16(i))
+		b.io[port] = append(b.io[port], p)
+	}
+	return nil
+}
+
+// ioDevice returns the device that decodes the port address.
+func (b *Bus) ioDevice(address uint16) (*ioPort, bool) {
+	for _, p := range b.io[byte(address)] {
+		if p.decodes(address) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// IORead reads from the I/O port on the 16 bit address bus.  Reading a port
+// without a device is an UnmappedIOError and returns $ff.
+func (b *Bus) IORead(address uint16) byte {
+	data := b.ioRead(address)
+	b.watch(true, address, AccessRead, data)
+	return data
+}
+
+func (b *Bus) ioRead(address uint16) byte {
+	p, ok := b.ioDevice(address)
+	if !ok {
+		b.setFault(UnmappedIOError{Port: address, Access: AccessRead})
+		return 0xff
+	}
+	if p.port != nil {
+		return p.port.ReadPort(address)
+	}
+	return p.dev.Read(byte(address - p.start))
+}
+
+// IOWrite writes data to the I/O port on the 16 bit address bus.  Writing a
+// port without a device is an UnmappedIOError.
+func (b *Bus) IOWrite(address uint16, data byte) {
+	b.ioWrite(address, data)
+	b.watch(true, address, AccessWrite, data)
+}
+
+func (b *Bus) ioWrite(address uint16, data byte) {
+	p, ok := b.ioDevice(address)
+	if !ok {
+		b.setFault(UnmappedIOError{Port: address, Access: AccessWrite})
+		return
+	}
+	if p.port != nil {
+		p.port.WritePort(address, data)
+		return
+	}
+	p.dev.Write(byte(address-p.start), data)
+}
@@ -0,0 +1,123 @@
+package bus
+
+import (
+	"errors"
+
+	"github.com/marcopeereboom/toyz80/device"
+)
+
+var ErrOverlap = errors.New("overlapping memory mapped devices")
+
+// mappedDevice is a device that decodes start through end in memory space.
+type mappedDevice struct {
+	start uint16
+	end   uint16 // Last address, inclusive
+	dev   device.MemoryMapper
+}
+
+// MapDevice decodes dev at size bytes starting at start in memory space.
+// Accesses to the range go to the device instead of memory.  Memory that
+// shares a memory unit with the device but is outside the range still works.
+// The device is wired to INT if it is an Interrupter.
+func (b *Bus) MapDevice(start uint16, size int, dev device.MemoryMapper) error {
+	err := b.mapDevice(start, size, dev)
+	if err != nil {
+		return err
+	}
+	b.addInterrupter(dev)
+	return nil
+}
+
+// checkMapped returns an error if size bytes starting at start can't be
+// decoded by a device.
+func (b *Bus) checkMapped(start uint16, size int) error {
+	if size <= 0 || int(start)+size > MemoryMax {
+		return ErrInvalidSize
+	}
+	end := uint16(int(start) + size - 1)
+	for _, o := range b.mapped {
+		if start <= o.end && o.start <= end {
+			return ErrOverlap
+		}
+	}
+	return nil
+}
+
+func (b *Bus) mapDevice(start uint16, size int, dev device.MemoryMapper) error {
+	err := b.checkMapped(start, size)
+	if err != nil {
+		return err
+	}
+	m := &mappedDevice{
+		start: start,
+		end:   uint16(int(start) + size - 1),
+		dev:   dev,
+	}
+
+	b.mapped = append(b.mapped, m)
+	for i := m.start >> MemoryShift; i <= m.end>>MemoryShift; i++ {
+		b.mappedUnits[i] = append(b.mappedUnits[i], m)
+		b.memoryFlags[i] |= memoryFlagDevice
+	}
+	return nil
+}
+
+// mappedDevice returns the memory mapped device that decodes address.
+func (b *Bus) mappedDevice(address uint16) (*mappedDevice, bool) {
+	for _, m := range b.mappedUnits[address>>MemoryShift] {
+		if address >= m.start && address <= m.end {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// read reads memory that is banked, shared with a memory mapped device or
+// watched.
+func (b *Bus) read(address uint16) byte {
+	data := b.readUnit(address)
+	if b.memoryFlags[address>>MemoryShift]&memoryFlagWatch != 0 {
+		b.watch(false, address, AccessRead, data)
+	}
+	return data
+}
+
+func (b *Bus) readUnit(address uint16) byte {
+	idx := address >> MemoryShift
+	flags := b.memoryFlags[idx]
+	if flags&memoryFlagDevice != 0 {
+		if m, ok := b.mappedDevice(address); ok {
+			return m.dev.ReadMemory(address - m.start)
+		}
+	}
+	if flags&memoryFlagRead == 0 {
+		b.setFault(BusFaultError{Address: address, Access: AccessRead})
+		return 0xff
+	}
+	return b.unit(address)[address&(MemoryUnit-1)]
+}
+
+// write writes memory that is banked, shared with a memory mapped device or
+// watched.
+func (b *Bus) write(address uint16, data byte) {
+	b.writeUnit(address, data)
+	if b.memoryFlags[address>>MemoryShift]&memoryFlagWatch != 0 {
+		b.watch(false, address, AccessWrite, data)
+	}
+}
+
+func (b *Bus) writeUnit(address uint16, data byte) {
+	idx := address >> MemoryShift
+	flags := b.memoryFlags[idx]
+	if flags&memoryFlagDevice != 0 {
+		if m, ok := b.mappedDevice(address); ok {
+			m.dev.WriteMemory(address-m.start, data)
+			return
+		}
+	}
+	if flags&memoryFlagWrite == 0 {
+		b.setFault(BusFaultError{Address: address, Access: AccessWrite})
+		return
+	}
+	b.unit(address)[address&(MemoryUnit-1)] = data
+}
@@ -0,0 +1,173 @@
+package bus
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+var (
+	ErrInvalidWatchpoint = errors.New("invalid watchpoint")
+	ErrNoWatchpoint      = errors.New("no such watchpoint")
+)
+
+// Watchpoint stops the machine when a range of memory or I/O ports is
+// accessed.
+type Watchpoint struct {
+	ID       int    // Set by AddWatchpoint
+	IO       bool   // Watch I/O ports instead of memory, see triggers
+	Start    uint16 // First address or port
+	End      uint16 // Last address or port, inclusive
+	Read     bool   // Trigger on reads
+	Write    bool   // Trigger on writes
+	HasValue bool   // Only trigger when Value is read or written
+	Value    byte
+}
+
+func (w Watchpoint) String() string {
+	space := "memory"
+	if w.IO {
+		space = "I/O"
+	}
+	access := ""
+	if w.Read {
+		access += "r"
+	}
+	if w.Write {
+		access += "w"
+	}
+	s := fmt.Sprintf("%v: %v $%04x", w.ID, space, w.Start)
+	if w.End != w.Start {
+		s += fmt.Sprintf("-$%04x", w.End)
+	}
+	s += " " + access
+	if w.HasValue {
+		s += fmt.Sprintf(" = $%02x", w.Value)
+	}
+	return s
+}
+
+// triggers returns true if an access of address with data sets off w.  Like
+// most devices I/O watchpoints below port $100 ignore the upper 8 bits of the
+// port address.
+func (w *Watchpoint) triggers(address uint16, access Access, data byte) bool {
+	if w.IO && w.End <= 0xff {
+		address &= 0xff
+	}
+	if address < w.Start || address > w.End {
+		return false
+	}
+	if access == AccessRead && !w.Read || access == AccessWrite && !w.Write {
+		return false
+	}
+	return !w.HasValue || data == w.Value
+}
+
+// WatchpointError is reported when a watchpoint triggers.  Watchpoints are
+// reported regardless of the fault policy.
+type WatchpointError struct {
+	PC         uint16 // Instruction that caused the access, set by the CPU
+	Watchpoint Watchpoint
+	Address    uint16
+	Access     Access
+	Value      byte // Data read or written
+}
+
+func (we WatchpointError) Error() string {
+	space := ""
+	if we.Watchpoint.IO {
+		space = " port"
+	}
+	return fmt.Sprintf("watchpoint %v: %v%v $%04x = $%02x",
+		we.Watchpoint.ID, we.Access, space, we.Address, we.Value)
+}
+
+// AddWatchpoint adds w and returns its ID.  The CPU must be paused.
+func (b *Bus) AddWatchpoint(w Watchpoint) (int, error) {
+	if w.End < w.Start || !w.Read && !w.Write {
+		return 0, ErrInvalidWatchpoint
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.watchID++
+	w.ID = b.watchID
+	if w.IO {
+		b.ioWatches = append(b.ioWatches, w)
+	} else {
+		b.watches = append(b.watches, w)
+		b.watchUnits()
+	}
+	return w.ID, nil
+}
+
+// DelWatchpoint removes the watchpoint with ID id.  The CPU must be paused.
+func (b *Bus) DelWatchpoint(id int) error {
+	b.Lock()
+	defer b.Unlock()
+	for _, watches := range []*[]Watchpoint{&b.watches, &b.ioWatches} {
+		for i, w := range *watches {
+			if w.ID != id {
+				continue
+			}
+			*watches = append((*watches)[:i], (*watches)[i+1:]...)
+			b.watchUnits()
+			return nil
+		}
+	}
+	return ErrNoWatchpoint
+}
+
+// Watchpoints returns the watchpoints sorted by ID.
+func (b *Bus) Watchpoints() []Watchpoint {
+	b.Lock()
+	w := make([]Watchpoint, 0, len(b.watches)+len(b.ioWatches))
+	w = append(w, b.watches...)
+	w = append(w, b.ioWatches...)
+	b.Unlock()
+	sort.Slice(w, func(i, j int) bool {
+		return w[i].ID < w[j].ID
+	})
+	return w
+}
+
+// watchUnits flags the memory units that are watched so that accesses to
+// them leave the fast path.  It must be called with the lock held and the CPU
+// paused.
+func (b *Bus) watchUnits() {
+	for i := range b.memoryFlags {
+		b.memoryFlags[i] &^= memoryFlagWatch
+	}
+	for _, w := range b.watches {
+		for i := w.Start >> MemoryShift; i <= w.End>>MemoryShift; i++ {
+			b.memoryFlags[i] |= memoryFlagWatch
+		}
+	}
+}
+
+// watch reports the first memory or I/O watchpoint that an access triggers.
+// An earlier fault or watchpoint that has not been collected wins.
+func (b *Bus) watch(io bool, address uint16, access Access, data byte) {
+	b.Lock()
+	defer b.Unlock()
+	if b.fault != nil {
+		return
+	}
+	watches := b.watches
+	if io {
+		watches = b.ioWatches
+	}
+	for _, w := range watches {
+		if !w.triggers(address, access, data) {
+			continue
+		}
+		b.fault = WatchpointError{
+			Watchpoint: w,
+			Address:    address,
+			Access:     access,
+			Value:      data,
+		}
+		atomic.StoreInt32(&b.faulted, 1)
+		return
+	}
+}
@@ -12,9 +12,27 @@ import (
 
 var (
 	ErrInvalidAddress = errors.New("invalid address")
-	socketName        = "/tmp/toyz80.socket"
+	ErrInvalidState   = errors.New("invalid state")
 )
 
+func init() {
+	device.Register(device.Description{
+		Name:       "console",
+		Usage:      "i8251A serial console on a unix socket",
+		Ports:      2,
+		Memory:     true,
+		Interrupts: true,
+		Options: []device.Option{
+			{
+				Name:    "socket",
+				Usage:   "unix socket to await the connection on",
+				Default: "/tmp/toyz80.socket",
+			},
+		},
+		New: New,
+	})
+}
+
 // Console is a i8251A serial console.  This implementation is incomplete and
 // it only needs to emulate the bare necessities.
 type Console struct {
@@ -24,6 +42,8 @@ type Console struct {
 	data    byte
 	dataC   chan byte
 	mode    byte
+	command byte // last command instruction
+	rxReady bool // data holds a received byte
 
 	errorFlag bool
 	enableTx  bool
@@ -41,7 +61,10 @@ type Console struct {
 }
 
 var (
-	_ device.Device = (*Console)(nil)
+	_ device.Device       = (*Console)(nil)
+	_ device.Interrupter  = (*Console)(nil)
+	_ device.Snapshotter  = (*Console)(nil)
+	_ device.MemoryMapper = (*Console)(nil)
 )
 
 func (c *Console) Write(address, data byte) {
@@ -104,6 +127,7 @@ func (c *Console) Write(address, data byte) {
 		// bit 7 EH
 		//	80 hunt mode
 		//	00 normal operation
+		c.command = data
 		if data&0x01 == 0x01 {
 			c.enableTx = true
 		}
@@ -128,30 +152,139 @@ func (c *Console) Read(address byte) byte {
 	case 0x00: // data
 		c.Lock()
 		defer c.Unlock()
-		if c.data != 0xff {
-			a := c.data
-			c.data = 0xff
-			return a
+		if c.rxReady {
+			c.rxReady = false
+			return c.data
 		}
 		return 0xff
 	case 0x01: // status
-		var rv byte
-		select {
-		case data := <-c.dataC:
-			c.Lock()
-			c.data = data
-			c.Unlock()
-			rv = 0x03
-		default:
-			rv = 0x01
+		c.Lock()
+		defer c.Unlock()
+		if c.receive() {
+			return 0x03 // TXRDY | RXRDY
 		}
-		return rv //0x01 //| 0x02 // TXRDY | RXRDY
+		return 0x01 // TXRDY
 	default:
 	}
 
 	return 0xff
 }
 
+// WriteMemory writes the register selected by A0 so the data and control
+// registers repeat throughout the range the console is mapped at.
+func (c *Console) WriteMemory(address uint16, data byte) {
+	c.Write(byte(address&0x01), data)
+}
+
+// ReadMemory reads the register selected by A0.
+func (c *Console) ReadMemory(address uint16) byte {
+	return c.Read(byte(address & 0x01))
+}
+
+// receive latches a byte from the socket if there is room for it and returns
+// true if received data is waiting to be read.  Must be called with the lock
+// held.
+func (c *Console) receive() bool {
+	if c.rxReady {
+		return true
+	}
+	select {
+	case data := <-c.dataC:
+		c.data = data
+		c.rxReady = true
+	default:
+	}
+	return c.rxReady
+}
+
+// Interrupt asserts INT while received data is waiting to be read; RxRDY is
+// wired to the interrupt line.
+func (c *Console) Interrupt() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.cold || c.errorFlag || !c.enableRx {
+		return false
+	}
+	return c.receive()
+}
+
+// InterruptAck returns what the data bus floats to since the 8251A does not
+// supply a vector.  In IM 0 this executes rst $38.
+func (c *Console) InterruptAck() byte {
+	return 0xff
+}
+
+// Console state flags as saved by Snapshot.
+const (
+	stateCold = 1 << iota
+	stateError
+	stateTx
+	stateRx
+	stateRxReady
+)
+
+// Snapshot returns the mode, the last command, the receive buffer and the
+// state flags.  The socket is not part of the state.
+func (c *Console) Snapshot() []byte {
+	c.Lock()
+	defer c.Unlock()
+
+	var flags byte
+	if c.cold {
+		flags |= stateCold
+	}
+	if c.errorFlag {
+		flags |= stateError
+	}
+	if c.enableTx {
+		flags |= stateTx
+	}
+	if c.enableRx {
+		flags |= stateRx
+	}
+	if c.rxReady {
+		flags |= stateRxReady
+	}
+	return []byte{c.mode, c.command, c.data, flags}
+}
+
+// Restore replaces the console state with one returned by Snapshot.
+func (c *Console) Restore(state []byte) error {
+	if len(state) != 4 {
+		return ErrInvalidState
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.mode = state[0]
+	c.command = state[1]
+	c.data = state[2]
+	flags := state[3]
+	c.cold = flags&stateCold != 0
+	c.errorFlag = flags&stateError != 0
+	c.enableTx = flags&stateTx != 0
+	c.enableRx = flags&stateRx != 0
+	c.rxReady = flags&stateRxReady != 0
+	return nil
+}
+
+// Reset puts the console back in cold boot where it waits for a mode
+// instruction.
+func (c *Console) Reset() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.cold = true
+	c.errorFlag = true
+	c.enableTx = false
+	c.enableRx = false
+	c.mode = 0
+	c.command = 0
+	c.rxReady = false
+}
+
 func (c *Console) Shutdown() {
 	c.Lock()
 	defer c.Unlock()
@@ -164,14 +297,15 @@ func (c *Console) Shutdown() {
 	c.shutdownC <- c.shutdownReason
 }
 
-func New(shutdownC chan string) (interface{}, error) {
+func New(config device.Config) (device.Device, error) {
 	c := &Console{
 		errorFlag: true,
 		cold:      true,
 		dataC:     make(chan byte, 0),
-		shutdownC: shutdownC,
+		shutdownC: config.Shutdown,
 	}
 
+	socketName := config.Options["socket"]
 	l, err := net.Listen("unix", socketName)
 	if err != nil {
 		log.Fatal("listen error:", err)
@@ -8,15 +8,28 @@ import (
 
 var (
 	ErrInvalidAddress = errors.New("invalid address")
+	ErrInvalidState   = errors.New("invalid state")
 )
 
+func init() {
+	device.Register(device.Description{
+		Name:   "dummy",
+		Usage:  "returns the last byte written, for testing",
+		Ports:  1,
+		Memory: true,
+		New:    New,
+	})
+}
+
 // Dummy is a dummy device for testing.
 type Dummy struct {
 	last byte
 }
 
 var (
-	_ device.Device = (*Dummy)(nil)
+	_ device.Device       = (*Dummy)(nil)
+	_ device.Snapshotter  = (*Dummy)(nil)
+	_ device.MemoryMapper = (*Dummy)(nil)
 )
 
 func (d *Dummy) Write(address, data byte) {
@@ -27,9 +40,35 @@ func (d *Dummy) Read(address byte) byte {
 	return d.last
 }
 
+func (d *Dummy) Reset() {
+	d.last = 0xff
+}
+
 func (d *Dummy) Shutdown() {
 }
 
-func New() (interface{}, error) {
+func (d *Dummy) WriteMemory(address uint16, data byte) {
+	d.last = data
+}
+
+func (d *Dummy) ReadMemory(address uint16) byte {
+	return d.last
+}
+
+// Snapshot returns the last byte written.
+func (d *Dummy) Snapshot() []byte {
+	return []byte{d.last}
+}
+
+// Restore replaces the last byte written.
+func (d *Dummy) Restore(state []byte) error {
+	if len(state) != 1 {
+		return ErrInvalidState
+	}
+	d.last = state[0]
+	return nil
+}
+
+func New(config device.Config) (device.Device, error) {
 	return &Dummy{last: 0xff}, nil
 }
@@ -1,7 +1,40 @@
 package device
 
+// Device is a peripheral decoded in I/O space.  Addresses are relative to the
+// first port of the device.
 type Device interface {
 	Write(byte, byte) // Write single byte to address
 	Read(byte) byte   // Read single byte to address
+	Reset()           // Return to the power on state
 	Shutdown()        // Nicely shut device down
 }
+
+// Interrupter is implemented by devices that are wired to the maskable
+// interrupt line.  A device that supplies a multi byte instruction in
+// interrupt mode 0 is acknowledged once for every byte.
+type Interrupter interface {
+	Interrupt() bool    // Returns true when the device asserts INT
+	InterruptAck() byte // Data bus contents during interrupt acknowledge
+}
+
+// Snapshotter is implemented by devices that have internal state that is
+// saved and restored along with the machine.
+type Snapshotter interface {
+	Snapshot() []byte     // Returns the device state
+	Restore([]byte) error // Replaces the device state
+}
+
+// MemoryMapper is implemented by devices that can be decoded in memory space.
+// Addresses are relative to the start of the range the device is mapped at.
+type MemoryMapper interface {
+	ReadMemory(uint16) byte   // Read single byte from address
+	WriteMemory(uint16, byte) // Write single byte to address
+}
+
+// PortDevice is implemented by devices that need the full 16 bit port
+// address, for example because they read the upper 8 bits as data.  The bus
+// then calls ReadPort and WritePort instead of Read and Write.
+type PortDevice interface {
+	ReadPort(uint16) byte   // Read single byte from port address
+	WritePort(uint16, byte) // Write single byte to port address
+}
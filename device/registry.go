@@ -0,0 +1,113 @@
+package device
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrInvalidOption = errors.New("invalid option")
+)
+
+// Space is the address space a device is decoded in.
+type Space int
+
+const (
+	SpaceIO Space = iota
+	SpaceMemory
+)
+
+// Option is a configuration option of a device type.
+type Option struct {
+	Name    string
+	Usage   string
+	Default string
+}
+
+// Config is the configuration a device is created with.
+type Config struct {
+	Space   Space
+	Start   uint16            // First port or address
+	Size    int               // Ports or bytes decoded
+	Options map[string]string // Every option of the device type
+
+	// Shutdown receives the reason when the device shuts the machine
+	// down.
+	Shutdown chan string
+}
+
+// Description describes a device type.  The description is all the bus and
+// the command line need to know to add a device to the machine.
+type Description struct {
+	Name       string   // Name used to select the device
+	Usage      string   // One line description
+	Ports      int      // I/O ports the device decodes
+	Memory     bool     // Can be decoded in memory space as a MemoryMapper
+	Interrupts bool     // Wired to INT, the device is an Interrupter
+	Options    []Option // Configuration options
+
+	// New returns a device in its power on state.
+	New func(Config) (Device, error)
+}
+
+// OptionValues returns options with the defaults of the options that are not
+// set filled in.  Options the device does not have are an ErrInvalidOption.
+func (d *Description) OptionValues(options map[string]string) (
+	map[string]string, error) {
+	for name := range options {
+		if !d.hasOption(name) {
+			return nil, ErrInvalidOption
+		}
+	}
+	o := make(map[string]string, len(d.Options))
+	for _, option := range d.Options {
+		value, ok := options[option.Name]
+		if !ok {
+			value = option.Default
+		}
+		o[option.Name] = value
+	}
+	return o, nil
+}
+
+func (d *Description) hasOption(name string) bool {
+	for _, option := range d.Options {
+		if option.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var registry = make(map[string]*Description)
+
+// Register makes a device type available by name.  It is meant to be called
+// from the init function of the package that implements the device and
+// panics if the name is taken or the description is incomplete.
+func Register(d Description) {
+	if d.Name == "" || d.New == nil {
+		panic("device: incomplete description")
+	}
+	if _, ok := registry[d.Name]; ok {
+		panic("device: register called twice for " + d.Name)
+	}
+	registry[d.Name] = &d
+}
+
+// Lookup returns the description of the device type called name.
+func Lookup(name string) (*Description, bool) {
+	d, ok := registry[name]
+	return d, ok
+}
+
+// Devices returns the descriptions of all device types sorted by name.
+func Devices() []*Description {
+	devices := make([]*Description, 0, len(registry))
+	for _, d := range registry {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices
+}
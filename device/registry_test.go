@@ -0,0 +1,82 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+// nop is a device that ignores everything.
+type nop struct{}
+
+func (n nop) Write(address, data byte) {}
+func (n nop) Read(address byte) byte   { return 0xff }
+func (n nop) Reset()                   {}
+func (n nop) Shutdown()                {}
+
+func newNop(config Config) (Device, error) {
+	return nop{}, nil
+}
+
+func TestRegister(t *testing.T) {
+	Register(Description{Name: "nop b", New: newNop})
+	Register(Description{Name: "nop a", New: newNop, Options: []Option{
+		{Name: "speed", Default: "9600"},
+		{Name: "parity"},
+	}})
+
+	var names []string
+	for _, d := range Devices() {
+		names = append(names, d.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"nop a", "nop b"}) {
+		t.Fatalf("devices: got %v", names)
+	}
+	if _, ok := Lookup("nop c"); ok {
+		t.Fatalf("lookup found nop c")
+	}
+
+	d, ok := Lookup("nop a")
+	if !ok {
+		t.Fatalf("lookup did not find nop a")
+	}
+	tests := []struct {
+		name     string
+		options  map[string]string
+		expected map[string]string
+		err      error
+	}{
+		{"defaults", nil,
+			map[string]string{"speed": "9600", "parity": ""}, nil},
+		{"set", map[string]string{"parity": "even"},
+			map[string]string{"speed": "9600", "parity": "even"}, nil},
+		{"invalid", map[string]string{"stop": "2"}, nil,
+			ErrInvalidOption},
+	}
+	for _, test := range tests {
+		options, err := d.OptionValues(test.options)
+		if err != test.err {
+			t.Fatalf("%v: got %v, expected %v", test.name, err,
+				test.err)
+		}
+		if !reflect.DeepEqual(options, test.expected) {
+			t.Fatalf("%v: got %v, expected %v", test.name,
+				options, test.expected)
+		}
+	}
+
+	for _, d := range []Description{
+		{Name: "nop a", New: newNop},
+		{Name: "nop d"},
+		{New: newNop},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%v: register did not panic",
+						d.Name)
+				}
+			}()
+			Register(d)
+		}()
+	}
+}
@@ -0,0 +1,8 @@
+package main
+
+// Peripherals that can be selected with device=.  A new device registers
+// itself from its package and only needs to be added here.
+import (
+	_ "github.com/marcopeereboom/toyz80/device/console"
+	_ "github.com/marcopeereboom/toyz80/device/dummy"
+)
@@ -0,0 +1,31 @@
+package loader
+
+const (
+	comOrigin  = 0x0100 // Start of the CP/M transient program area
+	comBDOS    = 0xfe00 // BDOS entry, the top of the transient program area
+	comMaxSize = comBDOS - comOrigin
+)
+
+// ReadCOM decodes a CP/M .COM file.  The image is placed at $0100 and started
+// there.  A minimal zero page is added: the warm boot at $0000 halts the
+// machine and $0005 jumps to a BDOS entry at $fe00 that returns to the caller,
+// so a breakpoint on $0005 is needed to emulate BDOS functions.  Programs that
+// take the top of memory from $0006 get $fe00 and the image has to fit below
+// it.
+func ReadCOM(data []byte) (*Image, error) {
+	if len(data) > comMaxSize {
+		return nil, ErrAddressRange
+	}
+
+	pc := uint16(comOrigin)
+	return &Image{
+		Chunks: []Chunk{
+			{Address: 0x0000, Data: []byte{0xf3, 0x76}}, // di; halt
+			{Address: 0x0005, Data: []byte{0xc3, comBDOS & 0xff,
+				comBDOS >> 8}}, // jp bdos
+			{Address: comOrigin, Data: data},
+			{Address: comBDOS, Data: []byte{0xc9}}, // ret
+		},
+		Start: &pc,
+	}, nil
+}
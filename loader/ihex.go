@@ -0,0 +1,138 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+)
+
+// Intel HEX record types.
+const (
+	ihexData                   = 0x00
+	ihexEOF                    = 0x01
+	ihexExtendedSegmentAddress = 0x02
+	ihexStartSegmentAddress    = 0x03
+	ihexExtendedLinearAddress  = 0x04
+	ihexStartLinearAddress     = 0x05
+)
+
+var (
+	ErrMissingEOF = errors.New("missing end of file record")
+)
+
+// isIntelHex returns true if data looks like an Intel HEX file.
+func isIntelHex(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(":"))
+}
+
+// ReadIntelHex decodes an Intel HEX file.  Every data record is placed at its
+// own address and the start address, if present, is returned in Start.
+// Extended addresses must stay within the 64K address space.
+func ReadIntelHex(data []byte) (*Image, error) {
+	var (
+		img  Image
+		base uint32 // Extended segment or linear address
+		eof  bool
+	)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		text := bytes.TrimSpace(s.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if eof {
+			return nil, LineError{Line: line, Err: ErrInvalidRecord}
+		}
+
+		record, err := ihexRecord(text)
+		if err != nil {
+			return nil, LineError{Line: line, Err: err}
+		}
+		count := record[0]
+		address := uint32(record[1])<<8 | uint32(record[2])
+		payload := record[4 : 4+count]
+
+		switch record[3] {
+		case ihexData:
+			address += base
+			if address+uint32(count) > 0x10000 {
+				return nil, LineError{Line: line,
+					Err: ErrAddressRange}
+			}
+			img.Chunks = append(img.Chunks, Chunk{
+				Address: uint16(address),
+				Data:    payload,
+			})
+		case ihexEOF:
+			eof = true
+		case ihexExtendedSegmentAddress, ihexExtendedLinearAddress:
+			if count != 2 {
+				return nil, LineError{Line: line,
+					Err: ErrInvalidRecord}
+			}
+			base = uint32(payload[0])<<8 | uint32(payload[1])
+			if record[3] == ihexExtendedSegmentAddress {
+				base <<= 4
+			} else {
+				base <<= 16
+			}
+		case ihexStartSegmentAddress, ihexStartLinearAddress:
+			if count != 4 {
+				return nil, LineError{Line: line,
+					Err: ErrInvalidRecord}
+			}
+			// Segment is CS:IP, linear is EIP.
+			start := uint32(payload[2])<<8 | uint32(payload[3])
+			high := uint32(payload[0])<<8 | uint32(payload[1])
+			if record[3] == ihexStartSegmentAddress {
+				start += high << 4
+			} else {
+				start += high << 16
+			}
+			if start > 0xffff {
+				return nil, LineError{Line: line,
+					Err: ErrAddressRange}
+			}
+			pc := uint16(start)
+			img.Start = &pc
+		default:
+			return nil, LineError{Line: line, Err: ErrInvalidRecord}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if !eof {
+		return nil, ErrMissingEOF
+	}
+
+	return &img, nil
+}
+
+// ihexRecord decodes a single :llaaaatt[dd...]cc record and verifies its
+// length and checksum.
+func ihexRecord(text []byte) ([]byte, error) {
+	if text[0] != ':' || len(text) < 11 || len(text)%2 != 1 {
+		return nil, ErrInvalidRecord
+	}
+	record := make([]byte, len(text)/2)
+	_, err := hex.Decode(record, text[1:])
+	if err != nil {
+		return nil, ErrInvalidRecord
+	}
+	if int(record[0])+5 != len(record) {
+		return nil, ErrInvalidRecord
+	}
+
+	var sum byte
+	for _, b := range record[:len(record)-1] {
+		sum += b
+	}
+	checksum := record[len(record)-1]
+	if expected := -sum; checksum != expected {
+		return nil, ChecksumError{Checksum: checksum, Expected: expected}
+	}
+
+	return record, nil
+}
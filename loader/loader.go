@@ -0,0 +1,90 @@
+// Package loader reads program and machine images in formats produced by
+// other tools and emulators.
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcopeereboom/toyz80/z80"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown image format")
+	ErrInvalidRecord = errors.New("invalid record")
+	ErrAddressRange  = errors.New("address out of range")
+)
+
+// LineError reports the line of a text image that could not be decoded.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (le LineError) Error() string {
+	return fmt.Sprintf("line %v: %v", le.Line, le.Err)
+}
+
+// ChecksumError is reported when the checksum of a record does not match its
+// contents.
+type ChecksumError struct {
+	Checksum byte // Checksum in the record
+	Expected byte // Checksum of the record contents
+}
+
+func (ce ChecksumError) Error() string {
+	return fmt.Sprintf("checksum $%02x, expected $%02x", ce.Checksum,
+		ce.Expected)
+}
+
+// Chunk is a run of bytes that is placed in memory at Address.
+type Chunk struct {
+	Address uint16
+	Data    []byte
+}
+
+// Image is a file that has been decoded and is ready to be placed in the
+// machine.
+type Image struct {
+	Chunks    []Chunk        // Memory contents
+	Registers *z80.Registers // CPU registers, nil if the image has none
+	Start     *uint16        // Start address, nil if the image has none
+}
+
+// Load reads filename and decodes it based on its extension.  Files with an
+// unknown extension are decoded if their contents can be recognized.
+func Load(filename string) (*Image, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".sna":
+		return ReadSNA(data)
+	case ".z80":
+		return ReadZ80(data)
+	case ".hex", ".ihx":
+		return ReadIntelHex(data)
+	case ".s19", ".s28", ".s37", ".srec", ".mot":
+		return ReadSRecord(data)
+	case ".com":
+		return ReadCOM(data)
+	}
+
+	switch {
+	case isIntelHex(data):
+		return ReadIntelHex(data)
+	case isSRecord(data):
+		return ReadSRecord(data)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// word returns the little endian word at data[offset].
+func word(data []byte, offset int) uint16 {
+	return uint16(data[offset]) | uint16(data[offset+1])<<8
+}
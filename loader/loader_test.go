@@ -0,0 +1,470 @@
+package loader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/marcopeereboom/toyz80/z80"
+)
+
+// z80Header returns a .z80 version 1 header.
+func z80Header(pc uint16, flags byte) []byte {
+	return []byte{
+		0x12, 0x34, // a, f
+		0x01, 0x02, // bc
+		0x03, 0x04, // hl
+		byte(pc), byte(pc >> 8),
+		0x00, 0x80, // sp
+		0x3f,       // i
+		0x05,       // r
+		flags,      // r bit 7, border, compressed
+		0x06, 0x07, // de
+		0x08, 0x09, // bc'
+		0x0a, 0x0b, // de'
+		0x0c, 0x0d, // hl'
+		0x56, 0x78, // a', f'
+		0x0e, 0x0f, // iy
+		0x10, 0x11, // ix
+		0x01, 0x01, // iff1, iff2
+		0x01, // im
+	}
+}
+
+// z80Registers are the registers in z80Header.
+func z80Registers(pc uint16) *z80.Registers {
+	return &z80.Registers{
+		AF: 0x1234, BC: 0x0201, HL: 0x0403, PC: pc, SP: 0x8000,
+		I: 0x3f, R: 0x85, DE: 0x0706, BC_: 0x0908, DE_: 0x0b0a,
+		HL_: 0x0d0c, AF_: 0x5678, IY: 0x0f0e, IX: 0x1110,
+		IFF1: 1, IFF2: 1, IM: 1,
+	}
+}
+
+// rle returns count times value in .z80 run length encoding.
+func rle(value byte, count int) []byte {
+	var data []byte
+	for ; count > 255; count -= 255 {
+		data = append(data, 0xed, 0xed, 0xff, value)
+	}
+	return append(data, 0xed, 0xed, byte(count), value)
+}
+
+// z80Page returns a .z80 version 2 page that is filled with value.  The page
+// is compressed if compressed is true.
+func z80Page(number, value byte, compressed bool) []byte {
+	if !compressed {
+		return append([]byte{0xff, 0xff, number},
+			bytes.Repeat([]byte{value}, pageSize)...)
+	}
+	page := rle(value, pageSize)
+	return append([]byte{byte(len(page)), byte(len(page) >> 8), number},
+		page...)
+}
+
+func TestReadSNA(t *testing.T) {
+	data := make([]byte, snaHeaderSize+ram48Size)
+	copy(data, []byte{
+		0x3f,       // i
+		0x01, 0x02, // hl'
+		0x03, 0x04, // de'
+		0x05, 0x06, // bc'
+		0x07, 0x08, // af'
+		0x09, 0x0a, // hl
+		0x0b, 0x0c, // de
+		0x0d, 0x0e, // bc
+		0x0f, 0x10, // iy
+		0x11, 0x12, // ix
+		0x04,       // iff2
+		0x13,       // r
+		0x14, 0x15, // af
+		0xfe, 0x7f, // sp
+		0x02, // im
+		0x07, // border
+	})
+	data[snaHeaderSize+0x7ffe-ram48Origin] = 0x34
+	data[snaHeaderSize+0x7fff-ram48Origin] = 0x12
+
+	img, err := ReadSNA(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &z80.Registers{
+		I: 0x3f, HL_: 0x0201, DE_: 0x0403, BC_: 0x0605, AF_: 0x0807,
+		HL: 0x0a09, DE: 0x0c0b, BC: 0x0e0d, IY: 0x100f, IX: 0x1211,
+		IFF1: 1, IFF2: 1, R: 0x13, AF: 0x1514, SP: 0x8000, IM: 2,
+		PC: 0x1234,
+	}
+	if !reflect.DeepEqual(img.Registers, expected) {
+		t.Fatalf("got %+v expected %+v", img.Registers, expected)
+	}
+	if len(img.Chunks) != 1 || img.Chunks[0].Address != ram48Origin ||
+		len(img.Chunks[0].Data) != ram48Size {
+		t.Fatalf("invalid chunks")
+	}
+
+	if _, err = ReadSNA(data[1:]); err != ErrInvalidSNA {
+		t.Fatalf("got %v expected %v", err, ErrInvalidSNA)
+	}
+}
+
+func TestReadZ80(t *testing.T) {
+	v1 := append(z80Header(0x1234, 0x21),
+		0x01, 0xed, 0xed, 0x05, 0xaa, 0xed, 0x02)
+	v1 = append(v1, rle(0x00, ram48Size-8)...)
+	v1 = append(v1, 0x00, 0xed, 0xed, 0x00) // end marker
+
+	v2 := append(z80Header(0, 0x01), 23, 0, 0x78, 0x56, 0)
+	v2 = append(v2, make([]byte, 23-3)...)
+	v2 = append(v2, z80Page(8, 0x11, true)...)
+	v2 = append(v2, z80Page(4, 0x22, false)...)
+	v2 = append(v2, z80Page(0, 0x33, true)...) // ROM is ignored
+
+	v3 := append(z80Header(0, 0x01), 54, 0, 0x78, 0x56, 4, 0x07)
+	v3 = append(v3, make([]byte, 54-4)...)
+	v3 = append(v3, z80Page(8, 0x11, true)...)
+	v3 = append(v3, z80Page(10, 0x77, true)...)
+	v3 = append(v3, z80Page(3, 0x00, true)...) // bank 0 is paged out
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []Chunk
+		pc       uint16
+		err      error
+	}{
+		{
+			name: "version 1",
+			data: v1,
+			pc:   0x1234,
+			expected: []Chunk{{
+				Address: 0x4000,
+				Data: append([]byte{0x01, 0xaa, 0xaa, 0xaa,
+					0xaa, 0xaa, 0xed, 0x02},
+					make([]byte, ram48Size-8)...),
+			}},
+		},
+		{
+			name: "version 2",
+			data: v2,
+			pc:   0x5678,
+			expected: []Chunk{
+				{
+					Address: 0x4000,
+					Data:    bytes.Repeat([]byte{0x11}, pageSize),
+				},
+				{
+					Address: 0x8000,
+					Data:    bytes.Repeat([]byte{0x22}, pageSize),
+				},
+			},
+		},
+		{
+			name: "version 3 128K",
+			data: v3,
+			pc:   0x5678,
+			expected: []Chunk{
+				{
+					Address: 0x4000,
+					Data:    bytes.Repeat([]byte{0x11}, pageSize),
+				},
+				{
+					Address: 0xc000,
+					Data:    bytes.Repeat([]byte{0x77}, pageSize),
+				},
+			},
+		},
+		{
+			name: "short header",
+			data: v1[:29],
+			err:  ErrInvalidZ80,
+		},
+		{
+			name: "short page",
+			data: v2[:len(v2)-1],
+			err:  ErrInvalidZ80,
+		},
+		{
+			name: "short compressed image",
+			data: v1[:len(v1)-8],
+			err:  ErrInvalidZ80,
+		},
+	}
+
+	for _, test := range tests {
+		img, err := ReadZ80(test.data)
+		if err != test.err {
+			t.Fatalf("%v: got %v expected %v", test.name, err,
+				test.err)
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(img.Registers, z80Registers(test.pc)) {
+			t.Fatalf("%v: got %+v expected %+v", test.name,
+				img.Registers, z80Registers(test.pc))
+		}
+		if !reflect.DeepEqual(img.Chunks, test.expected) {
+			t.Fatalf("%v: invalid chunks", test.name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "IMAGE.Z80")
+	err = ioutil.WriteFile(filename, append(z80Header(0x1234, 0x00),
+		make([]byte, ram48Size)...), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Registers.PC != 0x1234 {
+		t.Fatalf("got pc %04x expected 1234", img.Registers.PC)
+	}
+
+	// Intel HEX is recognized without an extension.
+	filename = filepath.Join(dir, "hello")
+	err = ioutil.WriteFile(filename,
+		[]byte(":040000050000018076\n:00000001FF\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Start == nil || *img.Start != 0x0180 {
+		t.Fatalf("got start %v expected 0180", img.Start)
+	}
+
+	filename = filepath.Join(dir, "image.bin")
+	err = ioutil.WriteFile(filename, []byte{0x00}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(filename); err != ErrUnknownFormat {
+		t.Fatalf("got %v expected %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestReadIntelHex(t *testing.T) {
+	pc := uint16(0x0180)
+	tests := []struct {
+		name     string
+		data     string
+		expected *Image
+		err      error
+	}{
+		{
+			name: "data",
+			data: ":0300000000C3C377\n" +
+				":020180003E013E\r\n" +
+				"\n" +
+				":00000001FF\n",
+			expected: &Image{Chunks: []Chunk{
+				{Address: 0x0000, Data: []byte{0x00, 0xc3, 0xc3}},
+				{Address: 0x0180, Data: []byte{0x3e, 0x01}},
+			}},
+		},
+		{
+			name: "start linear address",
+			data: ":040000050000018076\n" +
+				":00000001FF\n",
+			expected: &Image{Start: &pc},
+		},
+		{
+			name: "start segment address",
+			data: ":040000030010008069\n" +
+				":00000001FF\n",
+			expected: &Image{Start: &pc},
+		},
+		{
+			name: "extended segment address",
+			data: ":020000020100FB\n" +
+				":010000007689\n" +
+				":00000001FF\n",
+			expected: &Image{Chunks: []Chunk{
+				{Address: 0x1000, Data: []byte{0x76}},
+			}},
+		},
+		{
+			name: "checksum",
+			data: ":0300000000C3C378\n",
+			err: LineError{Line: 1, Err: ChecksumError{
+				Checksum: 0x78,
+				Expected: 0x77,
+			}},
+		},
+		{
+			name: "length",
+			data: ":00000001FF\n:0400000000C3C377\n",
+			err:  LineError{Line: 2, Err: ErrInvalidRecord},
+		},
+		{
+			name: "extended linear address",
+			data: ":020000040001F9\n:010000007689\n",
+			err:  LineError{Line: 2, Err: ErrAddressRange},
+		},
+		{
+			name: "wrap",
+			data: ":02FFFF00000000\n",
+			err:  LineError{Line: 1, Err: ErrAddressRange},
+		},
+		{
+			name: "record type",
+			data: ":00000006FA\n",
+			err:  LineError{Line: 1, Err: ErrInvalidRecord},
+		},
+		{
+			name: "eof",
+			data: ":010000007689\n",
+			err:  ErrMissingEOF,
+		},
+	}
+
+	for _, test := range tests {
+		img, err := ReadIntelHex([]byte(test.data))
+		if !reflect.DeepEqual(err, test.err) {
+			t.Fatalf("%v: got %v expected %v", test.name, err,
+				test.err)
+		}
+		if !reflect.DeepEqual(img, test.expected) {
+			t.Fatalf("%v: got %+v expected %+v", test.name, img,
+				test.expected)
+		}
+	}
+}
+
+func TestReadSRecord(t *testing.T) {
+	pc := uint16(0x0180)
+	tests := []struct {
+		name     string
+		data     string
+		expected *Image
+		err      error
+	}{
+		{
+			name: "S19",
+			data: "S00600004844521B\n" +
+				"S10500003E01BB\r\n" +
+				"S1040180C9B1\n" +
+				"\n" +
+				"S5030002FA\n" +
+				"S90301807B\n",
+			expected: &Image{
+				Chunks: []Chunk{
+					{Address: 0x0000, Data: []byte{0x3e, 0x01}},
+					{Address: 0x0180, Data: []byte{0xc9}},
+				},
+				Start: &pc,
+			},
+		},
+		{
+			name: "S28",
+			data: "S2060001003E01B9\nS8040001807A\n",
+			expected: &Image{
+				Chunks: []Chunk{
+					{Address: 0x0100, Data: []byte{0x3e, 0x01}},
+				},
+				Start: &pc,
+			},
+		},
+		{
+			name: "S37",
+			data: "S30700001000760072\nS7050000018079\n",
+			expected: &Image{
+				Chunks: []Chunk{
+					{Address: 0x1000, Data: []byte{0x76, 0x00}},
+				},
+				Start: &pc,
+			},
+		},
+		{
+			name: "checksum",
+			data: "S00600004844521B\nS10500003E01BC\n",
+			err: LineError{Line: 2, Err: ChecksumError{
+				Checksum: 0xbc,
+				Expected: 0xbb,
+			}},
+		},
+		{
+			name: "length",
+			data: "S10600003E01BB\n",
+			err:  LineError{Line: 1, Err: ErrInvalidRecord},
+		},
+		{
+			name: "address",
+			data: "S2050100007683\n",
+			err:  LineError{Line: 1, Err: ErrAddressRange},
+		},
+		{
+			name: "record type",
+			data: "S4030000FC\n",
+			err:  LineError{Line: 1, Err: ErrInvalidRecord},
+		},
+	}
+
+	for _, test := range tests {
+		img, err := ReadSRecord([]byte(test.data))
+		if !reflect.DeepEqual(err, test.err) {
+			t.Fatalf("%v: got %v expected %v", test.name, err,
+				test.err)
+		}
+		if !reflect.DeepEqual(img, test.expected) {
+			t.Fatalf("%v: got %+v expected %+v", test.name, img,
+				test.expected)
+		}
+	}
+}
+
+func TestReadCOM(t *testing.T) {
+	img, err := ReadCOM([]byte{0x0e, 0x00, 0xcd, 0x05, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := uint16(0x0100)
+	expected := &Image{
+		Chunks: []Chunk{
+			{Address: 0x0000, Data: []byte{0xf3, 0x76}},
+			{Address: 0x0005, Data: []byte{0xc3, 0x00, 0xfe}},
+			{Address: 0x0100, Data: []byte{0x0e, 0x00, 0xcd, 0x05,
+				0x00}},
+			{Address: 0xfe00, Data: []byte{0xc9}},
+		},
+		Start: &pc,
+	}
+	if !reflect.DeepEqual(img, expected) {
+		t.Fatalf("got %+v expected %+v", img, expected)
+	}
+
+	// The top of memory at $0006 must be above the stack of a program
+	// doing ld hl,($0006); ld sp,hl.
+	var zero [0x100]byte
+	for _, c := range img.Chunks {
+		if c.Address < uint16(len(zero)) {
+			copy(zero[c.Address:], c.Data)
+		}
+	}
+	if top := uint16(zero[6]) | uint16(zero[7])<<8; top != 0xfe00 {
+		t.Fatalf("top of memory got $%04x expected $fe00", top)
+	}
+
+	if _, err = ReadCOM(make([]byte, 0xfd00)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadCOM(make([]byte, 0xfd01)); err != ErrAddressRange {
+		t.Fatalf("got %v expected %v", err, ErrAddressRange)
+	}
+}
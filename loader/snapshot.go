@@ -0,0 +1,221 @@
+package loader
+
+import (
+	"errors"
+
+	"github.com/marcopeereboom/toyz80/z80"
+)
+
+const (
+	snaHeaderSize = 27
+	ram48Origin   = 0x4000 // RAM of a 48K machine
+	ram48Size     = 0xc000
+	pageSize      = 0x4000
+)
+
+var (
+	ErrInvalidSNA = errors.New("invalid SNA snapshot")
+	ErrInvalidZ80 = errors.New("invalid Z80 snapshot")
+)
+
+// ReadSNA decodes a 48K .sna snapshot.  The snapshot was taken during an
+// interrupt so pc is popped off the stack, as retn would do.
+func ReadSNA(data []byte) (*Image, error) {
+	if len(data) != snaHeaderSize+ram48Size {
+		return nil, ErrInvalidSNA
+	}
+
+	memory := data[snaHeaderSize:]
+	r := z80.Registers{
+		I:   data[0],
+		HL_: word(data, 1),
+		DE_: word(data, 3),
+		BC_: word(data, 5),
+		AF_: word(data, 7),
+		HL:  word(data, 9),
+		DE:  word(data, 11),
+		BC:  word(data, 13),
+		IY:  word(data, 15),
+		IX:  word(data, 17),
+		R:   data[20],
+		AF:  word(data, 21),
+		SP:  word(data, 23),
+		IM:  data[25] & 0x03,
+	}
+	if data[19]&0x04 != 0 {
+		r.IFF1 = 1
+		r.IFF2 = 1
+	}
+
+	// The stack has to be in the image for pc to be recovered.
+	if r.SP < ram48Origin || r.SP == 0xffff {
+		return nil, ErrInvalidSNA
+	}
+	r.PC = word(memory, int(r.SP-ram48Origin))
+	r.SP += 2
+
+	return &Image{
+		Chunks:    []Chunk{{Address: ram48Origin, Data: memory}},
+		Registers: &r,
+	}, nil
+}
+
+// ReadZ80 decodes a version 1, 2 or 3 .z80 snapshot.  Only the RAM that is
+// visible in the 64K address space is loaded; ROM and the pages of other
+// hardware are ignored.
+func ReadZ80(data []byte) (*Image, error) {
+	if len(data) < 30 {
+		return nil, ErrInvalidZ80
+	}
+
+	flags := data[12]
+	if flags == 0xff {
+		// Compatibility with old snapshots.
+		flags = 0x01
+	}
+	r := z80.Registers{
+		AF:  uint16(data[0])<<8 | uint16(data[1]),
+		BC:  word(data, 2),
+		HL:  word(data, 4),
+		PC:  word(data, 6),
+		SP:  word(data, 8),
+		I:   data[10],
+		R:   data[11]&0x7f | (flags&0x01)<<7,
+		DE:  word(data, 13),
+		BC_: word(data, 15),
+		DE_: word(data, 17),
+		HL_: word(data, 19),
+		AF_: uint16(data[21])<<8 | uint16(data[22]),
+		IY:  word(data, 23),
+		IX:  word(data, 25),
+		IM:  data[29] & 0x03,
+	}
+	if data[27] != 0 {
+		r.IFF1 = 1
+	}
+	if data[28] != 0 {
+		r.IFF2 = 1
+	}
+
+	// Version 1 is a 48K memory image following the header.
+	if r.PC != 0 {
+		memory := data[30:]
+		if flags&0x20 != 0 {
+			var err error
+			memory, err = decompress(memory, ram48Size)
+			if err != nil {
+				return nil, err
+			}
+		} else if len(memory) != ram48Size {
+			return nil, ErrInvalidZ80
+		}
+		return &Image{
+			Chunks:    []Chunk{{Address: ram48Origin, Data: memory}},
+			Registers: &r,
+		}, nil
+	}
+
+	// Versions 2 and 3 have an additional header followed by pages.
+	if len(data) < 32 {
+		return nil, ErrInvalidZ80
+	}
+	extra := int(word(data, 30))
+	if extra < 4 || len(data) < 32+extra {
+		return nil, ErrInvalidZ80
+	}
+	r.PC = word(data, 32)
+	pages := pageMap48
+	hardware := data[34]
+	if (extra == 23 && hardware >= 3) || (extra != 23 && hardware >= 4) {
+		pages = pageMap128(data[35])
+	}
+
+	img := &Image{Registers: &r}
+	for p := 32 + extra; p < len(data); {
+		if p+3 > len(data) {
+			return nil, ErrInvalidZ80
+		}
+		length := int(word(data, p))
+		number := data[p+2]
+		p += 3
+
+		var (
+			memory []byte
+			err    error
+		)
+		if length == 0xffff {
+			if p+pageSize > len(data) {
+				return nil, ErrInvalidZ80
+			}
+			memory = data[p : p+pageSize]
+			p += pageSize
+		} else {
+			if p+length > len(data) {
+				return nil, ErrInvalidZ80
+			}
+			memory, err = decompress(data[p:p+length], pageSize)
+			if err != nil {
+				return nil, err
+			}
+			p += length
+		}
+
+		for _, pg := range pages {
+			if pg.number != number {
+				continue
+			}
+			img.Chunks = append(img.Chunks, Chunk{
+				Address: pg.address,
+				Data:    memory,
+			})
+		}
+	}
+
+	return img, nil
+}
+
+// page is a .z80 page and the address it is placed at.
+type page struct {
+	number  byte
+	address uint16
+}
+
+// pageMap48 maps the .z80 pages of a 48K machine to their address.
+var pageMap48 = []page{
+	{number: 8, address: 0x4000},
+	{number: 4, address: 0x8000},
+	{number: 5, address: 0xc000},
+}
+
+// pageMap128 maps the .z80 pages of the RAM banks that are paged in on a 128K
+// machine to their address.  Banks 5 and 2 are fixed and the bank at $c000 is
+// selected by the last write to port $7ffd.  Pages are RAM banks plus 3.
+func pageMap128(port7ffd byte) []page {
+	return []page{
+		{number: 3 + 5, address: 0x4000},
+		{number: 3 + 2, address: 0x8000},
+		{number: 3 + port7ffd&0x07, address: 0xc000},
+	}
+}
+
+// decompress expands the run length encoding used by .z80 snapshots into
+// size bytes.  The sequence ed ed nn bb stands for nn times bb.  Version 1
+// images end with 00 ed ed 00 which is ignored.
+func decompress(data []byte, size int) ([]byte, error) {
+	memory := make([]byte, 0, size)
+	for i := 0; i < len(data) && len(memory) < size; {
+		if i+3 < len(data) && data[i] == 0xed && data[i+1] == 0xed {
+			for n := 0; n < int(data[i+2]); n++ {
+				memory = append(memory, data[i+3])
+			}
+			i += 4
+			continue
+		}
+		memory = append(memory, data[i])
+		i++
+	}
+	if len(memory) != size {
+		return nil, ErrInvalidZ80
+	}
+	return memory, nil
+}
@@ -0,0 +1,109 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+)
+
+// srecAddressSize returns the size of the address field of each Motorola
+// S-record type.  Types that are not valid have size 0.
+var srecAddressSize = map[byte]int{
+	'0': 2, // Header
+	'1': 2, // Data with 16 bit address
+	'2': 3, // Data with 24 bit address
+	'3': 4, // Data with 32 bit address
+	'5': 2, // 16 bit record count
+	'6': 3, // 24 bit record count
+	'7': 4, // 32 bit start address
+	'8': 3, // 24 bit start address
+	'9': 2, // 16 bit start address
+}
+
+// isSRecord returns true if data looks like a Motorola S-record file.
+func isSRecord(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 1 && data[0] == 'S' && srecAddressSize[data[1]] != 0
+}
+
+// ReadSRecord decodes a Motorola S19, S28 or S37 file.  Every data record is
+// placed at its own address and the start address, if present, is returned
+// in Start.  Addresses must be within the 64K address space.
+func ReadSRecord(data []byte) (*Image, error) {
+	var img Image
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		text := bytes.TrimSpace(s.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		record, err := srecRecord(text)
+		if err != nil {
+			return nil, LineError{Line: line, Err: err}
+		}
+		size := srecAddressSize[text[1]]
+		var address uint32
+		for _, b := range record[1 : 1+size] {
+			address = address<<8 | uint32(b)
+		}
+		payload := record[1+size : len(record)-1]
+
+		switch text[1] {
+		case '0', '5', '6':
+			// Header and record counts carry no memory contents.
+		case '1', '2', '3':
+			if address+uint32(len(payload)) > 0x10000 {
+				return nil, LineError{Line: line,
+					Err: ErrAddressRange}
+			}
+			img.Chunks = append(img.Chunks, Chunk{
+				Address: uint16(address),
+				Data:    payload,
+			})
+		case '7', '8', '9':
+			if address > 0xffff {
+				return nil, LineError{Line: line,
+					Err: ErrAddressRange}
+			}
+			pc := uint16(address)
+			img.Start = &pc
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return &img, nil
+}
+
+// srecRecord decodes the byte count, address, data and checksum of a single
+// Stccaaaa[dd...]cc record and verifies its length and checksum.
+func srecRecord(text []byte) ([]byte, error) {
+	if len(text) < 4 || text[0] != 'S' || len(text)%2 != 0 {
+		return nil, ErrInvalidRecord
+	}
+	size, ok := srecAddressSize[text[1]]
+	if !ok {
+		return nil, ErrInvalidRecord
+	}
+	record := make([]byte, len(text)/2-1)
+	_, err := hex.Decode(record, text[2:])
+	if err != nil {
+		return nil, ErrInvalidRecord
+	}
+	if int(record[0])+1 != len(record) || len(record) < size+2 {
+		return nil, ErrInvalidRecord
+	}
+
+	var sum byte
+	for _, b := range record[:len(record)-1] {
+		sum += b
+	}
+	checksum := record[len(record)-1]
+	if expected := ^sum; checksum != expected {
+		return nil, ChecksumError{Checksum: checksum, Expected: expected}
+	}
+
+	return record, nil
+}
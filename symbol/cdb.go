@@ -0,0 +1,154 @@
+package symbol
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Line is a line of source code and the address of the first instruction
+// that was generated for it.
+type Line struct {
+	File    string // Name of the source file
+	Line    int    // Line number, starting at 1
+	Address uint16
+}
+
+// function is the address range of a compiled function.
+type function struct {
+	start uint16
+	end   uint16 // Last address, inclusive
+}
+
+var (
+	// Linker C line record: L:C$hello.c$12$0_0$1:180
+	cdbLineRE = regexp.MustCompile(
+		`^L:C\$([^$]+)\$(\d+)\$[^:]*:([0-9A-Fa-f]+)$`)
+
+	// Linker function start and end records: L:G$main$0_0$0:180,
+	// L:F$hello$helper$0_0$0:1B4 and L:XG$main$0_0$0:1B3
+	cdbFunctionRE = regexp.MustCompile(
+		`^L:(X?)(?:G|F\$[^$]+)\$([^$]+)\$[^:]*:([0-9A-Fa-f]+)$`)
+)
+
+// loadCDB adds the functions and source lines in the sdcc debug file
+// filename.  Source files are looked up relative to filename.
+func (t *Table) loadCDB(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		symbols []symbol
+		lines   []Line
+		starts  = make(map[string]uint16)
+		funcs   []function
+	)
+	dir := filepath.Dir(filename)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		text := strings.TrimSpace(s.Text())
+		if m := cdbLineRE.FindStringSubmatch(text); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			address, ok := parseHex(m[3])
+			if !ok {
+				continue
+			}
+			lines = append(lines, Line{
+				File:    filepath.Join(dir, m[1]),
+				Line:    n,
+				Address: address,
+			})
+			continue
+		}
+		m := cdbFunctionRE.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		address, ok := parseHex(m[3])
+		if !ok {
+			continue
+		}
+		if m[1] == "" {
+			symbols = append(symbols, symbol{
+				name:    m[2],
+				address: address,
+				label:   true,
+			})
+			starts[m[2]] = address
+			continue
+		}
+		if start, ok := starts[m[2]]; ok && address >= start {
+			funcs = append(funcs, function{start: start, end: address})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	for _, sym := range symbols {
+		t.add(sym)
+	}
+	t.lines = append(t.lines, lines...)
+	sort.SliceStable(t.lines, func(i, j int) bool {
+		return t.lines[i].Address < t.lines[j].Address
+	})
+	t.functions = append(t.functions, funcs...)
+	return nil
+}
+
+// Source returns the source line that address belongs to.  That is the
+// closest line at or below address within the same function.
+func (t *Table) Source(address uint16) (Line, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	var fn *function
+	for i := range t.functions {
+		if address >= t.functions[i].start &&
+			address <= t.functions[i].end {
+			fn = &t.functions[i]
+			break
+		}
+	}
+	if fn == nil {
+		return Line{}, false
+	}
+
+	i := sort.Search(len(t.lines), func(i int) bool {
+		return t.lines[i].Address > address
+	})
+	if i == 0 || t.lines[i-1].Address < fn.start {
+		return Line{}, false
+	}
+	return t.lines[i-1], true
+}
+
+// SourceText returns the text of line.  Source files are read once and
+// remembered.
+func (t *Table) SourceText(line Line) (string, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	text, ok := t.files[line.File]
+	if !ok {
+		data, err := ioutil.ReadFile(line.File)
+		if err == nil {
+			text = strings.Split(string(data), "\n")
+		}
+		t.files[line.File] = text
+	}
+	if line.Line < 1 || line.Line > len(text) {
+		return "", false
+	}
+	return strings.TrimRight(text[line.Line-1], "\r"), true
+}
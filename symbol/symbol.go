@@ -0,0 +1,334 @@
+// Package symbol loads symbol tables produced by assemblers and compilers and
+// translates between names and addresses.
+package symbol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// MaxOffset is the largest distance from a label that is shown as
+	// label+offset.
+	MaxOffset = 0x100
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown symbol file format")
+	ErrUnknownSymbol = errors.New("unknown symbol")
+)
+
+// symbol is a named address.  Labels mark code or data and are used to name
+// addresses; other symbols, such as equates, can only be looked up.
+type symbol struct {
+	name    string
+	address uint16
+	label   bool
+}
+
+// Table holds the symbols of the program that is loaded in the machine.
+type Table struct {
+	sync.Mutex
+
+	names  map[string]uint16 // Address by name
+	folded map[string]uint16 // Address by lower case name
+	labels []symbol          // Labels sorted by address
+
+	lines     []Line              // Source lines sorted by address
+	functions []function          // Functions that have source lines
+	files     map[string][]string // Source file contents by name
+}
+
+// New returns an empty symbol table.
+func New() *Table {
+	return &Table{
+		names:  make(map[string]uint16),
+		folded: make(map[string]uint16),
+		files:  make(map[string][]string),
+	}
+}
+
+// add adds a symbol.  The first label at an address names it.
+func (t *Table) add(s symbol) {
+	t.names[s.name] = s.address
+	t.folded[strings.ToLower(s.name)] = s.address
+	if !s.label {
+		return
+	}
+	i := sort.Search(len(t.labels), func(i int) bool {
+		return t.labels[i].address >= s.address
+	})
+	if i < len(t.labels) && t.labels[i].address == s.address {
+		return
+	}
+	t.labels = append(t.labels, symbol{})
+	copy(t.labels[i+1:], t.labels[i:])
+	t.labels[i] = s
+}
+
+// Len returns the number of symbols in the table.
+func (t *Table) Len() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.names)
+}
+
+// Lookup returns the address of name.  If there is no exact match the name is
+// compared without regard to case and with a leading underscore, which is
+// how C compilers decorate their symbols.
+func (t *Table) Lookup(name string) (uint16, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, n := range []string{name, "_" + name} {
+		if address, ok := t.names[n]; ok {
+			return address, true
+		}
+		if address, ok := t.folded[strings.ToLower(n)]; ok {
+			return address, true
+		}
+	}
+	return 0, false
+}
+
+// LabelAt returns the name of the label at address.
+func (t *Table) LabelAt(address uint16) (string, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	i := sort.Search(len(t.labels), func(i int) bool {
+		return t.labels[i].address >= address
+	})
+	if i < len(t.labels) && t.labels[i].address == address {
+		return t.labels[i].name, true
+	}
+	return "", false
+}
+
+// Name returns address as label or label+offset using the closest label at
+// or below address that is no further away than MaxOffset.
+func (t *Table) Name(address uint16) (string, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	i := sort.Search(len(t.labels), func(i int) bool {
+		return t.labels[i].address > address
+	})
+	if i == 0 {
+		return "", false
+	}
+	s := t.labels[i-1]
+	offset := address - s.address
+	switch {
+	case offset == 0:
+		return s.name, true
+	case offset < MaxOffset:
+		return fmt.Sprintf("%v+$%02x", s.name, offset), true
+	}
+	return "", false
+}
+
+// Label is a name for an address.
+type Label struct {
+	Name    string
+	Address uint16
+}
+
+// Labels returns all labels sorted by address.
+func (t *Table) Labels() []Label {
+	t.Lock()
+	defer t.Unlock()
+
+	labels := make([]Label, 0, len(t.labels))
+	for _, s := range t.labels {
+		labels = append(labels, Label{Name: s.name, Address: s.address})
+	}
+	return labels
+}
+
+// parseNumber parses a number in the notation used by the control window;
+// $ and 0x prefix hexadecimal numbers.
+func parseNumber(s string) (uint16, error) {
+	if strings.HasPrefix(s, "$") {
+		s = "0x" + s[1:]
+	}
+	n, err := strconv.ParseUint(s, 0, 16)
+	return uint16(n), err
+}
+
+// Parse returns the address of s which is a number, a symbol or a symbol
+// followed by +offset or -offset.
+func (t *Table) Parse(s string) (uint16, error) {
+	s = strings.TrimSpace(s)
+	if n, err := parseNumber(s); err == nil {
+		return n, nil
+	}
+
+	name, offset := s, uint16(0)
+	if i := strings.LastIndexAny(s, "+-"); i > 0 {
+		n, err := parseNumber(strings.TrimSpace(s[i+1:]))
+		if err != nil {
+			return 0, err
+		}
+		name, offset = strings.TrimSpace(s[:i]), n
+		if s[i] == '-' {
+			offset = -offset
+		}
+	}
+
+	address, ok := t.Lookup(name)
+	if !ok {
+		return 0, ErrUnknownSymbol
+	}
+	return address + offset, nil
+}
+
+// Load adds the symbols in filename to the table.  The format is determined
+// by the extension: sdcc .map, .noi and .cdb files, assembler listings (.lst)
+// and label files (.sym).  Only .cdb files have source lines.
+func (t *Table) Load(filename string) error {
+	var parse func(string) (symbol, bool)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".cdb":
+		return t.loadCDB(filename)
+	case ".map":
+		parse = parseMap
+	case ".noi":
+		parse = parseNoi
+	case ".lst":
+		parse = parseListing
+	case ".sym":
+		parse = parseSym
+	default:
+		return ErrUnknownFormat
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var symbols []symbol
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		sym, ok := parse(s.Text())
+		if !ok {
+			continue
+		}
+		symbols = append(symbols, sym)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	for _, sym := range symbols {
+		t.add(sym)
+	}
+	return nil
+}
+
+// identifier matches the symbol names that the supported toolchains emit.
+const identifier = `([A-Za-z_.$?@][\w.$?@]*)`
+
+var (
+	// sdcc map: 00000180  _main  hello
+	mapRE = regexp.MustCompile(`^\s*(?:[A-Z]:\s*)?([0-9A-Fa-f]{4,8})\s+` +
+		identifier)
+
+	// sdcc noi: DEF _main 0x180
+	noiRE = regexp.MustCompile(`^\s*DEF\s+` + identifier + `\s+(\S+)`)
+
+	// Label file: main: equ $0180, main = 0180h or main 0180
+	symRE = regexp.MustCompile(`^\s*` + identifier +
+		`:?\s+(?:(?i:equ|defl)\s+|=\s*)?(\$?[0-9A-Fa-f]+[hH]?|0x[0-9A-Fa-f]+)\b`)
+
+	// Listing: [line:] 0180  3E01  main: ld a,1
+	labelRE = regexp.MustCompile(`^\s*(?:\d+:\s+)?([0-9A-Fa-f]{4})\s+` +
+		`(?:[0-9A-Fa-f]+\s+|\[[^\]]*\]\s*)*` + identifier + `:`)
+
+	// Listing: [line:] 000D  CR EQU 0DH
+	equRE = regexp.MustCompile(`^\s*(?:\d+:\s+)?([0-9A-Fa-f]{4})\s+` +
+		identifier + `:?\s+(?i:equ|set|defl|=)\s`)
+)
+
+// sdccLabel returns false for the symbols the sdcc linker generates for the
+// start and length of areas.
+func sdccLabel(name string) bool {
+	return !strings.HasPrefix(name, "s__") &&
+		!strings.HasPrefix(name, "l__") &&
+		!strings.HasPrefix(name, ".__")
+}
+
+// parseHex parses a hexadecimal value written as $0180, 0x0180, 0180h or
+// 0180.
+func parseHex(s string) (uint16, bool) {
+	s = strings.TrimPrefix(s, "$")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	s = strings.TrimRight(s, "hH")
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || n > 0xffff {
+		return 0, false
+	}
+	return uint16(n), true
+}
+
+func parseMap(line string) (symbol, bool) {
+	m := mapRE.FindStringSubmatch(line)
+	if m == nil {
+		return symbol{}, false
+	}
+	address, ok := parseHex(m[1])
+	if !ok {
+		return symbol{}, false
+	}
+	return symbol{name: m[2], address: address, label: sdccLabel(m[2])},
+		true
+}
+
+func parseNoi(line string) (symbol, bool) {
+	m := noiRE.FindStringSubmatch(line)
+	if m == nil {
+		return symbol{}, false
+	}
+	address, ok := parseHex(m[2])
+	if !ok {
+		return symbol{}, false
+	}
+	return symbol{name: m[1], address: address, label: sdccLabel(m[1])},
+		true
+}
+
+func parseSym(line string) (symbol, bool) {
+	m := symRE.FindStringSubmatch(line)
+	if m == nil {
+		return symbol{}, false
+	}
+	address, ok := parseHex(m[2])
+	if !ok {
+		return symbol{}, false
+	}
+	return symbol{name: m[1], address: address, label: true}, true
+}
+
+func parseListing(line string) (symbol, bool) {
+	if m := equRE.FindStringSubmatch(line); m != nil {
+		address, _ := parseHex(m[1])
+		return symbol{name: m[2], address: address}, true
+	}
+	if m := labelRE.FindStringSubmatch(line); m != nil {
+		address, _ := parseHex(m[1])
+		return symbol{name: m[2], address: address, label: true}, true
+	}
+	return symbol{}, false
+}
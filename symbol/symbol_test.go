@@ -0,0 +1,290 @@
+package symbol
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		data     string
+		expected []Label
+		lookup   map[string]uint16
+	}{
+		{
+			name:     "sdcc map",
+			filename: "hello.map",
+			data: "Area                       Addr   Size\n" +
+				"_CODE                      00000180    000000B4\n" +
+				"      Value  Global\n" +
+				"     --------  --------------------------------\n" +
+				"     00000180  s__CODE\n" +
+				"     000000B4  l__CODE\n" +
+				"     00000180  _main                hello\n" +
+				"     000001A0  _putchar             hello\n",
+			expected: []Label{
+				{Name: "_main", Address: 0x0180},
+				{Name: "_putchar", Address: 0x01a0},
+			},
+			lookup: map[string]uint16{
+				"main":    0x0180,
+				"s__CODE": 0x0180,
+				"l__CODE": 0x00b4,
+			},
+		},
+		{
+			name:     "sdcc noi",
+			filename: "hello.noi",
+			data: "LOAD hello.ihx\n" +
+				"DEF s__CODE 0x180\n" +
+				"DEF _main 0x180\n" +
+				"DEF _getchar 0x1C0\n",
+			expected: []Label{
+				{Name: "_main", Address: 0x0180},
+				{Name: "_getchar", Address: 0x01c0},
+			},
+			lookup: map[string]uint16{"getchar": 0x01c0},
+		},
+		{
+			name:     "label file",
+			filename: "monitor.sym",
+			data: "start:\tequ $0000\n" +
+				"loop: EQU 0x0010\n" +
+				"buffer = 0800h\n" +
+				"stack 1000\n",
+			expected: []Label{
+				{Name: "start", Address: 0x0000},
+				{Name: "loop", Address: 0x0010},
+				{Name: "buffer", Address: 0x0800},
+				{Name: "stack", Address: 0x1000},
+			},
+		},
+		{
+			name:     "zmac listing",
+			filename: "monitor.lst",
+			data: "   1:\t\t\t\t; monitor\n" +
+				"   2:\t0000  F3        [4]\tstart:\tdi\n" +
+				"   3:\t0001  C30000    [10]\tloop:\tjp loop\n" +
+				"   4:\t000D          \tcr\tequ\t0dh\n",
+			expected: []Label{
+				{Name: "start", Address: 0x0000},
+				{Name: "loop", Address: 0x0001},
+			},
+			lookup: map[string]uint16{"cr": 0x000d},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "symbol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		filename := filepath.Join(dir, test.filename)
+		err := ioutil.WriteFile(filename, []byte(test.data), 0644)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		table := New()
+		err = table.Load(filename)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		labels := table.Labels()
+		if !reflect.DeepEqual(labels, test.expected) {
+			t.Fatalf("%v: got %v expected %v", test.name, labels,
+				test.expected)
+		}
+		for name, expected := range test.lookup {
+			address, ok := table.Lookup(name)
+			if !ok || address != expected {
+				t.Fatalf("%v: %v got %04x %v expected %04x",
+					test.name, name, address, ok, expected)
+			}
+		}
+	}
+
+	if err := New().Load("hello.txt"); err != ErrUnknownFormat {
+		t.Fatalf("got %v expected %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestTinyBasic(t *testing.T) {
+	table := New()
+	err := table.Load("../src/cpuville/tinybasic2dms.lst")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := map[string]uint16{
+		"START":  0x0000,
+		"CRLF":   0x000e,
+		"LSTROM": 0x0769,
+		"OCSW":   0x0800,
+		"STACK":  0x1000,
+		"CR":     0x000d,
+		"crlf":   0x000e,
+	}
+	for name, expected := range lookup {
+		address, ok := table.Lookup(name)
+		if !ok || address != expected {
+			t.Fatalf("%v: got %04x %v expected %04x", name,
+				address, ok, expected)
+		}
+	}
+
+	// CR is an equate and does not name $000d.
+	if name, ok := table.LabelAt(0x000d); ok {
+		t.Fatalf("got label %v at $000d", name)
+	}
+}
+
+func TestName(t *testing.T) {
+	table := New()
+	table.add(symbol{name: "start", address: 0x0100, label: true})
+	table.add(symbol{name: "main", address: 0x0100, label: true})
+	table.add(symbol{name: "buffer", address: 0x8000, label: true})
+	table.add(symbol{name: "size", address: 0x0040})
+
+	tests := []struct {
+		address  uint16
+		expected string
+	}{
+		{0x0000, ""},
+		{0x0100, "start"},
+		{0x0104, "start+$04"},
+		{0x01ff, "start+$ff"},
+		{0x0200, ""},
+		{0x8000, "buffer"},
+		{0xffff, ""},
+	}
+	for _, test := range tests {
+		name, _ := table.Name(test.address)
+		if name != test.expected {
+			t.Fatalf("%04x: got %q expected %q", test.address,
+				name, test.expected)
+		}
+	}
+
+	parse := []struct {
+		s        string
+		expected uint16
+		err      error
+	}{
+		{"$100", 0x0100, nil},
+		{"0x8000", 0x8000, nil},
+		{"16", 0x0010, nil},
+		{"main", 0x0100, nil},
+		{"buffer+$10", 0x8010, nil},
+		{"buffer - 1", 0x7fff, nil},
+		{"START+4", 0x0104, nil},
+		{"size", 0x0040, nil},
+		{"nothere", 0, ErrUnknownSymbol},
+	}
+	for _, test := range parse {
+		address, err := table.Parse(test.s)
+		if err != test.err || address != test.expected {
+			t.Fatalf("%v: got %04x %v expected %04x %v", test.s,
+				address, err, test.expected, test.err)
+		}
+	}
+}
+
+func TestCDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symbol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := "#include <stdio.h>\n" +
+		"\n" +
+		"static int\n" +
+		"helper(int x)\n" +
+		"{\n" +
+		"\treturn x + 1;\n" +
+		"}\n" +
+		"\n" +
+		"void\n" +
+		"main(void)\n" +
+		"{\n" +
+		"\tint i = helper(1);\n" +
+		"\tprintf(\"%d\\n\", i);\n" +
+		"}\n"
+	cdb := "M:hello\n" +
+		"F:G$main$0_0$0({2}DF,SV:S),Z,0,0,0,0,0\n" +
+		"S:Lhello.main$i$1_0$2({2}SI:S),R,0,0,[c,b]\n" +
+		"L:F$hello$helper$0_0$0:180\n" +
+		"L:C$hello.c$6$1_0$1:180\n" +
+		"L:C$hello.c$7$1_0$1:183\n" +
+		"L:XF$hello$helper$0_0$0:183\n" +
+		"L:G$main$0_0$0:184\n" +
+		"L:C$hello.c$12$1_0$2:184\n" +
+		"L:C$hello.c$13$1_0$2:18A\n" +
+		"L:C$hello.c$14$1_0$2:195\n" +
+		"L:XG$main$0_0$0:195\n" +
+		"L:G$buffer$0_0$0:8000\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "hello.c"), []byte(source),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "hello.cdb")
+	err = ioutil.WriteFile(filename, []byte(cdb), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := New()
+	err = table.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address, ok := table.Lookup("main"); !ok || address != 0x0184 {
+		t.Fatalf("main: got %04x %v expected 0184", address, ok)
+	}
+
+	file := filepath.Join(dir, "hello.c")
+	tests := []struct {
+		address uint16
+		line    int
+		text    string
+	}{
+		{0x0180, 6, "\treturn x + 1;"},
+		{0x0182, 6, "\treturn x + 1;"},
+		{0x0183, 7, "}"},
+		{0x0184, 12, "\tint i = helper(1);"},
+		{0x0190, 13, "\tprintf(\"%d\\n\", i);"},
+		{0x0195, 14, "}"},
+		{0x0196, 0, ""},
+		{0x017f, 0, ""},
+		{0x8000, 0, ""},
+	}
+	for _, test := range tests {
+		line, ok := table.Source(test.address)
+		if test.line == 0 {
+			if ok {
+				t.Fatalf("%04x: got %v expected none",
+					test.address, line)
+			}
+			continue
+		}
+		expected := Line{File: file, Line: test.line}
+		if !ok || line.File != expected.File ||
+			line.Line != expected.Line {
+			t.Fatalf("%04x: got %v expected %v", test.address,
+				line, expected)
+		}
+		text, ok := table.SourceText(line)
+		if !ok || text != test.text {
+			t.Fatalf("%04x: got %q expected %q", test.address,
+				text, test.text)
+		}
+	}
+}
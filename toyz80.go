@@ -8,11 +8,16 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/chzyer/readline"
+	"github.com/marcopeereboom/toyz80/asm"
 	"github.com/marcopeereboom/toyz80/bus"
+	"github.com/marcopeereboom/toyz80/device"
+	"github.com/marcopeereboom/toyz80/loader"
+	"github.com/marcopeereboom/toyz80/symbol"
 	"github.com/marcopeereboom/toyz80/z80"
 )
 
@@ -38,6 +43,7 @@ var completer = readline.NewPrefixCompleter(
 	),
 
 	// emulator commands
+	readline.PcItem("asm"),
 	readline.PcItem("bp",
 		readline.PcItem("set"),
 		readline.PcItem("del")),
@@ -45,14 +51,27 @@ var completer = readline.NewPrefixCompleter(
 	readline.PcItem("disassemble"),
 	readline.PcItem("dump"),
 	readline.PcItem("help"),
+	readline.PcItem("load"),
+	readline.PcItem("next"),
+	readline.PcItem("nmi"),
 	readline.PcItem("pause"),
 	readline.PcItem("registers"),
+	readline.PcItem("reset"),
+	readline.PcItem("restore"),
+	readline.PcItem("save"),
 	readline.PcItem("step"),
+	readline.PcItem("step-line"),
+	readline.PcItem("symbols"),
 	readline.PcItem("pc"),
+	readline.PcItem("watch",
+		readline.PcItem("set"),
+		readline.PcItem("del"),
+		readline.PcItem("list")),
 )
 
 func help() {
 	h := [][]string{
+		{"asm <address>", "Assemble into memory, end with empty line."},
 		{"bp <set|del>", "Breakpoint, leave empty to list."},
 		{"continue", "Resume execution."},
 		{"disassemble [address[ count]]",
@@ -60,11 +79,22 @@ func help() {
 		{"dump [address[ count]]",
 			"Dump memory starting at provided address."},
 		{"help", "This help."},
+		{"load <file>", "Load snapshot, HEX, S-record or .COM file."},
 		{"mode <emacs|vi>", "Set edit mode."},
+		{"next", "Run to next source line, over calls."},
+		{"nmi", "Trigger non-maskable interrupt."},
 		{"pause", "Pause execution."},
 		{"pc <address>", "Set program counter to address."},
 		{"registers", "Print registers."},
+		{"reset", "Reset the CPU and the devices."},
+		{"restore <file>", "Restore machine state from file."},
+		{"save <file>", "Save machine state to file."},
 		{"step [count]", "Execute next instruction."},
+		{"step-line", "Run to next source line."},
+		{"symbols [file]", "Load symbols, leave empty to list."},
+		{"watch <set|del|list>", "Watchpoint, leave empty to list."},
+		{"watch set [io] <r|w|rw> <addr>",
+			"Pause on access, [count] [=value]."},
 	}
 	for i := range h {
 		fmt.Printf("%-32v%v\n", h[i][0], h[i][1])
@@ -97,15 +127,29 @@ func _main() error {
 	var (
 		logFile   = flag.String("log", "stderr", "log trace")
 		traceFlag = flag.Bool("trace", false, "trace execution")
-		err       error
+		faultFlag = flag.String("fault", "trap", "unmapped memory, "+
+			"ROM write and unmapped I/O policy {trap|log|ignore}")
+		cpuFlag = flag.String("cpu", "z80", "cpu {z80|8080}")
+		err     error
 	)
 	flag.Usage = func() {
 		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\nusage: %v device={rom|ram|console},"+
-			"origin-size[,image] load=origin,image\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nusage: %v device={rom|ram},"+
+			"origin-size[,image] "+
+			"device=peripheral,origin-size[,memory|wide]"+
+			"[,option:value]... "+
+			"device=banked,origin-size[,page:size]"+
+			",{rom|ram}[:image]... device=mmu,port-count[,latch][,wide] "+
+			"load=[origin,]image "+
+			"symbols=file\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "example: %v device=console,0x02-0x02 "+
 			"ram,0x0000-0x10000 load=mysuper.rom\n",
 			os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nperipherals:\n")
+		peripheralUsage(os.Stderr)
+		fmt.Fprintf(os.Stderr, "\nusage: %v asm [-cpu=8080] "+
+			"[-o out.bin|out.hex] [-l out.lst] source.asm\n",
+			os.Args[0])
 	}
 	flag.Parse()
 	if len(flag.Args()) == 0 {
@@ -126,17 +170,21 @@ func _main() error {
 	// devices
 	var devices []bus.Device
 	var loads []string
+	var symbolFiles []string
 	for _, args := range flag.Args() {
 		// format rom,0x1000-0x1000,image
 		cmd := strings.Split(args, "=")
 		if len(cmd) != 2 {
-			return fmt.Errorf("expected device={rom|ram|console}," +
-				"origin-size[,image] load=origin,image")
+			return fmt.Errorf("expected device={rom|ram}," +
+				"origin-size[,image] load=[origin,]image")
 		}
 		switch cmd[0] {
 		case "load":
 			loads = append(loads, cmd[1])
 			continue
+		case "symbols":
+			symbolFiles = append(symbolFiles, cmd[1])
+			continue
 		case "device":
 		default:
 			flag.Usage()
@@ -153,10 +201,19 @@ func _main() error {
 			d = bus.DeviceROM
 		case "ram":
 			d = bus.DeviceRAM
-		case "console":
-			d = bus.DeviceSerialConsole
+		case "banked":
+			d = bus.DeviceBanked
+		case "mmu":
+			d = bus.DeviceMMU
 		default:
-			return fmt.Errorf("invalid device type: %v", a[0])
+			if _, ok := device.Lookup(a[0]); !ok {
+				return fmt.Errorf("invalid device type: %v",
+					a[0])
+			}
+			d = bus.DevicePeripheral
+		}
+		if len(a) < 2 {
+			return fmt.Errorf("missing origin-size: %v", cmd[1])
 		}
 
 		// origin address
@@ -178,6 +235,44 @@ func _main() error {
 			return fmt.Errorf("size out of bounds: %v", o[1])
 		}
 
+		dev := bus.Device{
+			Name:  a[0],
+			Start: uint16(origin),
+			Size:  int(size),
+			Type:  d,
+		}
+		switch d {
+		case bus.DeviceBanked:
+			err = parseBanks(&dev, a[2:])
+			if err != nil {
+				return err
+			}
+			devices = append(devices, dev)
+			continue
+		case bus.DeviceMMU:
+			for _, arg := range a[2:] {
+				switch arg {
+				case "latch":
+					dev.Latch = true
+				case "wide":
+					dev.Wide = true
+				default:
+					return fmt.Errorf("invalid mmu: %v",
+						cmd[1])
+				}
+			}
+			devices = append(devices, dev)
+			continue
+		case bus.DevicePeripheral:
+			dev.Peripheral = a[0]
+			err = parseOptions(&dev, a[2:])
+			if err != nil {
+				return err
+			}
+			devices = append(devices, dev)
+			continue
+		}
+
 		// warn user if there is no rom image
 		if a[0] == "rom" && len(a) != 3 {
 			fmt.Printf("warning rom @ 0x%04x does not have an "+
@@ -196,13 +291,17 @@ func _main() error {
 			return fmt.Errorf("image out of bounds: %v", a[2])
 		}
 
-		devices = append(devices, bus.Device{
-			Name:  a[0],
-			Start: uint16(origin),
-			Size:  int(size),
-			Type:  d,
-			Image: image,
-		})
+		dev.Image = image
+		devices = append(devices, dev)
+	}
+
+	policy, err := bus.ParseFaultPolicy(*faultFlag)
+	if err != nil {
+		return fmt.Errorf("%v: %v", err, *faultFlag)
+	}
+	mode, err := z80.ParseCPUMode(*cpuFlag)
+	if err != nil {
+		return fmt.Errorf("%v: %v", err, *cpuFlag)
 	}
 
 	shutdown := make(chan string)
@@ -210,15 +309,33 @@ func _main() error {
 	if err != nil {
 		return err
 	}
+	bus.SetFaultPolicy(policy)
 
-	z, err := z80.New(z80.ModeZ80, bus)
+	z, err := z80.New(mode, bus)
 	if err != nil {
 		return err
 	}
 
+	// symbols
+	symbols := symbol.New()
+	for _, filename := range symbolFiles {
+		err := symbols.Load(filename)
+		if err != nil {
+			return fmt.Errorf("%v: %v", filename, err)
+		}
+	}
+	z.SetSymbolizer(symbols)
+
 	// load memory
 	for _, load := range loads {
 		a := strings.Split(load, ",")
+		if len(a) == 1 {
+			err := loadImage(z, bus, a[0])
+			if err != nil {
+				return fmt.Errorf("%v: %v", a[0], err)
+			}
+			continue
+		}
 		var image []byte
 		if len(a) != 2 {
 			flag.Usage()
@@ -268,8 +385,13 @@ func _main() error {
 	firstPause := false
 	interactive := true
 	stepCount := uint64(0)
+	lineStep := lineStepNone
+	var (
+		lineFrom symbol.Line // Source line where line stepping began
+		lineSP   uint16      // Stack pointer where line stepping began
+	)
 	go func() {
-		var prefix string
+		var prefix, lastSource string
 		for {
 			if pause {
 				if firstPause {
@@ -306,6 +428,14 @@ func _main() error {
 					return
 				}
 				prefix = fmt.Sprintf("%04x: %v", pc, s)
+				if label, ok := symbols.LabelAt(pc); ok {
+					prefix = label + ":\n" + prefix
+				}
+				src, ok := sourceLine(symbols, pc)
+				if ok && src != lastSource {
+					prefix = src + "\n" + prefix
+					lastSource = src
+				}
 			}
 
 			err := z.Step()
@@ -335,8 +465,35 @@ func _main() error {
 					fmt.Fprintf(l.Stdout(), "%v\n",
 						z.DumpRegisters())
 				default:
-					fmt.Fprintf(l.Stdout(),
-						"CPU error: %v\n", err)
+					pc, ok := faultPC(err)
+					if !ok {
+						fmt.Fprintf(l.Stdout(),
+							"CPU error: %v\n", err)
+						break
+					}
+					pause = true
+					fmt.Fprintf(l.Stdout(), "%v\n", err)
+					s, _, _, _ := z.Disassemble(pc, true)
+					fmt.Fprintf(l.Stdout(), "%04x: %v\n", pc, s)
+					fmt.Fprintf(l.Stdout(), "%v\n",
+						z.DumpRegisters())
+				}
+			}
+			if pause {
+				lineStep = lineStepNone
+			}
+			if lineStep != lineStepNone {
+				r := z.GetRegisters()
+				line, ok := symbols.Source(r.PC)
+				if ok && (line.File != lineFrom.File ||
+					line.Line != lineFrom.Line) &&
+					(lineStep == lineStepInto || r.SP >= lineSP) {
+					lineStep = lineStepNone
+					pause = true
+					src, _ := sourceLine(symbols, r.PC)
+					lastSource = src
+					fmt.Fprintf(l.Stdout(), "%v\n%v\n", src,
+						z.DumpRegisters())
 				}
 			}
 			if stepCount > 0 {
@@ -392,6 +549,8 @@ func _main() error {
 			}
 			pause = false
 			restart <- ""
+		case line == "nmi":
+			bus.RaiseNMI()
 		case line == "pause":
 			if pause {
 				fmt.Printf("CPU is not running\n")
@@ -419,7 +578,7 @@ func _main() error {
 			}
 
 			// address
-			address, err := parseUint(a[0], 16)
+			address, err := symbols.Parse(a[0])
 			if err != nil {
 				fmt.Printf("invalid address: %v\n", err)
 				continue
@@ -435,6 +594,9 @@ func _main() error {
 
 			// actually disassemble
 			for i := 0; i < lines; i++ {
+				if label, ok := symbols.LabelAt(addr); ok {
+					fmt.Printf("%v:\n", label)
+				}
 				s, _, count, _ := z.Disassemble(addr, true)
 				fmt.Printf("%04x: %v\n", addr, s)
 				addr += uint16(count)
@@ -459,7 +621,7 @@ func _main() error {
 			}
 
 			// address
-			address, err := parseUint(a[0], 16)
+			address, err := symbols.Parse(a[0])
 			if err != nil {
 				fmt.Printf("invalid address: %v\n", err)
 				continue
@@ -478,12 +640,15 @@ func _main() error {
 				s := bus.Dump(addr, 16)
 				sd := hex.Dump(s)
 				sd = sd[10 : len(sd)-1] // chop of addr and \n
+				if name, ok := symbols.Name(addr); ok {
+					sd += " " + name
+				}
 				fmt.Printf("%04x: %v\n", addr, sd)
 				addr += 16
 			}
 			lastAddr = addr
 		case strings.HasPrefix(line, "pc "):
-			x, err := parseUint(line[3:], 16)
+			x, err := symbols.Parse(line[3:])
 			if err != nil {
 				fmt.Printf("invalid PC: %v\n", err)
 				continue
@@ -512,6 +677,25 @@ func _main() error {
 			}
 			stepCount = 1
 			restart <- ""
+		case line == "next" || line == "step-line":
+			if pause == false {
+				fmt.Printf("CPU is currently running\n")
+				continue
+			}
+			r := z.GetRegisters()
+			from, ok := symbols.Source(r.PC)
+			if !ok {
+				fmt.Printf("no source line at $%04x\n", r.PC)
+				continue
+			}
+			lineFrom = from
+			lineSP = r.SP
+			lineStep = lineStepInto
+			if line == "next" {
+				lineStep = lineStepOver
+			}
+			pause = false
+			restart <- ""
 		case line == "registers":
 			if pause == false {
 				fmt.Printf("CPU is currently running\n")
@@ -519,13 +703,64 @@ func _main() error {
 			}
 			restart <- "registers"
 
+		case strings.HasPrefix(line, "load "):
+			if pause == false {
+				fmt.Printf("CPU is currently running\n")
+				continue
+			}
+			err := loadImage(z, bus, strings.TrimSpace(line[5:]))
+			if err != nil {
+				fmt.Printf("load: %v\n", err)
+				continue
+			}
+			restart <- "registers"
+		case strings.HasPrefix(line, "save "):
+			if pause == false {
+				fmt.Printf("CPU is currently running\n")
+				continue
+			}
+			err := saveState(z, strings.TrimSpace(line[5:]))
+			if err != nil {
+				fmt.Printf("save: %v\n", err)
+				continue
+			}
+		case line == "reset":
+			if pause == false {
+				fmt.Printf("CPU is currently running\n")
+				continue
+			}
+			z.Reset(false)
+			restart <- "registers"
+		case strings.HasPrefix(line, "restore "):
+			if pause == false {
+				fmt.Printf("CPU is currently running\n")
+				continue
+			}
+			err := restoreState(z, strings.TrimSpace(line[8:]))
+			if err != nil {
+				fmt.Printf("restore: %v\n", err)
+				continue
+			}
+			restart <- "registers"
+		case strings.HasPrefix(line, "asm "):
+			if pause == false {
+				fmt.Printf("CPU is currently running\n")
+				continue
+			}
+			address, err := symbols.Parse(line[4:])
+			if err != nil {
+				fmt.Printf("invalid address: %v\n", err)
+				continue
+			}
+			assemble(l, z, bus, mode, symbols, address)
+			continue // an empty line must not restart the assembler
 		case strings.HasPrefix(line, "bp "):
 			a := strings.Split(line[3:], " ")
 			if len(a) != 2 {
 				fmt.Printf("bp [set address][del address]\n")
 				continue
 			}
-			x, err := parseUint(a[1], 16)
+			x, err := symbols.Parse(a[1])
 			if err != nil {
 				fmt.Printf("invalid address: %v\n", err)
 				continue
@@ -539,12 +774,76 @@ func _main() error {
 				fmt.Printf("bp [set address][del address]\n")
 				continue
 			}
+		case line == "watch" || line == "watch list":
+			fmt.Printf("Watchpoints:\n")
+			for _, w := range bus.Watchpoints() {
+				name, ok := symbols.Name(w.Start)
+				if ok && !w.IO {
+					fmt.Printf("%v %v\n", w, name)
+					continue
+				}
+				fmt.Printf("%v\n", w)
+			}
+		case strings.HasPrefix(line, "watch "):
+			// Watchpoints change the memory flags the CPU reads
+			// without locking.
+			if pause == false {
+				fmt.Printf("CPU is currently running\n")
+				continue
+			}
+			a := strings.Fields(line[6:])
+			switch {
+			case len(a) > 1 && a[0] == "set":
+				w, err := parseWatchpoint(symbols, a[1:])
+				if err != nil {
+					fmt.Printf("%v\n", err)
+					continue
+				}
+				id, err := bus.AddWatchpoint(w)
+				if err != nil {
+					fmt.Printf("watch: %v\n", err)
+					continue
+				}
+				fmt.Printf("Watchpoint %v\n", id)
+			case len(a) == 2 && a[0] == "del":
+				id, err := strconv.Atoi(a[1])
+				if err != nil {
+					fmt.Printf("invalid watchpoint: %v\n",
+						a[1])
+					continue
+				}
+				err = bus.DelWatchpoint(id)
+				if err != nil {
+					fmt.Printf("watch: %v\n", err)
+					continue
+				}
+			default:
+				fmt.Printf("watch [set [io] <r|w|rw> address " +
+					"[count] [=value]][del n][list]\n")
+				continue
+			}
 		case line == "bp":
 			bps := z.GetBreakPoints()
 			fmt.Printf("Breakpoints:\n")
 			for _, bp := range bps {
+				if name, ok := symbols.Name(bp); ok {
+					fmt.Printf("$%04x %v\n", bp, name)
+					continue
+				}
 				fmt.Printf("$%04x\n", bp)
 			}
+		case strings.HasPrefix(line, "symbols "):
+			filename := strings.TrimSpace(line[8:])
+			err := symbols.Load(filename)
+			if err != nil {
+				fmt.Printf("symbols: %v\n", err)
+				continue
+			}
+			fmt.Printf("%v symbols\n", symbols.Len())
+		case line == "symbols":
+			for _, label := range symbols.Labels() {
+				fmt.Printf("$%04x %v\n", label.Address, label.Name)
+			}
 		default:
 			fmt.Printf("invalid command %v\n", line)
 		}
@@ -554,8 +853,259 @@ func _main() error {
 	return nil
 }
 
+// loadImage places an image in one of the formats known to the loader in
+// memory and sets the registers or program counter if the image has them.
+func loadImage(z *z80.CPU, b *bus.Bus, filename string) error {
+	img, err := loader.Load(filename)
+	if err != nil {
+		return err
+	}
+	for _, c := range img.Chunks {
+		err = b.WriteMemory(c.Address, c.Data)
+		if err != nil {
+			return err
+		}
+	}
+	if img.Registers != nil {
+		z.SetRegisters(*img.Registers)
+	}
+	if img.Start != nil {
+		z.SetPC(*img.Start)
+	}
+	return nil
+}
+
+// parseOptions parses the options of a peripheral.  memory maps the
+// peripheral in memory space, wide decodes all 16 bits of its ports and the
+// other options are written as name:value.
+func parseOptions(d *bus.Device, args []string) error {
+	for _, arg := range args {
+		switch arg {
+		case "memory":
+			d.Memory = true
+			continue
+		case "wide":
+			d.Wide = true
+			continue
+		}
+		a := strings.SplitN(arg, ":", 2)
+		if len(a) != 2 {
+			return fmt.Errorf("invalid option: %v", arg)
+		}
+		if d.Options == nil {
+			d.Options = make(map[string]string)
+		}
+		d.Options[a[0]] = a[1]
+	}
+	return nil
+}
+
+// peripheralUsage describes the registered devices and their options.
+func peripheralUsage(w io.Writer) {
+	for _, d := range device.Devices() {
+		fmt.Fprintf(w, "  %v: %v (ports %v", d.Name, d.Usage, d.Ports)
+		if d.Memory {
+			fmt.Fprintf(w, ", memory")
+		}
+		if d.Interrupts {
+			fmt.Fprintf(w, ", interrupts")
+		}
+		fmt.Fprintf(w, ")\n")
+		for _, o := range d.Options {
+			fmt.Fprintf(w, "    %v:%v  %v\n", o.Name, o.Default,
+				o.Usage)
+		}
+	}
+}
+
+// parseBanks parses the banks and page size of a banked memory window.  Each
+// bank is ram or rom followed by an optional :image and page:size sets the
+// page size.
+func parseBanks(d *bus.Device, args []string) error {
+	for _, arg := range args {
+		a := strings.SplitN(arg, ":", 2)
+		if a[0] == "page" && len(a) == 2 {
+			size, err := parseUint(a[1], 32)
+			if err != nil {
+				return fmt.Errorf("invalid page size: %v", err)
+			}
+			d.PageSize = int(size)
+			continue
+		}
+
+		var bank bus.Bank
+		switch a[0] {
+		case "rom":
+			bank.Type = bus.DeviceROM
+		case "ram":
+			bank.Type = bus.DeviceRAM
+		default:
+			return fmt.Errorf("invalid bank: %v", arg)
+		}
+		if len(a) == 2 {
+			image, err := ioutil.ReadFile(a[1])
+			if err != nil {
+				return err
+			}
+			bank.Image = image
+		}
+		d.Banks = append(d.Banks, bank)
+	}
+	return nil
+}
+
+// assemble reads instructions from the control window and stores them in
+// memory starting at address until an empty line or a single period is
+// entered.  Labels defined along the way can be used before their definition.
+func assemble(l *readline.Instance, z *z80.CPU, b *bus.Bus, mode z80.CPUMode, symbols *symbol.Table, address uint16) {
+	lookup := func(name string) (int, bool) {
+		v, ok := symbols.Lookup(name)
+		return int(v), ok
+	}
+	s := asm.NewSession(mode, address, b.WriteMemory, lookup)
+	defer l.SetPrompt("> ")
+	for {
+		l.SetPrompt(fmt.Sprintf("%04x: ", s.PC))
+		line, err := l.Readline()
+		if err != nil {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line == "." {
+			break
+		}
+		pc := s.PC
+		data, err := s.Assemble(line)
+		if err != nil {
+			fmt.Printf("asm: %v\n", err)
+			continue
+		}
+		if len(data) != 0 {
+			d, _, _, _ := z.Disassemble(pc, true)
+			fmt.Printf("%04x: %v\n", pc, d)
+		}
+	}
+	for _, f := range s.Unresolved() {
+		fmt.Printf("$%04x: undefined symbol: %v\n", f.Address, f.Symbol)
+	}
+}
+
+// Source level stepping modes.
+const (
+	lineStepNone = iota
+	lineStepInto // Run until the source line changes
+	lineStepOver // Same but do not stop in called functions
+)
+
+// sourceLine returns the source file, line number and text of the line that
+// address belongs to.
+func sourceLine(symbols *symbol.Table, address uint16) (string, bool) {
+	line, ok := symbols.Source(address)
+	if !ok {
+		return "", false
+	}
+	text, _ := symbols.SourceText(line)
+	return fmt.Sprintf("%v:%v: %v", filepath.Base(line.File), line.Line,
+		strings.TrimSpace(text)), true
+}
+
+// saveState writes the machine state to filename.
+func saveState(z *z80.CPU, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = z.Save(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// restoreState replaces the machine state with the one in filename.
+func restoreState(z *z80.CPU, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return z.Restore(f)
+}
+
+// parseWatchpoint parses the arguments of watch set which are
+// [io] <r|w|rw> <address> [count] [=value].
+func parseWatchpoint(symbols *symbol.Table, args []string) (bus.Watchpoint,
+	error) {
+	var w bus.Watchpoint
+	if len(args) > 0 && args[0] == "io" {
+		w.IO = true
+		args = args[1:]
+	}
+	if n := len(args); n > 0 && strings.HasPrefix(args[n-1], "=") {
+		value, err := parseUint(args[n-1][1:], 8)
+		if err != nil {
+			return w, fmt.Errorf("invalid value: %v", err)
+		}
+		w.HasValue = true
+		w.Value = byte(value)
+		args = args[:n-1]
+	}
+	if len(args) != 2 && len(args) != 3 {
+		return w, fmt.Errorf("watch set [io] <r|w|rw> address " +
+			"[count] [=value]")
+	}
+
+	switch args[0] {
+	case "r":
+		w.Read = true
+	case "w":
+		w.Write = true
+	case "rw":
+		w.Read = true
+		w.Write = true
+	default:
+		return w, fmt.Errorf("invalid access: %v", args[0])
+	}
+	start, err := symbols.Parse(args[1])
+	if err != nil {
+		return w, fmt.Errorf("invalid address: %v", err)
+	}
+	count := uint64(1)
+	if len(args) == 3 {
+		count, err = parseUint(args[2], 32)
+		if err != nil || count == 0 || uint64(start)+count > 0x10000 {
+			return w, fmt.Errorf("invalid count: %v", args[2])
+		}
+	}
+	w.Start = start
+	w.End = start + uint16(count-1)
+	return w, nil
+}
+
+// faultPC returns the address of the instruction that caused err if err is an
+// invalid opcode, a bus fault or a watchpoint.
+func faultPC(err error) (uint16, bool) {
+	switch e := err.(type) {
+	case bus.WatchpointError:
+		return e.PC, true
+	case z80.InvalidOpcodeError:
+		return e.PC, true
+	case bus.BusFaultError:
+		return e.PC, true
+	case bus.UnmappedIOError:
+		return e.PC, true
+	}
+	return 0, false
+}
+
 func main() {
-	err := _main()
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == "asm" {
+		err = asmMain(os.Args[2:])
+	} else {
+		err = _main()
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
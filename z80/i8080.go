@@ -0,0 +1,386 @@
+package z80
+
+// The Intel 8080 shares its registers and most of its instruction encoding
+// with the Z80 but it has no prefixes, computes parity instead of overflow,
+// has its own half carry rules and most instructions take a different number
+// of T-states.  The bytes the Z80 uses as prefixes and relative jumps are
+// undocumented aliases of other instructions.
+//
+// Reference used: Intel 8080 Assembly Language Programming Manual.
+
+// cycles8080 contains the T-states per opcode.  Conditional calls and returns
+// list the not taken case.
+var cycles8080 = [256]uint64{
+	4, 10, 7, 5, 5, 5, 7, 4, 4, 10, 7, 5, 5, 5, 7, 4, // 0x00
+	4, 10, 7, 5, 5, 5, 7, 4, 4, 10, 7, 5, 5, 5, 7, 4, // 0x10
+	4, 10, 16, 5, 5, 5, 7, 4, 4, 10, 16, 5, 5, 5, 7, 4, // 0x20
+	4, 10, 13, 5, 10, 10, 10, 4, 4, 10, 13, 5, 5, 5, 7, 4, // 0x30
+	5, 5, 5, 5, 5, 5, 7, 5, 5, 5, 5, 5, 5, 5, 7, 5, // 0x40
+	5, 5, 5, 5, 5, 5, 7, 5, 5, 5, 5, 5, 5, 5, 7, 5, // 0x50
+	5, 5, 5, 5, 5, 5, 7, 5, 5, 5, 5, 5, 5, 5, 7, 5, // 0x60
+	7, 7, 7, 7, 7, 7, 7, 7, 5, 5, 5, 5, 5, 5, 7, 5, // 0x70
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // 0x80
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // 0x90
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // 0xa0
+	4, 4, 4, 4, 4, 4, 7, 4, 4, 4, 4, 4, 4, 4, 7, 4, // 0xb0
+	5, 10, 10, 10, 11, 11, 7, 11, 5, 10, 10, 10, 11, 17, 7, 11, // 0xc0
+	5, 10, 10, 10, 11, 11, 7, 11, 5, 10, 10, 10, 11, 17, 7, 11, // 0xd0
+	5, 10, 10, 18, 11, 11, 7, 11, 5, 5, 10, 4, 11, 17, 7, 11, // 0xe0
+	5, 10, 10, 4, 11, 11, 7, 11, 5, 5, 10, 4, 11, 17, 7, 11, // 0xf0
+}
+
+// opcodes8080 is the 8080 view of the opcode table.  It is derived from
+// opcodes with the undocumented aliases filled in and 8080 timing.
+var opcodes8080 [256]opcode
+
+func init() {
+	aliases := map[byte]byte{
+		0x08: 0x00, 0x10: 0x00, 0x18: 0x00, 0x20: 0x00, // nop
+		0x28: 0x00, 0x30: 0x00, 0x38: 0x00,
+		0xcb: 0xc3,                         // jmp
+		0xd9: 0xc9,                         // ret
+		0xdd: 0xcd, 0xed: 0xcd, 0xfd: 0xcd, // call
+	}
+	for i := range opcodes8080 {
+		o := opcodes[i]
+		if alias, ok := aliases[byte(i)]; ok {
+			o = opcodes[alias]
+		}
+		o.noCycles = cycles8080[i]
+		opcodes8080[i] = o
+	}
+}
+
+// szp8080 returns the sign, zero and parity flags for val.  Bit 1 of the flags,
+// the Z80 N flag, is always set and bits 3 and 5 are always clear.
+func szp8080(val byte) byte {
+	return sz53pTable[val]&^(FLAG_3|FLAG_5) | FLAG_N
+}
+
+// setFlags8080 replaces the flags.
+func (z *CPU) setFlags8080(f byte) {
+	z.af = z.af&0xff00 | uint16(f)
+}
+
+// add8080 adds val and carry to A.
+func (z *CPU) add8080(val, carry byte) {
+	a := byte(z.af >> 8)
+	t := uint16(a) + uint16(val) + uint16(carry)
+	z.af = t<<8 | z.af&0x00ff
+	z.setFlags8080(szp8080(byte(t)) | ternB(t > 0xff, FLAG_C, 0) |
+		ternB(a&0x0f+val&0x0f+carry > 0x0f, FLAG_H, 0))
+}
+
+// sub8080 subtracts val and borrow from A and returns the result.  The 8080
+// subtracts by adding the complement so the half carry flag is set when there
+// is no borrow from bit 4.
+func (z *CPU) sub8080(val, borrow byte) byte {
+	a := byte(z.af >> 8)
+	t := uint16(a) - uint16(val) - uint16(borrow)
+	z.setFlags8080(szp8080(byte(t)) | ternB(t > 0xff, FLAG_C, 0) |
+		ternB(a&0x0f+^val&0x0f+1-borrow > 0x0f, FLAG_H, 0))
+	return byte(t)
+}
+
+// alu8080 executes add, adc, sub, sbb, ana, xra, ora or cmp on A and val.
+func (z *CPU) alu8080(op, val byte) {
+	a := byte(z.af >> 8)
+	carry := byte(z.af) & FLAG_C
+	switch op {
+	case 0: // add
+		z.add8080(val, 0)
+	case 1: // adc
+		z.add8080(val, carry)
+	case 2: // sub
+		z.af = uint16(z.sub8080(val, 0))<<8 | z.af&0x00ff
+	case 3: // sbb
+		z.af = uint16(z.sub8080(val, carry))<<8 | z.af&0x00ff
+	case 4: // ana, half carry is the or of bit 3 of the operands
+		h := ternB((a|val)&0x08 != 0, FLAG_H, 0)
+		a &= val
+		z.af = uint16(a)<<8 | uint16(szp8080(a)|h)
+	case 5: // xra
+		a ^= val
+		z.af = uint16(a)<<8 | uint16(szp8080(a))
+	case 6: // ora
+		a |= val
+		z.af = uint16(a)<<8 | uint16(szp8080(a))
+	case 7: // cmp
+		z.sub8080(val, 0)
+	}
+}
+
+// daa8080 decimal adjusts A.  Unlike the Z80 there is no subtract flag so the
+// adjustment always assumes the previous instruction was an addition.
+func (z *CPU) daa8080() {
+	a := byte(z.af >> 8)
+	f := byte(z.af)
+	carry := f & FLAG_C
+	correction := byte(0)
+	if f&FLAG_H != 0 || a&0x0f > 9 {
+		correction = 0x06
+	}
+	if carry != 0 || a>>4 > 9 || (a>>4 >= 9 && a&0x0f > 9) {
+		correction |= 0x60
+		carry = FLAG_C
+	}
+	z.add8080(correction, 0)
+	z.setFlags8080(byte(z.af)&^FLAG_C | carry)
+}
+
+// reg8080 returns the register encoded as b, c, d, e, h, l, m or a in an
+// opcode.
+func (z *CPU) reg8080(r byte) byte {
+	switch r {
+	case 0:
+		return byte(z.bc >> 8)
+	case 1:
+		return byte(z.bc)
+	case 2:
+		return byte(z.de >> 8)
+	case 3:
+		return byte(z.de)
+	case 4:
+		return byte(z.hl >> 8)
+	case 5:
+		return byte(z.hl)
+	case 6:
+		return z.bus.Read(z.hl)
+	}
+	return byte(z.af >> 8)
+}
+
+// setReg8080 sets the register encoded as b, c, d, e, h, l, m or a in an
+// opcode.
+func (z *CPU) setReg8080(r, val byte) {
+	switch r {
+	case 0:
+		z.bc = z.bc&0x00ff | uint16(val)<<8
+	case 1:
+		z.bc = z.bc&0xff00 | uint16(val)
+	case 2:
+		z.de = z.de&0x00ff | uint16(val)<<8
+	case 3:
+		z.de = z.de&0xff00 | uint16(val)
+	case 4:
+		z.hl = z.hl&0x00ff | uint16(val)<<8
+	case 5:
+		z.hl = z.hl&0xff00 | uint16(val)
+	case 6:
+		z.bus.Write(z.hl, val)
+	case 7:
+		z.af = z.af&0x00ff | uint16(val)<<8
+	}
+}
+
+// rp8080 returns the register pair encoded as b, d, h or sp in an opcode.
+func (z *CPU) rp8080(rp byte) *uint16 {
+	switch rp {
+	case 0:
+		return &z.bc
+	case 1:
+		return &z.de
+	case 2:
+		return &z.hl
+	}
+	return &z.sp
+}
+
+// cond8080 returns true if the condition encoded as nz, z, nc, c, po, pe, p or
+// m in an opcode is met.
+func (z *CPU) cond8080(c byte) bool {
+	f := byte(z.af)
+	switch c {
+	case 0:
+		return f&FLAG_Z == 0
+	case 1:
+		return f&FLAG_Z != 0
+	case 2:
+		return f&FLAG_C == 0
+	case 3:
+		return f&FLAG_C != 0
+	case 4:
+		return f&FLAG_P == 0
+	case 5:
+		return f&FLAG_P != 0
+	case 6:
+		return f&FLAG_S == 0
+	}
+	return f&FLAG_S != 0
+}
+
+// pop returns the word at the top of the stack.
+func (z *CPU) pop() uint16 {
+	val := uint16(z.bus.Read(z.sp))
+	z.sp++
+	val |= uint16(z.bus.Read(z.sp)) << 8
+	z.sp++
+	return val
+}
+
+// step8080 executes the instruction pointed at by PC as an 8080.
+func (z *CPU) step8080() error {
+	opc := z.fetch(z.pc)
+	o := &opcodes8080[opc]
+	// Only fetch the operand bytes the instruction has, reading past it
+	// can fault or set off a watchpoint.
+	var nn uint16
+	switch o.noBytes {
+	case 3:
+		nn = uint16(z.fetch(z.pc+2)) << 8
+		fallthrough
+	case 2:
+		nn |= uint16(z.fetch(z.pc + 1))
+	}
+	next := z.pc + o.noBytes
+	z.totalCycles += o.noCycles
+
+	// The regular blocks are decoded on the bit fields.
+	switch {
+	case opc == 0x76: // hlt
+		z.halted = true
+		if z.iff1 == 0 {
+			// Nothing can wake us up.
+			return HaltError{PC: z.pc}
+		}
+		return nil
+	case opc >= 0x40 && opc < 0x80: // mov
+		z.setReg8080(opc>>3&0x07, z.reg8080(opc&0x07))
+		z.pc = next
+		return nil
+	case opc >= 0x80 && opc < 0xc0: // add, adc, sub, sbb, ana, xra, ora, cmp
+		z.alu8080(opc>>3&0x07, z.reg8080(opc&0x07))
+		z.pc = next
+		return nil
+	}
+
+	switch opc {
+	case 0x00, 0x08, 0x10, 0x18, 0x20, 0x28, 0x30, 0x38: // nop
+	case 0x01, 0x11, 0x21, 0x31: // lxi
+		*z.rp8080(opc >> 4) = nn
+	case 0x02, 0x12: // stax
+		z.bus.Write(*z.rp8080(opc >> 4), byte(z.af>>8))
+	case 0x03, 0x13, 0x23, 0x33: // inx
+		*z.rp8080(opc >> 4)++
+	case 0x04, 0x0c, 0x14, 0x1c, 0x24, 0x2c, 0x34, 0x3c: // inr
+		val := z.reg8080(opc>>3&0x07) + 1
+		z.setFlags8080(byte(z.af)&FLAG_C | szp8080(val) |
+			ternB(val&0x0f == 0, FLAG_H, 0))
+		z.setReg8080(opc>>3&0x07, val)
+	case 0x05, 0x0d, 0x15, 0x1d, 0x25, 0x2d, 0x35, 0x3d: // dcr
+		val := z.reg8080(opc>>3&0x07) - 1
+		z.setFlags8080(byte(z.af)&FLAG_C | szp8080(val) |
+			ternB(val&0x0f != 0x0f, FLAG_H, 0))
+		z.setReg8080(opc>>3&0x07, val)
+	case 0x06, 0x0e, 0x16, 0x1e, 0x26, 0x2e, 0x36, 0x3e: // mvi
+		z.setReg8080(opc>>3&0x07, byte(nn))
+	case 0x07: // rlc
+		a := byte(z.af >> 8)
+		a = a<<1 | a>>7
+		z.af = uint16(a)<<8 | z.af&^carry&0x00ff | uint16(a&FLAG_C)
+	case 0x09, 0x19, 0x29, 0x39: // dad
+		t := uint(z.hl) + uint(*z.rp8080(opc >> 4))
+		z.hl = uint16(t)
+		z.setFlags8080(byte(z.af)&^FLAG_C | ternB(t > 0xffff, FLAG_C, 0))
+	case 0x0a, 0x1a: // ldax
+		z.af = uint16(z.bus.Read(*z.rp8080(opc >> 4)))<<8 | z.af&0x00ff
+	case 0x0b, 0x1b, 0x2b, 0x3b: // dcx
+		*z.rp8080(opc >> 4)--
+	case 0x0f: // rrc
+		a := byte(z.af >> 8)
+		f := byte(z.af)&^FLAG_C | a&FLAG_C
+		a = a>>1 | a<<7
+		z.af = uint16(a)<<8 | uint16(f)
+	case 0x17: // ral
+		a := byte(z.af >> 8)
+		f := byte(z.af)&^FLAG_C | a>>7
+		a = a<<1 | byte(z.af)&FLAG_C
+		z.af = uint16(a)<<8 | uint16(f)
+	case 0x1f: // rar
+		a := byte(z.af >> 8)
+		f := byte(z.af)&^FLAG_C | a&FLAG_C
+		a = a>>1 | byte(z.af)<<7
+		z.af = uint16(a)<<8 | uint16(f)
+	case 0x22: // shld
+		z.bus.Write(nn, byte(z.hl))
+		z.bus.Write(nn+1, byte(z.hl>>8))
+	case 0x27: // daa
+		z.daa8080()
+	case 0x2a: // lhld
+		z.hl = uint16(z.bus.Read(nn)) | uint16(z.bus.Read(nn+1))<<8
+	case 0x2f: // cma
+		z.af ^= 0xff00
+	case 0x32: // sta
+		z.bus.Write(nn, byte(z.af>>8))
+	case 0x37: // stc
+		z.af |= carry
+	case 0x3a: // lda
+		z.af = uint16(z.bus.Read(nn))<<8 | z.af&0x00ff
+	case 0x3f: // cmc
+		z.af ^= carry
+	case 0xc0, 0xc8, 0xd0, 0xd8, 0xe0, 0xe8, 0xf0, 0xf8: // rcc
+		if z.cond8080(opc >> 3 & 0x07) {
+			z.totalCycles += 6
+			next = z.pop()
+		}
+	case 0xc1, 0xd1, 0xe1: // pop
+		*z.rp8080(opc >> 4 & 0x03) = z.pop()
+	case 0xc2, 0xca, 0xd2, 0xda, 0xe2, 0xea, 0xf2, 0xfa: // jcc
+		if z.cond8080(opc >> 3 & 0x07) {
+			next = nn
+		}
+	case 0xc3, 0xcb: // jmp
+		next = nn
+	case 0xc4, 0xcc, 0xd4, 0xdc, 0xe4, 0xec, 0xf4, 0xfc: // ccc
+		if z.cond8080(opc >> 3 & 0x07) {
+			z.totalCycles += 6
+			z.push(next)
+			next = nn
+		}
+	case 0xc5, 0xd5, 0xe5: // push
+		z.push(*z.rp8080(opc >> 4 & 0x03))
+	case 0xc6, 0xce, 0xd6, 0xde, 0xe6, 0xee, 0xf6, 0xfe: // alu immediate
+		z.alu8080(opc>>3&0x07, byte(nn))
+	case 0xc7, 0xcf, 0xd7, 0xdf, 0xe7, 0xef, 0xf7, 0xff: // rst
+		z.push(next)
+		next = uint16(opc & 0x38)
+	case 0xc9, 0xd9: // ret
+		next = z.pop()
+	case 0xcd, 0xdd, 0xed, 0xfd: // call
+		z.push(next)
+		next = nn
+	case 0xd3: // out
+		// The port number is put on both halves of the address bus.
+		z.bus.IOWrite(uint16(byte(nn))<<8|uint16(byte(nn)),
+			byte(z.af>>8))
+	case 0xdb: // in
+		z.af = uint16(z.bus.IORead(uint16(byte(nn))<<8|
+			uint16(byte(nn))))<<8 | z.af&0x00ff
+	case 0xe3: // xthl
+		t := uint16(z.bus.Read(z.sp)) | uint16(z.bus.Read(z.sp+1))<<8
+		z.bus.Write(z.sp, byte(z.hl))
+		z.bus.Write(z.sp+1, byte(z.hl>>8))
+		z.hl = t
+	case 0xe9: // pchl
+		next = z.hl
+	case 0xeb: // xchg
+		z.de, z.hl = z.hl, z.de
+	case 0xf1: // pop psw
+		z.af = z.pop()
+		z.setFlags8080(byte(z.af)&^(FLAG_3|FLAG_5) | FLAG_N)
+	case 0xf3: // di
+		z.iff1 = 0
+		z.iff2 = 0
+	case 0xf5: // push psw
+		z.push(z.af&^uint16(FLAG_3|FLAG_5) | uint16(FLAG_N))
+	case 0xf9: // sphl
+		z.sp = z.hl
+	case 0xfb: // ei
+		z.iff1 = 1
+		z.iff2 = 1
+		z.eiDelay = true
+	}
+	z.pc = next
+
+	return nil
+}
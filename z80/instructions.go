@@ -0,0 +1,161 @@
+package z80
+
+import "strings"
+
+// OperandKind describes what an instruction operand accepts.
+type OperandKind int
+
+const (
+	OperandLiteral           OperandKind = iota // Text as is, e.g. a, (hl), nz or 7
+	OperandImmediate                            // 8 bit value
+	OperandImmediateExtended                    // 16 bit value
+	OperandExtended                             // 16 bit address in parentheses
+	OperandIndirect                             // 8 bit port in parentheses
+	OperandDisplacement                         // Relative jump target
+	OperandIndexed                              // (Text+d) with signed offset d
+)
+
+// Operand is an operand of an instruction form.
+type Operand struct {
+	Kind OperandKind
+	Text string // Literal or index register
+}
+
+// Instruction is one form of an instruction and its encoding.  The operand
+// bytes follow Opcode in operand order, except for dd cb and fd cb
+// instructions where the index offset precedes the last opcode byte.
+type Instruction struct {
+	Mnemonic string
+	Operands []Operand
+	Opcode   []byte // Prefix and opcode bytes
+	Size     int    // Size in bytes including operands
+}
+
+// Instructions returns all instruction forms of the CPU in mode as described
+// by the opcode tables.  The unprefixed table comes first followed by the cb,
+// ed, dd and fd tables, so unprefixed encodings precede their aliases.
+func Instructions(mode CPUMode) []Instruction {
+	if mode == Mode8080 {
+		return tableInstructions(opcodes8080[:], mode, nil)
+	}
+
+	var in []Instruction
+	in = append(in, tableInstructions(opcodes, mode, nil)...)
+	in = append(in, tableInstructions(opcodesCB[:], mode, []byte{0xcb})...)
+	in = append(in, tableInstructions(opcodesED[:], mode, []byte{0xed})...)
+	in = append(in, tableInstructions(opcodesDD[:], mode, []byte{0xdd})...)
+	in = append(in, tableInstructions(opcodesFD[:], mode, []byte{0xfd})...)
+	return in
+}
+
+// tableInstructions returns the instruction forms of an opcode table.
+func tableInstructions(table []opcode, mode CPUMode, prefix []byte) []Instruction {
+	var in []Instruction
+	for i := range table {
+		o := &table[i]
+		if o.multiByte || int(mode) >= len(o.mnemonic) ||
+			o.mnemonic[mode] == "" {
+			continue
+		}
+		opc := append(append([]byte{}, prefix...), byte(i))
+		if o.dst == bitIndexed {
+			in = append(in, bitIndexedInstructions(o, opc)...)
+			continue
+		}
+		in = append(in, Instruction{
+			Mnemonic: strings.TrimSpace(o.mnemonic[mode]),
+			Operands: append(operand(o.dst, o.dstR, mode),
+				operand(o.src, o.srcR, mode)...),
+			Opcode: opc,
+			Size:   int(o.noBytes),
+		})
+	}
+	return in
+}
+
+// operand returns the operand described by an addressing mode and its
+// disassembly cheat.  It returns nothing if there is no operand.
+func operand(m mode, r []string, cpu CPUMode) []Operand {
+	var text string
+	if int(cpu) < len(r) {
+		text = strings.TrimSpace(r[cpu])
+	}
+
+	var o Operand
+	switch m {
+	case register, implied, condition:
+		if text == "" {
+			return nil
+		}
+		o = Operand{Kind: OperandLiteral, Text: text}
+	case registerIndirect:
+		if text == "" {
+			return nil
+		}
+		if cpu != Mode8080 {
+			text = "(" + text + ")"
+		}
+		o = Operand{Kind: OperandLiteral, Text: text}
+	case immediate:
+		o = Operand{Kind: OperandImmediate}
+	case immediateExtended:
+		o = Operand{Kind: OperandImmediateExtended}
+	case extended:
+		o = Operand{Kind: OperandExtended}
+		if cpu == Mode8080 {
+			o.Kind = OperandImmediateExtended
+		}
+	case indirect:
+		o = Operand{Kind: OperandIndirect}
+		if cpu == Mode8080 {
+			o.Kind = OperandImmediate
+		}
+	case displacement:
+		o = Operand{Kind: OperandDisplacement}
+	case indexed:
+		o = Operand{Kind: OperandIndexed, Text: text}
+	default:
+		return nil
+	}
+	return []Operand{o}
+}
+
+// bitIndexedInstructions returns the dd cb or fd cb instruction forms.  They
+// are described by the cb table the same way the disassembler does.  Bit
+// ignores the register field so only its documented encoding is returned.
+func bitIndexedInstructions(o *opcode, prefix []byte) []Instruction {
+	var in []Instruction
+	for i := range opcodesCB {
+		cb := &opcodesCB[i]
+		if len(cb.mnemonic) == 0 || (i>>6 == 1 && i&0x07 != 6) {
+			continue
+		}
+		var (
+			index    = Operand{Kind: OperandIndexed, Text: o.srcR[0]}
+			y        = Operand{Text: strings.TrimSpace(cb.dstR[0])}
+			operands []Operand
+		)
+		switch i >> 6 {
+		case 0x00: // rot[y] (IX+d)[,r[z]]
+			operands = []Operand{index}
+			if i&0x07 != 6 {
+				operands = append(operands, y)
+			}
+		case 0x01: // bit y, (IX+d)
+			operands = []Operand{y, index}
+		default: // res/set y, (IX+d)[,r[z]]
+			operands = []Operand{y, index}
+			if i&0x07 != 6 {
+				operands = append(operands,
+					Operand{Text: strings.TrimSpace(cb.srcR[0])})
+			}
+		}
+		in = append(in, Instruction{
+			Mnemonic: strings.TrimSpace(cb.mnemonic[0]),
+			Operands: operands,
+			Opcode:   append(append([]byte{}, prefix...), byte(i)),
+			Size:     4,
+		})
+	}
+	return in
+}
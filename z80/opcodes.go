@@ -1122,7 +1122,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0x87: {
 			mnemonic: []string{"res", ""},
@@ -1194,7 +1194,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0x8f: {
 			mnemonic: []string{"res", ""},
@@ -1266,7 +1266,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0x97: {
 			mnemonic: []string{"res", ""},
@@ -1338,7 +1338,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0x9f: {
 			mnemonic: []string{"res", ""},
@@ -1410,7 +1410,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xa7: {
 			mnemonic: []string{"res", ""},
@@ -1482,7 +1482,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xaf: {
 			mnemonic: []string{"res", ""},
@@ -1554,7 +1554,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xb7: {
 			mnemonic: []string{"res", ""},
@@ -1626,7 +1626,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xbf: {
 			mnemonic: []string{"res", ""},
@@ -1698,7 +1698,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xc7: {
 			mnemonic: []string{"set", ""},
@@ -1770,7 +1770,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xcf: {
 			mnemonic: []string{"set", ""},
@@ -1842,7 +1842,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xd7: {
 			mnemonic: []string{"set", ""},
@@ -1914,7 +1914,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xdf: {
 			mnemonic: []string{"set", ""},
@@ -1986,7 +1986,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xe7: {
 			mnemonic: []string{"set", ""},
@@ -2058,7 +2058,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xef: {
 			mnemonic: []string{"set", ""},
@@ -2130,7 +2130,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xf7: {
 			mnemonic: []string{"set", ""},
@@ -2202,7 +2202,7 @@ var (
 			src:      registerIndirect,
 			srcR:     []string{"hl"},
 			noBytes:  2,
-			noCycles: 12,
+			noCycles: 15,
 		},
 		0xff: {
 			mnemonic: []string{"set", ""},
@@ -2261,21 +2261,22 @@ var (
 			dst:      register,
 			dstR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10,
+			noCycles: 8,
 		},
 		0x25: {
 			mnemonic: []string{"dec"},
 			dst:      register,
 			dstR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10,
+			noCycles: 8,
 		},
 		0x26: {
 			mnemonic: []string{"ld"},
 			dst:      register,
 			dstR:     []string{"ixh"},
+			src:      immediate,
 			noBytes:  3,
-			noCycles: 10, // XXX guess
+			noCycles: 11,
 		},
 		0x29: {
 			mnemonic: []string{"add"},
@@ -2306,21 +2307,22 @@ var (
 			dst:      register,
 			dstR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10,
+			noCycles: 8,
 		},
 		0x2d: {
 			mnemonic: []string{"dec"},
 			dst:      register,
 			dstR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10,
+			noCycles: 8,
 		},
 		0x2e: {
 			mnemonic: []string{"ld"},
 			dst:      register,
 			dstR:     []string{"ixl"},
+			src:      immediate,
 			noBytes:  3,
-			noCycles: 10, // XXX guess
+			noCycles: 11,
 		},
 		0x39: {
 			mnemonic: []string{"add"},
@@ -2659,14 +2661,6 @@ var (
 			noBytes:  3,
 			noCycles: 19,
 		},
-		0x76: {
-			mnemonic: []string{"ld"},
-			dst:      indexed,
-			dstR:     []string{"ix"},
-			src:      immediate,
-			noBytes:  4,
-			noCycles: 19,
-		},
 		0x77: {
 			mnemonic: []string{"ld"},
 			dst:      indexed,
@@ -2710,7 +2704,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x85: {
 			mnemonic: []string{"add"},
@@ -2719,7 +2713,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x86: {
 			mnemonic: []string{"add"},
@@ -2737,7 +2731,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x8d: {
 			mnemonic: []string{"adc"},
@@ -2746,7 +2740,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x8e: {
 			mnemonic: []string{"adc"},
@@ -2764,7 +2758,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x95: {
 			mnemonic: []string{"sub"},
@@ -2773,7 +2767,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x96: {
 			mnemonic: []string{"sub"},
@@ -2791,7 +2785,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x9d: {
 			mnemonic: []string{"sbc"},
@@ -2800,7 +2794,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x9e: {
 			mnemonic: []string{"sbc"},
@@ -2818,7 +2812,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xa5: {
 			mnemonic: []string{"and"},
@@ -2827,7 +2821,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xa6: {
 			mnemonic: []string{"and"},
@@ -2843,7 +2837,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xad: {
 			mnemonic: []string{"xor"},
@@ -2852,7 +2846,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xae: {
 			mnemonic: []string{"xor"},
@@ -2868,7 +2862,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xb5: {
 			mnemonic: []string{"or"},
@@ -2877,7 +2871,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xb6: {
 			mnemonic: []string{"or"},
@@ -2893,7 +2887,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xbd: {
 			mnemonic: []string{"cp"},
@@ -2902,7 +2896,7 @@ var (
 			src:      register,
 			srcR:     []string{"ixl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xbe: {
 			mnemonic: []string{"cp"},
@@ -2926,6 +2920,15 @@ var (
 			noBytes:  2,
 			noCycles: 14,
 		},
+		0xe3: opcode{
+			mnemonic: []string{"ex"},
+			dst:      registerIndirect,
+			dstR:     []string{"sp"},
+			src:      register,
+			srcR:     []string{"ix"},
+			noBytes:  2,
+			noCycles: 23,
+		},
 		0xe5: opcode{
 			mnemonic: []string{"push"},
 			dst:      register,
@@ -2933,6 +2936,13 @@ var (
 			noBytes:  2,
 			noCycles: 15,
 		},
+		0xe9: opcode{
+			mnemonic: []string{"jp"},
+			dst:      registerIndirect,
+			dstR:     []string{"ix"},
+			noBytes:  2,
+			noCycles: 8,
+		},
 		0xf9: opcode{
 			mnemonic: []string{"ld"},
 			dst:      register,
@@ -2944,6 +2954,24 @@ var (
 		},
 	}
 	opcodesED = [256]opcode{
+		0x40: {
+			mnemonic: []string{"in"},
+			dst:      register,
+			dstR:     []string{"b"},
+			src:      registerIndirect,
+			srcR:     []string{"c"},
+			noBytes:  2,
+			noCycles: 12,
+		},
+		0x41: {
+			mnemonic: []string{"out"},
+			dst:      registerIndirect,
+			dstR:     []string{"c"},
+			src:      register,
+			srcR:     []string{"b"},
+			noBytes:  2,
+			noCycles: 12,
+		},
 		0x42: {
 			mnemonic: []string{"sbc"},
 			dst:      register,
@@ -2966,6 +2994,45 @@ var (
 			noBytes:  2,
 			noCycles: 8,
 		},
+		0x45: {
+			mnemonic: []string{"retn"},
+			noBytes:  2,
+			noCycles: 14,
+		},
+		0x46: {
+			mnemonic: []string{"im"},
+			dst:      implied,
+			dstR:     []string{"0"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x47: {
+			mnemonic: []string{"ld"},
+			dst:      register,
+			dstR:     []string{"i"},
+			src:      register,
+			srcR:     []string{"a"},
+			noBytes:  2,
+			noCycles: 9,
+		},
+		0x48: {
+			mnemonic: []string{"in"},
+			dst:      register,
+			dstR:     []string{"c"},
+			src:      registerIndirect,
+			srcR:     []string{"c"},
+			noBytes:  2,
+			noCycles: 12,
+		},
+		0x49: {
+			mnemonic: []string{"out"},
+			dst:      registerIndirect,
+			dstR:     []string{"c"},
+			src:      register,
+			srcR:     []string{"c"},
+			noBytes:  2,
+			noCycles: 12,
+		},
 		0x4a: {
 			mnemonic: []string{"adc"},
 			dst:      register,
@@ -2983,11 +3050,50 @@ var (
 			noBytes:  4,
 			noCycles: 20,
 		},
+		0x4c: {
+			mnemonic: []string{"neg"},
+			noBytes:  2,
+			noCycles: 8,
+		},
 		0x4d: {
 			mnemonic: []string{"reti"},
 			noBytes:  2,
 			noCycles: 14,
 		},
+		0x4e: {
+			mnemonic: []string{"im"},
+			dst:      implied,
+			dstR:     []string{"0"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x4f: {
+			mnemonic: []string{"ld"},
+			dst:      register,
+			dstR:     []string{"r"},
+			src:      register,
+			srcR:     []string{"a"},
+			noBytes:  2,
+			noCycles: 9,
+		},
+		0x50: {
+			mnemonic: []string{"in"},
+			dst:      register,
+			dstR:     []string{"d"},
+			src:      registerIndirect,
+			srcR:     []string{"c"},
+			noBytes:  2,
+			noCycles: 12,
+		},
+		0x51: {
+			mnemonic: []string{"out"},
+			dst:      registerIndirect,
+			dstR:     []string{"c"},
+			src:      register,
+			srcR:     []string{"d"},
+			noBytes:  2,
+			noCycles: 12,
+		},
 		0x52: {
 			mnemonic: []string{"sbc"},
 			dst:      register,
@@ -3005,6 +3111,50 @@ var (
 			noBytes:  4,
 			noCycles: 20,
 		},
+		0x54: {
+			mnemonic: []string{"neg"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x55: {
+			mnemonic: []string{"retn"},
+			noBytes:  2,
+			noCycles: 14,
+		},
+		0x56: {
+			mnemonic: []string{"im"},
+			dst:      implied,
+			dstR:     []string{"1"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x57: {
+			mnemonic: []string{"ld"},
+			dst:      register,
+			dstR:     []string{"a"},
+			src:      register,
+			srcR:     []string{"i"},
+			noBytes:  2,
+			noCycles: 9,
+		},
+		0x58: {
+			mnemonic: []string{"in"},
+			dst:      register,
+			dstR:     []string{"e"},
+			src:      registerIndirect,
+			srcR:     []string{"c"},
+			noBytes:  2,
+			noCycles: 12,
+		},
+		0x59: {
+			mnemonic: []string{"out"},
+			dst:      registerIndirect,
+			dstR:     []string{"c"},
+			src:      register,
+			srcR:     []string{"e"},
+			noBytes:  2,
+			noCycles: 12,
+		},
 		0x5a: {
 			mnemonic: []string{"adc"},
 			dst:      register,
@@ -3022,6 +3172,50 @@ var (
 			noBytes:  4,
 			noCycles: 20,
 		},
+		0x5c: {
+			mnemonic: []string{"neg"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x5d: {
+			mnemonic: []string{"retn"},
+			noBytes:  2,
+			noCycles: 14,
+		},
+		0x5e: {
+			mnemonic: []string{"im"},
+			dst:      implied,
+			dstR:     []string{"2"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x5f: {
+			mnemonic: []string{"ld"},
+			dst:      register,
+			dstR:     []string{"a"},
+			src:      register,
+			srcR:     []string{"r"},
+			noBytes:  2,
+			noCycles: 9,
+		},
+		0x60: {
+			mnemonic: []string{"in"},
+			dst:      register,
+			dstR:     []string{"h"},
+			src:      registerIndirect,
+			srcR:     []string{"c"},
+			noBytes:  2,
+			noCycles: 12,
+		},
+		0x61: {
+			mnemonic: []string{"out"},
+			dst:      registerIndirect,
+			dstR:     []string{"c"},
+			src:      register,
+			srcR:     []string{"h"},
+			noBytes:  2,
+			noCycles: 12,
+		},
 		0x62: {
 			mnemonic: []string{"sbc"},
 			dst:      register,
@@ -3031,11 +3225,54 @@ var (
 			noBytes:  2,
 			noCycles: 15,
 		},
+		0x63: {
+			mnemonic: []string{"ld"},
+			dst:      extended,
+			src:      register,
+			srcR:     []string{"hl"},
+			noBytes:  4,
+			noCycles: 20,
+		},
+		0x64: {
+			mnemonic: []string{"neg"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x65: {
+			mnemonic: []string{"retn"},
+			noBytes:  2,
+			noCycles: 14,
+		},
+		0x66: {
+			mnemonic: []string{"im"},
+			dst:      implied,
+			dstR:     []string{"0"},
+			noBytes:  2,
+			noCycles: 8,
+		},
 		0x67: {
 			mnemonic: []string{"rrd"},
 			noBytes:  2,
 			noCycles: 18,
 		},
+		0x68: {
+			mnemonic: []string{"in"},
+			dst:      register,
+			dstR:     []string{"l"},
+			src:      registerIndirect,
+			srcR:     []string{"c"},
+			noBytes:  2,
+			noCycles: 12,
+		},
+		0x69: {
+			mnemonic: []string{"out"},
+			dst:      registerIndirect,
+			dstR:     []string{"c"},
+			src:      register,
+			srcR:     []string{"l"},
+			noBytes:  2,
+			noCycles: 12,
+		},
 		0x6a: {
 			mnemonic: []string{"adc"},
 			dst:      register,
@@ -3053,11 +3290,44 @@ var (
 			noBytes:  4,
 			noCycles: 20,
 		},
+		0x6c: {
+			mnemonic: []string{"neg"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x6d: {
+			mnemonic: []string{"retn"},
+			noBytes:  2,
+			noCycles: 14,
+		},
+		0x6e: {
+			mnemonic: []string{"im"},
+			dst:      implied,
+			dstR:     []string{"0"},
+			noBytes:  2,
+			noCycles: 8,
+		},
 		0x6f: {
 			mnemonic: []string{"rld"},
 			noBytes:  2,
 			noCycles: 18,
 		},
+		0x70: {
+			mnemonic: []string{"in"},
+			dst:      registerIndirect,
+			dstR:     []string{"c"},
+			noBytes:  2,
+			noCycles: 12,
+		},
+		0x71: {
+			mnemonic: []string{"out"},
+			dst:      registerIndirect,
+			dstR:     []string{"c"},
+			src:      register,
+			srcR:     []string{"0"},
+			noBytes:  2,
+			noCycles: 12,
+		},
 		0x72: {
 			mnemonic: []string{"sbc"},
 			dst:      register,
@@ -3075,6 +3345,41 @@ var (
 			noBytes:  4,
 			noCycles: 20,
 		},
+		0x74: {
+			mnemonic: []string{"neg"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x75: {
+			mnemonic: []string{"retn"},
+			noBytes:  2,
+			noCycles: 14,
+		},
+		0x76: {
+			mnemonic: []string{"im"},
+			dst:      implied,
+			dstR:     []string{"1"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x78: {
+			mnemonic: []string{"in"},
+			dst:      register,
+			dstR:     []string{"a"},
+			src:      registerIndirect,
+			srcR:     []string{"c"},
+			noBytes:  2,
+			noCycles: 12,
+		},
+		0x79: {
+			mnemonic: []string{"out"},
+			dst:      registerIndirect,
+			dstR:     []string{"c"},
+			src:      register,
+			srcR:     []string{"a"},
+			noBytes:  2,
+			noCycles: 12,
+		},
 		0x7a: {
 			mnemonic: []string{"adc"},
 			dst:      register,
@@ -3092,6 +3397,23 @@ var (
 			noBytes:  4,
 			noCycles: 20,
 		},
+		0x7c: {
+			mnemonic: []string{"neg"},
+			noBytes:  2,
+			noCycles: 8,
+		},
+		0x7d: {
+			mnemonic: []string{"retn"},
+			noBytes:  2,
+			noCycles: 14,
+		},
+		0x7e: {
+			mnemonic: []string{"im"},
+			dst:      implied,
+			dstR:     []string{"2"},
+			noBytes:  2,
+			noCycles: 8,
+		},
 		0xa0: {
 			mnemonic: []string{"ldi"},
 			noBytes:  2,
@@ -3102,6 +3424,16 @@ var (
 			noBytes:  2,
 			noCycles: 16,
 		},
+		0xa2: {
+			mnemonic: []string{"ini"},
+			noBytes:  2,
+			noCycles: 16,
+		},
+		0xa3: {
+			mnemonic: []string{"outi"},
+			noBytes:  2,
+			noCycles: 16,
+		},
 		0xa8: {
 			mnemonic: []string{"ldd"},
 			noBytes:  2,
@@ -3112,6 +3444,16 @@ var (
 			noBytes:  2,
 			noCycles: 16,
 		},
+		0xaa: {
+			mnemonic: []string{"ind"},
+			noBytes:  2,
+			noCycles: 16,
+		},
+		0xab: {
+			mnemonic: []string{"outd"},
+			noBytes:  2,
+			noCycles: 16,
+		},
 		0xb0: {
 			mnemonic: []string{"ldir"},
 			noBytes:  2,
@@ -3122,6 +3464,16 @@ var (
 			noBytes:  2,
 			noCycles: 16, // bc == 0 case
 		},
+		0xb2: {
+			mnemonic: []string{"inir"},
+			noBytes:  2,
+			noCycles: 16, // b == 0 case
+		},
+		0xb3: {
+			mnemonic: []string{"otir"},
+			noBytes:  2,
+			noCycles: 16, // b == 0 case
+		},
 		0xb8: {
 			mnemonic: []string{"lddr"},
 			noBytes:  2,
@@ -3132,6 +3484,16 @@ var (
 			noBytes:  2,
 			noCycles: 16, // bc == 0 case
 		},
+		0xba: {
+			mnemonic: []string{"indr"},
+			noBytes:  2,
+			noCycles: 16, // b == 0 case
+		},
+		0xbb: {
+			mnemonic: []string{"otdr"},
+			noBytes:  2,
+			noCycles: 16, // b == 0 case
+		},
 	}
 	opcodesFD = [256]opcode{
 		0x09: {
@@ -3180,21 +3542,22 @@ var (
 			dst:      register,
 			dstR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10,
+			noCycles: 8,
 		},
 		0x25: {
 			mnemonic: []string{"dec"},
 			dst:      register,
 			dstR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10,
+			noCycles: 8,
 		},
 		0x26: {
 			mnemonic: []string{"ld"},
 			dst:      register,
 			dstR:     []string{"iyh"},
+			src:      immediate,
 			noBytes:  3,
-			noCycles: 10, // XXX guess
+			noCycles: 11,
 		},
 		0x29: {
 			mnemonic: []string{"add"},
@@ -3225,21 +3588,22 @@ var (
 			dst:      register,
 			dstR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10,
+			noCycles: 8,
 		},
 		0x2d: {
 			mnemonic: []string{"dec"},
 			dst:      register,
 			dstR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10,
+			noCycles: 8,
 		},
 		0x2e: {
 			mnemonic: []string{"ld"},
 			dst:      register,
 			dstR:     []string{"iyl"},
+			src:      immediate,
 			noBytes:  3,
-			noCycles: 10, // XXX guess
+			noCycles: 11,
 		},
 		0x34: {
 			mnemonic: []string{"inc"},
@@ -3578,14 +3942,6 @@ var (
 			noBytes:  3,
 			noCycles: 19,
 		},
-		0x76: {
-			mnemonic: []string{"ld"},
-			dst:      indexed,
-			dstR:     []string{"iy"},
-			src:      immediate,
-			noBytes:  4,
-			noCycles: 19,
-		},
 		0x77: {
 			mnemonic: []string{"ld"},
 			dst:      indexed,
@@ -3629,7 +3985,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x85: {
 			mnemonic: []string{"add"},
@@ -3638,7 +3994,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x86: {
 			mnemonic: []string{"add"},
@@ -3656,7 +4012,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x8d: {
 			mnemonic: []string{"adc"},
@@ -3665,7 +4021,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x8e: {
 			mnemonic: []string{"adc"},
@@ -3683,7 +4039,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x95: {
 			mnemonic: []string{"sub"},
@@ -3692,7 +4048,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x96: {
 			mnemonic: []string{"sub"},
@@ -3710,7 +4066,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x9d: {
 			mnemonic: []string{"sbc"},
@@ -3719,7 +4075,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0x9e: {
 			mnemonic: []string{"sbc"},
@@ -3737,7 +4093,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xa5: {
 			mnemonic: []string{"and"},
@@ -3746,7 +4102,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xa6: {
 			mnemonic: []string{"and"},
@@ -3762,7 +4118,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xad: {
 			mnemonic: []string{"xor"},
@@ -3771,7 +4127,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xae: {
 			mnemonic: []string{"xor"},
@@ -3787,7 +4143,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xb5: {
 			mnemonic: []string{"or"},
@@ -3796,7 +4152,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xb6: {
 			mnemonic: []string{"or"},
@@ -3812,7 +4168,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyh"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xbd: {
 			mnemonic: []string{"cp"},
@@ -3821,7 +4177,7 @@ var (
 			src:      register,
 			srcR:     []string{"iyl"},
 			noBytes:  2,
-			noCycles: 10, // XXX guess
+			noCycles: 8,
 		},
 		0xbe: {
 			mnemonic: []string{"cp"},
@@ -3845,6 +4201,15 @@ var (
 			noBytes:  2,
 			noCycles: 14,
 		},
+		0xe3: opcode{
+			mnemonic: []string{"ex"},
+			dst:      registerIndirect,
+			dstR:     []string{"sp"},
+			src:      register,
+			srcR:     []string{"iy"},
+			noBytes:  2,
+			noCycles: 23,
+		},
 		0xe5: opcode{
 			mnemonic: []string{"push"},
 			dst:      register,
@@ -3852,6 +4217,13 @@ var (
 			noBytes:  2,
 			noCycles: 15,
 		},
+		0xe9: opcode{
+			mnemonic: []string{"jp"},
+			dst:      registerIndirect,
+			dstR:     []string{"iy"},
+			noBytes:  2,
+			noCycles: 8,
+		},
 		0xf9: opcode{
 			mnemonic: []string{"ld"},
 			dst:      register,
@@ -3966,7 +4338,7 @@ var (
 		opcode{
 			mnemonic: []string{"dec", "dcx"},
 			dst:      register,
-			dstR:     []string{"bc", "d"},
+			dstR:     []string{"bc", "b"},
 			noBytes:  1,
 			noCycles: 6,
 		},
@@ -4299,7 +4671,7 @@ var (
 		opcode{
 			mnemonic: []string{"inc", "inx"},
 			dst:      register,
-			dstR:     []string{"sp", "h"},
+			dstR:     []string{"sp", "sp"},
 			noBytes:  1,
 			noCycles: 6,
 		},
@@ -4313,7 +4685,7 @@ var (
 		},
 		// 0x35 dec (hl)
 		opcode{
-			mnemonic: []string{"dec", "dec"},
+			mnemonic: []string{"dec", "dcr"},
 			dst:      registerIndirect,
 			dstR:     []string{"hl", "m"},
 			noBytes:  1,
@@ -4321,7 +4693,7 @@ var (
 		},
 		// 0x36 ld (hl),n
 		opcode{
-			mnemonic: []string{"ld", "mov"},
+			mnemonic: []string{"ld", "mvi"},
 			dst:      registerIndirect,
 			dstR:     []string{"hl", "m"},
 			src:      immediate,
@@ -4360,7 +4732,7 @@ var (
 			dstR:     []string{"a", ""},
 			src:      extended,
 			noBytes:  3,
-			noCycles: 7,
+			noCycles: 13,
 		},
 		// 0x3b dec sp
 		opcode{
@@ -4889,7 +5261,7 @@ var (
 		opcode{
 			mnemonic: []string{"ld", "mov"},
 			dst:      registerIndirect,
-			dstR:     []string{"hl", "hl"},
+			dstR:     []string{"hl", "m"},
 			src:      register,
 			srcR:     []string{"b", "b"},
 			noBytes:  1,
@@ -4899,7 +5271,7 @@ var (
 		opcode{
 			mnemonic: []string{"ld", "mov"},
 			dst:      registerIndirect,
-			dstR:     []string{"hl", "hl"},
+			dstR:     []string{"hl", "m"},
 			src:      register,
 			srcR:     []string{"c", "c"},
 			noBytes:  1,
@@ -4909,7 +5281,7 @@ var (
 		opcode{
 			mnemonic: []string{"ld", "mov"},
 			dst:      registerIndirect,
-			dstR:     []string{"hl", "hl"},
+			dstR:     []string{"hl", "m"},
 			src:      register,
 			srcR:     []string{"d", "d"},
 			noBytes:  1,
@@ -4919,7 +5291,7 @@ var (
 		opcode{
 			mnemonic: []string{"ld", "mov"},
 			dst:      registerIndirect,
-			dstR:     []string{"hl", "hl"},
+			dstR:     []string{"hl", "m"},
 			src:      register,
 			srcR:     []string{"e", "e"},
 			noBytes:  1,
@@ -4929,7 +5301,7 @@ var (
 		opcode{
 			mnemonic: []string{"ld", "mov"},
 			dst:      registerIndirect,
-			dstR:     []string{"hl", "hl"},
+			dstR:     []string{"hl", "m"},
 			src:      register,
 			srcR:     []string{"h", "h"},
 			noBytes:  1,
@@ -4939,7 +5311,7 @@ var (
 		opcode{
 			mnemonic: []string{"ld", "mov"},
 			dst:      registerIndirect,
-			dstR:     []string{"hl", "hl"},
+			dstR:     []string{"hl", "m"},
 			src:      register,
 			srcR:     []string{"l", "l"},
 			noBytes:  1,
@@ -4955,7 +5327,7 @@ var (
 		opcode{
 			mnemonic: []string{"ld", "mov"},
 			dst:      registerIndirect,
-			dstR:     []string{"hl", "hl"},
+			dstR:     []string{"hl", "m"},
 			src:      register,
 			srcR:     []string{"a", "a"},
 			noBytes:  1,
@@ -5048,7 +5420,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "b"},
 			src:      register,
-			srcR:     []string{"b"},
+			srcR:     []string{"b", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5058,7 +5430,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "c"},
 			src:      register,
-			srcR:     []string{"c"},
+			srcR:     []string{"c", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5068,7 +5440,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "d"},
 			src:      register,
-			srcR:     []string{"d"},
+			srcR:     []string{"d", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5078,7 +5450,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "e"},
 			src:      register,
-			srcR:     []string{"e"},
+			srcR:     []string{"e", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5088,7 +5460,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "h"},
 			src:      register,
-			srcR:     []string{"h"},
+			srcR:     []string{"h", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5098,7 +5470,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "l"},
 			src:      register,
-			srcR:     []string{"l"},
+			srcR:     []string{"l", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5112,13 +5484,13 @@ var (
 			noBytes:  1,
 			noCycles: 7,
 		},
-		// 0x85 add a,a
+		// 0x87 add a,a
 		opcode{
 			mnemonic: []string{"add", "add"},
 			dst:      register,
 			dstR:     []string{"a", "a"},
 			src:      register,
-			srcR:     []string{"a"},
+			srcR:     []string{"a", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5128,7 +5500,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "b"},
 			src:      register,
-			srcR:     []string{"b"},
+			srcR:     []string{"b", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5138,7 +5510,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "c"},
 			src:      register,
-			srcR:     []string{"c"},
+			srcR:     []string{"c", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5148,7 +5520,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "d"},
 			src:      register,
-			srcR:     []string{"d"},
+			srcR:     []string{"d", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5158,7 +5530,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "e"},
 			src:      register,
-			srcR:     []string{"e"},
+			srcR:     []string{"e", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5168,7 +5540,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "h"},
 			src:      register,
-			srcR:     []string{"h"},
+			srcR:     []string{"h", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5178,7 +5550,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "l"},
 			src:      register,
-			srcR:     []string{"l"},
+			srcR:     []string{"l", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5187,8 +5559,8 @@ var (
 			mnemonic: []string{"adc", "adc"},
 			dst:      register,
 			dstR:     []string{"a", "m"},
-			src:      register,
-			srcR:     []string{"(hl)"},
+			src:      registerIndirect,
+			srcR:     []string{"hl", ""},
 			noBytes:  1,
 			noCycles: 7,
 		},
@@ -5196,9 +5568,9 @@ var (
 		opcode{
 			mnemonic: []string{"adc", "adc"},
 			dst:      register,
-			dstR:     []string{"a", "l"},
+			dstR:     []string{"a", "a"},
 			src:      register,
-			srcR:     []string{"l"},
+			srcR:     []string{"a", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5207,9 +5579,9 @@ var (
 		opcode{
 			mnemonic: []string{"sub", "sub"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "b"},
 			src:      register,
-			srcR:     []string{"b", "b"},
+			srcR:     []string{"b", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5217,9 +5589,9 @@ var (
 		opcode{
 			mnemonic: []string{"sub", "sub"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "c"},
 			src:      register,
-			srcR:     []string{"c", "c"},
+			srcR:     []string{"c", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5227,9 +5599,9 @@ var (
 		opcode{
 			mnemonic: []string{"sub", "sub"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "d"},
 			src:      register,
-			srcR:     []string{"d", "d"},
+			srcR:     []string{"d", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5237,9 +5609,9 @@ var (
 		opcode{
 			mnemonic: []string{"sub", "sub"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "e"},
 			src:      register,
-			srcR:     []string{"e", "e"},
+			srcR:     []string{"e", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5247,9 +5619,9 @@ var (
 		opcode{
 			mnemonic: []string{"sub", "sub"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "h"},
 			src:      register,
-			srcR:     []string{"h", "h"},
+			srcR:     []string{"h", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5257,9 +5629,9 @@ var (
 		opcode{
 			mnemonic: []string{"sub", "sub"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "l"},
 			src:      register,
-			srcR:     []string{"l", "l"},
+			srcR:     []string{"l", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5267,9 +5639,9 @@ var (
 		opcode{
 			mnemonic: []string{"sub", "sub"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "m"},
 			src:      registerIndirect,
-			srcR:     []string{"hl", "m"},
+			srcR:     []string{"hl", ""},
 			noBytes:  1,
 			noCycles: 7,
 		},
@@ -5279,87 +5651,87 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "a"},
 			src:      register,
-			srcR:     []string{"a", "a"},
+			srcR:     []string{"a", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
 		// 0x98 sbc a,b
 		opcode{
-			mnemonic: []string{"sbc", "sbc"},
+			mnemonic: []string{"sbc", "sbb"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "b"},
 			src:      register,
-			srcR:     []string{"b", "b"},
+			srcR:     []string{"b", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
 		// 0x99 sbc a,c
 		opcode{
-			mnemonic: []string{"sbc", "sbc"},
+			mnemonic: []string{"sbc", "sbb"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "c"},
 			src:      register,
-			srcR:     []string{"c", "c"},
+			srcR:     []string{"c", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
 		// 0x9a 0x91 sbc a,d
 		opcode{
-			mnemonic: []string{"sbc", "sbc"},
+			mnemonic: []string{"sbc", "sbb"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "d"},
 			src:      register,
-			srcR:     []string{"d", "d"},
+			srcR:     []string{"d", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
 		// 0x9b sbc a,e
 		opcode{
-			mnemonic: []string{"sbc", "sbc"},
+			mnemonic: []string{"sbc", "sbb"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "e"},
 			src:      register,
-			srcR:     []string{"e", "e"},
+			srcR:     []string{"e", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
 		// 0x9c sbc a,h
 		opcode{
-			mnemonic: []string{"sbc", "sbc"},
+			mnemonic: []string{"sbc", "sbb"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "h"},
 			src:      register,
-			srcR:     []string{"h", "h"},
+			srcR:     []string{"h", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
 		// 0x9d sbc a,l
 		opcode{
-			mnemonic: []string{"sbc", "sbc"},
+			mnemonic: []string{"sbc", "sbb"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "l"},
 			src:      register,
-			srcR:     []string{"l", "l"},
+			srcR:     []string{"l", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
 		// 0x9e sbc a,(hl)
 		opcode{
-			mnemonic: []string{"sbc", "sbc"},
+			mnemonic: []string{"sbc", "sbb"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "m"},
 			src:      registerIndirect,
-			srcR:     []string{"hl", "m"},
+			srcR:     []string{"hl", ""},
 			noBytes:  1,
 			noCycles: 7,
 		},
 		// 0x9f sbc a
 		opcode{
-			mnemonic: []string{"sbc", "sbc"},
+			mnemonic: []string{"sbc", "sbb"},
 			dst:      register,
 			dstR:     []string{"a", "a"},
 			src:      register,
-			srcR:     []string{"a", "a"},
+			srcR:     []string{"a", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5368,9 +5740,9 @@ var (
 		opcode{
 			mnemonic: []string{"and", "ana"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "b"},
 			src:      register,
-			srcR:     []string{"b", "b"},
+			srcR:     []string{"b", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5378,9 +5750,9 @@ var (
 		opcode{
 			mnemonic: []string{"and", "ana"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "c"},
 			src:      register,
-			srcR:     []string{"c", "c"},
+			srcR:     []string{"c", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5388,9 +5760,9 @@ var (
 		opcode{
 			mnemonic: []string{"and", "ana"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "d"},
 			src:      register,
-			srcR:     []string{"d", "d"},
+			srcR:     []string{"d", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5398,9 +5770,9 @@ var (
 		opcode{
 			mnemonic: []string{"and", "ana"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "e"},
 			src:      register,
-			srcR:     []string{"e", "e"},
+			srcR:     []string{"e", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5408,9 +5780,9 @@ var (
 		opcode{
 			mnemonic: []string{"and", "ana"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "h"},
 			src:      register,
-			srcR:     []string{"h", "h"},
+			srcR:     []string{"h", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5418,9 +5790,9 @@ var (
 		opcode{
 			mnemonic: []string{"and", "ana"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "l"},
 			src:      register,
-			srcR:     []string{"l", "l"},
+			srcR:     []string{"l", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5428,9 +5800,9 @@ var (
 		opcode{
 			mnemonic: []string{"and", "ana"},
 			dst:      register,
-			dstR:     []string{"a", "a"},
+			dstR:     []string{"a", "m"},
 			src:      registerIndirect,
-			srcR:     []string{"hl", "m"},
+			srcR:     []string{"hl", ""},
 			noBytes:  1,
 			noCycles: 7,
 		},
@@ -5440,7 +5812,7 @@ var (
 			dst:      register,
 			dstR:     []string{"a", "a"},
 			src:      register,
-			srcR:     []string{"a", "a"},
+			srcR:     []string{"a", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5498,9 +5870,9 @@ var (
 		opcode{
 			mnemonic: []string{"xor", "xra"},
 			dst:      register,
-			dstR:     []string{"a", "h"},
+			dstR:     []string{"a", "l"},
 			src:      register,
-			srcR:     []string{"h", ""},
+			srcR:     []string{"l", ""},
 			noBytes:  1,
 			noCycles: 4,
 		},
@@ -5508,9 +5880,9 @@ var (
 		opcode{
 			mnemonic: []string{"xor", "xra"},
 			dst:      register,
-			dstR:     []string{"a", "h"},
+			dstR:     []string{"a", "m"},
 			src:      registerIndirect,
-			srcR:     []string{"hl", "m"},
+			srcR:     []string{"hl", ""},
 			noBytes:  1,
 			noCycles: 7,
 		},
@@ -5688,7 +6060,7 @@ var (
 		},
 		// 0xc4 call nz
 		opcode{
-			mnemonic: []string{"call", "call"},
+			mnemonic: []string{"call", "cnz"},
 			dst:      condition,
 			dstR:     []string{"nz", ""},
 			src:      immediateExtended,
@@ -5749,7 +6121,7 @@ var (
 		},
 		// 0xcc call z
 		opcode{
-			mnemonic: []string{"call", "call"},
+			mnemonic: []string{"call", "cz"},
 			dst:      condition,
 			dstR:     []string{"z", ""},
 			src:      immediateExtended,
@@ -5767,7 +6139,7 @@ var (
 		opcode{
 			mnemonic: []string{"adc", "aci"},
 			dst:      register,
-			dstR:     []string{"a"},
+			dstR:     []string{"a", ""},
 			src:      immediate,
 			noBytes:  2,
 			noCycles: 7,
@@ -5815,8 +6187,15 @@ var (
 			noBytes:  2,
 			noCycles: 11,
 		},
-		// 0xd4
-		opcode{},
+		// 0xd4 call nc
+		opcode{
+			mnemonic: []string{"call", "cnc"},
+			dst:      condition,
+			dstR:     []string{"nc", ""},
+			src:      immediateExtended,
+			noBytes:  3,
+			noCycles: 10,
+		},
 		// 0xd5 push de
 		opcode{
 			mnemonic: []string{"push", "push"},
@@ -5827,7 +6206,7 @@ var (
 		},
 		// 0xd6 sub i
 		opcode{
-			mnemonic: []string{"sub", "sub"},
+			mnemonic: []string{"sub", "sui"},
 			dst:      immediate,
 			noBytes:  2,
 			noCycles: 7,
@@ -5848,8 +6227,12 @@ var (
 			noBytes:  1,
 			noCycles: 5,
 		},
-		// 0xd9
-		opcode{},
+		// 0xd9 exx
+		opcode{
+			mnemonic: []string{"exx", ""},
+			noBytes:  1,
+			noCycles: 4,
+		},
 		// 0xda jp c,nn
 		opcode{
 			mnemonic: []string{"jp", "jc"},
@@ -5870,7 +6253,7 @@ var (
 		},
 		// 0xdc call c
 		opcode{
-			mnemonic: []string{"call", "call"},
+			mnemonic: []string{"call", "cc"},
 			dst:      condition,
 			dstR:     []string{"c", ""},
 			src:      immediateExtended,
@@ -5885,7 +6268,7 @@ var (
 		opcode{
 			mnemonic: []string{"sbc", "sbi"},
 			dst:      register,
-			dstR:     []string{"a"},
+			dstR:     []string{"a", ""},
 			src:      immediate,
 			noBytes:  2,
 			noCycles: 7,
@@ -5899,8 +6282,14 @@ var (
 			noCycles: 11,
 		},
 
-		// 0xe0
-		opcode{},
+		// 0xe0 ret po
+		opcode{
+			mnemonic: []string{"ret", "rpo"},
+			dst:      condition,
+			dstR:     []string{"po", ""},
+			noBytes:  1,
+			noCycles: 5,
+		},
 		// 0xe1 pop hl
 		opcode{
 			mnemonic: []string{"pop", "pop"},
@@ -5924,12 +6313,19 @@ var (
 			dst:      registerIndirect,
 			dstR:     []string{"sp", ""},
 			src:      register,
-			srcR:     []string{"hl"},
+			srcR:     []string{"hl", ""},
 			noBytes:  1,
 			noCycles: 19,
 		},
-		// 0xe4
-		opcode{},
+		// 0xe4 call po
+		opcode{
+			mnemonic: []string{"call", "cpo"},
+			dst:      condition,
+			dstR:     []string{"po", ""},
+			src:      immediateExtended,
+			noBytes:  3,
+			noCycles: 10,
+		},
 		// 0xe5 push hl
 		opcode{
 			mnemonic: []string{"push", "push"},
@@ -5953,8 +6349,14 @@ var (
 			noBytes:  1,
 			noCycles: 11,
 		},
-		// 0xe8
-		opcode{},
+		// 0xe8 ret pe
+		opcode{
+			mnemonic: []string{"ret", "rpe"},
+			dst:      condition,
+			dstR:     []string{"pe", ""},
+			noBytes:  1,
+			noCycles: 5,
+		},
 		// 0xe9 jp (hl)
 		opcode{
 			mnemonic: []string{"jp", "pchl"},
@@ -5982,8 +6384,15 @@ var (
 			noBytes:  1,
 			noCycles: 4,
 		},
-		// 0xec
-		opcode{},
+		// 0xec call pe
+		opcode{
+			mnemonic: []string{"call", "cpe"},
+			dst:      condition,
+			dstR:     []string{"pe", ""},
+			src:      immediateExtended,
+			noBytes:  3,
+			noCycles: 10,
+		},
 		// 0xed z80 multi byte
 		opcode{
 			multiByte: true,
@@ -6035,8 +6444,15 @@ var (
 			noBytes:  1,
 			noCycles: 4,
 		},
-		// 0xf4
-		opcode{},
+		// 0xf4 call p
+		opcode{
+			mnemonic: []string{"call", "cp"},
+			dst:      condition,
+			dstR:     []string{"p", ""},
+			src:      immediateExtended,
+			noBytes:  3,
+			noCycles: 10,
+		},
 		// 0xf5
 		opcode{
 			mnemonic: []string{"push", "push"},
@@ -6056,19 +6472,25 @@ var (
 		opcode{
 			mnemonic: []string{"rst", "rst"},
 			dst:      implied,
-			dstR:     []string{"$30", "4"},
+			dstR:     []string{"$30", "6"},
 			noBytes:  1,
 			noCycles: 11,
 		},
-		// 0xf8
-		opcode{},
+		// 0xf8 ret m
+		opcode{
+			mnemonic: []string{"ret", "rm"},
+			dst:      condition,
+			dstR:     []string{"m", ""},
+			noBytes:  1,
+			noCycles: 5,
+		},
 		// 0xf9 ld sp,hl
 		opcode{
 			mnemonic: []string{"ld", "sphl"},
 			dst:      register,
-			dstR:     []string{"sp"},
+			dstR:     []string{"sp", ""},
 			src:      register,
-			srcR:     []string{"hl"},
+			srcR:     []string{"hl", ""},
 			noBytes:  1,
 			noCycles: 6,
 		},
@@ -6087,15 +6509,22 @@ var (
 			noBytes:  1,
 			noCycles: 4,
 		},
-		// 0xfc
-		opcode{},
+		// 0xfc call m
+		opcode{
+			mnemonic: []string{"call", "cm"},
+			dst:      condition,
+			dstR:     []string{"m", ""},
+			src:      immediateExtended,
+			noBytes:  3,
+			noCycles: 10,
+		},
 		// 0xfd z80 multi byte
 		opcode{
 			multiByte: true,
 		},
 		// 0xfe
 		opcode{
-			mnemonic: []string{"cp", "cmp"},
+			mnemonic: []string{"cp", "cpi"},
 			dst:      immediate,
 			noBytes:  2,
 			noCycles: 7,
@@ -6105,7 +6534,7 @@ var (
 		opcode{
 			mnemonic: []string{"rst", "rst"},
 			dst:      implied,
-			dstR:     []string{"$38", "4"},
+			dstR:     []string{"$38", "7"},
 			noBytes:  1,
 			noCycles: 11,
 		},
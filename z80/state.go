@@ -0,0 +1,86 @@
+package z80
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+
+	"github.com/marcopeereboom/toyz80/bus"
+)
+
+const (
+	stateMagic   = "toyz80 state"
+	StateVersion = 4 // Version of the machine state format
+)
+
+var (
+	ErrInvalidState = errors.New("invalid machine state")
+	ErrStateVersion = errors.New("unsupported machine state version")
+)
+
+// stateHeader precedes the machine state so that the version can be checked
+// before the state is decoded.
+type stateHeader struct {
+	Magic   string
+	Version int
+}
+
+// machineState is the saved state of the CPU and the bus.
+type machineState struct {
+	Registers Registers
+	EIDelay   bool    // ei executed, interrupts are accepted after next instruction
+	Mode      CPUMode // Mode CPU is running
+	Bus       bus.State
+}
+
+// Save writes the state of the CPU, memory and devices to w.  Breakpoints are
+// not part of the machine state.
+func (z *CPU) Save(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	err := enc.Encode(stateHeader{
+		Magic:   stateMagic,
+		Version: StateVersion,
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Encode(machineState{
+		Registers: z.GetRegisters(),
+		EIDelay:   z.eiDelay,
+		Mode:      z.mode,
+		Bus:       z.bus.SaveState(),
+	})
+}
+
+// Restore replaces the state of the CPU, memory and devices with one written
+// by Save.  The machine must be configured with the same devices as the one
+// that was saved and must not be running.
+func (z *CPU) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	var h stateHeader
+	err := dec.Decode(&h)
+	if err != nil || h.Magic != stateMagic {
+		return ErrInvalidState
+	}
+	if h.Version != StateVersion {
+		return ErrStateVersion
+	}
+
+	var s machineState
+	err = dec.Decode(&s)
+	if err != nil {
+		return ErrInvalidState
+	}
+	if s.Mode < 0 || int(s.Mode) >= len(cpuModes) {
+		return ErrInvalidState
+	}
+	err = z.bus.RestoreState(s.Bus)
+	if err != nil {
+		return err
+	}
+
+	z.SetRegisters(s.Registers)
+	z.eiDelay = s.EIDelay
+	z.mode = s.Mode
+	return nil
+}
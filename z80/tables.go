@@ -54,7 +54,8 @@ var overflowSubTable = []byte{0, FLAG_V, 0, 0, 0, 0, FLAG_V, 0}
 
 var sz53Table, sz53pTable, parityTable [0x100]byte
 
-func (z *z80) adc16(val uint16) {
+func (z *CPU) adc16(val uint16) {
+	z.memptr = z.hl + 1
 	t := uint(z.hl) + uint(val) + uint(z.af&carry)
 	lookup := byte(z.hl&0x8800>>11 | val&0x8800>>10 | uint16(t&0x8800>>9))
 	z.hl = uint16(t)
@@ -66,7 +67,8 @@ func (z *z80) adc16(val uint16) {
 	z.af = z.af&0xff00 | uint16(f)
 }
 
-func (z *z80) add16(v1, v2 uint16) uint16 {
+func (z *CPU) add16(v1, v2 uint16) uint16 {
+	z.memptr = v1 + 1
 	t := uint(v1) + uint(v2)
 	lookup := byte(v1&0x0800>>11 | v2&0x0800>>10 | uint16(t&0x0800>>9))
 	f := z.af&uint16(FLAG_V|FLAG_Z|FLAG_S) |
@@ -76,7 +78,7 @@ func (z *z80) add16(v1, v2 uint16) uint16 {
 	return uint16(t)
 }
 
-func (z *z80) add(val byte) {
+func (z *CPU) add(val byte) {
 	t := uint16(z.af>>8) + uint16(val)
 	lookup := byte(z.af>>8)&0x88>>3 | val&0x88>>2 | byte(t)&0x88>>1
 	z.af = t<<8 | uint16(ternB(t&0x100 != 0, FLAG_C, 0)|
@@ -84,7 +86,7 @@ func (z *z80) add(val byte) {
 		sz53Table[byte(t)])
 }
 
-func (z *z80) adc(val byte) {
+func (z *CPU) adc(val byte) {
 	a := byte(z.af >> 8)
 	t := uint16(a) + uint16(val) + uint16(z.af&carry)
 	lookup := uint16(a)&0x88>>3 | uint16(val)&0x88>>2 | t&0x88>>1
@@ -93,12 +95,12 @@ func (z *z80) adc(val byte) {
 	z.af = t<<8 | uint16(f)
 }
 
-func (z *z80) and(val byte) {
+func (z *CPU) and(val byte) {
 	a := byte(z.af>>8) & val
 	z.af = uint16(a)<<8 | halfCarry | uint16(sz53pTable[a])
 }
 
-func (z *z80) bit(bit, val byte) {
+func (z *CPU) bit(bit, val byte) {
 	f := byte(z.af)&FLAG_C | FLAG_H | val&(FLAG_3|FLAG_5)
 	if val&(0x01<<bit) == 0 {
 		f |= FLAG_P | FLAG_Z
@@ -109,7 +111,15 @@ func (z *z80) bit(bit, val byte) {
 	z.af = z.af&0xff00 | uint16(f)
 }
 
-func (z *z80) cp(val byte) {
+// bitMemptr sets the flags for bit n,(hl) and bit n,(ix+d).  Bits 3 and 5
+// come from the high byte of MEMPTR instead of the operand.
+func (z *CPU) bitMemptr(bit, val byte) {
+	z.bit(bit, val)
+	f := byte(z.af)&^(FLAG_3|FLAG_5) | byte(z.memptr>>8)&(FLAG_3|FLAG_5)
+	z.af = z.af&0xff00 | uint16(f)
+}
+
+func (z *CPU) cp(val byte) {
 	a := byte(z.af >> 8)
 	aTmp := uint16(a) - uint16(val)
 	lookup := ((a & 0x88) >> 3) | ((val & 0x88) >> 2) | byte((aTmp&0x88)>>1)
@@ -120,13 +130,14 @@ func (z *z80) cp(val byte) {
 	z.af = z.af&0xff00 | uint16(f)
 }
 
-func (z *z80) cpd() {
+func (z *CPU) cpd() {
 	a := byte(z.af >> 8)
 	val := z.bus.Read(z.hl)
 	t := a - val
 	lookup := a&0x08>>3 | val&0x08>>2 | t&0x08>>1
 	z.hl--
 	z.bc--
+	z.memptr--
 	f := byte(z.af)&FLAG_C | ternB(z.bc != 0, FLAG_V|FLAG_N, FLAG_N) |
 		halfcarrySubTable[lookup] | ternB(t != 0, 0, FLAG_Z) | t&FLAG_S
 	if (f & FLAG_H) != 0 {
@@ -136,13 +147,14 @@ func (z *z80) cpd() {
 	z.af = z.af&0xff00 | uint16(f)
 }
 
-func (z *z80) cpi() {
+func (z *CPU) cpi() {
 	a := byte(z.af >> 8)
 	val := z.bus.Read(z.hl)
 	t := a - val
 	lookup := a&0x08>>3 | val&0x08>>2 | t&0x08>>1
 	z.hl++
 	z.bc--
+	z.memptr++
 	f := byte(z.af)&FLAG_C | ternB(z.bc != 0, FLAG_V|FLAG_N, FLAG_N) |
 		halfcarrySubTable[lookup] | ternB(t != 0, 0, FLAG_Z) |
 		(t & FLAG_S)
@@ -153,7 +165,7 @@ func (z *z80) cpi() {
 	z.af = z.af&0xff00 | uint16(f)
 }
 
-func (z *z80) daa() {
+func (z *CPU) daa() {
 	a := byte(z.af >> 8)
 	f := byte(z.af)
 	add := byte(0)
@@ -177,7 +189,7 @@ func (z *z80) daa() {
 	z.af = z.af&0xff00 | uint16(newF)
 }
 
-func (z *z80) dec(val byte) byte {
+func (z *CPU) dec(val byte) byte {
 	f := byte(z.af)&FLAG_C | ternB(val&0x0f != 0, 0, FLAG_H) | FLAG_N
 	val--
 	f |= ternB(val == 0x7f, FLAG_V, 0) | sz53Table[val]
@@ -185,7 +197,72 @@ func (z *z80) dec(val byte) byte {
 	return val
 }
 
-func (z *z80) inc(val byte) byte {
+// in reads from port BC and sets the flags for in r,(c).
+func (z *CPU) in() byte {
+	val := z.bus.IORead(z.bc)
+	z.memptr = z.bc + 1
+	z.af = z.af&0xff00 | z.af&carry | uint16(sz53pTable[val])
+	return val
+}
+
+// out writes val to port BC for out (c),r.
+func (z *CPU) out(val byte) {
+	z.bus.IOWrite(z.bc, val)
+	z.memptr = z.bc + 1
+}
+
+// blockIOFlags sets the flags for ini, ind, outi and outd.  Most flags are
+// undocumented and derived from B, the transferred value and k, the sum of the
+// value and C+1, C-1 or L.  See "The Undocumented Z80 Documented" 4.3.
+func (z *CPU) blockIOFlags(val byte, k uint16) {
+	b := byte(z.bc >> 8)
+	f := sz53Table[b] | parityTable[byte(k)&0x07^b] |
+		ternB(val&0x80 != 0, FLAG_N, 0) |
+		ternB(k > 0xff, FLAG_H|FLAG_C, 0)
+	z.af = z.af&0xff00 | uint16(f)
+}
+
+// blockIORepeatFlags adjusts the flags of inir, indr, otir and otdr when the
+// instruction repeats.  Bits 3 and 5 come from the high byte of PC and H and
+// P/V are modified further as worked out by David Banks.
+func (z *CPU) blockIORepeatFlags(val byte) {
+	b := byte(z.bc >> 8)
+	f := byte(z.af)&^(FLAG_3|FLAG_5|FLAG_H) | byte(z.pc>>8)&(FLAG_3|FLAG_5)
+	if f&FLAG_C != 0 {
+		if val&0x80 != 0 {
+			f ^= parityTable[(b-1)&0x07] ^ FLAG_P
+			f |= ternB(b&0x0f == 0x00, FLAG_H, 0)
+		} else {
+			f ^= parityTable[(b+1)&0x07] ^ FLAG_P
+			f |= ternB(b&0x0f == 0x0f, FLAG_H, 0)
+		}
+	} else {
+		f ^= parityTable[b&0x07] ^ FLAG_P
+	}
+	z.af = z.af&0xff00 | uint16(f)
+}
+
+func (z *CPU) ind() byte {
+	val := z.bus.IORead(z.bc)
+	z.memptr = z.bc - 1
+	z.bus.Write(z.hl, val)
+	z.hl--
+	z.bc -= 0x100
+	z.blockIOFlags(val, uint16(val)+uint16(byte(z.bc)-1))
+	return val
+}
+
+func (z *CPU) ini() byte {
+	val := z.bus.IORead(z.bc)
+	z.memptr = z.bc + 1
+	z.bus.Write(z.hl, val)
+	z.hl++
+	z.bc -= 0x100
+	z.blockIOFlags(val, uint16(val)+uint16(byte(z.bc)+1))
+	return val
+}
+
+func (z *CPU) inc(val byte) byte {
 	val++
 	f := byte(z.af)&FLAG_C | ternB(val == 0x80, FLAG_V, 0) |
 		ternB(val&0x0f != 0, 0, FLAG_H) | sz53Table[val]
@@ -193,12 +270,39 @@ func (z *z80) inc(val byte) byte {
 	return val
 }
 
-func (z *z80) or(val byte) {
+func (z *CPU) or(val byte) {
 	a := byte(z.af>>8) | val
 	z.af = uint16(a)<<8 | uint16(sz53pTable[a])
 }
 
-func (z *z80) ldd() {
+// ldAIR loads A from I or R.  P/V reflects IFF2 so that interrupt handlers can
+// tell whether interrupts were enabled.
+func (z *CPU) ldAIR(val byte) {
+	f := byte(z.af)&FLAG_C | sz53Table[val] | ternB(z.iff2 != 0, FLAG_V, 0)
+	z.af = uint16(val)<<8 | uint16(f)
+}
+
+func (z *CPU) outd() byte {
+	val := z.bus.Read(z.hl)
+	z.bc -= 0x100
+	z.bus.IOWrite(z.bc, val)
+	z.memptr = z.bc - 1
+	z.hl--
+	z.blockIOFlags(val, uint16(val)+uint16(byte(z.hl)))
+	return val
+}
+
+func (z *CPU) outi() byte {
+	val := z.bus.Read(z.hl)
+	z.bc -= 0x100
+	z.bus.IOWrite(z.bc, val)
+	z.memptr = z.bc + 1
+	z.hl++
+	z.blockIOFlags(val, uint16(val)+uint16(byte(z.hl)))
+	return val
+}
+
+func (z *CPU) ldd() {
 	t := z.bus.Read(z.hl)
 	z.bc--
 	z.bus.Write(z.de, t)
@@ -211,7 +315,7 @@ func (z *z80) ldd() {
 	z.af = z.af&0xff00 | uint16(f)
 }
 
-func (z *z80) ldi() {
+func (z *CPU) ldi() {
 	t := z.bus.Read(z.hl)
 	z.bc--
 	z.bus.Write(z.de, t)
@@ -224,36 +328,37 @@ func (z *z80) ldi() {
 	z.af = z.af&0xff00 | uint16(f)
 }
 
-func (z *z80) rl(val byte) byte {
+func (z *CPU) rl(val byte) byte {
 	t := val
 	val = val<<1 | byte(z.af)&FLAG_C
 	z.af = z.af&0xff00 | uint16(t>>7|sz53pTable[val])
 	return val
 }
 
-func (z *z80) rlc(val byte) byte {
+func (z *CPU) rlc(val byte) byte {
 	val = val<<1 | val>>7
 	z.af = z.af&0xff00 | uint16(val&FLAG_C|sz53pTable[val])
 	return val
 }
 
-func (z *z80) rld() {
+func (z *CPU) rld() {
 	a := byte(z.af >> 8)
 	t := z.bus.Read(z.hl)
 	z.bus.Write(z.hl, t<<4|a&0x0f)
+	z.memptr = z.hl + 1
 	a = a&0xf0 | t>>4
 	f := byte(z.af)&FLAG_C | sz53pTable[a]
 	z.af = uint16(a)<<8 | uint16(f)
 }
 
-func (z *z80) rr(val byte) byte {
+func (z *CPU) rr(val byte) byte {
 	t := val
 	val = val>>1 | byte(z.af)<<7
 	z.af = z.af&0xff00 | uint16(t&FLAG_C|sz53pTable[val])
 	return val
 }
 
-func (z *z80) rrc(val byte) byte {
+func (z *CPU) rrc(val byte) byte {
 	f := val & FLAG_C
 	val = val>>1 | val<<7
 	f |= sz53pTable[val]
@@ -261,16 +366,17 @@ func (z *z80) rrc(val byte) byte {
 	return val
 }
 
-func (z *z80) rrd() {
+func (z *CPU) rrd() {
 	a := byte(z.af >> 8)
 	t := z.bus.Read(z.hl)
 	z.bus.Write(z.hl, a<<4|t>>4)
+	z.memptr = z.hl + 1
 	a = a&0xf0 | t&0x0f
 	f := byte(z.af)&FLAG_C | sz53pTable[a]
 	z.af = uint16(a)<<8 | uint16(f)
 }
 
-func (z *z80) sla(val byte) byte {
+func (z *CPU) sla(val byte) byte {
 	f := val >> 7
 	val <<= 1
 	f |= sz53pTable[val]
@@ -278,7 +384,7 @@ func (z *z80) sla(val byte) byte {
 	return val
 }
 
-func (z *z80) sll(val byte) byte {
+func (z *CPU) sll(val byte) byte {
 	f := val >> 7
 	val = val<<1 | 0x01
 	f |= sz53pTable[val]
@@ -286,7 +392,7 @@ func (z *z80) sll(val byte) byte {
 	return val
 }
 
-func (z *z80) sra(val byte) byte {
+func (z *CPU) sra(val byte) byte {
 	f := val & FLAG_C
 	val = val&0x80 | val>>1
 	f |= sz53pTable[val]
@@ -294,7 +400,7 @@ func (z *z80) sra(val byte) byte {
 	return val
 }
 
-func (z *z80) srl(val byte) byte {
+func (z *CPU) srl(val byte) byte {
 	f := val & FLAG_C
 	val >>= 1
 	f |= sz53pTable[val]
@@ -302,7 +408,7 @@ func (z *z80) srl(val byte) byte {
 	return val
 }
 
-func (z *z80) sbc(val byte) {
+func (z *CPU) sbc(val byte) {
 	a := byte(z.af >> 8)
 	t := uint16(a) - uint16(val) - z.af&carry
 	lookup := a&0x88>>3 | val&0x88>>2 | byte(t&0x88>>1)
@@ -313,7 +419,8 @@ func (z *z80) sbc(val byte) {
 	z.af = uint16(t)<<8 | uint16(f)
 }
 
-func (z *z80) sbc16(val uint16) {
+func (z *CPU) sbc16(val uint16) {
+	z.memptr = z.hl + 1
 	t := uint(z.hl) - uint(val) - uint(z.af&carry)
 	lookup := byte(z.hl&0x8800>>11 | val&0x8800>>10 | uint16(t)&0x8800>>9)
 	z.hl = uint16(t)
@@ -325,7 +432,7 @@ func (z *z80) sbc16(val uint16) {
 	z.af = z.af&0xff00 | uint16(f)
 }
 
-func (z *z80) sub(val byte) {
+func (z *CPU) sub(val byte) {
 	a := byte(z.af >> 8)
 	t := uint16(a) - uint16(val)
 	lookup := a&0x88>>3 | val&0x88>>2 | byte(t&0x88>>1)
@@ -335,7 +442,7 @@ func (z *z80) sub(val byte) {
 		sz53Table[a])
 }
 
-func (z *z80) xor(val byte) {
+func (z *CPU) xor(val byte) {
 	a := byte(z.af>>8) ^ val
 	z.af = uint16(a)<<8 | uint16(sz53pTable[a])
 }
@@ -9,6 +9,7 @@ import (
 
 var (
 	ErrDisassemble = errors.New("could not disassemble")
+	ErrInvalidMode = errors.New("invalid cpu mode")
 	//ErrHalt        = errors.New("halt")
 	//ErrInvalidInstruction = errors.New("invalid instruction")
 )
@@ -30,6 +31,17 @@ func (hp HaltError) Error() string {
 	return fmt.Sprintf("halt: $%04x", hp.PC)
 }
 
+// InvalidOpcodeError is returned when the instruction at PC can not be
+// decoded.  Bytes contains the offending instruction bytes.
+type InvalidOpcodeError struct {
+	PC    uint16
+	Bytes []byte
+}
+
+func (ie InvalidOpcodeError) Error() string {
+	return fmt.Sprintf("invalid opcode: % x @ $%04x", ie.Bytes, ie.PC)
+}
+
 type CPUMode int
 
 const (
@@ -37,6 +49,25 @@ const (
 	Mode8080
 )
 
+var cpuModes = []string{"z80", "8080"}
+
+func (m CPUMode) String() string {
+	if m < 0 || int(m) >= len(cpuModes) {
+		return "invalid mode"
+	}
+	return cpuModes[m]
+}
+
+// ParseCPUMode returns the CPU mode called s.
+func ParseCPUMode(s string) (CPUMode, error) {
+	for i, name := range cpuModes {
+		if s == name {
+			return CPUMode(i), nil
+		}
+	}
+	return 0, ErrInvalidMode
+}
+
 const (
 	carry     uint16 = 1 << 0 // C Carry Flag
 	addsub    uint16 = 1 << 1 // N Add/Subtract
@@ -48,8 +79,8 @@ const (
 	sign      uint16 = 1 << 7 // S Sign Flag
 )
 
-// z80 describes a z80/8080 CPU.
-type z80 struct {
+// CPU describes a z80/8080 CPU.
+type CPU struct {
 	af  uint16 // A & Flags
 	af_ uint16 // A' & Flags'
 	bc  uint16 // B & C
@@ -66,19 +97,117 @@ type z80 struct {
 
 	iff1 byte // iff1 flip-flop
 	iff2 byte // iff2 flip-flop
+	im   byte // interrupt mode
+	i    byte // interrupt vector
+	r    byte // memory refresh
+
+	memptr uint16 // internal WZ register, leaks through bit flags
+
+	halted  bool // halt executed, waiting for an interrupt
+	eiDelay bool // ei executed, interrupts are accepted after next instruction
 
 	bus *bus.Bus // System bus
 
+	supply   []byte // Instruction supplied in interrupt mode 0, see fetch
+	supplyPC uint16 // Address the supplied instruction is executed at
+
 	totalCycles uint64 // Total cycles used
 
 	mode CPUMode // Mode CPU is running
 
 	debug bool                    // debug mode enabled
 	bp    map[uint16]func() error // break points with optional callback
+
+	symbols Symbolizer // Names addresses in the disassembly
+}
+
+// Symbolizer names addresses, for example as label or label+offset.
+type Symbolizer interface {
+	Name(address uint16) (string, bool)
+}
+
+// Registers is the programmer visible state of the CPU.  The shadow
+// registers are suffixed with an underscore.
+type Registers struct {
+	AF  uint16 // A & Flags
+	AF_ uint16 // A' & Flags'
+	BC  uint16 // B & C
+	BC_ uint16 // B' & C'
+	DE  uint16 // D & E
+	DE_ uint16 // D' & E'
+	HL  uint16 // H & L
+	HL_ uint16 // H' & L'
+	IX  uint16 // index register X
+	IY  uint16 // index register Y
+
+	PC uint16 // program counter
+	SP uint16 // stack pointer
+
+	IFF1 byte // iff1 flip-flop
+	IFF2 byte // iff2 flip-flop
+	IM   byte // interrupt mode
+	I    byte // interrupt vector
+	R    byte // memory refresh
+
+	MEMPTR uint16 // internal WZ register
+
+	Halted bool   // halt executed, waiting for an interrupt
+	Cycles uint64 // Total cycles used
+}
+
+// GetRegisters returns a copy of the CPU registers.
+func (z *CPU) GetRegisters() Registers {
+	return Registers{
+		AF:     z.af,
+		AF_:    z.af_,
+		BC:     z.bc,
+		BC_:    z.bc_,
+		DE:     z.de,
+		DE_:    z.de_,
+		HL:     z.hl,
+		HL_:    z.hl_,
+		IX:     z.ix,
+		IY:     z.iy,
+		PC:     z.pc,
+		SP:     z.sp,
+		IFF1:   z.iff1,
+		IFF2:   z.iff2,
+		IM:     z.im,
+		I:      z.i,
+		R:      z.r,
+		MEMPTR: z.memptr,
+		Halted: z.halted,
+		Cycles: z.totalCycles,
+	}
+}
+
+// SetRegisters replaces the CPU registers.
+func (z *CPU) SetRegisters(r Registers) {
+	z.af = r.AF
+	z.af_ = r.AF_
+	z.bc = r.BC
+	z.bc_ = r.BC_
+	z.de = r.DE
+	z.de_ = r.DE_
+	z.hl = r.HL
+	z.hl_ = r.HL_
+	z.ix = r.IX
+	z.iy = r.IY
+	z.pc = r.PC
+	z.sp = r.SP
+	z.iff1 = r.IFF1
+	z.iff2 = r.IFF2
+	z.im = r.IM
+	z.i = r.I
+	z.r = r.R
+	z.memptr = r.MEMPTR
+	z.halted = r.Halted
+	z.totalCycles = r.Cycles
+	z.eiDelay = false
 }
 
 // DumpRegisters returns a dump of all registers.
-func (z *z80) DumpRegisters() string {
+func (z *CPU) DumpRegisters() string {
 	flags := ""
 	if z.af&sign == sign {
 		flags += "S"
@@ -121,21 +250,22 @@ func (z *z80) DumpRegisters() string {
 		flags += "-"
 	}
 	return fmt.Sprintf("af $%04x bc $%04x de $%04x hl $%04x ix $%04x "+
-		"iy $%04x pc $%04x sp $%04x f %v ", uint16(z.af),
-		uint16(z.bc), uint16(z.de), uint16(z.hl), uint16(z.ix),
-		uint16(z.iy), uint16(z.pc), uint16(z.sp), flags)
+		"iy $%04x pc $%04x sp $%04x i $%02x r $%02x wz $%04x f %v ",
+		uint16(z.af), uint16(z.bc), uint16(z.de), uint16(z.hl),
+		uint16(z.ix), uint16(z.iy), uint16(z.pc), uint16(z.sp), z.i, z.r,
+		z.memptr, flags)
 }
 
 // New returns a cold reset Z80 CPU struct.
-func New(mode CPUMode, bus *bus.Bus) (*z80, error) {
-	return &z80{
+func New(mode CPUMode, bus *bus.Bus) (*CPU, error) {
+	return &CPU{
 		mode: mode,
 		bus:  bus,
 		bp:   make(map[uint16]func() error),
 	}, nil
 }
 
-func (z *z80) GetBreakPoints() []uint16 {
+func (z *CPU) GetBreakPoints() []uint16 {
 	if !z.debug {
 		return []uint16{}
 	}
@@ -147,51 +277,194 @@ func (z *z80) GetBreakPoints() []uint16 {
 	return bps
 }
 
-func (z *z80) SetBreakPoint(address uint16, f func() error) {
+func (z *CPU) SetBreakPoint(address uint16, f func() error) {
 	z.bp[address] = f
 	z.debug = true
 }
 
-func (z *z80) DelBreakPoint(address uint16) {
+func (z *CPU) DelBreakPoint(address uint16) {
 	delete(z.bp, address)
 	if len(z.bp) == 0 {
 		z.debug = false
 	}
 }
 
-func (z *z80) SetPC(address uint16) {
-	z.pc = address
+// SetSymbolizer makes the disassembler show addresses by name.  Passing nil
+// shows them as numbers again.
+func (z *CPU) SetSymbolizer(s Symbolizer) {
+	z.symbols = s
 }
 
-// Reset resets the CPU.  If cold is true then memory is zeroed.
-func (z *z80) Reset(cold bool) {
-	if cold {
-		// toss memory.
-		//z.bus.Reset()
+// addressName returns address as a name if possible.
+func (z *CPU) addressName(address uint16) string {
+	if z.symbols != nil {
+		if name, ok := z.symbols.Name(address); ok {
+			return name
+		}
 	}
+	return fmt.Sprintf("$%04x", address)
+}
+
+func (z *CPU) SetPC(address uint16) {
+	z.pc = address
+	z.halted = false
+}
+
+// Reset resets the CPU and the devices on the bus.  Memory is left alone, cold
+// resets that zero memory are not implemented yet.
+func (z *CPU) Reset(cold bool) {
+	z.bus.Reset()
 
 	//The program counter is reset to 0000h
 	z.pc = 0
 
 	//Interrupt mode 0.
+	z.im = 0
 
 	//Interrupt are dissabled.
+	z.iff1 = 0
+	z.iff2 = 0
+	z.eiDelay = false
+	z.halted = false
 
 	//The register I = 00h
+	z.i = 0
 	//The register R = 00h
+	z.r = 0
+}
+
+// refresh increments the lower 7 bits of R.  This happens on every M1 cycle,
+// including the fetch of prefix bytes.  Bit 7 is only changed by ld r,a.
+func (z *CPU) refresh() {
+	z.r = z.r&0x80 | (z.r+1)&0x7f
 }
 
-func (z *z80) res(bit, val byte) byte {
+// noni skips a dd or fd prefix that is not followed by an index instruction.
+// The prefix behaves as a nop and the next byte is fetched as a regular
+// opcode, which also means it is refreshed again.
+func (z *CPU) noni() error {
+	z.r = z.r&0x80 | (z.r-1)&0x7f
+	z.pc += 1
+	z.totalCycles += 4
+	return nil
+}
+
+// fetch reads the instruction byte at address.  While an instruction supplied
+// in interrupt mode 0 is executed its bytes are returned instead of memory.
+func (z *CPU) fetch(address uint16) byte {
+	if z.supply != nil {
+		if i := int(address - z.supplyPC); i < len(z.supply) {
+			return z.supply[i]
+		}
+	}
+	return z.bus.Read(address)
+}
+
+// push pushes val onto the stack.
+func (z *CPU) push(val uint16) {
+	z.sp--
+	z.bus.Write(z.sp, byte(val>>8))
+	z.sp--
+	z.bus.Write(z.sp, byte(val))
+}
+
+// interrupt accepts a maskable interrupt and vectors according to the current
+// interrupt mode.  The interrupting device supplies the data byte during the
+// acknowledge cycle.
+func (z *CPU) interrupt() error {
+	data := z.bus.InterruptAck()
+
+	z.iff1 = 0
+	z.iff2 = 0
+
+	// Return to the instruction following halt.
+	if z.halted {
+		z.halted = false
+		z.pc++
+	}
+	if z.im == 0 {
+		return z.interruptInstruction(data)
+	}
+
+	z.refresh()
+	z.push(z.pc)
+	switch z.im {
+	case 1: // rst $38
+		z.pc = 0x38
+		z.memptr = z.pc
+		z.totalCycles += 13
+	case 2: // vector table pointed at by i and device
+		vector := uint16(z.i)<<8 | uint16(data)
+		z.pc = uint16(z.bus.Read(vector)) |
+			uint16(z.bus.Read(vector+1))<<8
+		z.memptr = z.pc
+		z.totalCycles += 19
+	}
+
+	return nil
+}
+
+// interruptInstruction executes the instruction that starts with data in
+// interrupt mode 0, usually an rst or a call.  The device supplies the
+// remaining bytes of the instruction as well.  PC is not advanced during the
+// acknowledge so the instruction is executed as if it ends at PC, which makes
+// call and rst push the address of the interrupted instruction.
+func (z *CPU) interruptInstruction(data byte) error {
+	p := []byte{data}
+	o := &opcodes[data]
+	if z.mode == Mode8080 {
+		o = &opcodes8080[data]
+	} else if o.multiByte {
+		p = append(p, z.bus.InterruptData())
+		switch data {
+		case 0xcb:
+			o = &opcodesCB[p[1]]
+		case 0xdd:
+			o = &opcodesDD[p[1]]
+		case 0xed:
+			o = &opcodesED[p[1]]
+		case 0xfd:
+			o = &opcodesFD[p[1]]
+		}
+	}
+	pc := z.pc
+	if o.noBytes == 0 {
+		return InvalidOpcodeError{PC: pc, Bytes: p}
+	}
+	for len(p) < int(o.noBytes) {
+		p = append(p, z.bus.InterruptData())
+	}
+
+	z.pc -= uint16(len(p))
+	z.supply = p
+	z.supplyPC = z.pc
+	err := z.execute()
+	z.supply = nil
+	if err != nil {
+		z.pc = pc
+		if e, ok := err.(InvalidOpcodeError); ok {
+			e.PC = pc
+			return e
+		}
+		return err
+	}
+
+	// The acknowledge cycle adds two wait states.
+	z.totalCycles += 2
+	return nil
+}
+
+func (z *CPU) res(bit, val byte) byte {
 	mask := byte(^(1 << bit))
 	return val & mask
 }
 
-func (z *z80) set(bit, val byte) byte {
+func (z *CPU) set(bit, val byte) byte {
 	x := byte(1 << bit)
 	return val | x
 }
 
-func (z *z80) undocumentedSetReg(reg, val byte) {
+func (z *CPU) undocumentedSetReg(reg, val byte) {
 	// According to http://www.z80.info/zip/z80-documented.pdf we have to
 	// write the indexed location to a register as well.  This function
 	// emulates that bahavior.
@@ -213,233 +486,122 @@ func (z *z80) undocumentedSetReg(reg, val byte) {
 		z.hl = z.hl&0xff00 | uint16(val)
 	case 7: // a
 		z.af = z.af&0x00ff | uint16(val)<<8
-	default:
-		panic(fmt.Sprintf("invalid undocumented write to register %02x",
-			reg))
 	}
 }
 
-func (z *z80) ddcb() error {
-	// zilog really is crazy, 4th byte + bit 7&6
-	// descriminates the instruction type
-	byte4 := z.bus.Read(z.pc + 3)
-	xx := byte4 >> 6
-	yy := 0x07 & (byte4 >> 3)
-	zz := 0x07 & byte4
-	switch xx {
-	case 0:
-		if zz == 6 {
-			// rot[y],(IX+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-			val := z.bus.Read(z.ix + displacement)
-			switch yy {
-			case 0x00:
-				val = z.rlc(val)
-			case 0x01:
-				val = z.rrc(val)
-			case 0x02:
-				val = z.rl(val)
-			case 0x03:
-				val = z.rr(val)
-			case 0x04:
-				val = z.sla(val)
-			case 0x05:
-				val = z.sra(val)
-			case 0x06:
-				val = z.sll(val)
-			case 0x07:
-				val = z.srl(val)
-			}
-			z.bus.Write(z.ix+displacement, val)
-			z.totalCycles += 1 // XXX
-			z.pc += 4
-			return nil
-		}
-		// ld r[z], rot[y] (IX+d)
-		fmt.Printf("%02x %02x %02x %02x\n",
-			z.bus.Read(z.pc+0),
-			z.bus.Read(z.pc+1),
-			z.bus.Read(z.pc+2),
-			z.bus.Read(z.pc+3))
-		panic("ld r[z], rot[y] (IX+d)")
-	case 0x01: // bit y, r[z]
-		bit := byte4 & 0x38 >> 3
-		displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-		val := z.bus.Read(z.ix + displacement)
-		z.bit(bit, val)
-
-		z.totalCycles += 1 // XXX
-		z.pc += 4
-		return nil
-	case 0x02:
-		displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-		val := z.bus.Read(z.ix + displacement)
-		if zz == 6 {
-			val := z.res(yy, val)
-			z.bus.Write(z.ix+displacement, val)
-
-			// write to register as well?
-
-			z.totalCycles += 1 // XXX
-			z.pc += 4
-			return nil
-		}
-		// LD r[z], RES y, (IX+d)
-		fmt.Printf("%02x %02x %02x %02x\n",
-			z.bus.Read(z.pc+0),
-			z.bus.Read(z.pc+1),
-			z.bus.Read(z.pc+2),
-			z.bus.Read(z.pc+3))
-		panic("LD r[z], RES y, (IX+d)")
-
-	case 0x03:
-		displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-		val := z.bus.Read(z.ix + displacement)
-		if zz == 6 {
-			val := z.set(yy, val)
-			z.bus.Write(z.ix+displacement, val)
-
-			// write to register as well?
-
-			z.totalCycles += 1 // XXX
-			z.pc += 4
-			return nil
-		}
-		// LD r[z], SET y, (IX+d)
-		fmt.Printf("%02x %02x %02x %02x\n",
-			z.bus.Read(z.pc+0),
-			z.bus.Read(z.pc+1),
-			z.bus.Read(z.pc+2),
-			z.bus.Read(z.pc+3))
-		panic("LD r[z], SET y, (IX+d)")
-	default:
-		fmt.Printf("x %x y %x z %x dd cb %02x %02x\n", xx, yy, zz, z.bus.Read(z.pc+2), byte4)
-		panic("xxx")
-	}
+func (z *CPU) ddcb() error {
+	return z.indexedCB(z.ix)
+}
 
-	return nil
+func (z *CPU) fdcb() error {
+	return z.indexedCB(z.iy)
 }
 
-func (z *z80) fdcb() error {
+// indexedCB executes the dd cb and fd cb prefixed instructions on
+// (index+d).  The undocumented variants where r[z] is not 6 also copy the
+// result into register r[z], bit behaves the same for all of them.
+func (z *CPU) indexedCB(index uint16) error {
 	// zilog really is crazy, 4th byte + bit 7&6
 	// descriminates the instruction type
-	byte4 := z.bus.Read(z.pc + 3)
+	byte4 := z.fetch(z.pc + 3)
 	xx := byte4 >> 6
 	yy := 0x07 & (byte4 >> 3)
 	zz := 0x07 & byte4
+	displacement := uint16(int8(z.fetch(z.pc + 2)))
+	address := index + displacement
+	z.memptr = address
+	val := z.bus.Read(address)
 	switch xx {
-	case 0:
-		if zz == 6 {
-			// rot[y],(IY+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-			val := z.bus.Read(z.iy + displacement)
-			switch yy {
-			case 0x00:
-				val = z.rlc(val)
-			case 0x01:
-				val = z.rrc(val)
-			case 0x02:
-				val = z.rl(val)
-			case 0x03:
-				val = z.rr(val)
-			case 0x04:
-				val = z.sla(val)
-			case 0x05:
-				val = z.sra(val)
-			case 0x06:
-				val = z.sll(val)
-			case 0x07:
-				val = z.srl(val)
-			}
-			z.bus.Write(z.iy+displacement, val)
-			z.totalCycles += 1 // XXX
-			z.pc += 4
-			return nil
+	case 0x00: // rot[y] (IX+d)
+		switch yy {
+		case 0x00:
+			val = z.rlc(val)
+		case 0x01:
+			val = z.rrc(val)
+		case 0x02:
+			val = z.rl(val)
+		case 0x03:
+			val = z.rr(val)
+		case 0x04:
+			val = z.sla(val)
+		case 0x05:
+			val = z.sra(val)
+		case 0x06:
+			val = z.sll(val)
+		case 0x07:
+			val = z.srl(val)
 		}
-		// ld r[z], rot[y] (IY+d)
-		fmt.Printf("%02x %02x %02x %02x\n",
-			z.bus.Read(z.pc+0),
-			z.bus.Read(z.pc+1),
-			z.bus.Read(z.pc+2),
-			z.bus.Read(z.pc+3))
-		panic("ld r[z], rot[y] (IY+d)")
-	case 0x01:
-		bit := byte4 & 0x38 >> 3
-		displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-		val := z.bus.Read(z.iy + displacement)
-		z.bit(bit, val)
-
-		z.totalCycles += 1 // XXX
+	case 0x01: // bit y, (IX+d)
+		z.bitMemptr(yy, val)
+
+		z.totalCycles += 20
 		z.pc += 4
 		return nil
-	case 0x02:
-		displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-		val := z.bus.Read(z.iy + displacement)
-		if zz == 6 {
-			val := z.res(yy, val)
-			z.bus.Write(z.iy+displacement, val)
-
-			// write to register as well?
-
-			z.totalCycles += 1 // XXX
-			z.pc += 4
-			return nil
-		}
-		// LD r[z], RES y, (IY+d)
-		fmt.Printf("%02x %02x %02x %02x\n",
-			z.bus.Read(z.pc+0),
-			z.bus.Read(z.pc+1),
-			z.bus.Read(z.pc+2),
-			z.bus.Read(z.pc+3))
-		panic("LD r[z], RES y, (IY+d)")
-
-	case 0x03:
-		displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-		val := z.bus.Read(z.iy + displacement)
-		if zz == 6 {
-			val := z.set(yy, val)
-			z.bus.Write(z.iy+displacement, val)
-
-			// write to register as well?
+	case 0x02: // res y, (IX+d)
+		val = z.res(yy, val)
+	case 0x03: // set y, (IX+d)
+		val = z.set(yy, val)
+	}
+	z.bus.Write(address, val)
 
-			z.totalCycles += 1 // XXX
-			z.pc += 4
-			return nil
-		}
-		// LD r[z], SET y, (IY+d)
-		fmt.Printf("%02x %02x %02x %02x\n",
-			z.bus.Read(z.pc+0),
-			z.bus.Read(z.pc+1),
-			z.bus.Read(z.pc+2),
-			z.bus.Read(z.pc+3))
-		panic("LD r[z], SET y, (IY+d)")
-	default:
-		fmt.Printf("x %x y %x z %x fd cb %02x %02x\n", xx, yy, zz, z.bus.Read(z.pc+2), byte4)
-		panic("yyy")
+	// LD r[z], op (IX+d)
+	if zz != 6 {
+		z.undocumentedSetReg(zz, val)
 	}
 
+	z.totalCycles += 23
+	z.pc += 4
 	return nil
 }
 
-func (z *z80) genericPostInstruction(o *opcode) {
-	// panic for now
+func (z *CPU) genericPostInstruction(o *opcode) error {
+	// The opcode table entry is missing.
 	if o.noBytes == 0 || o.noCycles == 0 {
-		panic(fmt.Sprintf("opcode missing or invalid: pc %04x "+
-			"%02x %02x %02x %02x", z.pc,
-			z.bus.Read(z.pc+0), z.bus.Read(z.pc+1),
-			z.bus.Read(z.pc+2), z.bus.Read(z.pc+3)))
+		return z.invalidOpcode(4)
 	}
 
 	z.totalCycles += o.noCycles
 	z.pc += uint16(o.noBytes)
+	return nil
+}
+
+// invalidOpcode returns an InvalidOpcodeError for the n bytes at PC.
+func (z *CPU) invalidOpcode(n int) error {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = z.fetch(z.pc + uint16(i))
+	}
+	return InvalidOpcodeError{PC: z.pc, Bytes: b}
 }
 
-func (z *z80) Step() error {
+func (z *CPU) Step() error {
+	// Discard faults caused outside of instruction execution, for example
+	// by the disassembler.
+	z.bus.Fault()
+
+	pc := z.pc
 	err := z.step()
 	if err != nil {
 		return err
 	}
 
+	// Report bus faults and watchpoints at the instruction that caused
+	// them.
+	switch e := z.bus.Fault().(type) {
+	case nil:
+	case bus.BusFaultError:
+		e.PC = pc
+		return e
+	case bus.UnmappedIOError:
+		e.PC = pc
+		return e
+	case bus.WatchpointError:
+		e.PC = pc
+		return e
+	default:
+		return e
+	}
+
 	if !z.debug {
 		return nil
 	}
@@ -452,8 +614,59 @@ func (z *z80) Step() error {
 	return nil
 }
 
+// nmi accepts a non-maskable interrupt.  IFF1 is preserved in IFF2 so that
+// retn can restore it.
+func (z *CPU) nmi() {
+	z.refresh()
+	z.iff2 = z.iff1
+	z.iff1 = 0
+	z.eiDelay = false
+
+	// Return to the instruction following halt.
+	if z.halted {
+		z.halted = false
+		z.pc++
+	}
+	z.push(z.pc)
+	z.pc = 0x66
+	z.memptr = z.pc
+	z.totalCycles += 11
+}
+
 // Step executes the instruction as pointed at by PC.
-func (z *z80) step() error {
+func (z *CPU) step() error {
+	// NMI has priority and can not be masked.  The 8080 has no NMI.
+	if z.mode != Mode8080 && z.bus.NMI() {
+		z.nmi()
+		return nil
+	}
+
+	// Interrupts are sampled at instruction boundaries.  The instruction
+	// following ei is always executed before an interrupt is accepted.
+	if z.eiDelay {
+		z.eiDelay = false
+	} else if z.iff1 != 0 && z.bus.Interrupt() {
+		return z.interrupt()
+	}
+
+	// Halt executes nops until an interrupt arrives.
+	if z.halted {
+		z.refresh()
+		z.totalCycles += 4
+		if z.iff1 == 0 {
+			return HaltError{PC: z.pc}
+		}
+		return nil
+	}
+
+	return z.execute()
+}
+
+// execute executes the instruction pointed at by PC.
+func (z *CPU) execute() error {
+	if z.mode == Mode8080 {
+		return z.step8080()
+	}
 
 	// This is a little messy because of multi-byte opcodes.  We assume the
 	// opcode is one byte and we change in the switch statement to contain
@@ -462,18 +675,20 @@ func (z *z80) step() error {
 	// Instructions that return early shall handle pc and noCycles.
 	//
 	// Reference used: http://zilog.com/docs/z80/um0080.pdf
-	opc := z.bus.Read(z.pc)
+	opc := z.fetch(z.pc)
 	opcodeStruct := &opcodes[opc]
 	pi := z.genericPostInstruction
+	z.refresh()
 
 	// Move all code into opcodes array for extra vroom vroom.
 	switch opc {
 	case 0x00: // nop
 		// nothing to do
 	case 0x01: // ld bc,nn
-		z.bc = uint16(z.bus.Read(z.pc+1)) | uint16(z.bus.Read(z.pc+2))<<8
+		z.bc = uint16(z.fetch(z.pc+1)) | uint16(z.fetch(z.pc+2))<<8
 	case 0x02: // ld (bc),a
 		z.bus.Write(z.bc, byte(z.af>>8))
+		z.memptr = z.af&0xff00 | (z.bc+1)&0x00ff
 	case 0x03: // inc bc
 		z.bc += 1
 	case 0x04: // inc b
@@ -481,7 +696,7 @@ func (z *z80) step() error {
 	case 0x05: // dec b
 		z.bc = uint16(z.dec(byte(z.bc>>8)))<<8 | z.bc&0x00ff
 	case 0x06: // ld b,n
-		z.bc = uint16(z.bus.Read(z.pc+1))<<8 | z.bc&0x00ff
+		z.bc = uint16(z.fetch(z.pc+1))<<8 | z.bc&0x00ff
 	case 0x07: // rlca
 		a := byte(z.af >> 8)
 		a = a<<1 | a>>7
@@ -496,6 +711,7 @@ func (z *z80) step() error {
 		z.hl = z.add16(z.hl, z.bc)
 	case 0x0a: // ld a,(bc)
 		z.af = uint16(z.bus.Read(z.bc))<<8 | z.af&0x00ff
+		z.memptr = z.bc + 1
 	case 0x0b: //dec bc
 		z.bc -= 1
 	case 0x0c: // inc c
@@ -503,7 +719,7 @@ func (z *z80) step() error {
 	case 0x0d: // dec c
 		z.bc = uint16(z.dec(byte(z.bc))) | z.bc&0xff00
 	case 0x0e: // ld c,n
-		z.bc = uint16(z.bus.Read(z.pc+1)) | z.bc&0xff00
+		z.bc = uint16(z.fetch(z.pc+1)) | z.bc&0xff00
 	case 0x0f: // rrca
 		a := byte(z.af >> 8)
 		f := byte(z.af)&(FLAG_P|FLAG_Z|FLAG_S) | a&FLAG_C
@@ -514,14 +730,16 @@ func (z *z80) step() error {
 		b := byte(z.bc>>8) - 1
 		z.bc = z.bc&0x00ff | uint16(b)<<8
 		if b != 0 {
-			z.pc = z.pc + 2 + uint16(int8(z.bus.Read(z.pc+1)))
+			z.pc = z.pc + 2 + uint16(int8(z.fetch(z.pc+1)))
+			z.memptr = z.pc
 			z.totalCycles += 13
 			return nil
 		}
 	case 0x11: // ld de,nn
-		z.de = uint16(z.bus.Read(z.pc+1)) | uint16(z.bus.Read(z.pc+2))<<8
+		z.de = uint16(z.fetch(z.pc+1)) | uint16(z.fetch(z.pc+2))<<8
 	case 0x12: // ld (de),a
 		z.bus.Write(z.de, byte(z.af>>8))
+		z.memptr = z.af&0xff00 | (z.de+1)&0x00ff
 	case 0x13: // inc de
 		z.de += 1
 	case 0x14: // inc d
@@ -529,7 +747,7 @@ func (z *z80) step() error {
 	case 0x15: // dec d
 		z.de = uint16(z.dec(byte(z.de>>8)))<<8 | z.de&0x00ff
 	case 0x16: // ld d,n
-		z.de = uint16(z.bus.Read(z.pc+1))<<8 | z.de&0x00ff
+		z.de = uint16(z.fetch(z.pc+1))<<8 | z.de&0x00ff
 	case 0x17: // rla
 		t := byte(z.af >> 8)
 		a := t<<1 | byte(z.af)&FLAG_C
@@ -537,13 +755,15 @@ func (z *z80) step() error {
 			t>>7
 		z.af = uint16(a)<<8 | uint16(f)
 	case 0x18: // jr d
-		z.pc = z.pc + 2 + uint16(int8(z.bus.Read(z.pc+1)))
+		z.pc = z.pc + 2 + uint16(int8(z.fetch(z.pc+1)))
+		z.memptr = z.pc
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
 	case 0x19: // add hl,de
 		z.hl = z.add16(z.hl, z.de)
 	case 0x1a: // ld a,(de)
 		z.af = uint16(z.bus.Read(z.de))<<8 | z.af&0x00ff
+		z.memptr = z.de + 1
 	case 0x1b: // dec de
 		z.de -= 1
 	case 0x1c: // inc e
@@ -551,7 +771,7 @@ func (z *z80) step() error {
 	case 0x1d: // dec e
 		z.de = uint16(z.dec(byte(z.de))) | z.de&0xff00
 	case 0x1e: // ld e,n
-		z.de = uint16(z.bus.Read(z.pc+1)) | z.de&0xff00
+		z.de = uint16(z.fetch(z.pc+1)) | z.de&0xff00
 	case 0x1f: // rra
 		t := byte(z.af >> 8)
 		a := t>>1 | byte(z.af)<<7
@@ -560,16 +780,18 @@ func (z *z80) step() error {
 		z.af = uint16(a)<<8 | uint16(f)
 	case 0x20: // jr nz,d
 		if z.af&zero == 0 {
-			z.pc = z.pc + 2 + uint16(int8(z.bus.Read(z.pc+1)))
+			z.pc = z.pc + 2 + uint16(int8(z.fetch(z.pc+1)))
+			z.memptr = z.pc
 			z.totalCycles += 12
 			return nil
 		}
 	case 0x21: // ld hl,nn
-		z.hl = uint16(z.bus.Read(z.pc+1)) | uint16(z.bus.Read(z.pc+2))<<8
+		z.hl = uint16(z.fetch(z.pc+1)) | uint16(z.fetch(z.pc+2))<<8
 	case 0x22: // ld (nn),hl
-		addr := uint16(z.bus.Read(z.pc+1)) | uint16(z.bus.Read(z.pc+2))<<8
+		addr := uint16(z.fetch(z.pc+1)) | uint16(z.fetch(z.pc+2))<<8
 		z.bus.Write(addr, byte(z.hl))
 		z.bus.Write(addr+1, byte(z.hl>>8))
+		z.memptr = addr + 1
 	case 0x23: // inc hl
 		z.hl += 1
 	case 0x24: // inc h
@@ -577,20 +799,22 @@ func (z *z80) step() error {
 	case 0x25: // dec h
 		z.hl = uint16(z.dec(byte(z.hl>>8)))<<8 | z.hl&0x00ff
 	case 0x26: // ld h,n
-		z.hl = uint16(z.bus.Read(z.pc+1))<<8 | z.hl&0x00ff
+		z.hl = uint16(z.fetch(z.pc+1))<<8 | z.hl&0x00ff
 	case 0x27: // daa
 		z.daa()
 	case 0x28: // jr z,d
 		if z.af&zero == zero {
-			z.pc = z.pc + 2 + uint16(int8(z.bus.Read(z.pc+1)))
+			z.pc = z.pc + 2 + uint16(int8(z.fetch(z.pc+1)))
+			z.memptr = z.pc
 			z.totalCycles += 12
 			return nil
 		}
 	case 0x29: // add hl,hl
 		z.hl = z.add16(z.hl, z.hl)
 	case 0x2a: // ld (hl),nn
-		addr := uint16(z.bus.Read(z.pc+1)) | uint16(z.bus.Read(z.pc+2))<<8
+		addr := uint16(z.fetch(z.pc+1)) | uint16(z.fetch(z.pc+2))<<8
 		z.hl = uint16(z.bus.Read(addr)) | uint16(z.bus.Read(addr+1))<<8
+		z.memptr = addr + 1
 	case 0x2b: //dec hl
 		z.hl -= 1
 	case 0x2c: // inc l
@@ -598,7 +822,7 @@ func (z *z80) step() error {
 	case 0x2d: // dec l
 		z.hl = uint16(z.dec(byte(z.hl))) | z.hl&0xff00
 	case 0x2e: // ld l,n
-		z.hl = uint16(z.bus.Read(z.pc+1)) | z.hl&0xff00
+		z.hl = uint16(z.fetch(z.pc+1)) | z.hl&0xff00
 	case 0x2f: // cpl
 		a := byte(z.af >> 8)
 		a ^= 0xff
@@ -608,15 +832,17 @@ func (z *z80) step() error {
 		z.af = uint16(a)<<8 | uint16(f)
 	case 0x30: // jr nc,d
 		if z.af&carry == 0 {
-			z.pc = z.pc + 2 + uint16(int8(z.bus.Read(z.pc+1)))
+			z.pc = z.pc + 2 + uint16(int8(z.fetch(z.pc+1)))
+			z.memptr = z.pc
 			z.totalCycles += 12
 			return nil
 		}
 	case 0x31: // ld sp,nn
-		z.sp = uint16(z.bus.Read(z.pc+1)) | uint16(z.bus.Read(z.pc+2))<<8
+		z.sp = uint16(z.fetch(z.pc+1)) | uint16(z.fetch(z.pc+2))<<8
 	case 0x32: // ld (nn),a
-		z.bus.Write(uint16(z.bus.Read(z.pc+1))|
-			uint16(z.bus.Read(z.pc+2))<<8, byte(z.af>>8))
+		addr := uint16(z.fetch(z.pc+1)) | uint16(z.fetch(z.pc+2))<<8
+		z.bus.Write(addr, byte(z.af>>8))
+		z.memptr = z.af&0xff00 | (addr+1)&0x00ff
 	case 0x33: //inc sp
 		z.sp += 1
 	case 0x34: // inc (hl)
@@ -624,7 +850,7 @@ func (z *z80) step() error {
 	case 0x35: // dec (hl)
 		z.bus.Write(z.hl, z.dec(z.bus.Read(z.hl)))
 	case 0x36: // ld (hl),n
-		z.bus.Write(z.hl, z.bus.Read(z.pc+1))
+		z.bus.Write(z.hl, z.fetch(z.pc+1))
 	case 0x37: // scf
 		a := byte(z.af >> 8)
 		f := byte(z.af)&(FLAG_P|FLAG_Z|FLAG_S) |
@@ -633,7 +859,8 @@ func (z *z80) step() error {
 		z.af = z.af&0xff00 | uint16(f)
 	case 0x38: // jr c,d
 		if z.af&carry == carry {
-			z.pc = z.pc + 2 + uint16(int8(z.bus.Read(z.pc+1)))
+			z.pc = z.pc + 2 + uint16(int8(z.fetch(z.pc+1)))
+			z.memptr = z.pc
 			z.totalCycles += 12
 			return nil
 		}
@@ -648,14 +875,15 @@ func (z *z80) step() error {
 			a&(FLAG_3|FLAG_5)
 		z.af = z.af&0xff00 | uint16(f)
 	case 0x3a: // ld a,(nn)
-		z.af = uint16(z.bus.Read(uint16(z.bus.Read(z.pc+1))|
-			uint16(z.bus.Read(z.pc+2))<<8))<<8 | z.af&0x00ff
+		addr := uint16(z.fetch(z.pc+1)) | uint16(z.fetch(z.pc+2))<<8
+		z.af = uint16(z.bus.Read(addr))<<8 | z.af&0x00ff
+		z.memptr = addr + 1
 	case 0x3b: //dec sp
 		z.sp -= 1
 	case 0x3c: // inc a
 		z.af = uint16(z.inc(byte(z.af>>8)))<<8 | z.af&0x00ff
 	case 0x3e: // ld a,n
-		z.af = uint16(z.bus.Read(z.pc+1))<<8 | z.af&0x00ff
+		z.af = uint16(z.fetch(z.pc+1))<<8 | z.af&0x00ff
 	case 0x40: //ld b,b
 		// nothing to do
 	case 0x41: //ld b,c
@@ -766,7 +994,12 @@ func (z *z80) step() error {
 		z.bus.Write(z.hl, byte(z.hl))
 	case 0x76: // halt
 		z.totalCycles += opcodeStruct.noCycles
-		return HaltError{PC: z.pc}
+		z.halted = true
+		if z.iff1 == 0 {
+			// Nothing can wake us up.
+			return HaltError{PC: z.pc}
+		}
+		return nil
 	case 0x77: // ld (hl),a
 		z.bus.Write(z.hl, byte(z.af>>8))
 	case 0x78: // ld a,b
@@ -919,8 +1152,9 @@ func (z *z80) step() error {
 			z.sp++
 			pc = uint16(z.bus.Read(z.sp))<<8 | pc&0x00ff
 			z.sp++
-			z.totalCycles += 11 // XXX
+			z.totalCycles += 11
 			z.pc = pc
+			z.memptr = pc
 			return nil
 		}
 	case 0xc1: // pop bc
@@ -929,15 +1163,17 @@ func (z *z80) step() error {
 		z.bc = uint16(z.bus.Read(z.sp))<<8 | z.bc&0x00ff
 		z.sp++
 	case 0xc2: // jp nz,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&zero == 0 {
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 			z.totalCycles += opcodeStruct.noCycles
 			return nil
 		}
 	case 0xc3: // jp nn
-		z.pc = uint16(z.bus.Read(z.pc+1)) |
-			uint16(z.bus.Read(z.pc+2))<<8
+		z.pc = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
+		z.memptr = z.pc
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
 	case 0xc5: // push bc
@@ -946,6 +1182,8 @@ func (z *z80) step() error {
 		z.sp--
 		z.bus.Write(z.sp, byte(z.bc))
 	case 0xc4: //call nz
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&zero == 0 {
 			retPC := z.pc + opcodeStruct.noBytes
 			z.sp--
@@ -953,14 +1191,13 @@ func (z *z80) step() error {
 			z.sp--
 			z.bus.Write(z.sp, byte(retPC))
 
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 
 			z.totalCycles += 17
 			return nil
 		}
 	case 0xc6: // add a,i
-		z.add(z.bus.Read(z.pc + 1))
+		z.add(z.fetch(z.pc + 1))
 	case 0xc7: // rst $0
 		retPC := z.pc + opcodeStruct.noBytes
 		z.sp--
@@ -969,6 +1206,7 @@ func (z *z80) step() error {
 		z.bus.Write(z.sp, byte(retPC))
 
 		z.pc = 0x00
+		z.memptr = z.pc
 
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
@@ -978,8 +1216,9 @@ func (z *z80) step() error {
 			z.sp++
 			pc = uint16(z.bus.Read(z.sp))<<8 | pc&0x00ff
 			z.sp++
-			z.totalCycles += 11 // XXX
+			z.totalCycles += 11
 			z.pc = pc
+			z.memptr = pc
 			return nil
 		}
 	case 0xc9: // ret
@@ -989,17 +1228,20 @@ func (z *z80) step() error {
 		z.sp++
 		z.totalCycles += opcodeStruct.noCycles
 		z.pc = pc
+		z.memptr = pc
 		return nil
 	case 0xca: // jp z,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&zero == zero {
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 			z.totalCycles += opcodeStruct.noCycles
 			return nil
 		}
 	case 0xcb: // z80 only
-		byte2 := z.bus.Read(z.pc + 1)
+		byte2 := z.fetch(z.pc + 1)
 		opcodeStruct = &opcodesCB[byte2]
+		z.refresh()
 		switch byte2 {
 		case 0x00: // rlc b
 			z.bc = uint16(z.rlc(byte(z.bc>>8)))<<8 | z.bc&0x00ff
@@ -1150,7 +1392,7 @@ func (z *z80) step() error {
 		case 0x45: // bit 0,l
 			z.bit(0, byte(z.hl))
 		case 0x46: // bit 0,(hl)
-			z.bit(0, z.bus.Read(z.hl))
+			z.bitMemptr(0, z.bus.Read(z.hl))
 		case 0x47: // bit 0,a
 			z.bit(0, byte(z.af>>8))
 		case 0x48: // bit 1,b
@@ -1166,7 +1408,7 @@ func (z *z80) step() error {
 		case 0x4d: // bit 1,l
 			z.bit(1, byte(z.hl))
 		case 0x4e: // bit 1,(hl)
-			z.bit(1, z.bus.Read(z.hl))
+			z.bitMemptr(1, z.bus.Read(z.hl))
 		case 0x4f: // bit 1,a
 			z.bit(1, byte(z.af>>8))
 		case 0x50: // bit 2,b
@@ -1182,7 +1424,7 @@ func (z *z80) step() error {
 		case 0x55: // bit 2,l
 			z.bit(2, byte(z.hl))
 		case 0x56: // bit 2,(hl)
-			z.bit(2, z.bus.Read(z.hl))
+			z.bitMemptr(2, z.bus.Read(z.hl))
 		case 0x57: // bit 2,a
 			z.bit(2, byte(z.af>>8))
 		case 0x58: // bit 3,b
@@ -1198,7 +1440,7 @@ func (z *z80) step() error {
 		case 0x5d: // bit 3,l
 			z.bit(3, byte(z.hl))
 		case 0x5e: // bit 3,(hl)
-			z.bit(3, z.bus.Read(z.hl))
+			z.bitMemptr(3, z.bus.Read(z.hl))
 		case 0x5f: // bit 3,a
 			z.bit(3, byte(z.af>>8))
 		case 0x60: // bit 4,b
@@ -1214,7 +1456,7 @@ func (z *z80) step() error {
 		case 0x65: // bit 4,l
 			z.bit(4, byte(z.hl))
 		case 0x66: // bit 4,(hl)
-			z.bit(4, z.bus.Read(z.hl))
+			z.bitMemptr(4, z.bus.Read(z.hl))
 		case 0x67: // bit 4,a
 			z.bit(4, byte(z.af>>8))
 		case 0x68: // bit 5,b
@@ -1230,7 +1472,7 @@ func (z *z80) step() error {
 		case 0x6d: // bit 5,l
 			z.bit(5, byte(z.hl))
 		case 0x6e: // bit 5,(hl)
-			z.bit(5, z.bus.Read(z.hl))
+			z.bitMemptr(5, z.bus.Read(z.hl))
 		case 0x6f: // bit 5,a
 			z.bit(5, byte(z.af>>8))
 		case 0x70: // bit 6,b
@@ -1246,7 +1488,7 @@ func (z *z80) step() error {
 		case 0x75: // bit 6,l
 			z.bit(6, byte(z.hl))
 		case 0x76: // bit 6,(hl)
-			z.bit(6, z.bus.Read(z.hl))
+			z.bitMemptr(6, z.bus.Read(z.hl))
 		case 0x77: // bit 6,a
 			z.bit(6, byte(z.af>>8))
 		case 0x78: // bit 7,b
@@ -1262,7 +1504,7 @@ func (z *z80) step() error {
 		case 0x7d: // bit 7,l
 			z.bit(7, byte(z.hl))
 		case 0x7e: // bit 7,(hl)
-			z.bit(7, z.bus.Read(z.hl))
+			z.bitMemptr(7, z.bus.Read(z.hl))
 		case 0x7f: // bit 7,a
 			z.bit(7, byte(z.af>>8))
 		case 0x80: // res 0,b
@@ -1538,12 +1780,11 @@ func (z *z80) step() error {
 		case 0xff: // set 7,a
 			z.af = z.af&0x00ff | uint16(z.set(7, byte(z.af>>8)))<<8
 		default:
-			return fmt.Errorf("invalid instruction: 0x%02x "+
-				"0x%02x @ 0x%04x", opc, z.bus.Read(z.pc+1),
-				z.pc)
-			//return ErrInvalidInstruction
+			return z.invalidOpcode(2)
 		}
 	case 0xcc: //call z,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&zero == zero {
 			retPC := z.pc + opcodeStruct.noBytes
 			z.sp--
@@ -1551,8 +1792,7 @@ func (z *z80) step() error {
 			z.sp--
 			z.bus.Write(z.sp, byte(retPC))
 
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 
 			z.totalCycles += 17
 			return nil
@@ -1564,13 +1804,14 @@ func (z *z80) step() error {
 		z.sp--
 		z.bus.Write(z.sp, byte(retPC))
 
-		z.pc = uint16(z.bus.Read(z.pc+1)) |
-			uint16(z.bus.Read(z.pc+2))<<8
+		z.pc = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
+		z.memptr = z.pc
 
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
 	case 0xce: // adc a,i
-		z.adc(z.bus.Read(z.pc + 1))
+		z.adc(z.fetch(z.pc + 1))
 	case 0xcf: // rst $08
 		retPC := z.pc + opcodeStruct.noBytes
 		z.sp--
@@ -1579,6 +1820,7 @@ func (z *z80) step() error {
 		z.bus.Write(z.sp, byte(retPC))
 
 		z.pc = 0x08
+		z.memptr = z.pc
 
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
@@ -1588,8 +1830,9 @@ func (z *z80) step() error {
 			z.sp++
 			pc = uint16(z.bus.Read(z.sp))<<8 | pc&0x00ff
 			z.sp++
-			z.totalCycles += 11 // XXX
+			z.totalCycles += 11
 			z.pc = pc
+			z.memptr = pc
 			return nil
 		}
 	case 0xd1: // pop de
@@ -1598,21 +1841,40 @@ func (z *z80) step() error {
 		z.de = uint16(z.bus.Read(z.sp))<<8 | z.de&0x00ff
 		z.sp++
 	case 0xd2: // jp nc,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&carry == 0 {
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 			z.totalCycles += opcodeStruct.noCycles
 			return nil
 		}
 	case 0xd3: // out (n), a
-		z.bus.IOWrite(z.bus.Read(z.pc+1), byte(z.af>>8))
+		// A is put on the upper half of the address bus.
+		port := z.af&0xff00 | uint16(z.fetch(z.pc+1))
+		z.bus.IOWrite(port, byte(z.af>>8))
+		z.memptr = port&0xff00 | (port+1)&0x00ff
+	case 0xd4: //call nc,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
+		if z.af&carry == 0 {
+			retPC := z.pc + opcodeStruct.noBytes
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC>>8))
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC))
+
+			z.pc = z.memptr
+
+			z.totalCycles += 17
+			return nil
+		}
 	case 0xd5: // push de
 		z.sp--
 		z.bus.Write(z.sp, byte(z.de>>8))
 		z.sp--
 		z.bus.Write(z.sp, byte(z.de))
 	case 0xd6: // sub i
-		z.sub(z.bus.Read(z.pc + 1))
+		z.sub(z.fetch(z.pc + 1))
 	case 0xd7: // rst $10
 		retPC := z.pc + opcodeStruct.noBytes
 		z.sp--
@@ -1621,6 +1883,7 @@ func (z *z80) step() error {
 		z.bus.Write(z.sp, byte(retPC))
 
 		z.pc = 0x10
+		z.memptr = z.pc
 
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
@@ -1630,20 +1893,31 @@ func (z *z80) step() error {
 			z.sp++
 			pc = uint16(z.bus.Read(z.sp))<<8 | pc&0x00ff
 			z.sp++
-			z.totalCycles += 11 // XXX
+			z.totalCycles += 11
 			z.pc = pc
+			z.memptr = pc
 			return nil
 		}
+	case 0xd9: // exx
+		z.bc, z.bc_ = z.bc_, z.bc
+		z.de, z.de_ = z.de_, z.de
+		z.hl, z.hl_ = z.hl_, z.hl
 	case 0xda: // jp c,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&carry == carry {
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 			z.totalCycles += opcodeStruct.noCycles
 			return nil
 		}
 	case 0xdb: // in a,(n)
-		z.af = uint16(z.bus.IORead(z.bus.Read(z.pc+1)))<<8 | z.af&0x00ff
+		// A is put on the upper half of the address bus.
+		port := z.af&0xff00 | uint16(z.fetch(z.pc+1))
+		z.af = uint16(z.bus.IORead(port))<<8 | z.af&0x00ff
+		z.memptr = port + 1
 	case 0xdc: //call c,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&carry == carry {
 			retPC := z.pc + opcodeStruct.noBytes
 			z.sp--
@@ -1651,28 +1925,29 @@ func (z *z80) step() error {
 			z.sp--
 			z.bus.Write(z.sp, byte(retPC))
 
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 
 			z.totalCycles += 17
 			return nil
 		}
 	case 0xdd: // z80 only
-		byte2 := z.bus.Read(z.pc + 1)
+		byte2 := z.fetch(z.pc + 1)
 		opcodeStruct = &opcodesDD[byte2]
+		z.refresh()
 		switch byte2 {
 		case 0x09: // add ix,bc
 			z.ix = z.add16(z.ix, z.bc)
 		case 0x19: // add ix,de
 			z.ix = z.add16(z.ix, z.de)
 		case 0x21: // ld ix,nn
-			z.ix = uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			z.ix = uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 		case 0x22: // ld (nn),ix
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 			z.bus.Write(addr, byte(z.ix))
 			z.bus.Write(addr+1, byte(z.ix>>8))
+			z.memptr = addr + 1
 		case 0x23: // inc ix
 			z.ix += 1
 		case 0x24: // inc ixh XXX this is supposed to be undocumented
@@ -1680,14 +1955,15 @@ func (z *z80) step() error {
 		case 0x25: // dec ixh XXX this is supposed to be undocumented
 			z.ix = uint16(z.dec(byte(z.ix>>8)))<<8 | z.ix&0x00ff
 		case 0x26: // ld ixh,n XXX this is supposed to be undocumented
-			z.ix = z.ix&0x00ff | uint16(z.bus.Read(z.pc+2))<<8
+			z.ix = z.ix&0x00ff | uint16(z.fetch(z.pc+2))<<8
 		case 0x29: // add ix,ix
 			z.ix = z.add16(z.ix, z.ix)
 		case 0x2a: // ld ix,(nn)
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 			z.ix = uint16(z.bus.Read(addr)) |
 				uint16(z.bus.Read(addr+1))<<8
+			z.memptr = addr + 1
 		case 0x2b: // dec ix
 			z.ix -= 1
 		case 0x2c: // inc ixl XXX this is supposed to be undocumented
@@ -1695,73 +1971,70 @@ func (z *z80) step() error {
 		case 0x2d: // dec ixl XXX this is supposed to be undocumented
 			z.ix = uint16(z.dec(byte(z.ix))) | z.ix&0xff00
 		case 0x2e: // ld ixl,n XXX this is supposed to be undocumented
-			z.ix = z.ix&0xff00 | uint16(z.bus.Read(z.pc+2))
+			z.ix = z.ix&0xff00 | uint16(z.fetch(z.pc+2))
 		case 0x34: // inc (ix+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			x := z.inc(z.bus.Read(z.ix + displacement))
 			z.bus.Write(z.ix+displacement, x)
 		case 0x35: // dec (ix+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			x := z.dec(z.bus.Read(z.ix + displacement))
 			z.bus.Write(z.ix+displacement, x)
 		case 0x36: // ld (ix+d),n
-			val := z.bus.Read(z.pc + 3)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			val := z.fetch(z.pc + 3)
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bus.Write(z.ix+displacement, val)
 		case 0x39: // add ix,sp
 			z.ix = z.add16(z.ix, z.sp)
 		case 0x40, 0x41, 0x42, 0x43: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x44: // ld b,ixh
 			z.bc = z.bc&0x00ff | z.ix&0xff00
 		case 0x45: // ld b,ixl
 			z.bc = z.bc&0x00ff | z.ix<<8
 		case 0x46: // ld b,(ix+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bc = z.bc&0x00ff |
 				uint16(z.bus.Read(z.ix+displacement))<<8
 		case 0x47, 0x48, 0x49, 0x4a, 0x4b: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x4c: // ld c,ixh
 			z.bc = z.bc&0xff00 | z.ix>>8
 		case 0x4d: // ld c,ixl
 			z.bc = z.bc&0xff00 | z.ix&0x00ff
 		case 0x4e: // ld c,(ix+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bc = z.bc&0xff00 |
 				uint16(z.bus.Read(z.ix+displacement))
 		case 0x4f, 0x50, 0x51, 0x52, 0x53: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x54: // ld d,ixh
 			z.de = z.de&0x00ff | z.ix&0xff00
 		case 0x55: // ld d,ixl
 			z.de = z.de&0x00ff | z.ix<<8
 		case 0x56: // ld d,(ix+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.de = z.de&0x00ff |
 				uint16(z.bus.Read(z.ix+displacement))<<8
 		case 0x57, 0x58, 0x59, 0x5a, 0x5b: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x5c: // ld e,ixh
 			z.de = z.de&0xff00 | z.ix>>8
 		case 0x5d: // ld e,ixl
 			z.de = z.de&0xff00 | z.ix&0x00ff
 		case 0x5e: // ld e,(ix+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.de = z.de&0xff00 |
 				uint16(z.bus.Read(z.ix+displacement))
 		case 0x5f: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x60: // ld ixh,b
 			z.ix = z.ix&0x00ff | z.bc&0xff00
 		case 0x61: // ld ixh,c
@@ -1775,7 +2048,8 @@ func (z *z80) step() error {
 		case 0x65: // ld ixh,ixl
 			z.ix = z.ix&0x00ff | z.ix<<8
 		case 0x66: // ld h,(ix+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.hl = z.hl&0x00ff |
 				uint16(z.bus.Read(z.ix+displacement))<<8
 		case 0x67: // ld ixh,a
@@ -1793,107 +2067,118 @@ func (z *z80) step() error {
 		case 0x6d: // ld ixl,ixl
 			// nop
 		case 0x6e: // ld l,(ix+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.hl = z.hl&0xff00 |
 				uint16(z.bus.Read(z.ix+displacement))
 		case 0x6f: // ld ixl,a
 			z.ix = z.ix&0xff00 | z.af>>8
 		case 0x70: // ld (ix+d),b
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bus.Write(z.ix+displacement, byte(z.bc>>8))
 		case 0x71: // ld (ix+d),c
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bus.Write(z.ix+displacement, byte(z.bc))
 		case 0x72: // ld (ix+d),d
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bus.Write(z.ix+displacement, byte(z.de>>8))
 		case 0x73: // ld (ix+d),e
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bus.Write(z.ix+displacement, byte(z.de))
 		case 0x74: // ld (ix+d),h
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bus.Write(z.ix+displacement, byte(z.hl>>8))
 		case 0x75: // ld (ix+d),l
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bus.Write(z.ix+displacement, byte(z.hl))
-		case 0x76: // ld (ix+d),n
-			val := z.bus.Read(z.pc + 3)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-			z.bus.Write(z.ix+displacement, val)
+		case 0x76: // noni, halt
+			return z.noni()
 		case 0x77: // ld (ix+d),a
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.bus.Write(z.ix+displacement, byte(z.af>>8))
 		case 0x78, 0x79, 0x7a, 0x7b: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x7c: // ld a,ixh
 			z.af = z.af&0x00ff | z.ix&0xff00
 		case 0x7d: // ld a,ixl
 			z.af = z.af&0x00ff | z.ix<<8
 		case 0x7e: // ld a,(ix+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.af = z.af&0x00ff |
 				uint16(z.bus.Read(z.ix+displacement))<<8
 		case 0x7f: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x84: // add a,ixh XXX this is supposed to be undocumented
 			z.add(byte(z.ix >> 8))
 		case 0x85: // add a,ixl XXX this is supposed to be undocumented
 			z.add(byte(z.ix))
 		case 0x86: // add a,(ixl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.add(z.bus.Read(z.ix + displacement))
 		case 0x8c: // adc a,ixh XXX this is supposed to be undocumented
 			z.adc(byte(z.ix >> 8))
 		case 0x8d: // add a,ixl XXX this is supposed to be undocumented
 			z.adc(byte(z.ix))
 		case 0x8e: // adc a,(ixl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.adc(z.bus.Read(z.ix + displacement))
 		case 0x94: // sub a,ixh XXX this is supposed to be undocumented
 			z.sub(byte(z.ix >> 8))
 		case 0x95: // sub a,ixl XXX this is supposed to be undocumented
 			z.sub(byte(z.ix))
 		case 0x96: // sub a,(ixl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.sub(z.bus.Read(z.ix + displacement))
 		case 0x9c: // sbc a,ixh XXX this is supposed to be undocumented
 			z.sbc(byte(z.ix >> 8))
 		case 0x9d: // sbc a,ixl XXX this is supposed to be undocumented
 			z.sbc(byte(z.ix))
 		case 0x9e: // sbc a,(ixl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.sbc(z.bus.Read(z.ix + displacement))
 		case 0xa4: // and a,ixh XXX this is supposed to be undocumented
 			z.and(byte(z.ix >> 8))
 		case 0xa5: // and a,ixl XXX this is supposed to be undocumented
 			z.and(byte(z.ix))
 		case 0xa6: // and (ixl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.and(z.bus.Read(z.ix + displacement))
 		case 0xac: // xor a,ixh XXX this is supposed to be undocumented
 			z.xor(byte(z.ix >> 8))
 		case 0xad: // xor a,ixl XXX this is supposed to be undocumented
 			z.xor(byte(z.ix))
 		case 0xae: // xor (ixl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.xor(z.bus.Read(z.ix + displacement))
 		case 0xb4: // or a,ixh XXX this is supposed to be undocumented
 			z.or(byte(z.ix >> 8))
 		case 0xb5: // or a,ixl XXX this is supposed to be undocumented
 			z.or(byte(z.ix))
 		case 0xb6: // or (ixl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.or(z.bus.Read(z.ix + displacement))
 		case 0xbc: // cp a,ixh XXX this is supposed to be undocumented
 			z.cp(byte(z.ix >> 8))
 		case 0xbd: // cp a,ixl XXX this is supposed to be undocumented
 			z.cp(byte(z.ix))
 		case 0xbe: // cp (ixl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.ix + displacement
 			z.cp(z.bus.Read(z.ix + displacement))
 		case 0xcb:
 			return z.ddcb()
@@ -1902,22 +2187,30 @@ func (z *z80) step() error {
 			z.sp++
 			z.ix = uint16(z.bus.Read(z.sp))<<8 | z.ix&0x00ff
 			z.sp++
+		case 0xe3: // ex (sp),ix
+			t := uint16(z.bus.Read(z.sp)) |
+				uint16(z.bus.Read(z.sp+1))<<8
+			z.bus.Write(z.sp, byte(z.ix))
+			z.bus.Write(z.sp+1, byte(z.ix>>8))
+			z.ix = t
+			z.memptr = t
 		case 0xe5: // push ix
 			z.sp--
 			z.bus.Write(z.sp, byte(z.ix>>8))
 			z.sp--
 			z.bus.Write(z.sp, byte(z.ix))
+		case 0xe9: // jp (ix)
+			z.pc = z.ix
+			z.totalCycles += opcodeStruct.noCycles
+			return nil
 		case 0xf9: // ld sp, ix
 			z.sp = z.ix
 		default:
-			return fmt.Errorf("invalid instruction: 0x%02x "+
-				"0x%02x 0x%02x 0x%02x @ 0x%04x", opc,
-				z.bus.Read(z.pc+1), z.bus.Read(z.pc+2),
-				z.bus.Read(z.pc+3), z.pc)
-			//return ErrInvalidInstruction
+			// The prefix has no effect on the instruction.
+			return z.noni()
 		}
 	case 0xde: // sbc a,i
-		z.sbc(z.bus.Read(z.pc + 1))
+		z.sbc(z.fetch(z.pc + 1))
 	case 0xdf: // rst $18
 		retPC := z.pc + opcodeStruct.noBytes
 		z.sp--
@@ -1926,18 +2219,31 @@ func (z *z80) step() error {
 		z.bus.Write(z.sp, byte(retPC))
 
 		z.pc = 0x18
+		z.memptr = z.pc
 
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
+	case 0xe0: // ret po
+		if z.af&parity == 0 {
+			pc := uint16(z.bus.Read(z.sp))
+			z.sp++
+			pc = uint16(z.bus.Read(z.sp))<<8 | pc&0x00ff
+			z.sp++
+			z.totalCycles += 11
+			z.pc = pc
+			z.memptr = pc
+			return nil
+		}
 	case 0xe1: // pop hl
 		z.hl = uint16(z.bus.Read(z.sp)) | z.hl&0xff00
 		z.sp++
 		z.hl = uint16(z.bus.Read(z.sp))<<8 | z.hl&0x00ff
 		z.sp++
 	case 0xe2: // jp po,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&parity == 0 {
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 			z.totalCycles += opcodeStruct.noCycles
 			return nil
 		}
@@ -1947,13 +2253,29 @@ func (z *z80) step() error {
 		z.bus.Write(z.sp+1, byte(z.hl>>8))
 		z.bus.Write(z.sp, byte(z.hl))
 		z.hl = uint16(h)<<8 | uint16(l)
+		z.memptr = z.hl
+	case 0xe4: //call po,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
+		if z.af&parity == 0 {
+			retPC := z.pc + opcodeStruct.noBytes
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC>>8))
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC))
+
+			z.pc = z.memptr
+
+			z.totalCycles += 17
+			return nil
+		}
 	case 0xe5: // push hl
 		z.sp--
 		z.bus.Write(z.sp, byte(z.hl>>8))
 		z.sp--
 		z.bus.Write(z.sp, byte(z.hl))
 	case 0xe6: // and n
-		z.and(z.bus.Read(z.pc + 1))
+		z.and(z.fetch(z.pc + 1))
 	case 0xe7: // rst $20
 		retPC := z.pc + opcodeStruct.noBytes
 		z.sp--
@@ -1962,18 +2284,31 @@ func (z *z80) step() error {
 		z.bus.Write(z.sp, byte(retPC))
 
 		z.pc = 0x20
+		z.memptr = z.pc
 
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
+	case 0xe8: // ret pe
+		if z.af&parity == parity {
+			pc := uint16(z.bus.Read(z.sp))
+			z.sp++
+			pc = uint16(z.bus.Read(z.sp))<<8 | pc&0x00ff
+			z.sp++
+			z.totalCycles += 11
+			z.pc = pc
+			z.memptr = pc
+			return nil
+		}
 	case 0xe9: // jp (hl)
 		// but we don't dereference, *sigh* zilog
 		z.pc = z.hl
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
 	case 0xea: // jp pe,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&parity == parity {
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 			z.totalCycles += opcodeStruct.noCycles
 			return nil
 		}
@@ -1981,87 +2316,174 @@ func (z *z80) step() error {
 		t := z.hl
 		z.hl = z.de
 		z.de = t
+	case 0xec: //call pe,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
+		if z.af&parity == parity {
+			retPC := z.pc + opcodeStruct.noBytes
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC>>8))
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC))
+
+			z.pc = z.memptr
+
+			z.totalCycles += 17
+			return nil
+		}
 	case 0xed: // z80 only
-		byte2 := z.bus.Read(z.pc + 1)
+		byte2 := z.fetch(z.pc + 1)
 		opcodeStruct = &opcodesED[byte2]
+		z.refresh()
 		switch byte2 {
+		case 0x40: // in b,(c)
+			z.bc = uint16(z.in())<<8 | z.bc&0x00ff
+		case 0x41: // out (c),b
+			z.out(byte(z.bc >> 8))
 		case 0x42: // sbc hl,bc
 			z.sbc16(z.bc)
 		case 0x43: // ld (nn),bc
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 			z.bus.Write(addr, byte(z.bc))
 			z.bus.Write(addr+1, byte(z.bc>>8))
-		case 0x44: // neg
+			z.memptr = addr + 1
+		case 0x44, 0x4c, 0x54, 0x5c, 0x64, 0x6c, 0x74, 0x7c: // neg
 			t := byte(z.af >> 8)
 			z.af = z.af & 0x00ff
 			z.sub(t)
-		case 0x4a: // adc hl,bc
-			z.adc16(z.bc)
-		case 0x4b: // ld bc,(nn)
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
-			z.bc = uint16(z.bus.Read(addr)) |
-				uint16(z.bus.Read(addr+1))<<8
-		case 0x4d: // reti
+		case 0x45, 0x4d, 0x55, 0x5d, 0x65, 0x6d, 0x75, 0x7d: // retn, reti
+			// Both restore IFF1, reti is only special to Z80
+			// peripherals that watch the bus for it.
 			z.iff1 = z.iff2
 
 			pc := uint16(z.bus.Read(z.sp))
 			z.sp++
 			pc = uint16(z.bus.Read(z.sp))<<8 | pc&0x00ff
 			z.sp++
-			z.totalCycles += 14 // XXX
+			z.totalCycles += opcodeStruct.noCycles
 			z.pc = pc
+			z.memptr = pc
 			return nil
+		case 0x46, 0x4e, 0x66, 0x6e: // im 0
+			z.im = 0
+		case 0x47: // ld i,a
+			z.i = byte(z.af >> 8)
+		case 0x48: // in c,(c)
+			z.bc = uint16(z.in()) | z.bc&0xff00
+		case 0x49: // out (c),c
+			z.out(byte(z.bc))
+		case 0x4a: // adc hl,bc
+			z.adc16(z.bc)
+		case 0x4b: // ld bc,(nn)
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
+			z.bc = uint16(z.bus.Read(addr)) |
+				uint16(z.bus.Read(addr+1))<<8
+			z.memptr = addr + 1
+		case 0x4f: // ld r,a
+			z.r = byte(z.af >> 8)
+		case 0x50: // in d,(c)
+			z.de = uint16(z.in())<<8 | z.de&0x00ff
+		case 0x51: // out (c),d
+			z.out(byte(z.de >> 8))
 		case 0x52: // sbc hl,de
 			z.sbc16(z.de)
 		case 0x53: // ld (nn),de
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 			z.bus.Write(addr, byte(z.de))
 			z.bus.Write(addr+1, byte(z.de>>8))
+			z.memptr = addr + 1
+		case 0x56, 0x76: // im 1
+			z.im = 1
+		case 0x57: // ld a,i
+			z.ldAIR(z.i)
+		case 0x58: // in e,(c)
+			z.de = uint16(z.in()) | z.de&0xff00
+		case 0x59: // out (c),e
+			z.out(byte(z.de))
 		case 0x5a: // adc hl,de
 			z.adc16(z.de)
 		case 0x5b: // ld de,(nn)
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 			z.de = uint16(z.bus.Read(addr)) |
 				uint16(z.bus.Read(addr+1))<<8
+			z.memptr = addr + 1
+		case 0x5e, 0x7e: // im 2
+			z.im = 2
+		case 0x5f: // ld a,r
+			z.ldAIR(z.r)
+		case 0x60: // in h,(c)
+			z.hl = uint16(z.in())<<8 | z.hl&0x00ff
+		case 0x61: // out (c),h
+			z.out(byte(z.hl >> 8))
 		case 0x62: // sbc hl,hl
 			z.sbc16(z.hl)
+		case 0x63: // ld (nn),hl
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
+			z.bus.Write(addr, byte(z.hl))
+			z.bus.Write(addr+1, byte(z.hl>>8))
+			z.memptr = addr + 1
 		case 0x67: // rrd
 			z.rrd()
+		case 0x68: // in l,(c)
+			z.hl = uint16(z.in()) | z.hl&0xff00
+		case 0x69: // out (c),l
+			z.out(byte(z.hl))
 		case 0x6a: // adc hl,hl
 			z.adc16(z.hl)
-		case 0x6b: // ld (nn),hl
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
-			z.bus.Write(addr, byte(z.hl))
-			z.bus.Write(addr+1, byte(z.hl>>8))
+		case 0x6b: // ld hl,(nn)
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
+			z.hl = uint16(z.bus.Read(addr)) |
+				uint16(z.bus.Read(addr+1))<<8
+			z.memptr = addr + 1
 		case 0x6f: // rld
 			z.rld()
+		case 0x70: // in (c)
+			// Only affects flags.
+			z.in()
+		case 0x71: // out (c),0
+			z.out(0)
 		case 0x72: // sbc hl,sp
 			z.sbc16(z.sp)
 		case 0x73: // ld (nn),sp
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 			z.bus.Write(addr, byte(z.sp))
 			z.bus.Write(addr+1, byte(z.sp>>8))
+			z.memptr = addr + 1
+		case 0x78: // in a,(c)
+			z.af = uint16(z.in())<<8 | z.af&0x00ff
+		case 0x79: // out (c),a
+			z.out(byte(z.af >> 8))
 		case 0x7a: // adc hl,sp
 			z.adc16(z.sp)
 		case 0x7b: // ld sp,(nn)
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 			z.sp = uint16(z.bus.Read(addr)) |
 				uint16(z.bus.Read(addr+1))<<8
+			z.memptr = addr + 1
 		case 0xa0: // ldi
 			z.ldi()
 		case 0xa1: // cpi
 			z.cpi()
+		case 0xa2: // ini
+			z.ini()
+		case 0xa3: // outi
+			z.outi()
 		case 0xa8: // ldd
 			z.ldd()
 		case 0xa9: // cpd
 			z.cpd()
+		case 0xaa: // ind
+			z.ind()
+		case 0xab: // outd
+			z.outd()
 		case 0xb0: // ldir
 			t := z.bus.Read(z.hl)
 			z.bus.Write(z.de, t)
@@ -2075,6 +2497,7 @@ func (z *z80) step() error {
 			z.hl++
 			if z.bc != 0 {
 				// don't move pc
+				z.memptr = z.pc + 1
 				z.totalCycles += 21
 				return nil
 			}
@@ -2085,6 +2508,7 @@ func (z *z80) step() error {
 			lookup := a&0x08>>3 | val&0x08>>2 | t&0x08>>1
 			z.bc--
 			z.hl++
+			z.memptr++
 			f := byte(z.af)&FLAG_C |
 				ternB(z.bc != 0, FLAG_V|FLAG_N, FLAG_N) |
 				halfcarrySubTable[lookup] |
@@ -2096,6 +2520,23 @@ func (z *z80) step() error {
 			z.af = z.af&0xff00 | uint16(f)
 			if f&(FLAG_V|FLAG_Z) == FLAG_V {
 				// don't move pc
+				z.memptr = z.pc + 1
+				z.totalCycles += 21
+				return nil
+			}
+		case 0xb2: // inir
+			val := z.ini()
+			if z.bc&0xff00 != 0 {
+				// don't move pc
+				z.blockIORepeatFlags(val)
+				z.totalCycles += 21
+				return nil
+			}
+		case 0xb3: // otir
+			val := z.outi()
+			if z.bc&0xff00 != 0 {
+				// don't move pc
+				z.blockIORepeatFlags(val)
 				z.totalCycles += 21
 				return nil
 			}
@@ -2112,6 +2553,7 @@ func (z *z80) step() error {
 			z.de--
 			if z.bc != 0 {
 				// don't move pc
+				z.memptr = z.pc + 1
 				z.totalCycles += 21
 				return nil
 			}
@@ -2122,6 +2564,7 @@ func (z *z80) step() error {
 			lookup := a&0x08>>3 | val&0x08>>2 | t&0x08>>1
 			z.bc--
 			z.hl--
+			z.memptr--
 			f := byte(z.af)&FLAG_C |
 				ternB(z.bc != 0, FLAG_V|FLAG_N, FLAG_N) |
 				halfcarrySubTable[lookup] |
@@ -2133,17 +2576,31 @@ func (z *z80) step() error {
 			z.af = z.af&0xff00 | uint16(f)
 			if f&(FLAG_V|FLAG_Z) == FLAG_V {
 				// don't move pc
+				z.memptr = z.pc + 1
+				z.totalCycles += 21
+				return nil
+			}
+		case 0xba: // indr
+			val := z.ind()
+			if z.bc&0xff00 != 0 {
+				// don't move pc
+				z.blockIORepeatFlags(val)
+				z.totalCycles += 21
+				return nil
+			}
+		case 0xbb: // otdr
+			val := z.outd()
+			if z.bc&0xff00 != 0 {
+				// don't move pc
+				z.blockIORepeatFlags(val)
 				z.totalCycles += 21
 				return nil
 			}
 		default:
-			return fmt.Errorf("invalid instruction: 0x%02x "+
-				"0x%02x @ 0x%04x", opc, z.bus.Read(z.pc+1),
-				z.pc)
-			//return ErrInvalidInstruction
+			return z.invalidOpcode(2)
 		}
 	case 0xee: // xor n
-		z.xor(z.bus.Read(z.pc + 1))
+		z.xor(z.fetch(z.pc + 1))
 	case 0xef: // rst $28
 		retPC := z.pc + opcodeStruct.noBytes
 		z.sp--
@@ -2152,17 +2609,19 @@ func (z *z80) step() error {
 		z.bus.Write(z.sp, byte(retPC))
 
 		z.pc = 0x28
+		z.memptr = z.pc
 
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
 	case 0xf0: // ret p
-		if z.af&parity == parity {
+		if z.af&sign == 0 {
 			pc := uint16(z.bus.Read(z.sp))
 			z.sp++
 			pc = uint16(z.bus.Read(z.sp))<<8 | pc&0x00ff
 			z.sp++
-			z.totalCycles += 11 // XXX
+			z.totalCycles += 11
 			z.pc = pc
+			z.memptr = pc
 			return nil
 		}
 	case 0xf1: // pop af
@@ -2171,22 +2630,38 @@ func (z *z80) step() error {
 		z.af = uint16(z.bus.Read(z.sp))<<8 | z.af&0x00ff
 		z.sp++
 	case 0xf2: // jp p,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&sign == 0 {
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 			z.totalCycles += opcodeStruct.noCycles
 			return nil
 		}
 	case 0xf3: // di
 		z.iff1 = 0
 		z.iff2 = 0
+	case 0xf4: //call p,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
+		if z.af&sign == 0 {
+			retPC := z.pc + opcodeStruct.noBytes
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC>>8))
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC))
+
+			z.pc = z.memptr
+
+			z.totalCycles += 17
+			return nil
+		}
 	case 0xf5: // push af
 		z.sp--
 		z.bus.Write(z.sp, byte(z.af>>8))
 		z.sp--
 		z.bus.Write(z.sp, byte(z.af))
 	case 0xf6: // or n
-		z.or(z.bus.Read(z.pc + 1))
+		z.or(z.fetch(z.pc + 1))
 	case 0xf7: // rst $30
 		retPC := z.pc + opcodeStruct.noBytes
 		z.sp--
@@ -2195,37 +2670,68 @@ func (z *z80) step() error {
 		z.bus.Write(z.sp, byte(retPC))
 
 		z.pc = 0x30
+		z.memptr = z.pc
 
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
+	case 0xf8: // ret m
+		if z.af&sign == sign {
+			pc := uint16(z.bus.Read(z.sp))
+			z.sp++
+			pc = uint16(z.bus.Read(z.sp))<<8 | pc&0x00ff
+			z.sp++
+			z.totalCycles += 11
+			z.pc = pc
+			z.memptr = pc
+			return nil
+		}
 	case 0xf9: // ld sp,hl
 		z.sp = z.hl
 	case 0xfa: // jp m,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
 		if z.af&sign == sign {
-			z.pc = uint16(z.bus.Read(z.pc+1)) |
-				uint16(z.bus.Read(z.pc+2))<<8
+			z.pc = z.memptr
 			z.totalCycles += opcodeStruct.noCycles
 			return nil
 		}
 	case 0xfb: // ei
 		z.iff1 = 1
 		z.iff2 = 1
+		z.eiDelay = true
+	case 0xfc: //call m,nn
+		z.memptr = uint16(z.fetch(z.pc+1)) |
+			uint16(z.fetch(z.pc+2))<<8
+		if z.af&sign == sign {
+			retPC := z.pc + opcodeStruct.noBytes
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC>>8))
+			z.sp--
+			z.bus.Write(z.sp, byte(retPC))
+
+			z.pc = z.memptr
+
+			z.totalCycles += 17
+			return nil
+		}
 	case 0xfd: // z80 only
-		byte2 := z.bus.Read(z.pc + 1)
+		byte2 := z.fetch(z.pc + 1)
 		opcodeStruct = &opcodesFD[byte2]
+		z.refresh()
 		switch byte2 {
 		case 0x09: // add iy,bc
 			z.iy = z.add16(z.iy, z.bc)
 		case 0x19: // add iy,de
 			z.iy = z.add16(z.iy, z.de)
 		case 0x21: // ld iy,nn
-			z.iy = uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			z.iy = uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 		case 0x22: // ld (nn),iy
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 			z.bus.Write(addr, byte(z.iy))
 			z.bus.Write(addr+1, byte(z.iy>>8))
+			z.memptr = addr + 1
 		case 0x23: // inc iy
 			z.iy += 1
 		case 0x24: // inc iyh XXX this is supposed to be undocumented
@@ -2233,14 +2739,15 @@ func (z *z80) step() error {
 		case 0x25: // dec iyh XXX this is supposed to be undocumented
 			z.iy = uint16(z.dec(byte(z.iy>>8)))<<8 | z.iy&0x00ff
 		case 0x26: // ld iyh,n XXX this is supposed to be undocumented
-			z.iy = z.iy&0x00ff | uint16(z.bus.Read(z.pc+2))<<8
+			z.iy = z.iy&0x00ff | uint16(z.fetch(z.pc+2))<<8
 		case 0x29: // add iy,iy
 			z.iy = z.add16(z.iy, z.iy)
 		case 0x2a: // ld iy,(nn)
-			addr := uint16(z.bus.Read(z.pc+2)) |
-				uint16(z.bus.Read(z.pc+3))<<8
+			addr := uint16(z.fetch(z.pc+2)) |
+				uint16(z.fetch(z.pc+3))<<8
 			z.iy = uint16(z.bus.Read(addr)) |
 				uint16(z.bus.Read(addr+1))<<8
+			z.memptr = addr + 1
 		case 0x2b: // dec iy
 			z.iy -= 1
 		case 0x2c: // inc iyl XXX this is supposed to be undocumented
@@ -2248,73 +2755,70 @@ func (z *z80) step() error {
 		case 0x2d: // dec iyl XXX this is supposed to be undocumented
 			z.iy = uint16(z.dec(byte(z.iy))) | z.iy&0xff00
 		case 0x2e: // ld iyl,n XXX this is supposed to be undocumented
-			z.iy = z.iy&0xff00 | uint16(z.bus.Read(z.pc+2))
+			z.iy = z.iy&0xff00 | uint16(z.fetch(z.pc+2))
 		case 0x34: // inc (iy+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			x := z.inc(z.bus.Read(z.iy + displacement))
 			z.bus.Write(z.iy+displacement, x)
 		case 0x35: // dec (iy+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			x := z.dec(z.bus.Read(z.iy + displacement))
 			z.bus.Write(z.iy+displacement, x)
 		case 0x36: // ld (iy+d),n
-			val := z.bus.Read(z.pc + 3)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			val := z.fetch(z.pc + 3)
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bus.Write(z.iy+displacement, val)
 		case 0x39: // add iy,sp
 			z.iy = z.add16(z.iy, z.sp)
 		case 0x40, 0x41, 0x42, 0x43: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x44: // ld b,iyh
 			z.bc = z.bc&0x00ff | z.iy&0xff00
 		case 0x45: // ld b,iyl
 			z.bc = z.bc&0x00ff | z.iy<<8
 		case 0x46: // ld b,(iy+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bc = z.bc&0x00ff |
 				uint16(z.bus.Read(z.iy+displacement))<<8
 		case 0x47, 0x48, 0x49, 0x4a, 0x4b: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x4c: // ld c,iyh
 			z.bc = z.bc&0xff00 | z.iy>>8
 		case 0x4d: // ld c,iyl
 			z.bc = z.bc&0xff00 | z.iy&0x00ff
 		case 0x4e: // ld c,(iy+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bc = z.bc&0xff00 |
 				uint16(z.bus.Read(z.iy+displacement))
 		case 0x4f, 0x50, 0x51, 0x52, 0x53: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x54: // ld d,iyh
 			z.de = z.de&0x00ff | z.iy&0xff00
 		case 0x55: // ld d,iyl
 			z.de = z.de&0x00ff | z.iy<<8
 		case 0x56: // ld d,(iy+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.de = z.de&0x00ff |
 				uint16(z.bus.Read(z.iy+displacement))<<8
 		case 0x57, 0x58, 0x59, 0x5a, 0x5b: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x5c: // ld e,iyh
 			z.de = z.de&0xff00 | z.iy>>8
 		case 0x5d: // ld e,iyl
 			z.de = z.de&0xff00 | z.iy&0x00ff
 		case 0x5e: // ld e,(iy+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.de = z.de&0xff00 |
 				uint16(z.bus.Read(z.iy+displacement))
 		case 0x5f: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x60: // ld iyh,b
 			z.iy = z.iy&0x00ff | z.bc&0xff00
 		case 0x61: // ld iyh,c
@@ -2328,7 +2832,8 @@ func (z *z80) step() error {
 		case 0x65: // ld iyh,iyl
 			z.iy = z.iy&0x00ff | z.iy<<8
 		case 0x66: // ld h,(iy+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.hl = z.hl&0x00ff |
 				uint16(z.bus.Read(z.iy+displacement))<<8
 		case 0x67: // ld iyh,a
@@ -2346,107 +2851,118 @@ func (z *z80) step() error {
 		case 0x6d: // ld iyl,iyl
 			// nop
 		case 0x6e: // ld l,(iy+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.hl = z.hl&0xff00 |
 				uint16(z.bus.Read(z.iy+displacement))
 		case 0x6f: // ld iyl,a
 			z.iy = z.iy&0xff00 | z.af>>8
 		case 0x70: // ld (iy+d),b
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bus.Write(z.iy+displacement, byte(z.bc>>8))
 		case 0x71: // ld (iy+d),c
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bus.Write(z.iy+displacement, byte(z.bc))
 		case 0x72: // ld (iy+d),d
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bus.Write(z.iy+displacement, byte(z.de>>8))
 		case 0x73: // ld (iy+d),e
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bus.Write(z.iy+displacement, byte(z.de))
 		case 0x74: // ld (iy+d),h
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bus.Write(z.iy+displacement, byte(z.hl>>8))
 		case 0x75: // ld (iy+d),l
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bus.Write(z.iy+displacement, byte(z.hl))
-		case 0x76: // ld (iy+d),n
-			val := z.bus.Read(z.pc + 3)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
-			z.bus.Write(z.iy+displacement, val)
+		case 0x76: // noni, halt
+			return z.noni()
 		case 0x77: // ld (iy+d),a
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.bus.Write(z.iy+displacement, byte(z.af>>8))
 		case 0x78, 0x79, 0x7a, 0x7b: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x7c: // ld a,iyh
 			z.af = z.af&0x00ff | z.iy&0xff00
 		case 0x7d: // ld a,iyl
 			z.af = z.af&0x00ff | z.iy<<8
 		case 0x7e: // ld a,(iy+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.af = z.af&0x00ff |
 				uint16(z.bus.Read(z.iy+displacement))<<8
 		case 0x7f: // noni
-			z.pc += 1
-			z.totalCycles += 4 // XXX
-			return nil
+			return z.noni()
 		case 0x84: // add a,iyh XXX this is supposed to be undocumented
 			z.add(byte(z.iy >> 8))
 		case 0x85: // add a,iyl XXX this is supposed to be undocumented
 			z.add(byte(z.iy))
 		case 0x86: // add a,(iyl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.add(z.bus.Read(z.iy + displacement))
 		case 0x8c: // adc a,iyh XXX this is supposed to be undocumented
 			z.adc(byte(z.iy >> 8))
 		case 0x8d: // add a,iyl XXX this is supposed to be undocumented
 			z.adc(byte(z.iy))
 		case 0x8e: // adc a,(iyl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.adc(z.bus.Read(z.iy + displacement))
 		case 0x94: // sub a,iyh XXX this is supposed to be undocumented
 			z.sub(byte(z.iy >> 8))
 		case 0x95: // sub a,iyl XXX this is supposed to be undocumented
 			z.sub(byte(z.iy))
 		case 0x96: // sub a,(iyl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.sub(z.bus.Read(z.iy + displacement))
 		case 0x9c: // sbc a,iyh XXX this is supposed to be undocumented
 			z.sbc(byte(z.iy >> 8))
 		case 0x9d: // sbc a,iyl XXX this is supposed to be undocumented
 			z.sbc(byte(z.iy))
 		case 0x9e: // sbc a,(iyl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.sbc(z.bus.Read(z.iy + displacement))
 		case 0xa4: // and a,iyh XXX this is supposed to be undocumented
 			z.and(byte(z.iy >> 8))
 		case 0xa5: // and a,iyl XXX this is supposed to be undocumented
 			z.and(byte(z.iy))
 		case 0xa6: // and (iyl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.and(z.bus.Read(z.iy + displacement))
 		case 0xac: // xor a,iyh XXX this is supposed to be undocumented
 			z.xor(byte(z.iy >> 8))
 		case 0xad: // xor a,iyl XXX this is supposed to be undocumented
 			z.xor(byte(z.iy))
 		case 0xae: // xor (iyl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.xor(z.bus.Read(z.iy + displacement))
 		case 0xb4: // or a,iyh XXX this is supposed to be undocumented
 			z.or(byte(z.iy >> 8))
 		case 0xb5: // or a,iyl XXX this is supposed to be undocumented
 			z.or(byte(z.iy))
 		case 0xb6: // or (iyl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.or(z.bus.Read(z.iy + displacement))
 		case 0xbc: // cp a,iyh XXX this is supposed to be undocumented
 			z.cp(byte(z.iy >> 8))
 		case 0xbd: // cp a,iyl XXX this is supposed to be undocumented
 			z.cp(byte(z.iy))
 		case 0xbe: // cp (iyl+d)
-			displacement := uint16(int8(z.bus.Read(z.pc + 2)))
+			displacement := uint16(int8(z.fetch(z.pc + 2)))
+			z.memptr = z.iy + displacement
 			z.cp(z.bus.Read(z.iy + displacement))
 		case 0xcb: // bit b,(iy+d)
 			return z.fdcb()
@@ -2455,21 +2971,30 @@ func (z *z80) step() error {
 			z.sp++
 			z.iy = uint16(z.bus.Read(z.sp))<<8 | z.iy&0x00ff
 			z.sp++
+		case 0xe3: // ex (sp),iy
+			t := uint16(z.bus.Read(z.sp)) |
+				uint16(z.bus.Read(z.sp+1))<<8
+			z.bus.Write(z.sp, byte(z.iy))
+			z.bus.Write(z.sp+1, byte(z.iy>>8))
+			z.iy = t
+			z.memptr = t
 		case 0xe5: // push iy
 			z.sp--
 			z.bus.Write(z.sp, byte(z.iy>>8))
 			z.sp--
 			z.bus.Write(z.sp, byte(z.iy))
+		case 0xe9: // jp (iy)
+			z.pc = z.iy
+			z.totalCycles += opcodeStruct.noCycles
+			return nil
 		case 0xf9: // ld sp,iy
 			z.sp = z.iy
 		default:
-			return fmt.Errorf("invalid instruction: 0x%02x "+
-				"0x%02x @ 0x%04x", opc, z.bus.Read(z.pc+1),
-				z.pc)
-			//return ErrInvalidInstruction
+			// The prefix has no effect on the instruction.
+			return z.noni()
 		}
 	case 0xfe: // cp i
-		z.cp(z.bus.Read(z.pc + 1))
+		z.cp(z.fetch(z.pc + 1))
 	case 0xff: // rst $38
 		retPC := z.pc + opcodeStruct.noBytes
 		z.sp--
@@ -2478,24 +3003,20 @@ func (z *z80) step() error {
 		z.bus.Write(z.sp, byte(retPC))
 
 		z.pc = 0x38
+		z.memptr = z.pc
 
 		z.totalCycles += opcodeStruct.noCycles
 		return nil
 	default:
-		//fmt.Printf("opcode %x\n", opcode)
-		//return ErrInvalidInstruction
-		// XXX make this a generic ErrInvalidInstruction
-		return fmt.Errorf("invalid instruction: 0x%02x @ 0x%04x", opc, z.pc)
+		return z.invalidOpcode(1)
 	}
 
-	pi(opcodeStruct)
-
-	return nil
+	return pi(opcodeStruct)
 }
 
 // Disassemble disassembles the instruction at the provided address and also
 // returns the address and the number of bytes consumed.
-func (z *z80) Disassemble(address uint16, loud bool) (string, uint16, int, error) {
+func (z *CPU) Disassemble(address uint16, loud bool) (string, uint16, int, error) {
 	mn, dst, src, opc, noBytes, err := z.DisassembleComponents(address)
 
 	if dst != "" && src != "" {
@@ -2513,16 +3034,19 @@ func (z *z80) Disassemble(address uint16, loud bool) (string, uint16, int, error
 }
 
 // Disassemble disassembles the instruction at the current program counter.
-func (z *z80) DisassemblePC(loud bool) (string, uint16, int, error) {
+func (z *CPU) DisassemblePC(loud bool) (string, uint16, int, error) {
 	return z.Disassemble(z.pc, loud)
 }
 
 // DisassembleComponents disassmbles the instruction at the provided address
 // and returns all compnonts of the instruction (opcode, destination, source).
-func (z *z80) DisassembleComponents(address uint16) (mnemonic string, dst string, src string, opc string, noBytes int, retErr error) {
+func (z *CPU) DisassembleComponents(address uint16) (mnemonic string, dst string, src string, opc string, noBytes int, retErr error) {
 	p := make([]byte, 4)
 	p[0] = z.bus.Read(address)
 	o := &opcodes[p[0]]
+	if z.mode == Mode8080 {
+		o = &opcodes8080[p[0]]
+	}
 	start := uint16(1)
 	if o.multiByte {
 		p[1] = z.bus.Read(address + 1)
@@ -2548,7 +3072,7 @@ func (z *z80) DisassembleComponents(address uint16) (mnemonic string, dst string
 	case condition:
 		dst = o.dstR[z.mode]
 	case displacement:
-		dst = fmt.Sprintf("$%04x", address+2+uint16(int8(p[start])))
+		dst = z.addressName(address + 2 + uint16(int8(p[start])))
 	case registerIndirect:
 		if z.mode == Mode8080 {
 			dst = fmt.Sprintf("%v", o.dstR[z.mode])
@@ -2556,15 +3080,23 @@ func (z *z80) DisassembleComponents(address uint16) (mnemonic string, dst string
 			dst = fmt.Sprintf("(%v)", o.dstR[z.mode])
 		}
 	case extended:
-		dst = fmt.Sprintf("($%04x)", uint16(p[start])|uint16(p[start+1])<<8)
+		if z.mode == Mode8080 {
+			dst = z.addressName(uint16(p[start]) | uint16(p[start+1])<<8)
+		} else {
+			dst = "(" + z.addressName(uint16(p[start])|uint16(p[start+1])<<8) + ")"
+		}
 	case immediate:
 		dst = fmt.Sprintf("$%02x", p[start])
 	case immediateExtended:
-		dst = fmt.Sprintf("$%04x", uint16(p[start])|uint16(p[start+1])<<8)
+		dst = z.addressName(uint16(p[start]) | uint16(p[start+1])<<8)
 	case register:
 		dst = o.dstR[z.mode]
 	case indirect:
-		dst = fmt.Sprintf("($%02x)", p[start])
+		if z.mode == Mode8080 {
+			dst = fmt.Sprintf("$%02x", p[start])
+		} else {
+			dst = fmt.Sprintf("($%02x)", p[start])
+		}
 	case implied:
 		dst = o.dstR[z.mode]
 	case indexed:
@@ -2576,7 +3108,7 @@ func (z *z80) DisassembleComponents(address uint16) (mnemonic string, dst string
 
 	switch o.src {
 	case displacement:
-		src = fmt.Sprintf("$%04x", address+2+uint16(int8(p[start])))
+		src = z.addressName(address + 2 + uint16(int8(p[start])))
 	case registerIndirect:
 		if z.mode == Mode8080 {
 			src = fmt.Sprintf("%v", o.srcR[z.mode])
@@ -2584,7 +3116,11 @@ func (z *z80) DisassembleComponents(address uint16) (mnemonic string, dst string
 			src = fmt.Sprintf("(%v)", o.srcR[z.mode])
 		}
 	case extended:
-		src = fmt.Sprintf("($%04x)", uint16(p[start])|uint16(p[start+1])<<8)
+		if z.mode == Mode8080 {
+			src = z.addressName(uint16(p[start]) | uint16(p[start+1])<<8)
+		} else {
+			src = "(" + z.addressName(uint16(p[start])|uint16(p[start+1])<<8) + ")"
+		}
 	case immediate:
 		// XXX immediate is special with 4 byte opcodes
 		if o.noBytes == 4 {
@@ -2592,11 +3128,15 @@ func (z *z80) DisassembleComponents(address uint16) (mnemonic string, dst string
 		}
 		src = fmt.Sprintf("$%02x", p[start])
 	case immediateExtended:
-		src = fmt.Sprintf("$%04x", uint16(p[start])|uint16(p[start+1])<<8)
+		src = z.addressName(uint16(p[start]) | uint16(p[start+1])<<8)
 	case register:
 		src = o.srcR[z.mode]
 	case indirect:
-		src = fmt.Sprintf("($%02x)", p[start])
+		if z.mode == Mode8080 {
+			src = fmt.Sprintf("$%02x", p[start])
+		} else {
+			src = fmt.Sprintf("($%02x)", p[start])
+		}
 	case indexed:
 		src = fmt.Sprintf("(%v+$%02x)", o.srcR[z.mode], p[start])
 	}
@@ -2631,10 +3171,34 @@ func (z *z80) DisassembleComponents(address uint16) (mnemonic string, dst string
 		mnemonic = o.mnemonic[z.mode]
 	}
 
+	// dd cb and fd cb instructions are described by the 4th byte.
+	if o.dst == bitIndexed {
+		cb := &opcodesCB[p[3]]
+		index := fmt.Sprintf("(%v+$%02x)", o.srcR[z.mode], p[2])
+		mnemonic = cb.mnemonic[0]
+		switch p[3] >> 6 {
+		case 0x00: // rot[y] (IX+d)[,r[z]]
+			dst = index
+			src = ""
+			if p[3]&0x07 != 6 {
+				src = cb.dstR[0]
+			}
+		case 0x01: // bit y, (IX+d)
+			dst = cb.dstR[0]
+			src = index
+		default: // res/set y, (IX+d)[,r[z]]
+			dst = cb.dstR[0]
+			src = index
+			if p[3]&0x07 != 6 {
+				src += "," + cb.srcR[0]
+			}
+		}
+	}
+
 	return
 }
 
-func (z *z80) Trace() ([]string, []string, error) {
+func (z *CPU) Trace() ([]string, []string, error) {
 	trace := make([]string, 0, 1024)
 	registers := make([]string, 0, 1024)
 
@@ -1,11 +1,15 @@
 package z80
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/marcopeereboom/toyz80/bus"
+	_ "github.com/marcopeereboom/toyz80/device/dummy"
 )
 
 func TestInstructions(t *testing.T) {
@@ -15,8 +19,8 @@ func TestInstructions(t *testing.T) {
 		dst        string
 		src        string
 		data       []byte
-		init       func(z *z80)
-		expect     func(z *z80) bool
+		init       func(z *CPU)
+		expect     func(z *CPU) bool
 		err        error
 		dontSkipPC bool
 	}{
@@ -25,7 +29,7 @@ func TestInstructions(t *testing.T) {
 			name:   "nop",
 			mn:     "nop",
 			data:   []byte{0x00},
-			expect: func(z *z80) bool { return z.pc == 0x0001 },
+			expect: func(z *CPU) bool { return z.pc == 0x0001 },
 		},
 		// 0x01
 		{
@@ -34,7 +38,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "$55aa",
 			data: []byte{0x01, 0xaa, 0x55},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return 0x55aa == z.bc && z.pc == 0x0003
 			},
 		},
@@ -44,7 +48,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "$ffff",
 			data: []byte{0x01, 0xff, 0xff},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return 0xffff == z.bc && z.pc == 0x0003
 			},
 		},
@@ -56,10 +60,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(bc)",
 			src:  "a",
 			data: []byte{0x02},
-			init: func(z *z80) { z.af = 0xff00; z.bc = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00; z.bc = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.af == 0xff00 && z.pc == 0x0001 &&
-					z.bus.Read(0x1122) == 0xff
+					z.bus.Read(0x1122) == 0xff &&
+					z.memptr == 0xff23
 			},
 		},
 		// 0x03
@@ -69,8 +74,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "",
 			data: []byte{0x03},
-			init: func(z *z80) { z.bc = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x1123
 			},
 		},
@@ -80,8 +85,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "",
 			data: []byte{0x03},
-			init: func(z *z80) { z.bc = 0xffff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xffff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x0
 			},
 		},
@@ -91,8 +96,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "",
 			data: []byte{0x03},
-			init: func(z *z80) { z.bc = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x8000
 			},
 		},
@@ -103,8 +108,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "",
 			data: []byte{0x04},
-			init: func(z *z80) { z.bc = 0x11a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x11a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x12a5 &&
 					z.af&sign == 0 && z.af&zero == 0 &&
 					z.af&parity == 0 && z.af&halfCarry == 0 &&
@@ -117,8 +122,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "",
 			data: []byte{0x04},
-			init: func(z *z80) { z.bc = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x00a5 &&
 					z.af&sign == 0 && z.af&zero == zero &&
 					z.af&parity == 0 &&
@@ -132,8 +137,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "",
 			data: []byte{0x04},
-			init: func(z *z80) { z.bc = 0x7fa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x7fa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x80a5 &&
 					z.af&sign == sign && z.af&zero == 0 &&
 					z.af&parity == parity &&
@@ -148,8 +153,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "",
 			data: []byte{0x05},
-			init: func(z *z80) { z.af = 0x9988; z.bc = 0x80a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x9988; z.bc = 0x80a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x7fa5 &&
 					z.af&0xff00 == 0x9900 &&
 					z.af&sign == 0 &&
@@ -165,8 +170,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "",
 			data: []byte{0x05},
-			init: func(z *z80) { z.bc = 0x01a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x01a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x00a5 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -181,8 +186,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "",
 			data: []byte{0x05},
-			init: func(z *z80) { z.bc = 0x00a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x00a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0xffa5 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -196,8 +201,8 @@ func TestInstructions(t *testing.T) {
 			name: "rlca",
 			mn:   "rlca",
 			data: []byte{0x07},
-			init: func(z *z80) { z.af = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x4b00 &&
 					z.af&sign == sign &&
 					z.af&zero == zero &&
@@ -215,8 +220,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "$55",
 			data: []byte{0x06, 0x55},
-			init: func(z *z80) { z.bc = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.bc == 0x5522 && z.pc == 0x0002
 			},
 		},
@@ -226,8 +231,8 @@ func TestInstructions(t *testing.T) {
 			name: "rlca $a5",
 			mn:   "rlca",
 			data: []byte{0x07},
-			init: func(z *z80) { z.af = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x4b00 &&
 					z.af&sign == sign &&
@@ -243,8 +248,8 @@ func TestInstructions(t *testing.T) {
 			name: "rlca $80",
 			mn:   "rlca",
 			data: []byte{0x07},
-			init: func(z *z80) { z.af = 0x80ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x80ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0100 &&
 					z.af&sign == sign &&
@@ -260,8 +265,8 @@ func TestInstructions(t *testing.T) {
 			name: "rlca $ff",
 			mn:   "rlca",
 			data: []byte{0x07},
-			init: func(z *z80) { z.af = 0xffff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xffff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
@@ -277,8 +282,8 @@ func TestInstructions(t *testing.T) {
 			name: "rlca $7f",
 			mn:   "rlca",
 			data: []byte{0x07},
-			init: func(z *z80) { z.af = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0xfe00 &&
 					z.af&sign == sign &&
@@ -297,11 +302,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "af",
 			src:  "af'",
 			data: []byte{0x08},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x1122
 				z.af_ = 0x3344
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af == 0x3344 &&
 					z.af_ == 0x1122
 			},
@@ -313,9 +318,10 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "bc",
 			data: []byte{0x09},
-			init: func(z *z80) { z.bc = 0x1000; z.hl = 0x1000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x1000; z.hl = 0x1000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x2000 &&
+					z.memptr == 0x1001 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
 					z.af&halfCarry == 0 &&
@@ -330,8 +336,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "bc",
 			data: []byte{0x09},
-			init: func(z *z80) { z.bc = 0x7fff; z.hl = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x7fff; z.hl = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xfffe &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -349,11 +355,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "(bc)",
 			data: []byte{0x0a},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.bc = 0x1122
 				z.bus.Write(0x1122, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.af == 0xaa00 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xaa
 			},
@@ -365,8 +371,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "",
 			data: []byte{0x0b},
-			init: func(z *z80) { z.bc = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x1121
 			},
 		},
@@ -376,7 +382,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "",
 			data: []byte{0x0b},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0xffff
 			},
 		},
@@ -386,8 +392,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "",
 			data: []byte{0x0b},
-			init: func(z *z80) { z.bc = 0x8000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x8000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0x7fff
 			},
 		},
@@ -398,8 +404,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "",
 			data: []byte{0x0c},
-			init: func(z *z80) { z.bc = 0xa511 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xa511 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0xa512 &&
 					z.af&sign == 0 && z.af&zero == 0 &&
 					z.af&parity == 0 && z.af&halfCarry == 0 &&
@@ -412,8 +418,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "",
 			data: []byte{0x0c},
-			init: func(z *z80) { z.bc = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0xa500 &&
 					z.af&sign == 0 && z.af&zero == zero &&
 					z.af&parity == 0 &&
@@ -427,8 +433,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "",
 			data: []byte{0x0c},
-			init: func(z *z80) { z.bc = 0xa57f },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xa57f },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0xa580 &&
 					z.af&sign == sign && z.af&zero == 0 &&
 					z.af&parity == parity &&
@@ -443,8 +449,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "",
 			data: []byte{0x0d},
-			init: func(z *z80) { z.af = 0x9988; z.bc = 0xa580 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x9988; z.bc = 0xa580 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0xa57f &&
 					z.af&0xff00 == 0x9900 &&
 					z.af&sign == 0 &&
@@ -460,8 +466,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "",
 			data: []byte{0x0d},
-			init: func(z *z80) { z.bc = 0xa501 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xa501 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0xa500 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -476,8 +482,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "",
 			data: []byte{0x0d},
-			init: func(z *z80) { z.bc = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.bc == 0xa5ff &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -494,8 +500,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "$55",
 			data: []byte{0x0e, 0x55},
-			init: func(z *z80) { z.bc = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233 },
+			expect: func(z *CPU) bool {
 				return z.bc == 0x2255 && z.pc == 0x0002
 			},
 		},
@@ -505,8 +511,8 @@ func TestInstructions(t *testing.T) {
 			name: "rrca $a5",
 			mn:   "rrca",
 			data: []byte{0x0f},
-			init: func(z *z80) { z.af = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0xd200 &&
 					z.af&sign == sign &&
@@ -522,8 +528,8 @@ func TestInstructions(t *testing.T) {
 			name: "rrca $80",
 			mn:   "rrca",
 			data: []byte{0x0f},
-			init: func(z *z80) { z.af = 0x80ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x80ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x4000 &&
 					z.af&sign == sign &&
@@ -539,8 +545,8 @@ func TestInstructions(t *testing.T) {
 			name: "rrca $ff",
 			mn:   "rrca",
 			data: []byte{0x0f},
-			init: func(z *z80) { z.af = 0xffff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xffff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
@@ -556,8 +562,8 @@ func TestInstructions(t *testing.T) {
 			name: "rrca $7f",
 			mn:   "rrca",
 			data: []byte{0x0f},
-			init: func(z *z80) { z.af = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0xbf00 &&
 					z.af&sign == sign &&
@@ -575,8 +581,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "djnz",
 			dst:  "$0005",
 			data: []byte{0x10, 0x03},
-			init: func(z *z80) { z.bc = 0x0100 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x0100 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.bc == 0x0000
 			},
 		},
@@ -586,8 +592,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "djnz",
 			dst:  "$0005",
 			data: []byte{0x10, 0x03},
-			init: func(z *z80) { z.bc = 0x0200 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x0200 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0005 && z.bc == 0x0100
 			},
 			dontSkipPC: true,
@@ -600,7 +606,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "$55aa",
 			data: []byte{0x11, 0xaa, 0x55},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return 0x55aa == z.de && z.pc == 0x0003
 			},
 		},
@@ -612,8 +618,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "(de)",
 			src:  "a",
 			data: []byte{0x12},
-			init: func(z *z80) { z.af = 0xffee; z.de = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xffee; z.de = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.af == 0xffee && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xff
 			},
@@ -625,8 +631,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "",
 			data: []byte{0x13},
-			init: func(z *z80) { z.de = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x1123
 			},
 		},
@@ -636,8 +642,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "",
 			data: []byte{0x13},
-			init: func(z *z80) { z.de = 0xffff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0xffff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x0
 			},
 		},
@@ -647,8 +653,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "",
 			data: []byte{0x13},
-			init: func(z *z80) { z.de = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x8000
 			},
 		},
@@ -659,8 +665,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "",
 			data: []byte{0x14},
-			init: func(z *z80) { z.de = 0x11a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x11a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x12a5 &&
 					z.af&sign == 0 && z.af&zero == 0 &&
 					z.af&parity == 0 && z.af&halfCarry == 0 &&
@@ -673,8 +679,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "",
 			data: []byte{0x14},
-			init: func(z *z80) { z.de = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x00a5 &&
 					z.af&sign == 0 && z.af&zero == zero &&
 					z.af&parity == 0 &&
@@ -688,8 +694,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "",
 			data: []byte{0x14},
-			init: func(z *z80) { z.de = 0x7fa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x7fa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x80a5 &&
 					z.af&sign == sign && z.af&zero == 0 &&
 					z.af&parity == parity &&
@@ -704,8 +710,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "",
 			data: []byte{0x15},
-			init: func(z *z80) { z.af = 0x9988; z.de = 0x80a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x9988; z.de = 0x80a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x7fa5 &&
 					z.af&0xff00 == 0x9900 &&
 					z.af&sign == 0 &&
@@ -721,8 +727,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "",
 			data: []byte{0x15},
-			init: func(z *z80) { z.de = 0x01a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x01a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x00a5 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -737,8 +743,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "",
 			data: []byte{0x15},
-			init: func(z *z80) { z.de = 0x00a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x00a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0xffa5 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -755,8 +761,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "$55",
 			data: []byte{0x16, 0x55},
-			init: func(z *z80) { z.de = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233 },
+			expect: func(z *CPU) bool {
 				return z.de == 0x5533 && z.pc == 0x0002
 			},
 		},
@@ -766,7 +772,7 @@ func TestInstructions(t *testing.T) {
 			mn:   "jr",
 			dst:  "$0005",
 			data: []byte{0x18, 0x03},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0005
 			},
 			dontSkipPC: true,
@@ -776,7 +782,7 @@ func TestInstructions(t *testing.T) {
 			mn:   "jr",
 			dst:  "$ffff",
 			data: []byte{0x18, 0xfd},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0xffff
 			},
 			dontSkipPC: true,
@@ -788,8 +794,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "de",
 			data: []byte{0x19},
-			init: func(z *z80) { z.de = 0x1234; z.hl = 0x4321 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x1234; z.hl = 0x4321 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x5555 &&
 					z.de == 0x1234 &&
 					z.af&sign == 0 &&
@@ -806,8 +812,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "de",
 			data: []byte{0x19},
-			init: func(z *z80) { z.de = 0x7fff; z.hl = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x7fff; z.hl = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xfffe &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -825,11 +831,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "(de)",
 			data: []byte{0x1a},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.de = 0x1122
 				z.bus.Write(0x1122, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.af == 0xaa00 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xaa
 			},
@@ -841,8 +847,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "",
 			data: []byte{0x1b},
-			init: func(z *z80) { z.de = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x1121
 			},
 		},
@@ -852,7 +858,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "",
 			data: []byte{0x1b},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0xffff
 			},
 		},
@@ -862,8 +868,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "",
 			data: []byte{0x1b},
-			init: func(z *z80) { z.de = 0x8000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x8000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0x7fff
 			},
 		},
@@ -874,8 +880,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "",
 			data: []byte{0x1c},
-			init: func(z *z80) { z.de = 0xa511 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0xa511 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0xa512 &&
 					z.af&sign == 0 && z.af&zero == 0 &&
 					z.af&parity == 0 && z.af&halfCarry == 0 &&
@@ -888,8 +894,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "",
 			data: []byte{0x1c},
-			init: func(z *z80) { z.de = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0xa500 &&
 					z.af&sign == 0 && z.af&zero == zero &&
 					z.af&parity == 0 &&
@@ -903,8 +909,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "",
 			data: []byte{0x1c},
-			init: func(z *z80) { z.de = 0xa57f },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0xa57f },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0xa580 &&
 					z.af&sign == sign && z.af&zero == 0 &&
 					z.af&parity == parity &&
@@ -919,8 +925,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "",
 			data: []byte{0x1d},
-			init: func(z *z80) { z.af = 0x9988; z.de = 0xa580 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x9988; z.de = 0xa580 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0xa57f &&
 					z.af&0xff00 == 0x9900 &&
 					z.af&sign == 0 &&
@@ -936,8 +942,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "",
 			data: []byte{0x1d},
-			init: func(z *z80) { z.de = 0xa501 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0xa501 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0xa500 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -952,8 +958,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "",
 			data: []byte{0x1d},
-			init: func(z *z80) { z.de = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.de == 0xa5ff &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -970,8 +976,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "$55",
 			data: []byte{0x1e, 0x55},
-			init: func(z *z80) { z.de = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233 },
+			expect: func(z *CPU) bool {
 				return z.de == 0x2255 && z.pc == 0x0002
 			},
 		},
@@ -980,8 +986,8 @@ func TestInstructions(t *testing.T) {
 			name: "rra 01",
 			mn:   "rra",
 			data: []byte{0x1f},
-			init: func(z *z80) { z.af = 0x0100 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&carry == carry
@@ -991,8 +997,8 @@ func TestInstructions(t *testing.T) {
 			name: "rra 01 + Carry",
 			mn:   "rra",
 			data: []byte{0x1f},
-			init: func(z *z80) { z.af = 0x0100 | carry },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100 | carry },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&carry == carry
@@ -1006,7 +1012,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "$55aa",
 			data: []byte{0x21, 0xaa, 0x55},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return 0x55aa == z.hl && z.pc == 0x0003
 			},
 		},
@@ -1017,10 +1023,10 @@ func TestInstructions(t *testing.T) {
 			dst:  "($b229)",
 			src:  "hl",
 			data: []byte{0x22, 0x29, 0xb2},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x483a
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003 && z.hl == 0x483a &&
 					z.bus.Read(0xb229) == 0x3a &&
 					z.bus.Read(0xb22a) == 0x48
@@ -1033,8 +1039,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "",
 			data: []byte{0x23},
-			init: func(z *z80) { z.hl = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x1123
 			},
 		},
@@ -1044,8 +1050,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "",
 			data: []byte{0x23},
-			init: func(z *z80) { z.hl = 0xffff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0xffff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x0
 			},
 		},
@@ -1055,8 +1061,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "",
 			data: []byte{0x23},
-			init: func(z *z80) { z.hl = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x8000
 			},
 		},
@@ -1067,8 +1073,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "",
 			data: []byte{0x24},
-			init: func(z *z80) { z.hl = 0x11a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x11a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x12a5 &&
 					z.af&sign == 0 && z.af&zero == 0 &&
 					z.af&parity == 0 && z.af&halfCarry == 0 &&
@@ -1081,8 +1087,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "",
 			data: []byte{0x24},
-			init: func(z *z80) { z.hl = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x00a5 &&
 					z.af&sign == 0 && z.af&zero == zero &&
 					z.af&parity == 0 &&
@@ -1096,8 +1102,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "",
 			data: []byte{0x24},
-			init: func(z *z80) { z.hl = 0x7fa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x7fa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x80a5 &&
 					z.af&sign == sign && z.af&zero == 0 &&
 					z.af&parity == parity &&
@@ -1112,8 +1118,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "",
 			data: []byte{0x25},
-			init: func(z *z80) { z.af = 0x9988; z.hl = 0x80a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x9988; z.hl = 0x80a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x7fa5 &&
 					z.af&0xff00 == 0x9900 &&
 					z.af&sign == 0 &&
@@ -1129,8 +1135,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "",
 			data: []byte{0x25},
-			init: func(z *z80) { z.hl = 0x01a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x01a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x00a5 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -1145,8 +1151,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "",
 			data: []byte{0x25},
-			init: func(z *z80) { z.hl = 0x00a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x00a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xffa5 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -1163,8 +1169,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "$55",
 			data: []byte{0x26, 0x55},
-			init: func(z *z80) { z.hl = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233 },
+			expect: func(z *CPU) bool {
 				return z.hl == 0x5533 && z.pc == 0x0002
 			},
 		},
@@ -1175,8 +1181,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "z",
 			src:  "$ffff",
 			data: []byte{0x28, 0xfd},
-			init: func(z *z80) { z.af = zero },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = zero },
+			expect: func(z *CPU) bool {
 				return z.pc == 0xffff
 			},
 			dontSkipPC: true,
@@ -1187,8 +1193,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "z",
 			src:  "$0005",
 			data: []byte{0x28, 0x3},
-			init: func(z *z80) { z.af = zero },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = zero },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0005
 			},
 			dontSkipPC: true,
@@ -1199,7 +1205,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "z",
 			src:  "$ffff",
 			data: []byte{0x28, 0xfd},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002
 			},
 			dontSkipPC: true,
@@ -1210,7 +1216,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "z",
 			src:  "$0005",
 			data: []byte{0x28, 0x3},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002
 			},
 			dontSkipPC: true,
@@ -1222,8 +1228,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "hl",
 			data: []byte{0x29},
-			init: func(z *z80) { z.af = 0xffff; z.hl = 0x1000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xffff; z.hl = 0x1000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x2000 &&
 					z.af&sign == sign &&
 					z.af&zero == zero &&
@@ -1239,8 +1245,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "hl",
 			data: []byte{0x29},
-			init: func(z *z80) { z.af = 0xffff; z.hl = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xffff; z.hl = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xfffe &&
 					z.af&sign == sign &&
 					z.af&zero == zero &&
@@ -1257,12 +1263,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "($4545)",
 			data: []byte{0x2a, 0x45, 0x45},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0xa55a
 				z.bus.Write(0x4545, 0x37)
 				z.bus.Write(0x4546, 0xa1)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003 && z.hl == 0xa137
 			},
 		},
@@ -1273,8 +1279,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "",
 			data: []byte{0x2b},
-			init: func(z *z80) { z.hl = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x1121
 			},
 		},
@@ -1284,7 +1290,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "",
 			data: []byte{0x2b},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xffff
 			},
 		},
@@ -1294,8 +1300,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "",
 			data: []byte{0x2b},
-			init: func(z *z80) { z.hl = 0x8000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x8000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x7fff
 			},
 		},
@@ -1306,8 +1312,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "",
 			data: []byte{0x2c},
-			init: func(z *z80) { z.hl = 0xa511 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0xa511 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xa512 &&
 					z.af&sign == 0 && z.af&zero == 0 &&
 					z.af&parity == 0 && z.af&halfCarry == 0 &&
@@ -1320,8 +1326,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "",
 			data: []byte{0x2c},
-			init: func(z *z80) { z.hl = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xa500 &&
 					z.af&sign == 0 && z.af&zero == zero &&
 					z.af&parity == 0 &&
@@ -1335,8 +1341,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "",
 			data: []byte{0x2c},
-			init: func(z *z80) { z.hl = 0xa57f },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0xa57f },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xa580 &&
 					z.af&sign == sign && z.af&zero == 0 &&
 					z.af&parity == parity &&
@@ -1351,8 +1357,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "",
 			data: []byte{0x2d},
-			init: func(z *z80) { z.af = 0x9988; z.hl = 0xa580 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x9988; z.hl = 0xa580 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xa57f &&
 					z.af&0xff00 == 0x9900 &&
 					z.af&sign == 0 &&
@@ -1368,8 +1374,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "",
 			data: []byte{0x2d},
-			init: func(z *z80) { z.hl = 0xa501 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0xa501 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xa500 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -1384,8 +1390,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "",
 			data: []byte{0x2d},
-			init: func(z *z80) { z.hl = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xa5ff &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -1402,8 +1408,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "$55",
 			data: []byte{0x2e, 0x55},
-			init: func(z *z80) { z.hl = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233 },
+			expect: func(z *CPU) bool {
 				return z.hl == 0x2255 && z.pc == 0x0002
 			},
 		},
@@ -1413,8 +1419,8 @@ func TestInstructions(t *testing.T) {
 			name: "cpl",
 			mn:   "cpl",
 			data: []byte{0x2f},
-			init: func(z *z80) { z.af = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.af&0xffff == 0x5a1a && z.pc == 0x0001
 			},
 		},
@@ -1426,7 +1432,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "sp",
 			src:  "$55aa",
 			data: []byte{0x31, 0xaa, 0x55},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return 0x55aa == z.sp && z.pc == 0x0003
 			},
 		},
@@ -1438,11 +1444,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "($ffee)",
 			src:  "a",
 			data: []byte{0x32, 0xee, 0xff},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x1122
 				z.bus.Write(0xffee, 0x55)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.af == 0x1122 && z.pc == 0x0003 &&
 					z.bus.Read(0xffee) == 0x11
 			},
@@ -1454,8 +1460,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "sp",
 			src:  "",
 			data: []byte{0x33},
-			init: func(z *z80) { z.sp = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0x1123
 			},
 		},
@@ -1465,8 +1471,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "sp",
 			src:  "",
 			data: []byte{0x33},
-			init: func(z *z80) { z.sp = 0xffff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0xffff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0x0
 			},
 		},
@@ -1476,8 +1482,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "sp",
 			src:  "",
 			data: []byte{0x33},
-			init: func(z *z80) { z.sp = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0x8000
 			},
 		},
@@ -1488,11 +1494,11 @@ func TestInstructions(t *testing.T) {
 			mn:   "inc",
 			dst:  "(hl)",
 			data: []byte{0x34},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x11)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x12 &&
 					z.af&sign == 0 && z.af&zero == 0 &&
@@ -1505,11 +1511,11 @@ func TestInstructions(t *testing.T) {
 			mn:   "inc",
 			dst:  "(hl)",
 			data: []byte{0x34},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xff)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x00 &&
 					z.af&sign == 0 &&
@@ -1524,11 +1530,11 @@ func TestInstructions(t *testing.T) {
 			mn:   "inc",
 			dst:  "(hl)",
 			data: []byte{0x34},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x7f)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x80 &&
 					z.af&sign == sign &&
@@ -1545,11 +1551,11 @@ func TestInstructions(t *testing.T) {
 			mn:   "dec",
 			dst:  "(hl)",
 			data: []byte{0x35},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x11)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x10 &&
 					z.af&sign == 0 &&
@@ -1564,11 +1570,11 @@ func TestInstructions(t *testing.T) {
 			mn:   "dec",
 			dst:  "(hl)",
 			data: []byte{0x35},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x00)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xff &&
 					z.af&sign == sign &&
@@ -1583,11 +1589,11 @@ func TestInstructions(t *testing.T) {
 			mn:   "dec",
 			dst:  "(hl)",
 			data: []byte{0x35},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x80)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x7f &&
 					z.af&sign == 0 &&
@@ -1605,11 +1611,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(hl)",
 			src:  "$55",
 			data: []byte{0x36, 0x55},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(z.hl, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x1122 && z.pc == 0x0002 &&
 					z.bus.Read(0x1122) == 0x55
 			},
@@ -1620,8 +1626,8 @@ func TestInstructions(t *testing.T) {
 			name: "scf",
 			mn:   "scf",
 			data: []byte{0x37},
-			init: func(z *z80) { z.af = 0xff00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&halfCarry == 0 &&
 					z.af&addsub == 0 &&
@@ -1635,8 +1641,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "sp",
 			data: []byte{0x39},
-			init: func(z *z80) { z.sp = 0x1000; z.hl = 0x1000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0x1000; z.hl = 0x1000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -1652,8 +1658,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "sp",
 			data: []byte{0x39},
-			init: func(z *z80) { z.sp = 0x7fff; z.hl = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0x7fff; z.hl = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.hl == 0xfffe &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -1670,8 +1676,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "sp",
 			src:  "",
 			data: []byte{0x3b},
-			init: func(z *z80) { z.sp = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0x1121
 			},
 		},
@@ -1681,7 +1687,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "sp",
 			src:  "",
 			data: []byte{0x3b},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xffff
 			},
 		},
@@ -1691,8 +1697,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "sp",
 			src:  "",
 			data: []byte{0x3b},
-			init: func(z *z80) { z.sp = 0x8000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0x8000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0x7fff
 			},
 		},
@@ -1703,8 +1709,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "",
 			data: []byte{0x3c},
-			init: func(z *z80) { z.af = 0x11a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x11a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x1200 &&
 					z.af&sign == 0 &&
@@ -1720,8 +1726,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "",
 			data: []byte{0x3c},
-			init: func(z *z80) { z.af = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -1737,8 +1743,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "",
 			data: []byte{0x3c},
-			init: func(z *z80) { z.af = 0x7fa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x7fa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -1754,8 +1760,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "dec",
 			dst:  "a",
 			data: []byte{0x3d},
-			init: func(z *z80) { z.af = 0x80a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x80a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x7f00 &&
 					z.af&sign == 0 &&
@@ -1770,8 +1776,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "dec",
 			dst:  "a",
 			data: []byte{0x3d},
-			init: func(z *z80) { z.af = 0x01a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x01a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -1787,8 +1793,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "",
 			data: []byte{0x3d},
-			init: func(z *z80) { z.af = 0x00a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x00a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
@@ -1804,8 +1810,8 @@ func TestInstructions(t *testing.T) {
 			name: "ccf (0xff)",
 			mn:   "ccf",
 			data: []byte{0x3f},
-			init: func(z *z80) { z.af = 0x00ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x00ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&halfCarry == halfCarry &&
 					z.af&addsub == 0 &&
@@ -1817,7 +1823,7 @@ func TestInstructions(t *testing.T) {
 			name: "ccf (0x00)",
 			mn:   "ccf",
 			data: []byte{0x3f},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&halfCarry == 0 &&
 					z.af&addsub == 0 &&
@@ -1832,13 +1838,14 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "($55aa)",
 			data: []byte{0x3a, 0xaa, 0x55},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x3344
 				z.bus.Write(0x55aa, 0xff)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.bus.Read(0x55aa) == 0xff &&
-					z.af == 0xff44 && z.pc == 0x0003
+					z.af == 0xff44 && z.pc == 0x0003 &&
+					z.memptr == 0x55ab
 			},
 		},
 		// 0x3e
@@ -1849,8 +1856,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$55",
 			data: []byte{0x3e, 0x55},
-			init: func(z *z80) { z.af = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2233 },
+			expect: func(z *CPU) bool {
 				return z.af == 0x5533 && z.pc == 0x0002
 			},
 		},
@@ -1861,8 +1868,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "b",
 			data: []byte{0x40},
-			init: func(z *z80) { z.bc = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2233 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1873,8 +1880,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "c",
 			data: []byte{0x41},
-			init: func(z *z80) { z.bc = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x3333 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1885,8 +1892,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "d",
 			data: []byte{0x42},
-			init: func(z *z80) { z.bc = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x4433 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1897,8 +1904,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "e",
 			data: []byte{0x43},
-			init: func(z *z80) { z.bc = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x5533 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1909,8 +1916,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "h",
 			data: []byte{0x44},
-			init: func(z *z80) { z.bc = 0x2233; z.hl = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.hl = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x4433 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1921,8 +1928,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "l",
 			data: []byte{0x45},
-			init: func(z *z80) { z.bc = 0x2233; z.hl = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.hl = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x5533 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1934,12 +1941,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "(hl)",
 			data: []byte{0x46},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.bc = 0x3344
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.bc == 0xaa44 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xaa
 			},
@@ -1951,8 +1958,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "b",
 			src:  "a",
 			data: []byte{0x47},
-			init: func(z *z80) { z.af = 0x1144; z.bc = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1144; z.bc = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x1133 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1963,8 +1970,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "b",
 			data: []byte{0x48},
-			init: func(z *z80) { z.af = 0x1144; z.bc = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1144; z.bc = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2222 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1975,8 +1982,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "c",
 			data: []byte{0x49},
-			init: func(z *z80) { z.bc = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2233 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1987,8 +1994,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "d",
 			data: []byte{0x4a},
-			init: func(z *z80) { z.bc = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2244 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -1999,8 +2006,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "e",
 			data: []byte{0x4b},
-			init: func(z *z80) { z.bc = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2255 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -2011,8 +2018,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "h",
 			data: []byte{0x4c},
-			init: func(z *z80) { z.bc = 0x2233; z.hl = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.hl = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2244 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -2023,8 +2030,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "l",
 			data: []byte{0x4d},
-			init: func(z *z80) { z.bc = 0x2233; z.hl = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.hl = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2255 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -2036,12 +2043,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "(hl)",
 			data: []byte{0x4e},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.bc = 0x3344
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.bc == 0x33aa && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xaa
 			},
@@ -2053,8 +2060,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "a",
 			data: []byte{0x4f},
-			init: func(z *z80) { z.af = 0x1144; z.bc = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1144; z.bc = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2211 == z.bc && z.pc == 0x0001
 			},
 		},
@@ -2065,8 +2072,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "b",
 			data: []byte{0x50},
-			init: func(z *z80) { z.bc = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2255 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2077,8 +2084,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "c",
 			data: []byte{0x51},
-			init: func(z *z80) { z.bc = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x3355 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2089,8 +2096,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "d",
 			data: []byte{0x52},
-			init: func(z *z80) { z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x4455 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2101,8 +2108,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "e",
 			data: []byte{0x53},
-			init: func(z *z80) { z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x5555 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2113,8 +2120,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "h",
 			data: []byte{0x54},
-			init: func(z *z80) { z.de = 0x2233; z.hl = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233; z.hl = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x4433 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2125,8 +2132,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "l",
 			data: []byte{0x55},
-			init: func(z *z80) { z.de = 0x2233; z.hl = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233; z.hl = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x5533 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2138,12 +2145,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "(hl)",
 			data: []byte{0x56},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.de = 0x3344
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.de == 0xaa44 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xaa
 			},
@@ -2155,8 +2162,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "d",
 			src:  "a",
 			data: []byte{0x57},
-			init: func(z *z80) { z.de = 0x2233; z.af = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233; z.af = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x4433 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2167,8 +2174,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "b",
 			data: []byte{0x58},
-			init: func(z *z80) { z.de = 0x2233; z.bc = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233; z.bc = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2244 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2179,8 +2186,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "c",
 			data: []byte{0x59},
-			init: func(z *z80) { z.de = 0x2233; z.bc = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233; z.bc = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2255 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2191,8 +2198,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "d",
 			data: []byte{0x5a},
-			init: func(z *z80) { z.de = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2222 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2203,8 +2210,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "e",
 			data: []byte{0x5b},
-			init: func(z *z80) { z.de = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2233 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2215,8 +2222,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "h",
 			data: []byte{0x5c},
-			init: func(z *z80) { z.de = 0x2233; z.hl = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233; z.hl = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2244 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2227,8 +2234,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "l",
 			data: []byte{0x5d},
-			init: func(z *z80) { z.de = 0x2233; z.hl = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233; z.hl = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2255 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2240,12 +2247,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "(hl)",
 			data: []byte{0x5e},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.de = 0x3344
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.de == 0x33aa && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xaa
 			},
@@ -2257,8 +2264,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "e",
 			src:  "a",
 			data: []byte{0x5f},
-			init: func(z *z80) { z.de = 0x2233; z.af = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x2233; z.af = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2244 == z.de && z.pc == 0x0001
 			},
 		},
@@ -2269,8 +2276,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "b",
 			data: []byte{0x60},
-			init: func(z *z80) { z.hl = 0x2233; z.bc = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.bc = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x4433 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2281,8 +2288,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "c",
 			data: []byte{0x61},
-			init: func(z *z80) { z.hl = 0x2233; z.bc = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.bc = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x5533 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2293,8 +2300,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "d",
 			data: []byte{0x62},
-			init: func(z *z80) { z.hl = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x4433 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2305,8 +2312,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "e",
 			data: []byte{0x63},
-			init: func(z *z80) { z.hl = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x5533 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2317,8 +2324,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "h",
 			data: []byte{0x64},
-			init: func(z *z80) { z.hl = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2233 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2329,8 +2336,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "l",
 			data: []byte{0x65},
-			init: func(z *z80) { z.hl = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x3333 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2342,11 +2349,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "(hl)",
 			data: []byte{0x66},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0xaa22 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xaa
 			},
@@ -2358,8 +2365,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "h",
 			src:  "a",
 			data: []byte{0x67},
-			init: func(z *z80) { z.hl = 0x2233; z.af = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.af = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x4433 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2370,8 +2377,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "b",
 			data: []byte{0x68},
-			init: func(z *z80) { z.hl = 0x2233; z.bc = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.bc = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2244 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2382,8 +2389,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "c",
 			data: []byte{0x69},
-			init: func(z *z80) { z.hl = 0x2233; z.bc = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.bc = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2255 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2394,8 +2401,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "d",
 			data: []byte{0x6a},
-			init: func(z *z80) { z.hl = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2244 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2406,8 +2413,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "e",
 			data: []byte{0x6b},
-			init: func(z *z80) { z.hl = 0x2233; z.de = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.de = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2255 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2418,8 +2425,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "h",
 			data: []byte{0x6c},
-			init: func(z *z80) { z.hl = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2222 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2430,8 +2437,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "l",
 			data: []byte{0x6d},
-			init: func(z *z80) { z.hl = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2233 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2443,11 +2450,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "(hl)",
 			data: []byte{0x6e},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x11aa && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xaa
 			},
@@ -2459,8 +2466,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "l",
 			src:  "a",
 			data: []byte{0x6f},
-			init: func(z *z80) { z.hl = 0x2233; z.af = 0x4455 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x2233; z.af = 0x4455 },
+			expect: func(z *CPU) bool {
 				return 0x2244 == z.hl && z.pc == 0x0001
 			},
 		},
@@ -2472,11 +2479,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(hl)",
 			src:  "b",
 			data: []byte{0x70},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bc = 0x3344
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x1122 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x33
 			},
@@ -2489,11 +2496,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(hl)",
 			src:  "c",
 			data: []byte{0x71},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bc = 0x3344
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x1122 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x44
 			},
@@ -2506,11 +2513,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(hl)",
 			src:  "d",
 			data: []byte{0x72},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.de = 0x3344
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x1122 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x33
 			},
@@ -2523,11 +2530,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(hl)",
 			src:  "e",
 			data: []byte{0x73},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.de = 0x3344
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x1122 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x44
 			},
@@ -2540,10 +2547,10 @@ func TestInstructions(t *testing.T) {
 			dst:  "(hl)",
 			src:  "h",
 			data: []byte{0x74},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x1122 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x11
 			},
@@ -2556,10 +2563,10 @@ func TestInstructions(t *testing.T) {
 			dst:  "(hl)",
 			src:  "l",
 			data: []byte{0x75},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x1122 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x22
 			},
@@ -2573,7 +2580,7 @@ func TestInstructions(t *testing.T) {
 		//	dst:  "",
 		//	src:  "",
 		//	data: []byte{0x76},
-		//	expect: func(z *z80) bool {
+		//	expect: func(z *CPU) bool {
 		//		return z.pc == 0x0000
 		//	},
 		//	err:        ErrHalt,
@@ -2587,11 +2594,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(hl)",
 			src:  "a",
 			data: []byte{0x77},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.af = 0x3344
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x1122 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0x33
 			},
@@ -2603,8 +2610,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "b",
 			data: []byte{0x78},
-			init: func(z *z80) { z.af = 0x1100; z.bc = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1100; z.bc = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2200 == z.af && z.pc == 0x0001
 			},
 		},
@@ -2615,8 +2622,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "c",
 			data: []byte{0x79},
-			init: func(z *z80) { z.af = 0x1100; z.bc = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1100; z.bc = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x3300 == z.af && z.pc == 0x0001
 			},
 		},
@@ -2627,8 +2634,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "d",
 			data: []byte{0x7a},
-			init: func(z *z80) { z.af = 0x1100; z.de = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1100; z.de = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2200 == z.af && z.pc == 0x0001
 			},
 		},
@@ -2639,8 +2646,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "e",
 			data: []byte{0x7b},
-			init: func(z *z80) { z.af = 0x1100; z.de = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1100; z.de = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x3300 == z.af && z.pc == 0x0001
 			},
 		},
@@ -2651,8 +2658,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "h",
 			data: []byte{0x7c},
-			init: func(z *z80) { z.af = 0x1100; z.hl = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1100; z.hl = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x2200 == z.af && z.pc == 0x0001
 			},
 		},
@@ -2663,8 +2670,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "l",
 			data: []byte{0x7d},
-			init: func(z *z80) { z.af = 0x1100; z.hl = 0x2233 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1100; z.hl = 0x2233 },
+			expect: func(z *CPU) bool {
 				return 0x3300 == z.af && z.pc == 0x0001
 			},
 		},
@@ -2676,11 +2683,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "(hl)",
 			data: []byte{0x7e},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xaa)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.af == 0xaa00 && z.pc == 0x0001 &&
 					z.bus.Read(0x1122) == 0xaa
 			},
@@ -2692,8 +2699,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "a",
 			data: []byte{0x7f},
-			init: func(z *z80) { z.af = 0x11a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x11a5 },
+			expect: func(z *CPU) bool {
 				return 0x11a5 == z.af && z.pc == 0x0001
 			},
 		},
@@ -2704,8 +2711,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "b",
 			data: []byte{0x80},
-			init: func(z *z80) { z.af = 0x10ff; z.bc = 0x10a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff; z.bc = 0x10a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
@@ -2722,8 +2729,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "b",
 			data: []byte{0x80},
-			init: func(z *z80) { z.af = 0x0100; z.bc = 0x7fa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.bc = 0x7fa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -2740,8 +2747,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "b",
 			data: []byte{0x80},
-			init: func(z *z80) { z.af = 0x0100; z.bc = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.bc = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -2759,8 +2766,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "c",
 			data: []byte{0x81},
-			init: func(z *z80) { z.af = 0x10ff; z.bc = 0xa510 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff; z.bc = 0xa510 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
@@ -2777,8 +2784,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "c",
 			data: []byte{0x81},
-			init: func(z *z80) { z.af = 0x0100; z.bc = 0xa57f },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.bc = 0xa57f },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -2795,8 +2802,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "c",
 			data: []byte{0x81},
-			init: func(z *z80) { z.af = 0x0100; z.bc = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.bc = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -2814,8 +2821,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "d",
 			data: []byte{0x82},
-			init: func(z *z80) { z.af = 0x10ff; z.de = 0x10a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff; z.de = 0x10a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
@@ -2832,8 +2839,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "d",
 			data: []byte{0x82},
-			init: func(z *z80) { z.af = 0x0100; z.de = 0x7fa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.de = 0x7fa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -2850,8 +2857,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "d",
 			data: []byte{0x82},
-			init: func(z *z80) { z.af = 0x0100; z.de = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.de = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -2869,8 +2876,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "e",
 			data: []byte{0x83},
-			init: func(z *z80) { z.af = 0x10ff; z.de = 0xa510 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff; z.de = 0xa510 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
@@ -2887,8 +2894,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "e",
 			data: []byte{0x83},
-			init: func(z *z80) { z.af = 0x0100; z.de = 0xa57f },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.de = 0xa57f },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -2905,8 +2912,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "e",
 			data: []byte{0x83},
-			init: func(z *z80) { z.af = 0x0100; z.de = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.de = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -2924,8 +2931,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "h",
 			data: []byte{0x84},
-			init: func(z *z80) { z.af = 0x10ff; z.hl = 0x10a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff; z.hl = 0x10a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
@@ -2942,8 +2949,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "h",
 			data: []byte{0x84},
-			init: func(z *z80) { z.af = 0x0100; z.hl = 0x7fa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.hl = 0x7fa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -2960,8 +2967,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "h",
 			data: []byte{0x84},
-			init: func(z *z80) { z.af = 0x0100; z.hl = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.hl = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -2979,8 +2986,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "l",
 			data: []byte{0x85},
-			init: func(z *z80) { z.af = 0x10ff; z.hl = 0xa510 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff; z.hl = 0xa510 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
@@ -2997,8 +3004,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "l",
 			data: []byte{0x85},
-			init: func(z *z80) { z.af = 0x0100; z.hl = 0xa57f },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.hl = 0xa57f },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -3015,8 +3022,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "l",
 			data: []byte{0x85},
-			init: func(z *z80) { z.af = 0x0100; z.hl = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.hl = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -3034,12 +3041,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "(hl)",
 			data: []byte{0x86},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x10ff
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x10)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
@@ -3056,12 +3063,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "(hl)",
 			data: []byte{0x86},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x01ff
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x7f)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -3078,12 +3085,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "(hl)",
 			data: []byte{0x86},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x01ff
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xff)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -3101,8 +3108,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "a",
 			data: []byte{0x87},
-			init: func(z *z80) { z.af = 0x10ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
@@ -3119,8 +3126,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "a",
 			data: []byte{0x87},
-			init: func(z *z80) { z.af = 0x7f00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x7f00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0xfe00 &&
 					z.af&sign == sign &&
@@ -3137,8 +3144,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "a",
 			data: []byte{0x87},
-			init: func(z *z80) { z.af = 0x4000; z.hl = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x4000; z.hl = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -3156,8 +3163,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "b",
 			data: []byte{0x88},
-			init: func(z *z80) { z.af = 0x10ff; z.bc = 0x10a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff; z.bc = 0x10a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2100 &&
 					z.af&sign == 0 &&
@@ -3174,8 +3181,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "b",
 			data: []byte{0x88},
-			init: func(z *z80) { z.af = 0x0100; z.bc = 0x7fa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.bc = 0x7fa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -3192,8 +3199,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "b",
 			data: []byte{0x88},
-			init: func(z *z80) { z.af = 0x0100; z.bc = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.bc = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -3211,8 +3218,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "c",
 			data: []byte{0x89},
-			init: func(z *z80) { z.af = 0x10ff; z.bc = 0xa510 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff; z.bc = 0xa510 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2100 &&
 					z.af&sign == 0 &&
@@ -3229,8 +3236,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "c",
 			data: []byte{0x89},
-			init: func(z *z80) { z.af = 0x0100; z.bc = 0xa57f },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.bc = 0xa57f },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -3247,8 +3254,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "c",
 			data: []byte{0x89},
-			init: func(z *z80) { z.af = 0x0100; z.bc = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.bc = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -3266,8 +3273,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "d",
 			data: []byte{0x8a},
-			init: func(z *z80) { z.af = 0x10ff; z.de = 0x10a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff; z.de = 0x10a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x2100 &&
 					z.af&sign == 0 &&
@@ -3284,8 +3291,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "d",
 			data: []byte{0x8a},
-			init: func(z *z80) { z.af = 0x0100; z.de = 0x7fa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.de = 0x7fa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -3302,8 +3309,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "d",
 			data: []byte{0x8a},
-			init: func(z *z80) { z.af = 0x0100; z.de = 0xffa5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100; z.de = 0xffa5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -3321,8 +3328,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "a",
 			data: []byte{0xa7},
-			init: func(z *z80) { z.af = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xa500 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3338,8 +3345,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "a",
 			data: []byte{0xa7},
-			init: func(z *z80) { z.af = 0x0000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3355,8 +3362,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "a",
 			data: []byte{0xa7},
-			init: func(z *z80) { z.af = 0xaf00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xaf00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xaf00 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3372,8 +3379,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "b",
 			data: []byte{0xb0},
-			init: func(z *z80) { z.af = 0xa500; z.bc = 0xf0a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500; z.bc = 0xf0a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xf500 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3388,8 +3395,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "b",
 			data: []byte{0x0b0},
-			init: func(z *z80) { z.af = 0xa500; z.bc = 0x00a5 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500; z.bc = 0x00a5 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xa500 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3404,8 +3411,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "b",
 			data: []byte{0xb0},
-			init: func(z *z80) { z.af = 0x0000; z.bc = 0x005a },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0000; z.bc = 0x005a },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3420,8 +3427,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "b",
 			data: []byte{0xb0},
-			init: func(z *z80) { z.af = 0x8500; z.bc = 0x7f5a },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x8500; z.bc = 0x7f5a },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3437,8 +3444,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "c",
 			data: []byte{0xb1},
-			init: func(z *z80) { z.af = 0xa500; z.bc = 0xa5f0 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500; z.bc = 0xa5f0 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xf500 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3453,8 +3460,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "c",
 			data: []byte{0x0b1},
-			init: func(z *z80) { z.af = 0xa500; z.bc = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500; z.bc = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xa500 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3469,8 +3476,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "c",
 			data: []byte{0xb1},
-			init: func(z *z80) { z.af = 0x0000; z.bc = 0x5a00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0000; z.bc = 0x5a00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3485,8 +3492,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "c",
 			data: []byte{0xb1},
-			init: func(z *z80) { z.af = 0x8500; z.bc = 0x5a7f },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x8500; z.bc = 0x5a7f },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3502,8 +3509,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "d",
 			data: []byte{0xb2},
-			init: func(z *z80) { z.af = 0xa5ff; z.de = 0x5aff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa5ff; z.de = 0x5aff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3519,8 +3526,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "e",
 			data: []byte{0xb3},
-			init: func(z *z80) { z.af = 0xa5ff; z.de = 0xff5a },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa5ff; z.de = 0xff5a },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3536,8 +3543,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "h",
 			data: []byte{0xb4},
-			init: func(z *z80) { z.af = 0xa5ff; z.hl = 0x5aff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa5ff; z.hl = 0x5aff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3553,8 +3560,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "l",
 			data: []byte{0xb5},
-			init: func(z *z80) { z.af = 0xa5ff; z.hl = 0xff5a },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa5ff; z.hl = 0xff5a },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3570,12 +3577,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "(hl)",
 			data: []byte{0xb6},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0xa5ff
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x5a)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3591,8 +3598,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "a",
 			data: []byte{0xb7},
-			init: func(z *z80) { z.af = 0xa5ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa5ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xa500 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3608,8 +3615,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "b",
 			data: []byte{0xb8},
-			init: func(z *z80) { z.af = 0x1000; z.bc = 0x2000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1000; z.bc = 0x2000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x1000 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3624,8 +3631,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "b",
 			data: []byte{0xb8},
-			init: func(z *z80) { z.af = 0x2000; z.bc = 0x1000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.bc = 0x1000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -3640,8 +3647,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "b",
 			data: []byte{0xb8},
-			init: func(z *z80) { z.af = 0x2000; z.bc = 0x2000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.bc = 0x2000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3657,8 +3664,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "c",
 			data: []byte{0xb9},
-			init: func(z *z80) { z.af = 0x1000; z.bc = 0x0020 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1000; z.bc = 0x0020 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x1000 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3673,8 +3680,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "c",
 			data: []byte{0xb9},
-			init: func(z *z80) { z.af = 0x2000; z.bc = 0x0010 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.bc = 0x0010 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -3689,8 +3696,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "c",
 			data: []byte{0xb9},
-			init: func(z *z80) { z.af = 0x2000; z.bc = 0x0020 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.bc = 0x0020 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3706,8 +3713,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "d",
 			data: []byte{0xba},
-			init: func(z *z80) { z.af = 0x1000; z.de = 0x2000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1000; z.de = 0x2000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x1000 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3722,8 +3729,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "d",
 			data: []byte{0xba},
-			init: func(z *z80) { z.af = 0x2000; z.de = 0x1000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.de = 0x1000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -3738,8 +3745,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "d",
 			data: []byte{0xba},
-			init: func(z *z80) { z.af = 0x2000; z.de = 0x2000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.de = 0x2000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3755,8 +3762,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "e",
 			data: []byte{0xbb},
-			init: func(z *z80) { z.af = 0x1000; z.de = 0x0020 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1000; z.de = 0x0020 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x1000 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3771,8 +3778,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "e",
 			data: []byte{0xbb},
-			init: func(z *z80) { z.af = 0x2000; z.de = 0x0010 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.de = 0x0010 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -3787,8 +3794,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "e",
 			data: []byte{0xbb},
-			init: func(z *z80) { z.af = 0x2000; z.de = 0x0020 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.de = 0x0020 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3804,8 +3811,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "h",
 			data: []byte{0xbc},
-			init: func(z *z80) { z.af = 0x1000; z.hl = 0x2000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1000; z.hl = 0x2000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x1000 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3820,8 +3827,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "h",
 			data: []byte{0xbc},
-			init: func(z *z80) { z.af = 0x2000; z.hl = 0x1000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.hl = 0x1000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -3836,8 +3843,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "h",
 			data: []byte{0xbc},
-			init: func(z *z80) { z.af = 0x2000; z.hl = 0x2000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.hl = 0x2000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3853,8 +3860,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "l",
 			data: []byte{0xbd},
-			init: func(z *z80) { z.af = 0x1000; z.hl = 0x0020 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1000; z.hl = 0x0020 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x1000 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3869,8 +3876,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "l",
 			data: []byte{0xbd},
-			init: func(z *z80) { z.af = 0x2000; z.hl = 0x10 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.hl = 0x10 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -3885,8 +3892,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "l",
 			data: []byte{0xbd},
-			init: func(z *z80) { z.af = 0x2000; z.hl = 0x0020 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000; z.hl = 0x0020 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3902,12 +3909,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "(hl)",
 			data: []byte{0xbe},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x1000
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x20)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x1000 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -3922,12 +3929,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "(hl)",
 			data: []byte{0xbe},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x2000
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x10)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -3942,12 +3949,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "(hl)",
 			data: []byte{0xbe},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x2000
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x20)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3963,8 +3970,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "a",
 			data: []byte{0xbf},
-			init: func(z *z80) { z.af = 0xaf00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xaf00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.af&0xff00 == 0xaf00 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -3980,12 +3987,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "ret",
 			dst:  "nz",
 			data: []byte{0xc0},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0xeeff && z.sp == 0xaa57
 			},
 			dontSkipPC: true,
@@ -3995,13 +4002,13 @@ func TestInstructions(t *testing.T) {
 			mn:   "ret",
 			dst:  "nz",
 			data: []byte{0xc0},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af |= zero
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa55
 			},
 			dontSkipPC: true,
@@ -4013,13 +4020,13 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "",
 			data: []byte{0xc1},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.bc = 0x1122
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa57 &&
 					z.bc == 0xeeff
 			},
@@ -4031,9 +4038,9 @@ func TestInstructions(t *testing.T) {
 			dst:  "nz",
 			src:  "$1122",
 			data: []byte{0xc2, 0x22, 0x11},
-			init: func(z *z80) { z.af = 0xff00 | zero },
-			expect: func(z *z80) bool {
-				return z.pc == 0x0003
+			init: func(z *CPU) { z.af = 0xff00 | zero },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0003 && z.memptr == 0x1122
 			},
 			dontSkipPC: true,
 		},
@@ -4043,7 +4050,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "nz",
 			src:  "$1122",
 			data: []byte{0xc2, 0x22, 0x11},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
@@ -4055,7 +4062,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "$1122",
 			src:  "",
 			data: []byte{0xc3, 0x22, 0x11},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
@@ -4067,8 +4074,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "nz",
 			src:  "$1122",
 			data: []byte{0xc4, 0x22, 0x11},
-			init: func(z *z80) { z.af = zero; z.sp = 0x5566 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = zero; z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003 && z.sp == 0x5566
 			},
 			dontSkipPC: true,
@@ -4079,8 +4086,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "nz",
 			src:  "$1122",
 			data: []byte{0xc4, 0x22, 0x11},
-			init: func(z *z80) { z.sp = 0x5566 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x03 &&
 					z.bus.Read(0x5565) == 0x00
@@ -4094,8 +4101,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "bc",
 			src:  "",
 			data: []byte{0xc5},
-			init: func(z *z80) { z.bc = 0x1122; z.sp = 0xaa55 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0x1122; z.sp = 0xaa55 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa53 &&
 					z.bus.Read(0xaa53) == 0x22 &&
 					z.bus.Read(0xaa54) == 0x11
@@ -4108,8 +4115,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$10",
 			data: []byte{0xc6, 0x10},
-			init: func(z *z80) { z.af = 0x10ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
@@ -4126,8 +4133,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$7f",
 			data: []byte{0xc6, 0x7f},
-			init: func(z *z80) { z.af = 0x0100 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -4144,8 +4151,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$ff",
 			data: []byte{0xc6, 0xff},
-			init: func(z *z80) { z.af = 0x0100 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -4162,12 +4169,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "rst",
 			dst:  "$00",
 			data: []byte{0xc7},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0x5566
 				z.bus.Write(0x5564, 0x64)
 				z.bus.Write(0x5565, 0x65)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0000 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x01 &&
 					z.bus.Read(0x5565) == 0x00
@@ -4180,13 +4187,13 @@ func TestInstructions(t *testing.T) {
 			mn:   "ret",
 			dst:  "z",
 			data: []byte{0xc8},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af |= zero
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0xeeff && z.sp == 0xaa57
 			},
 			dontSkipPC: true,
@@ -4196,12 +4203,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "ret",
 			dst:  "z",
 			data: []byte{0xc8},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa55
 			},
 			dontSkipPC: true,
@@ -4211,12 +4218,12 @@ func TestInstructions(t *testing.T) {
 			name: "ret",
 			mn:   "ret",
 			data: []byte{0xc9},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0xeeff && z.sp == 0xaa57
 			},
 			dontSkipPC: true,
@@ -4228,8 +4235,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "z",
 			src:  "$1122",
 			data: []byte{0xca, 0x22, 0x11},
-			init: func(z *z80) { z.af = 0xff00 | zero },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 | zero },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
@@ -4240,7 +4247,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "z",
 			src:  "$1122",
 			data: []byte{0xca, 0x22, 0x11},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003
 			},
 			dontSkipPC: true,
@@ -4251,8 +4258,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "sla",
 			dst:  "a",
 			data: []byte{0xcb, 0x27},
-			init: func(z *z80) { z.af = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x2200 &&
 					z.af&sign == 0 &&
@@ -4268,8 +4275,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "sla",
 			dst:  "a",
 			data: []byte{0xcb, 0x27},
-			init: func(z *z80) { z.af = 0x8022 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x8022 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -4285,8 +4292,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "sla",
 			dst:  "a",
 			data: []byte{0xcb, 0x27},
-			init: func(z *z80) { z.af = 0xff22 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff22 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0xfe00 &&
 					z.af&sign == sign &&
@@ -4303,8 +4310,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "srl",
 			dst:  "a",
 			data: []byte{0xcb, 0x3f},
-			init: func(z *z80) { z.af = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x0800 &&
 					z.af&sign == 0 &&
@@ -4320,8 +4327,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "srl",
 			dst:  "a",
 			data: []byte{0xcb, 0x3f},
-			init: func(z *z80) { z.af = 0x8022 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x8022 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x4000 &&
 					z.af&sign == 0 &&
@@ -4337,8 +4344,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "srl",
 			dst:  "a",
 			data: []byte{0xcb, 0x3f},
-			init: func(z *z80) { z.af = 0xff22 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff22 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x7f00 &&
 					z.af&sign == 0 &&
@@ -4356,8 +4363,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "0",
 			src:  "b",
 			data: []byte{0xcb, 0x40},
-			init: func(z *z80) { z.bc = 0xff00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xff00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.bc&0xff00 == 0xff00 &&
 					z.af&sign == 0 &&
@@ -4374,8 +4381,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "0",
 			src:  "b",
 			data: []byte{0xcb, 0x40},
-			init: func(z *z80) { z.bc = 0xfe00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xfe00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.bc&0xff00 == 0xfe00 &&
 					z.af&sign == 0 &&
@@ -4393,11 +4400,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "7",
 			src:  "(hl)",
 			data: []byte{0xcb, 0x7e},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0xff)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -4413,11 +4420,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "7",
 			src:  "(hl)",
 			data: []byte{0xcb, 0x7e},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.bus.Write(0x1122, 0x7f)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -4434,8 +4441,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "0",
 			src:  "b",
 			data: []byte{0xcb, 0x80},
-			init: func(z *z80) { z.bc = 0xff00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xff00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.bc&0xff00 == 0xfe00
 			},
@@ -4446,12 +4453,28 @@ func TestInstructions(t *testing.T) {
 			dst:  "0",
 			src:  "b",
 			data: []byte{0xcb, 0x40},
-			init: func(z *z80) { z.bc = 0xfe00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.bc = 0xfe00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.bc&0xff00 == 0xfe00
 			},
 		},
+		{
+			name: "bit 0,(hl) memptr",
+			mn:   "bit",
+			dst:  "0",
+			src:  "(hl)",
+			data: []byte{0xcb, 0x46},
+			init: func(z *CPU) {
+				z.hl = 0x1000
+				z.memptr = 0x2800
+				z.bus.Write(0x1000, 0xff)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 &&
+					z.af&0x00ff == uint16(FLAG_H|FLAG_5|FLAG_3)
+			},
+		},
 		// 0xcb 0xbf
 		{
 			name: "res 7,a (bit 7 SET)",
@@ -4459,8 +4482,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "7",
 			src:  "a",
 			data: []byte{0xcb, 0xbf},
-			init: func(z *z80) { z.af = 0xff00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x7f00
 			},
@@ -4471,8 +4494,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "7",
 			src:  "a",
 			data: []byte{0xcb, 0xbf},
-			init: func(z *z80) { z.af = 0x7f00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x7f00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x7f00
 			},
@@ -4484,8 +4507,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "$1122",
 			src:  "",
 			data: []byte{0xcd, 0x22, 0x11},
-			init: func(z *z80) { z.sp = 0x5566 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x03 &&
 					z.bus.Read(0x5565) == 0x00
@@ -4499,8 +4522,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$10",
 			data: []byte{0xce, 0x10},
-			init: func(z *z80) { z.af = 0x10ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x2100 &&
 					z.af&sign == 0 &&
@@ -4517,8 +4540,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$7f",
 			data: []byte{0xce, 0x7f},
-			init: func(z *z80) { z.af = 0x0100 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x8000 &&
 					z.af&sign == sign &&
@@ -4535,8 +4558,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$ff",
 			data: []byte{0xce, 0xff},
-			init: func(z *z80) { z.af = 0x0000 | carry },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0000 | carry },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -4553,12 +4576,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "rst",
 			dst:  "$08",
 			data: []byte{0xcf},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0x5566
 				z.bus.Write(0x5564, 0x64)
 				z.bus.Write(0x5565, 0x65)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0008 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x01 &&
 					z.bus.Read(0x5565) == 0x00
@@ -4571,12 +4594,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "ret",
 			dst:  "nc",
 			data: []byte{0xd0},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0xeeff && z.sp == 0xaa57
 			},
 			dontSkipPC: true,
@@ -4586,13 +4609,13 @@ func TestInstructions(t *testing.T) {
 			mn:   "ret",
 			dst:  "nc",
 			data: []byte{0xd0},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af |= carry
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa55
 			},
 			dontSkipPC: true,
@@ -4604,13 +4627,13 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "",
 			data: []byte{0xd1},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.de = 0x1122
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa57 &&
 					z.de == 0xeeff
 			},
@@ -4622,8 +4645,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "nc",
 			src:  "$1122",
 			data: []byte{0xd2, 0x22, 0x11},
-			init: func(z *z80) { z.af = 0xff00 | carry },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 | carry },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003
 			},
 			dontSkipPC: true,
@@ -4634,7 +4657,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "nc",
 			src:  "$1122",
 			data: []byte{0xd2, 0x22, 0x11},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
@@ -4646,11 +4669,38 @@ func TestInstructions(t *testing.T) {
 			dst:  "($aa)",
 			src:  "a",
 			data: []byte{0xd3, 0xaa},
-			init: func(z *z80) { z.af = 0xff00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002
 			},
 		},
+		// 0xd4
+		{
+			name: "call nc,nn (C clear)",
+			mn:   "call",
+			dst:  "nc",
+			src:  "$1122",
+			data: []byte{0xd4, 0x22, 0x11},
+			init: func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x1122 && z.sp == 0x5564 &&
+					z.bus.Read(0x5564) == 0x03 &&
+					z.bus.Read(0x5565) == 0x00
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "call nc,nn (C set)",
+			mn:   "call",
+			dst:  "nc",
+			src:  "$1122",
+			data: []byte{0xd4, 0x22, 0x11},
+			init: func(z *CPU) { z.af = carry; z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0003 && z.sp == 0x5566
+			},
+			dontSkipPC: true,
+		},
 		// 0xd5
 		{
 			name: "push de",
@@ -4658,8 +4708,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "",
 			data: []byte{0xd5},
-			init: func(z *z80) { z.de = 0x1122; z.sp = 0xaa55 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x1122; z.sp = 0xaa55 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa53 &&
 					z.bus.Read(0xaa53) == 0x22 &&
 					z.bus.Read(0xaa54) == 0x11
@@ -4671,8 +4721,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "sub",
 			dst:  "$10",
 			data: []byte{0xd6, 0x10},
-			init: func(z *z80) { z.af = 0x10ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
@@ -4689,12 +4739,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "rst",
 			dst:  "$10",
 			data: []byte{0xd7},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0x5566
 				z.bus.Write(0x5564, 0x64)
 				z.bus.Write(0x5565, 0x65)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0010 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x01 &&
 					z.bus.Read(0x5565) == 0x00
@@ -4707,13 +4757,13 @@ func TestInstructions(t *testing.T) {
 			mn:   "ret",
 			dst:  "c",
 			data: []byte{0xd8},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af |= carry
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0xeeff && z.sp == 0xaa57
 			},
 			dontSkipPC: true,
@@ -4723,16 +4773,32 @@ func TestInstructions(t *testing.T) {
 			mn:   "ret",
 			dst:  "c",
 			data: []byte{0xd8},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa55
 			},
 			dontSkipPC: true,
 		},
+		// 0xd9
+		{
+			name: "exx",
+			mn:   "exx",
+			data: []byte{0xd9},
+			init: func(z *CPU) {
+				z.bc, z.de, z.hl = 0x1111, 0x2222, 0x3333
+				z.bc_, z.de_, z.hl_ = 0x4444, 0x5555, 0x6666
+			},
+			expect: func(z *CPU) bool {
+				return z.bc == 0x4444 && z.de == 0x5555 &&
+					z.hl == 0x6666 && z.bc_ == 0x1111 &&
+					z.de_ == 0x2222 && z.hl_ == 0x3333 &&
+					z.pc == 0x0001
+			},
+		},
 		// 0xda
 		{
 			name: "jp c,nn (C set)",
@@ -4740,8 +4806,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "$1122",
 			data: []byte{0xda, 0x22, 0x11},
-			init: func(z *z80) { z.af = 0xff00 | carry },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 | carry },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
@@ -4752,7 +4818,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "$1122",
 			data: []byte{0xda, 0x22, 0x11},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003
 			},
 			dontSkipPC: true,
@@ -4764,8 +4830,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "$1122",
 			data: []byte{0xdc, 0x22, 0x11},
-			init: func(z *z80) { z.af = carry; z.sp = 0x5566 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = carry; z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x03 &&
 					z.bus.Read(0x5565) == 0x00
@@ -4778,8 +4844,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "c",
 			src:  "$1122",
 			data: []byte{0xdc, 0x22, 0x11},
-			init: func(z *z80) { z.sp = 0x5566 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003 && z.sp == 0x5566
 			},
 			dontSkipPC: true,
@@ -4791,9 +4857,9 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "($aa)",
 			data: []byte{0xdb, 0xaa},
-			init: func(z *z80) { z.af = 0xff00 },
-			expect: func(z *z80) bool {
-				return z.pc == 0x0002
+			init: func(z *CPU) { z.af = 0xff00 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.memptr == 0xffab
 			},
 		},
 		// 0xdd 0x09 add ix,bc
@@ -4803,8 +4869,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "bc",
 			data: []byte{0xdd, 0x09},
-			init: func(z *z80) { z.ix = 0x3344; z.bc = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.ix = 0x3344; z.bc = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.ix == 0x4466 &&
 					z.af&sign == 0 &&
@@ -4821,8 +4887,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "bc",
 			data: []byte{0xdd, 0x09},
-			init: func(z *z80) { z.ix = 0xffff; z.bc = 0x0001 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.ix = 0xffff; z.bc = 0x0001 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.ix == 0x0000 &&
 					z.af&sign == 0 &&
@@ -4839,12 +4905,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "bc",
 			data: []byte{0xdd, 0x09},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.ix = 0xffff
 				z.bc = 0x0002
 				z.af |= carry
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.ix == 0x0001 &&
 					z.af&sign == 0 &&
@@ -4862,8 +4928,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "",
 			data: []byte{0xdd, 0x23},
-			init: func(z *z80) { z.ix = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.ix = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.ix == 0x1123
 			},
 		},
@@ -4873,8 +4939,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "",
 			data: []byte{0xdd, 0x23},
-			init: func(z *z80) { z.ix = 0xffff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.ix = 0xffff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.ix == 0x0
 			},
 		},
@@ -4884,8 +4950,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "",
 			data: []byte{0xdd, 0x23},
-			init: func(z *z80) { z.ix = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.ix = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.ix == 0x8000
 			},
 		},
@@ -4896,8 +4962,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "",
 			data: []byte{0xdd, 0x2b},
-			init: func(z *z80) { z.ix = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.ix = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.ix == 0x1121
 			},
 		},
@@ -4907,7 +4973,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "",
 			data: []byte{0xdd, 0x2b},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.ix == 0xffff
 			},
 		},
@@ -4917,8 +4983,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "",
 			data: []byte{0xdd, 0x2b},
-			init: func(z *z80) { z.ix = 0x8000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.ix = 0x8000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.ix == 0x7fff
 			},
 		},
@@ -4929,11 +4995,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(ix+$11)",
 			src:  "",
 			data: []byte{0xdd, 0x34, 0x11},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.ix = 0x1122
 				z.bus.Write(0x1122+0x11, 0x7f)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003 && z.ix == 0x1122 &&
 					z.bus.Read(0x1122+0x11) == 0x80 &&
 					z.af&sign == sign &&
@@ -4951,11 +5017,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(ix+$11)",
 			src:  "",
 			data: []byte{0xdd, 0x35, 0x11},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.ix = 0x1122
 				z.bus.Write(0x1122+0x11, 0xff)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003 && z.ix == 0x1122 &&
 					z.bus.Read(0x1122+0x11) == 0xfe &&
 					z.af&sign == sign &&
@@ -4973,11 +5039,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "(ix+$ff)",
 			src:  "$aa",
 			data: []byte{0xdd, 0x36, 0xff, 0xaa},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.ix = 0x1122
 				z.bus.Write(0x1122-1, 0xff)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0004 && z.ix == 0x1122 &&
 					z.bus.Read(0x1122-1) == 0xaa &&
 					z.af&sign == 0 &&
@@ -4995,12 +5061,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "(ix+$11)",
 			data: []byte{0xdd, 0x86, 0x11},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x1100
 				z.ix = 0x3344
 				z.bus.Write(0x3344+0x11, 0x55)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003 &&
 					z.af&0xff00 == 0x6600 &&
 					z.ix == 0x3344 &&
@@ -5019,11 +5085,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "0",
 			src:  "(ix+$11)",
 			data: []byte{0xdd, 0xcb, 0x11, 0x46},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.ix = 0x3344
 				z.bus.Write(0x3344+0x11, 0x01)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0004 &&
 					z.ix == 0x3344 &&
 					z.af&sign == 0 &&
@@ -5040,11 +5106,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "0",
 			src:  "(ix+$11)",
 			data: []byte{0xdd, 0xcb, 0x11, 0x46},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.ix = 0x3344
 				z.bus.Write(0x3344+0x11, 0xf0)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0004 &&
 					z.ix == 0x3344 &&
 					z.af&sign == 0 &&
@@ -5055,6 +5121,119 @@ func TestInstructions(t *testing.T) {
 					z.af&carry == 0
 			},
 		},
+		{
+			name: "rlc (ix+d)",
+			mn:   "rlc",
+			dst:  "(ix+$11)",
+			data: []byte{0xdd, 0xcb, 0x11, 0x06},
+			init: func(z *CPU) {
+				z.ix = 0x3344
+				z.bus.Write(0x3344+0x11, 0x81)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bus.Read(0x3344+0x11) == 0x03 &&
+					z.bc == 0x0000 &&
+					z.af&carry == carry
+			},
+		},
+		{
+			name: "rlc (ix+d),b",
+			mn:   "rlc",
+			dst:  "(ix+$11)",
+			src:  "b",
+			data: []byte{0xdd, 0xcb, 0x11, 0x00},
+			init: func(z *CPU) {
+				z.ix = 0x3344
+				z.bus.Write(0x3344+0x11, 0x81)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bus.Read(0x3344+0x11) == 0x03 &&
+					z.bc == 0x0300 &&
+					z.af&carry == carry
+			},
+		},
+		{
+			name: "srl (ix+d),a",
+			mn:   "srl",
+			dst:  "(ix+$11)",
+			src:  "a",
+			data: []byte{0xdd, 0xcb, 0x11, 0x3f},
+			init: func(z *CPU) {
+				z.ix = 0x3344
+				z.bus.Write(0x3344+0x11, 0x81)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bus.Read(0x3344+0x11) == 0x40 &&
+					z.af&0xff00 == 0x4000 &&
+					z.af&carry == carry
+			},
+		},
+		{
+			name: "bit 0,(ix+d) undocumented",
+			mn:   "bit",
+			dst:  "0",
+			src:  "(ix+$11)",
+			data: []byte{0xdd, 0xcb, 0x11, 0x40},
+			init: func(z *CPU) {
+				z.ix = 0x3344
+				z.bus.Write(0x3344+0x11, 0xf0)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bc == 0x0000 &&
+					z.af&zero == zero
+			},
+		},
+		{
+			name: "bit 7,(ix+d) memptr",
+			mn:   "bit",
+			dst:  "7",
+			src:  "(ix+$ff)",
+			data: []byte{0xdd, 0xcb, 0xff, 0x7e},
+			init: func(z *CPU) {
+				z.ix = 0x2801
+				z.bus.Write(0x2800, 0x80)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.memptr == 0x2800 &&
+					z.af&0x00ff == uint16(FLAG_S|FLAG_H|FLAG_5|FLAG_3)
+			},
+		},
+		{
+			name: "res 0,(ix+d),c",
+			mn:   "res",
+			dst:  "0",
+			src:  "(ix+$11),c",
+			data: []byte{0xdd, 0xcb, 0x11, 0x81},
+			init: func(z *CPU) {
+				z.ix = 0x3344
+				z.bus.Write(0x3344+0x11, 0xff)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bus.Read(0x3344+0x11) == 0xfe &&
+					z.bc == 0x00fe
+			},
+		},
+		{
+			name: "set 7,(ix+d)",
+			mn:   "set",
+			dst:  "7",
+			src:  "(ix+$11)",
+			data: []byte{0xdd, 0xcb, 0x11, 0xfe},
+			init: func(z *CPU) {
+				z.ix = 0x3344
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bus.Read(0x3344+0x11) == 0x80 &&
+					z.af&0xff00 == 0x0000
+			},
+		},
 		// 0xdd 0xe1
 		{
 			name: "pop ix",
@@ -5062,13 +5241,13 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "",
 			data: []byte{0xdd, 0xe1},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.ix = 0x1122
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.sp == 0xaa57 &&
 					z.ix == 0xeeff
 			},
@@ -5080,8 +5259,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "ix",
 			src:  "",
 			data: []byte{0xdd, 0xe5},
-			init: func(z *z80) { z.ix = 0x1122; z.sp = 0xaa55 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.ix = 0x1122; z.sp = 0xaa55 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.sp == 0xaa53 &&
 					z.bus.Read(0xaa53) == 0x22 &&
 					z.bus.Read(0xaa54) == 0x11
@@ -5094,8 +5273,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$10",
 			data: []byte{0xde, 0x10},
-			init: func(z *z80) { z.af = 0x10ff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x10ff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
@@ -5112,8 +5291,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$7f",
 			data: []byte{0xde, 0x7f},
-			init: func(z *z80) { z.af = 0x0100 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0x8200 &&
 					z.af&sign == sign &&
@@ -5130,8 +5309,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "$ff",
 			data: []byte{0xde, 0xff},
-			init: func(z *z80) { z.af = 0xff00 | carry },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 | carry },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
@@ -5148,18 +5327,50 @@ func TestInstructions(t *testing.T) {
 			mn:   "rst",
 			dst:  "$18",
 			data: []byte{0xdf},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0x5566
 				z.bus.Write(0x5564, 0x64)
 				z.bus.Write(0x5565, 0x65)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0018 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x01 &&
 					z.bus.Read(0x5565) == 0x00
 			},
 			dontSkipPC: true,
 		},
+		// 0xe0
+		{
+			name: "ret po (P clear)",
+			mn:   "ret",
+			dst:  "po",
+			data: []byte{0xe0},
+			init: func(z *CPU) {
+				z.sp = 0xaa55
+				z.bus.Write(0xaa55, 0xff)
+				z.bus.Write(0xaa56, 0xee)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0xeeff && z.sp == 0xaa57
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "ret po (P set)",
+			mn:   "ret",
+			dst:  "po",
+			data: []byte{0xe0},
+			init: func(z *CPU) {
+				z.af |= parity
+				z.sp = 0xaa55
+				z.bus.Write(0xaa55, 0xff)
+				z.bus.Write(0xaa56, 0xee)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0001 && z.sp == 0xaa55
+			},
+			dontSkipPC: true,
+		},
 		// 0xe1
 		{
 			name: "pop hl",
@@ -5167,13 +5378,13 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "",
 			data: []byte{0xe1},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x1122
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa57 &&
 					z.hl == 0xeeff
 			},
@@ -5185,8 +5396,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "po",
 			src:  "$1122",
 			data: []byte{0xe2, 0x22, 0x11},
-			init: func(z *z80) { z.af = 0xff00 | parity },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 | parity },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003
 			},
 			dontSkipPC: true,
@@ -5197,7 +5408,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "po",
 			src:  "$1122",
 			data: []byte{0xe2, 0x22, 0x11},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
@@ -5209,20 +5420,47 @@ func TestInstructions(t *testing.T) {
 			dst:  "(sp)",
 			src:  "hl",
 			data: []byte{0xe3},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0x8856
 				z.hl = 0x7012
 				z.bus.Write(0x8856, 0x11)
 				z.bus.Write(0x8857, 0x22)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.hl == 0x2211 && z.pc == 0x0001 &&
-					z.sp == 0x8856 &&
+					z.sp == 0x8856 && z.memptr == 0x2211 &&
 					z.bus.Read(0x8856) == 0x12 &&
 					z.bus.Read(0x8857) == 0x70
 			},
 			dontSkipPC: true,
 		},
+		// 0xe4
+		{
+			name: "call po,nn (P clear)",
+			mn:   "call",
+			dst:  "po",
+			src:  "$1122",
+			data: []byte{0xe4, 0x22, 0x11},
+			init: func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x1122 && z.sp == 0x5564 &&
+					z.bus.Read(0x5564) == 0x03 &&
+					z.bus.Read(0x5565) == 0x00
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "call po,nn (P set)",
+			mn:   "call",
+			dst:  "po",
+			src:  "$1122",
+			data: []byte{0xe4, 0x22, 0x11},
+			init: func(z *CPU) { z.af = parity; z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0003 && z.sp == 0x5566
+			},
+			dontSkipPC: true,
+		},
 		// 0xe5
 		{
 			name: "push hl",
@@ -5230,8 +5468,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "",
 			data: []byte{0xe5},
-			init: func(z *z80) { z.hl = 0x1122; z.sp = 0xaa55 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x1122; z.sp = 0xaa55 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa53 &&
 					z.bus.Read(0xaa53) == 0x22 &&
 					z.bus.Read(0xaa54) == 0x11
@@ -5243,8 +5481,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "and",
 			dst:  "$f0",
 			data: []byte{0xe6, 0xf0},
-			init: func(z *z80) { z.af = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0xa000 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -5259,8 +5497,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "and",
 			dst:  "$00",
 			data: []byte{0xe6, 0x00},
-			init: func(z *z80) { z.af = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -5275,8 +5513,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "and",
 			dst:  "$7f",
 			data: []byte{0xe6, 0x7f},
-			init: func(z *z80) { z.af = 0xaf00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xaf00 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0x2f00 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -5292,18 +5530,50 @@ func TestInstructions(t *testing.T) {
 			mn:   "rst",
 			dst:  "$20",
 			data: []byte{0xe7},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0x5566
 				z.bus.Write(0x5564, 0x64)
 				z.bus.Write(0x5565, 0x65)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0020 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x01 &&
 					z.bus.Read(0x5565) == 0x00
 			},
 			dontSkipPC: true,
 		},
+		// 0xe8
+		{
+			name: "ret pe (P set)",
+			mn:   "ret",
+			dst:  "pe",
+			data: []byte{0xe8},
+			init: func(z *CPU) {
+				z.af |= parity
+				z.sp = 0xaa55
+				z.bus.Write(0xaa55, 0xff)
+				z.bus.Write(0xaa56, 0xee)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0xeeff && z.sp == 0xaa57
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "ret pe (P clear)",
+			mn:   "ret",
+			dst:  "pe",
+			data: []byte{0xe8},
+			init: func(z *CPU) {
+				z.sp = 0xaa55
+				z.bus.Write(0xaa55, 0xff)
+				z.bus.Write(0xaa56, 0xee)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0001 && z.sp == 0xaa55
+			},
+			dontSkipPC: true,
+		},
 		// 0xe9
 		{
 			// jp (hl) DOES NOT dereference hl but according to
@@ -5313,8 +5583,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "(hl)",
 			src:  "",
 			data: []byte{0xe9},
-			init: func(z *z80) { z.hl = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
@@ -5326,8 +5596,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "pe",
 			src:  "$1122",
 			data: []byte{0xea, 0x22, 0x11},
-			init: func(z *z80) { z.af = 0xff00 | parity },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 | parity },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
@@ -5338,7 +5608,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "pe",
 			src:  "$1122",
 			data: []byte{0xea, 0x22, 0x11},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003
 			},
 			dontSkipPC: true,
@@ -5350,12 +5620,42 @@ func TestInstructions(t *testing.T) {
 			dst:  "de",
 			src:  "hl",
 			data: []byte{0xeb},
-			init: func(z *z80) { z.de = 0x1122; z.hl = 0x3344 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.de = 0x1122; z.hl = 0x3344 },
+			expect: func(z *CPU) bool {
 				return 0x1122 == z.hl && 0x3344 == z.de &&
 					z.pc == 0x0001
 			},
 		},
+		// 0xed 0x40 in b,(c)
+		{
+			name: "in b,(c)",
+			mn:   "in",
+			dst:  "b",
+			src:  "(c)",
+			data: []byte{0xed, 0x40},
+			init: func(z *CPU) { z.bc = 0x12aa; z.af = 0x0001 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.bc == 0xffaa &&
+					z.af&sign == sign &&
+					z.af&zero == 0 &&
+					z.af&parity == parity &&
+					z.af&halfCarry == 0 &&
+					z.af&addsub == 0 &&
+					z.af&carry == carry
+			},
+		},
+		// 0xed 0x41 out (c),b
+		{
+			name: "out (c),b",
+			mn:   "out",
+			dst:  "(c)",
+			src:  "b",
+			data: []byte{0xed, 0x41},
+			init: func(z *CPU) { z.bc = 0x12aa },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.bus.IORead(0x00aa) == 0x12
+			},
+		},
 		// 0xed 0x42 sbc hl,bc
 		{
 			name: "sbc hl,bc",
@@ -5363,8 +5663,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "bc",
 			data: []byte{0xed, 0x42},
-			init: func(z *z80) { z.hl = 0x3344; z.bc = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x3344; z.bc = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.hl == 0x2222 &&
 					z.af&sign == 0 &&
@@ -5380,8 +5680,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "bc",
 			data: []byte{0xed, 0x42},
-			init: func(z *z80) { z.hl = 0x0001; z.bc = 0x0001 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x0001; z.bc = 0x0001 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.hl == 0x0000 &&
 					z.af&sign == 0 &&
@@ -5397,12 +5697,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "bc",
 			data: []byte{0xed, 0x42},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0x0001
 				z.bc = 0x0001
 				z.af |= carry
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.hl == 0xffff &&
 					z.af&sign == sign &&
@@ -5421,7 +5721,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "",
 			src:  "",
 			data: []byte{0xed, 0x44},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return 0x0000 == z.af&0xff00 && z.pc == 0x0002 &&
 					z.af&zero == zero && z.af&sign == 0 &&
 					z.af&addsub == addsub
@@ -5433,8 +5733,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "",
 			src:  "",
 			data: []byte{0xed, 0x44},
-			init: func(z *z80) { z.af = 0x0100 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0100 },
+			expect: func(z *CPU) bool {
 				return 0xff00 == z.af&0xff00 &&
 					z.pc == 0x0002 && z.af&zero == 0 &&
 					z.af&sign == sign &&
@@ -5447,8 +5747,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "",
 			src:  "",
 			data: []byte{0xed, 0x44},
-			init: func(z *z80) { z.af = 0xff00 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 },
+			expect: func(z *CPU) bool {
 				return 0x0100 == z.af&0xff00 &&
 					z.pc == 0x0002 && z.af&zero == 0 &&
 					z.af&sign == 0
@@ -5461,8 +5761,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "bc",
 			data: []byte{0xed, 0x4a},
-			init: func(z *z80) { z.hl = 0x3344; z.bc = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0x3344; z.bc = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.hl == 0x4466 &&
 					z.af&sign == 0 &&
@@ -5479,8 +5779,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "bc",
 			data: []byte{0xed, 0x4a},
-			init: func(z *z80) { z.hl = 0xffff; z.bc = 0x0001 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.hl = 0xffff; z.bc = 0x0001 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.hl == 0x0000 &&
 					z.af&sign == 0 &&
@@ -5497,12 +5797,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "hl",
 			src:  "bc",
 			data: []byte{0xed, 0x4a},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.hl = 0xffff
 				z.bc = 0x0001
 				z.af |= carry
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.hl == 0x0001 &&
 					z.af&sign == 0 &&
@@ -5513,93 +5813,443 @@ func TestInstructions(t *testing.T) {
 					z.af&carry == carry
 			},
 		},
-		// 0xed 0x73 ld (nn),sp
+		// 0xed 0x45 retn
 		{
-			name: "ld (nn),sp",
+			name: "retn",
+			mn:   "retn",
+			data: []byte{0xed, 0x45},
+			init: func(z *CPU) {
+				z.iff2 = 1
+				z.sp = 0x5564
+				z.bus.Write(0x5564, 0x34)
+				z.bus.Write(0x5565, 0x12)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x1234 && z.sp == 0x5566 &&
+					z.iff1 == 1 && z.iff2 == 1
+			},
+			dontSkipPC: true,
+		},
+		// 0xed 0x46 im 0
+		{
+			name: "im 0",
+			mn:   "im",
+			dst:  "0",
+			data: []byte{0xed, 0x46},
+			init: func(z *CPU) { z.im = 2 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.im == 0
+			},
+		},
+		// 0xed 0x47 ld i,a
+		{
+			name: "ld i,a",
 			mn:   "ld",
-			dst:  "($1000)",
-			src:  "sp",
-			data: []byte{0xed, 0x73, 0x00, 0x10},
-			init: func(z *z80) { z.sp = 0x4644 },
-			expect: func(z *z80) bool {
-				return z.pc == 0x0004 &&
-					z.bus.Read(0x1000) == 0x44 &&
-					z.bus.Read(0x1001) == 0x46 &&
-					z.af&sign == 0 &&
-					z.af&zero == 0 &&
-					z.af&parity == 0 &&
-					z.af&halfCarry == 0 &&
-					z.af&addsub == 0
+			dst:  "i",
+			src:  "a",
+			data: []byte{0xed, 0x47},
+			init: func(z *CPU) { z.af = 0xa5ff },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.i == 0xa5 &&
+					z.af == 0xa5ff
 			},
 		},
-		// 0xed 0x7b ld sp,(nn)
+		// 0xed 0x4f ld r,a
 		{
-			name: "ld sp,(nn)",
+			name: "ld r,a",
 			mn:   "ld",
-			dst:  "sp",
-			src:  "($2130)",
-			data: []byte{0xed, 0x7b, 0x30, 0x21},
-			init: func(z *z80) {
-				z.bus.Write(0x2130, 0x65)
-				z.bus.Write(0x2131, 0x78)
-				z.sp = 0x4644
+			dst:  "r",
+			src:  "a",
+			data: []byte{0xed, 0x4f},
+			init: func(z *CPU) { z.af = 0xa500 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.r == 0xa5 &&
+					z.af == 0xa500
 			},
-			expect: func(z *z80) bool {
-				return z.pc == 0x0004 &&
-					z.sp == 0x7865 &&
-					z.af&sign == 0 &&
+		},
+		// 0xed 0x56 im 1
+		{
+			name: "im 1",
+			mn:   "im",
+			dst:  "1",
+			data: []byte{0xed, 0x56},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.im == 1
+			},
+		},
+		// 0xed 0x57 ld a,i
+		{
+			name: "ld a,i",
+			mn:   "ld",
+			dst:  "a",
+			src:  "i",
+			data: []byte{0xed, 0x57},
+			init: func(z *CPU) { z.i = 0x80; z.iff2 = 1; z.af = 0x0001 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.af&0xff00 == 0x8000 &&
+					z.af&sign == sign &&
 					z.af&zero == 0 &&
-					z.af&parity == 0 &&
+					z.af&parity == parity &&
 					z.af&halfCarry == 0 &&
-					z.af&addsub == 0
+					z.af&addsub == 0 &&
+					z.af&carry == carry
 			},
 		},
-		// 0xef
 		{
-			name: "rst $28",
-			mn:   "rst",
-			dst:  "$28",
-			data: []byte{0xef},
-			init: func(z *z80) {
-				z.sp = 0x5566
-				z.bus.Write(0x5564, 0x64)
-				z.bus.Write(0x5565, 0x65)
-			},
-			expect: func(z *z80) bool {
-				return z.pc == 0x0028 && z.sp == 0x5564 &&
-					z.bus.Read(0x5564) == 0x01 &&
-					z.bus.Read(0x5565) == 0x00
+			name: "ld a,i (iff2 == 0)",
+			mn:   "ld",
+			dst:  "a",
+			src:  "i",
+			data: []byte{0xed, 0x57},
+			init: func(z *CPU) { z.af = 0xffff },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.af&0xff00 == 0x0000 &&
+					z.af&sign == 0 &&
+					z.af&zero == zero &&
+					z.af&parity == 0 &&
+					z.af&halfCarry == 0 &&
+					z.af&addsub == 0 &&
+					z.af&carry == carry
 			},
-			dontSkipPC: true,
 		},
-		// 0xf0
+		// 0xed 0x5e im 2
 		{
-			name: "ret p (P set)",
-			mn:   "ret",
-			dst:  "p",
-			data: []byte{0xf0},
-			init: func(z *z80) {
-				z.af |= parity
-				z.sp = 0xaa55
-				z.bus.Write(0xaa55, 0xff)
-				z.bus.Write(0xaa56, 0xee)
-			},
-			expect: func(z *z80) bool {
-				return z.pc == 0xeeff && z.sp == 0xaa57
+			name: "im 2",
+			mn:   "im",
+			dst:  "2",
+			data: []byte{0xed, 0x5e},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.im == 2
 			},
-			dontSkipPC: true,
 		},
+		// 0xed 0x5f ld a,r
 		{
-			name: "ret p (P clear)",
+			name: "ld a,r",
+			mn:   "ld",
+			dst:  "a",
+			src:  "r",
+			data: []byte{0xed, 0x5f},
+			init: func(z *CPU) { z.r = 0xfe },
+			expect: func(z *CPU) bool {
+				// Both opcode fetches increment R before the
+				// load and bit 7 is preserved.
+				return z.pc == 0x0002 && z.af&0xff00 == 0x8000 &&
+					z.r == 0x80 &&
+					z.af&sign == sign &&
+					z.af&zero == 0 &&
+					z.af&parity == 0
+			},
+		},
+		// 0xed 0x63 ld (nn),hl
+		{
+			name: "ld (nn),hl",
+			mn:   "ld",
+			dst:  "($1000)",
+			src:  "hl",
+			data: []byte{0xed, 0x63, 0x00, 0x10},
+			init: func(z *CPU) { z.hl = 0x4644 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bus.Read(0x1000) == 0x44 &&
+					z.bus.Read(0x1001) == 0x46 &&
+					z.memptr == 0x1001
+			},
+		},
+		// 0xed 0x6b ld hl,(nn)
+		{
+			name: "ld hl,(nn)",
+			mn:   "ld",
+			dst:  "hl",
+			src:  "($2130)",
+			data: []byte{0xed, 0x6b, 0x30, 0x21},
+			init: func(z *CPU) {
+				z.bus.Write(0x2130, 0x65)
+				z.bus.Write(0x2131, 0x78)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.hl == 0x7865 &&
+					z.memptr == 0x2131
+			},
+		},
+		// 0xed 0x70 in (c)
+		{
+			name: "in (c)",
+			mn:   "in",
+			dst:  "(c)",
+			data: []byte{0xed, 0x70},
+			init: func(z *CPU) { z.bc = 0x00aa; z.hl = 0x1234 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.hl == 0x1234 &&
+					z.af&sign == sign &&
+					z.af&parity == parity
+			},
+		},
+		// 0xed 0x71 out (c),0
+		{
+			name: "out (c),0",
+			mn:   "out",
+			dst:  "(c)",
+			src:  "0",
+			data: []byte{0xed, 0x71},
+			init: func(z *CPU) { z.bc = 0x12aa },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.bus.IORead(0x00aa) == 0x00
+			},
+		},
+		// 0xed 0x73 ld (nn),sp
+		{
+			name: "ld (nn),sp",
+			mn:   "ld",
+			dst:  "($1000)",
+			src:  "sp",
+			data: []byte{0xed, 0x73, 0x00, 0x10},
+			init: func(z *CPU) { z.sp = 0x4644 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bus.Read(0x1000) == 0x44 &&
+					z.bus.Read(0x1001) == 0x46 &&
+					z.af&sign == 0 &&
+					z.af&zero == 0 &&
+					z.af&parity == 0 &&
+					z.af&halfCarry == 0 &&
+					z.af&addsub == 0
+			},
+		},
+		// 0xed 0x7b ld sp,(nn)
+		{
+			name: "ld sp,(nn)",
+			mn:   "ld",
+			dst:  "sp",
+			src:  "($2130)",
+			data: []byte{0xed, 0x7b, 0x30, 0x21},
+			init: func(z *CPU) {
+				z.bus.Write(0x2130, 0x65)
+				z.bus.Write(0x2131, 0x78)
+				z.sp = 0x4644
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.sp == 0x7865 && z.memptr == 0x2131 &&
+					z.af&sign == 0 &&
+					z.af&zero == 0 &&
+					z.af&parity == 0 &&
+					z.af&halfCarry == 0 &&
+					z.af&addsub == 0
+			},
+		},
+		// 0xed 0xa1 cpi
+		{
+			name: "cpi",
+			mn:   "cpi",
+			data: []byte{0xed, 0xa1},
+			init: func(z *CPU) {
+				z.af = 0x1100
+				z.bc = 0x0002
+				z.hl = 0x1000
+				z.memptr = 0x3000
+				z.bus.Write(0x1000, 0x11)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 &&
+					z.hl == 0x1001 &&
+					z.bc == 0x0001 &&
+					z.memptr == 0x3001 &&
+					z.af&zero == zero &&
+					z.af&parity == parity
+			},
+		},
+		// 0xed 0xa2 ini
+		{
+			name: "ini",
+			mn:   "ini",
+			data: []byte{0xed, 0xa2},
+			init: func(z *CPU) { z.bc = 0x02aa; z.hl = 0x1000 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.bc == 0x01aa &&
+					z.hl == 0x1001 &&
+					z.bus.Read(0x1000) == 0xff &&
+					z.af&sign == 0 &&
+					z.af&zero == 0 &&
+					z.af&halfCarry == halfCarry &&
+					z.af&parity == parity &&
+					z.af&addsub == addsub &&
+					z.af&carry == carry
+			},
+		},
+		// 0xed 0xa3 outi
+		{
+			name: "outi",
+			mn:   "outi",
+			data: []byte{0xed, 0xa3},
+			init: func(z *CPU) {
+				z.bc = 0x01aa
+				z.hl = 0x1000
+				z.bus.Write(0x1000, 0x80)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.bc == 0x00aa &&
+					z.hl == 0x1001 &&
+					z.bus.IORead(0x00aa) == 0x80 &&
+					z.af&sign == 0 &&
+					z.af&zero == zero &&
+					z.af&halfCarry == 0 &&
+					z.af&parity == 0 &&
+					z.af&addsub == addsub &&
+					z.af&carry == 0
+			},
+		},
+		// 0xed 0xaa ind
+		{
+			name: "ind",
+			mn:   "ind",
+			data: []byte{0xed, 0xaa},
+			init: func(z *CPU) { z.bc = 0x01aa; z.hl = 0x1000 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.bc == 0x00aa &&
+					z.hl == 0x0fff &&
+					z.bus.Read(0x1000) == 0xff &&
+					z.af&zero == zero &&
+					z.af&carry == carry
+			},
+		},
+		// 0xed 0xab outd
+		{
+			name: "outd",
+			mn:   "outd",
+			data: []byte{0xed, 0xab},
+			init: func(z *CPU) {
+				z.bc = 0x02aa
+				z.hl = 0x1000
+				z.bus.Write(0x1000, 0x12)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.bc == 0x01aa &&
+					z.hl == 0x0fff &&
+					z.bus.IORead(0x00aa) == 0x12 &&
+					z.af&zero == 0 &&
+					z.af&addsub == 0
+			},
+		},
+		// 0xed 0xb3 otir
+		{
+			name: "otir repeat",
+			mn:   "otir",
+			data: []byte{0xed, 0xb3},
+			init: func(z *CPU) {
+				z.bc = 0x02aa
+				z.hl = 0x1000
+				z.bus.Write(0x1000, 0x12)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0000 && z.bc == 0x01aa &&
+					z.hl == 0x1001 &&
+					z.bus.IORead(0x00aa) == 0x12 &&
+					z.totalCycles == 21
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "otir done",
+			mn:   "otir",
+			data: []byte{0xed, 0xb3},
+			init: func(z *CPU) {
+				z.bc = 0x01aa
+				z.hl = 0x1000
+				z.bus.Write(0x1000, 0x12)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.bc == 0x00aa &&
+					z.af&zero == zero &&
+					z.totalCycles == 16
+			},
+		},
+		// 0xed 0xb2 inir
+		{
+			name: "inir repeat",
+			mn:   "inir",
+			data: []byte{0xed, 0xb2},
+			init: func(z *CPU) { z.bc = 0x02aa; z.hl = 0x1000 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0000 && z.bc == 0x01aa &&
+					z.hl == 0x1001 &&
+					z.bus.Read(0x1000) == 0xff
+			},
+			dontSkipPC: true,
+		},
+		// 0xec
+		{
+			name: "call pe,nn (P set)",
+			mn:   "call",
+			dst:  "pe",
+			src:  "$1122",
+			data: []byte{0xec, 0x22, 0x11},
+			init: func(z *CPU) { z.af = parity; z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x1122 && z.sp == 0x5564 &&
+					z.bus.Read(0x5564) == 0x03 &&
+					z.bus.Read(0x5565) == 0x00
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "call pe,nn (P clear)",
+			mn:   "call",
+			dst:  "pe",
+			src:  "$1122",
+			data: []byte{0xec, 0x22, 0x11},
+			init: func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0003 && z.sp == 0x5566
+			},
+			dontSkipPC: true,
+		},
+		// 0xef
+		{
+			name: "rst $28",
+			mn:   "rst",
+			dst:  "$28",
+			data: []byte{0xef},
+			init: func(z *CPU) {
+				z.sp = 0x5566
+				z.bus.Write(0x5564, 0x64)
+				z.bus.Write(0x5565, 0x65)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0028 && z.sp == 0x5564 &&
+					z.bus.Read(0x5564) == 0x01 &&
+					z.bus.Read(0x5565) == 0x00
+			},
+			dontSkipPC: true,
+		},
+		// 0xf0
+		{
+			name: "ret p (S clear)",
 			mn:   "ret",
 			dst:  "p",
 			data: []byte{0xf0},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
+				return z.pc == 0xeeff && z.sp == 0xaa57
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "ret p (S set)",
+			mn:   "ret",
+			dst:  "p",
+			data: []byte{0xf0},
+			init: func(z *CPU) {
+				z.af |= sign
+				z.sp = 0xaa55
+				z.bus.Write(0xaa55, 0xff)
+				z.bus.Write(0xaa56, 0xee)
+			},
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa55
 			},
 			dontSkipPC: true,
@@ -5611,13 +6261,13 @@ func TestInstructions(t *testing.T) {
 			dst:  "af",
 			src:  "",
 			data: []byte{0xf1},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x1122
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa57 &&
 					z.af == 0xeeff
 			},
@@ -5627,7 +6277,7 @@ func TestInstructions(t *testing.T) {
 			name: "di",
 			mn:   "di",
 			data: []byte{0xf3},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.iff1 == 0 &&
 					z.iff2 == 0
 			},
@@ -5639,8 +6289,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "p",
 			src:  "$1122",
 			data: []byte{0xf2, 0x22, 0x11},
-			init: func(z *z80) { z.af = 0xff00 | sign },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 | sign },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003
 			},
 			dontSkipPC: true,
@@ -5651,11 +6301,38 @@ func TestInstructions(t *testing.T) {
 			dst:  "p",
 			src:  "$1122",
 			data: []byte{0xf2, 0x22, 0x11},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
 		},
+		// 0xf4
+		{
+			name: "call p,nn (S clear)",
+			mn:   "call",
+			dst:  "p",
+			src:  "$1122",
+			data: []byte{0xf4, 0x22, 0x11},
+			init: func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x1122 && z.sp == 0x5564 &&
+					z.bus.Read(0x5564) == 0x03 &&
+					z.bus.Read(0x5565) == 0x00
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "call p,nn (S set)",
+			mn:   "call",
+			dst:  "p",
+			src:  "$1122",
+			data: []byte{0xf4, 0x22, 0x11},
+			init: func(z *CPU) { z.af = sign; z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0003 && z.sp == 0x5566
+			},
+			dontSkipPC: true,
+		},
 		// 0xf5
 		{
 			name: "push af",
@@ -5663,8 +6340,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "af",
 			src:  "",
 			data: []byte{0xf5},
-			init: func(z *z80) { z.af = 0x1122; z.sp = 0xaa55 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1122; z.sp = 0xaa55 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.sp == 0xaa53 &&
 					z.bus.Read(0xaa53) == 0x22 &&
 					z.bus.Read(0xaa54) == 0x11
@@ -5676,8 +6353,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "$f0",
 			data: []byte{0xf6, 0xf0},
-			init: func(z *z80) { z.af = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0xf500 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -5692,8 +6369,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "$00",
 			data: []byte{0xf6, 0x00},
-			init: func(z *z80) { z.af = 0xa500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xa500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0xa500 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -5708,8 +6385,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "$00",
 			data: []byte{0xf6, 0x00},
-			init: func(z *z80) { z.af = 0x0000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x0000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0x0000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -5724,8 +6401,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "or",
 			dst:  "$7f",
 			data: []byte{0xf6, 0x7f},
-			init: func(z *z80) { z.af = 0x8500 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x8500 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0xff00 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -5741,18 +6418,50 @@ func TestInstructions(t *testing.T) {
 			mn:   "rst",
 			dst:  "$30",
 			data: []byte{0xf7},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0x5566
 				z.bus.Write(0x5564, 0x64)
 				z.bus.Write(0x5565, 0x65)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0030 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x01 &&
 					z.bus.Read(0x5565) == 0x00
 			},
 			dontSkipPC: true,
 		},
+		// 0xf8
+		{
+			name: "ret m (S set)",
+			mn:   "ret",
+			dst:  "m",
+			data: []byte{0xf8},
+			init: func(z *CPU) {
+				z.af |= sign
+				z.sp = 0xaa55
+				z.bus.Write(0xaa55, 0xff)
+				z.bus.Write(0xaa56, 0xee)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0xeeff && z.sp == 0xaa57
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "ret m (S clear)",
+			mn:   "ret",
+			dst:  "m",
+			data: []byte{0xf8},
+			init: func(z *CPU) {
+				z.sp = 0xaa55
+				z.bus.Write(0xaa55, 0xff)
+				z.bus.Write(0xaa56, 0xee)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0001 && z.sp == 0xaa55
+			},
+			dontSkipPC: true,
+		},
 		// 0xfa
 		{
 			name: "jp m,nn (S set)",
@@ -5760,8 +6469,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "m",
 			src:  "$1122",
 			data: []byte{0xfa, 0x22, 0x11},
-			init: func(z *z80) { z.af = 0xff00 | sign },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0xff00 | sign },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x1122
 			},
 			dontSkipPC: true,
@@ -5772,7 +6481,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "m",
 			src:  "$1122",
 			data: []byte{0xfa, 0x22, 0x11},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003
 			},
 			dontSkipPC: true,
@@ -5782,7 +6491,7 @@ func TestInstructions(t *testing.T) {
 			name: "ei",
 			mn:   "ei",
 			data: []byte{0xfb},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0001 && z.iff1 == 1 &&
 					z.iff2 == 1
 			},
@@ -5794,8 +6503,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "bc",
 			data: []byte{0xfd, 0x09},
-			init: func(z *z80) { z.iy = 0x3344; z.bc = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.iy = 0x3344; z.bc = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.iy == 0x4466 &&
 					z.af&sign == 0 &&
@@ -5812,8 +6521,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "bc",
 			data: []byte{0xfd, 0x09},
-			init: func(z *z80) { z.iy = 0xffff; z.bc = 0x0001 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.iy = 0xffff; z.bc = 0x0001 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.iy == 0x0000 &&
 					z.af&sign == 0 &&
@@ -5830,12 +6539,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "bc",
 			data: []byte{0xfd, 0x09},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.iy = 0xffff
 				z.bc = 0x0002
 				z.af |= carry
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 &&
 					z.iy == 0x0001 &&
 					z.af&sign == 0 &&
@@ -5853,8 +6562,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "",
 			data: []byte{0xfd, 0x23},
-			init: func(z *z80) { z.iy = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.iy = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.iy == 0x1123
 			},
 		},
@@ -5864,8 +6573,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "",
 			data: []byte{0xfd, 0x23},
-			init: func(z *z80) { z.iy = 0xffff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.iy = 0xffff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.iy == 0x0
 			},
 		},
@@ -5875,8 +6584,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "",
 			data: []byte{0xfd, 0x23},
-			init: func(z *z80) { z.iy = 0x7fff },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.iy = 0x7fff },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.iy == 0x8000
 			},
 		},
@@ -5887,8 +6596,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "",
 			data: []byte{0xfd, 0x2b},
-			init: func(z *z80) { z.iy = 0x1122 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.iy = 0x1122 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.iy == 0x1121
 			},
 		},
@@ -5898,7 +6607,7 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "",
 			data: []byte{0xfd, 0x2b},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.iy == 0xffff
 			},
 		},
@@ -5908,8 +6617,8 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "",
 			data: []byte{0xfd, 0x2b},
-			init: func(z *z80) { z.iy = 0x8000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.iy = 0x8000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.iy == 0x7fff
 			},
 		},
@@ -5920,12 +6629,12 @@ func TestInstructions(t *testing.T) {
 			dst:  "a",
 			src:  "(iy+$11)",
 			data: []byte{0xfd, 0x86, 0x11},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0x1100
 				z.iy = 0x3344
 				z.bus.Write(0x3344+0x11, 0x55)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003 &&
 					z.af&0xff00 == 0x6600 &&
 					z.iy == 0x3344 &&
@@ -5943,12 +6652,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "and",
 			dst:  "(iy+$11)",
 			data: []byte{0xfd, 0xa6, 0x11},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.af = 0xa500
 				z.iy = 0x3344
 				z.bus.Write(0x3344+0x11, 0xf0)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0003 &&
 					z.af&0xff00 == 0xa000 &&
 					z.iy == 0x3344 &&
@@ -5967,13 +6676,13 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "",
 			data: []byte{0xfd, 0xe1},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.iy = 0x1122
 				z.sp = 0xaa55
 				z.bus.Write(0xaa55, 0xff)
 				z.bus.Write(0xaa56, 0xee)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.sp == 0xaa57 &&
 					z.iy == 0xeeff
 			},
@@ -5985,11 +6694,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "0",
 			src:  "(iy+$11)",
 			data: []byte{0xfd, 0xcb, 0x11, 0x46},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.iy = 0x3344
 				z.bus.Write(0x3344+0x11, 0x01)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0004 &&
 					z.iy == 0x3344 &&
 					z.af&sign == 0 &&
@@ -6006,11 +6715,11 @@ func TestInstructions(t *testing.T) {
 			dst:  "0",
 			src:  "(iy+$11)",
 			data: []byte{0xfd, 0xcb, 0x11, 0x46},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.iy = 0x3344
 				z.bus.Write(0x3344+0x11, 0xf0)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0004 &&
 					z.iy == 0x3344 &&
 					z.af&sign == 0 &&
@@ -6021,6 +6730,37 @@ func TestInstructions(t *testing.T) {
 					z.af&carry == 0
 			},
 		},
+		{
+			name: "set 7,(iy+d),a",
+			mn:   "set",
+			dst:  "7",
+			src:  "(iy+$fe),a",
+			data: []byte{0xfd, 0xcb, 0xfe, 0xff},
+			init: func(z *CPU) {
+				z.iy = 0x3344
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bus.Read(0x3342) == 0x80 &&
+					z.af&0xff00 == 0x8000
+			},
+		},
+		{
+			name: "sla (iy+d),l",
+			mn:   "sla",
+			dst:  "(iy+$fe)",
+			src:  "l",
+			data: []byte{0xfd, 0xcb, 0xfe, 0x25},
+			init: func(z *CPU) {
+				z.iy = 0x3344
+				z.bus.Write(0x3342, 0x41)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0004 &&
+					z.bus.Read(0x3342) == 0x82 &&
+					z.hl == 0x0082
+			},
+		},
 		// 0xfd 0xe5
 		{
 			name: "push iy",
@@ -6028,21 +6768,48 @@ func TestInstructions(t *testing.T) {
 			dst:  "iy",
 			src:  "",
 			data: []byte{0xfd, 0xe5},
-			init: func(z *z80) { z.iy = 0x1122; z.sp = 0xaa55 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.iy = 0x1122; z.sp = 0xaa55 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.sp == 0xaa53 &&
 					z.bus.Read(0xaa53) == 0x22 &&
 					z.bus.Read(0xaa54) == 0x11
 			},
 		},
+		// 0xfc
+		{
+			name: "call m,nn (S set)",
+			mn:   "call",
+			dst:  "m",
+			src:  "$1122",
+			data: []byte{0xfc, 0x22, 0x11},
+			init: func(z *CPU) { z.af = sign; z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x1122 && z.sp == 0x5564 &&
+					z.bus.Read(0x5564) == 0x03 &&
+					z.bus.Read(0x5565) == 0x00
+			},
+			dontSkipPC: true,
+		},
+		{
+			name: "call m,nn (S clear)",
+			mn:   "call",
+			dst:  "m",
+			src:  "$1122",
+			data: []byte{0xfc, 0x22, 0x11},
+			init: func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0003 && z.sp == 0x5566
+			},
+			dontSkipPC: true,
+		},
 		// 0xfe
 		{
 			name: "cp i <",
 			mn:   "cp",
 			dst:  "$20",
 			data: []byte{0xfe, 0x20},
-			init: func(z *z80) { z.af = 0x1000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x1000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0x1000 &&
 					z.af&sign == sign &&
 					z.af&zero == 0 &&
@@ -6057,8 +6824,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "$10",
 			data: []byte{0xfe, 0x10},
-			init: func(z *z80) { z.af = 0x2000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == 0 &&
@@ -6073,8 +6840,8 @@ func TestInstructions(t *testing.T) {
 			mn:   "cp",
 			dst:  "$20",
 			data: []byte{0xfe, 0x20},
-			init: func(z *z80) { z.af = 0x2000 },
-			expect: func(z *z80) bool {
+			init: func(z *CPU) { z.af = 0x2000 },
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0002 && z.af&0xff00 == 0x2000 &&
 					z.af&sign == 0 &&
 					z.af&zero == zero &&
@@ -6090,12 +6857,12 @@ func TestInstructions(t *testing.T) {
 			mn:   "rst",
 			dst:  "$38",
 			data: []byte{0xff},
-			init: func(z *z80) {
+			init: func(z *CPU) {
 				z.sp = 0x5566
 				z.bus.Write(0x5564, 0x64)
 				z.bus.Write(0x5565, 0x65)
 			},
-			expect: func(z *z80) bool {
+			expect: func(z *CPU) bool {
 				return z.pc == 0x0038 && z.sp == 0x5564 &&
 					z.bus.Read(0x5564) == 0x01 &&
 					z.bus.Read(0x5565) == 0x00
@@ -6117,10 +6884,11 @@ func TestInstructions(t *testing.T) {
 				Image: test.data,
 			},
 			bus.Device{
-				Name:  "Dummy",
-				Start: 0xaa,
-				Size:  1,
-				Type:  bus.DeviceDummy,
+				Name:       "Dummy",
+				Start:      0xaa,
+				Size:       1,
+				Type:       bus.DevicePeripheral,
+				Peripheral: "dummy",
 			},
 		}
 		bus, err := bus.New(devices, make(chan string))
@@ -6196,3 +6964,1358 @@ func TestInstructions(t *testing.T) {
 	next:
 	}
 }
+
+// ackDevice supplies a multi byte instruction in interrupt mode 0, one byte
+// per acknowledge cycle.
+type ackDevice struct {
+	data []byte
+	n    int
+}
+
+func (a *ackDevice) ReadMemory(address uint16) byte {
+	return 0xff
+}
+
+func (a *ackDevice) WriteMemory(address uint16, data byte) {
+}
+
+func (a *ackDevice) Interrupt() bool {
+	return true
+}
+
+func (a *ackDevice) InterruptAck() byte {
+	data := a.data[a.n%len(a.data)]
+	a.n++
+	return data
+}
+
+func TestInterrupt(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		init   func(z *CPU)
+		steps  int
+		vector byte
+		ack    []byte // Instruction supplied by an ackDevice
+		nmi    bool
+		expect func(z *CPU) bool
+	}{
+		{
+			name:   "di masks int",
+			data:   []byte{0xf3, 0x00},
+			steps:  2,
+			vector: 0xff,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.sp == 0x0000
+			},
+		},
+		{
+			name:   "ei delay",
+			data:   []byte{0xfb, 0x00, 0x00},
+			steps:  2,
+			vector: 0xff,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.iff1 == 1
+			},
+		},
+		{
+			name:   "im 0 rst $28",
+			data:   []byte{0xfb, 0x00, 0x00},
+			steps:  3,
+			vector: 0xef,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0028 && z.sp == 0xfffe &&
+					z.bus.Read(0xfffe) == 0x02 &&
+					z.bus.Read(0xffff) == 0x00 &&
+					z.iff1 == 0 && z.iff2 == 0 &&
+					z.totalCycles == 4+4+13
+			},
+		},
+		{
+			name:  "im 0 call $1234",
+			data:  []byte{0xfb, 0x00, 0x00},
+			steps: 3,
+			ack:   []byte{0xcd, 0x34, 0x12},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x1234 && z.sp == 0xfffe &&
+					z.bus.Read(0xfffe) == 0x02 &&
+					z.bus.Read(0xffff) == 0x00 &&
+					z.iff1 == 0 && z.totalCycles == 4+4+19
+			},
+		},
+		{
+			name:  "im 0 ld a,$42",
+			data:  []byte{0xfb, 0x00, 0x00},
+			steps: 3,
+			ack:   []byte{0x3e, 0x42},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0002 && z.sp == 0x0000 &&
+					z.af>>8 == 0x42 && z.iff1 == 0 &&
+					z.totalCycles == 4+4+9
+			},
+		},
+		{
+			name:  "im 0 8080 call $1234",
+			data:  []byte{0xfb, 0x00, 0x00},
+			init:  func(z *CPU) { z.mode = Mode8080 },
+			steps: 3,
+			ack:   []byte{0xcd, 0x34, 0x12},
+			expect: func(z *CPU) bool {
+				return z.pc == 0x1234 && z.sp == 0xfffe &&
+					z.bus.Read(0xfffe) == 0x02 &&
+					z.bus.Read(0xffff) == 0x00
+			},
+		},
+		{
+			name:   "im 1",
+			data:   []byte{0xed, 0x56, 0xfb, 0x00, 0x00},
+			steps:  4,
+			vector: 0x00,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0038 && z.sp == 0xfffe &&
+					z.bus.Read(0xfffe) == 0x04 &&
+					z.totalCycles == 8+4+4+13
+			},
+		},
+		{
+			name: "im 2",
+			data: []byte{0xed, 0x5e, 0xfb, 0x00, 0x00},
+			init: func(z *CPU) {
+				z.i = 0x12
+				z.bus.Write(0x1234, 0x78)
+				z.bus.Write(0x1235, 0x56)
+			},
+			steps:  4,
+			vector: 0x34,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x5678 && z.sp == 0xfffe &&
+					z.bus.Read(0xfffe) == 0x04 &&
+					z.totalCycles == 8+4+4+19
+			},
+		},
+		{
+			name:   "halt wakeup",
+			data:   []byte{0xfb, 0x76, 0x00},
+			steps:  3,
+			vector: 0xff,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0038 && !z.halted &&
+					z.bus.Read(0xfffe) == 0x02
+			},
+		},
+		{
+			name:  "nmi",
+			data:  []byte{0x00, 0x00},
+			init:  func(z *CPU) { z.iff1 = 1; z.iff2 = 1 },
+			steps: 1,
+			nmi:   true,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0066 && z.sp == 0xfffe &&
+					z.bus.Read(0xfffe) == 0x00 &&
+					z.iff1 == 0 && z.iff2 == 1 &&
+					z.totalCycles == 11
+			},
+		},
+		{
+			name: "reti after nmi",
+			data: []byte{0x00, 0x00},
+			init: func(z *CPU) {
+				z.iff1 = 1
+				z.iff2 = 1
+				z.bus.Write(0x66, 0xed)
+				z.bus.Write(0x67, 0x4d) // reti
+			},
+			steps: 2,
+			nmi:   true,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0000 && z.sp == 0x0000 &&
+					z.iff1 == 1 && z.iff2 == 1
+			},
+		},
+		{
+			name:  "nmi after ei",
+			data:  []byte{0xfb, 0x00},
+			steps: 2,
+			nmi:   true,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0067 && z.iff1 == 0 && z.iff2 == 0
+			},
+		},
+		{
+			name:  "nmi halt wakeup",
+			data:  []byte{0xf3, 0x76, 0x00},
+			init:  func(z *CPU) { z.halted = true; z.pc = 1 },
+			steps: 1,
+			nmi:   true,
+			expect: func(z *CPU) bool {
+				return z.pc == 0x0066 && !z.halted &&
+					z.bus.Read(0xfffe) == 0x02
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Logf("running: %v", test.name)
+
+		devices := []bus.Device{
+			{
+				Name:  "RAM",
+				Start: 0x0000,
+				Size:  65536,
+				Type:  bus.DeviceRAM,
+				Image: test.data,
+			},
+		}
+		b, err := bus.New(devices, make(chan string))
+		if err != nil {
+			t.Fatalf("%v: bus %v", test.name, err)
+		}
+
+		z, err := New(ModeZ80, b)
+		if err != nil {
+			t.Fatalf("%v: z80 %v", test.name, err)
+		}
+
+		if test.init != nil {
+			test.init(z)
+		}
+
+		switch {
+		case test.nmi:
+			b.RaiseNMI()
+		case test.ack != nil:
+			err = b.MapDevice(0xc000, 1, &ackDevice{data: test.ack})
+			if err != nil {
+				t.Fatalf("%v: map %v", test.name, err)
+			}
+		default:
+			b.RaiseInterrupt(test.vector)
+		}
+		for i := 0; i < test.steps; i++ {
+			err = z.Step()
+			if err != nil {
+				t.Fatalf("%v: step %v", test.name, err)
+			}
+		}
+
+		if !test.expect(z) {
+			t.Fatalf("%v: failed %v", test.name, z.DumpRegisters())
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		init func(z *CPU)
+		r    byte
+	}{
+		{
+			name: "nop",
+			data: []byte{0x00},
+			r:    0x01,
+		},
+		{
+			name: "wrap",
+			data: []byte{0x00},
+			init: func(z *CPU) { z.r = 0xff },
+			r:    0x80,
+		},
+		{
+			name: "cb prefix",
+			data: []byte{0xcb, 0x00},
+			r:    0x02,
+		},
+		{
+			name: "ed prefix",
+			data: []byte{0xed, 0x44},
+			r:    0x02,
+		},
+		{
+			name: "dd prefix",
+			data: []byte{0xdd, 0x23},
+			r:    0x02,
+		},
+		{
+			name: "ddcb prefix",
+			data: []byte{0xdd, 0xcb, 0x00, 0x06},
+			r:    0x02,
+		},
+		{
+			name: "fd prefix",
+			data: []byte{0xfd, 0x23},
+			r:    0x02,
+		},
+		{
+			name: "dd noni",
+			data: []byte{0xdd, 0x40},
+			r:    0x01,
+		},
+	}
+
+	for _, test := range tests {
+		devices := []bus.Device{
+			{
+				Name:  "RAM",
+				Start: 0x0000,
+				Size:  65536,
+				Type:  bus.DeviceRAM,
+				Image: test.data,
+			},
+		}
+		b, err := bus.New(devices, make(chan string))
+		if err != nil {
+			t.Fatalf("%v: bus %v", test.name, err)
+		}
+
+		z, err := New(ModeZ80, b)
+		if err != nil {
+			t.Fatalf("%v: z80 %v", test.name, err)
+		}
+
+		if test.init != nil {
+			test.init(z)
+		}
+
+		err = z.Step()
+		if err != nil {
+			t.Fatalf("%v: step %v", test.name, err)
+		}
+
+		if z.r != test.r {
+			t.Fatalf("%v: invalid r got $%02x expected $%02x",
+				test.name, z.r, test.r)
+		}
+	}
+}
+
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		init   func(z *CPU)
+		cycles uint64
+	}{
+		{name: "nop", data: []byte{0x00}, cycles: 4},
+		{name: "ld bc,nn", data: []byte{0x01, 0x34, 0x12}, cycles: 10},
+		{
+			name:   "ld (bc),a",
+			data:   []byte{0x02},
+			init:   func(z *CPU) { z.bc = 0x1000 },
+			cycles: 7,
+		},
+		{name: "inc bc", data: []byte{0x03}, cycles: 6},
+		{name: "inc b", data: []byte{0x04}, cycles: 4},
+		{name: "ld b,n", data: []byte{0x06, 0x12}, cycles: 7},
+		{name: "ex af,af'", data: []byte{0x08}, cycles: 4},
+		{name: "add hl,bc", data: []byte{0x09}, cycles: 11},
+		{
+			name:   "djnz taken",
+			data:   []byte{0x10, 0xfe},
+			init:   func(z *CPU) { z.bc = 0x0200 },
+			cycles: 13,
+		},
+		{
+			name:   "djnz not taken",
+			data:   []byte{0x10, 0xfe},
+			init:   func(z *CPU) { z.bc = 0x0100 },
+			cycles: 8,
+		},
+		{name: "jr", data: []byte{0x18, 0x00}, cycles: 12},
+		{name: "jr nz taken", data: []byte{0x20, 0x00}, cycles: 12},
+		{
+			name:   "jr nz not taken",
+			data:   []byte{0x20, 0x00},
+			init:   func(z *CPU) { z.af = zero },
+			cycles: 7,
+		},
+		{name: "ld (nn),hl", data: []byte{0x22, 0x00, 0x10}, cycles: 16},
+		{name: "ld hl,(nn)", data: []byte{0x2a, 0x00, 0x10}, cycles: 16},
+		{name: "ld (nn),a", data: []byte{0x32, 0x00, 0x10}, cycles: 13},
+		{name: "ld a,(nn)", data: []byte{0x3a, 0x00, 0x10}, cycles: 13},
+		{
+			name:   "inc (hl)",
+			data:   []byte{0x34},
+			init:   func(z *CPU) { z.hl = 0x1000 },
+			cycles: 11,
+		},
+		{
+			name:   "ld (hl),n",
+			data:   []byte{0x36, 0x12},
+			init:   func(z *CPU) { z.hl = 0x1000 },
+			cycles: 10,
+		},
+		{name: "ld b,c", data: []byte{0x41}, cycles: 4},
+		{name: "ld b,(hl)", data: []byte{0x46}, cycles: 7},
+		{
+			name:   "ld (hl),b",
+			data:   []byte{0x70},
+			init:   func(z *CPU) { z.hl = 0x1000 },
+			cycles: 7,
+		},
+		{
+			name:   "halt",
+			data:   []byte{0x76},
+			init:   func(z *CPU) { z.iff1 = 1 },
+			cycles: 4,
+		},
+		{name: "add a,b", data: []byte{0x80}, cycles: 4},
+		{name: "add a,(hl)", data: []byte{0x86}, cycles: 7},
+		{name: "ret nz taken", data: []byte{0xc0}, cycles: 11},
+		{
+			name:   "ret nz not taken",
+			data:   []byte{0xc0},
+			init:   func(z *CPU) { z.af = zero },
+			cycles: 5,
+		},
+		{name: "pop bc", data: []byte{0xc1}, cycles: 10},
+		{name: "jp nz,nn taken", data: []byte{0xc2, 0x00, 0x10}, cycles: 10},
+		{
+			name:   "jp nz,nn not taken",
+			data:   []byte{0xc2, 0x00, 0x10},
+			init:   func(z *CPU) { z.af = zero },
+			cycles: 10,
+		},
+		{name: "jp nn", data: []byte{0xc3, 0x00, 0x10}, cycles: 10},
+		{name: "call nz,nn taken", data: []byte{0xc4, 0x00, 0x10}, cycles: 17},
+		{
+			name:   "call nz,nn not taken",
+			data:   []byte{0xc4, 0x00, 0x10},
+			init:   func(z *CPU) { z.af = zero },
+			cycles: 10,
+		},
+		{name: "push bc", data: []byte{0xc5}, cycles: 11},
+		{name: "add a,n", data: []byte{0xc6, 0x12}, cycles: 7},
+		{name: "rst $00", data: []byte{0xc7}, cycles: 11},
+		{name: "ret", data: []byte{0xc9}, cycles: 10},
+		{name: "call nn", data: []byte{0xcd, 0x00, 0x10}, cycles: 17},
+		{name: "out (n),a", data: []byte{0xd3, 0xaa}, cycles: 11},
+		{name: "exx", data: []byte{0xd9}, cycles: 4},
+		{name: "in a,(n)", data: []byte{0xdb, 0xaa}, cycles: 11},
+		{name: "ex (sp),hl", data: []byte{0xe3}, cycles: 19},
+		{name: "jp (hl)", data: []byte{0xe9}, cycles: 4},
+		{name: "ex de,hl", data: []byte{0xeb}, cycles: 4},
+		{name: "di", data: []byte{0xf3}, cycles: 4},
+		{name: "ld sp,hl", data: []byte{0xf9}, cycles: 6},
+
+		// cb
+		{name: "rlc b", data: []byte{0xcb, 0x00}, cycles: 8},
+		{name: "rlc (hl)", data: []byte{0xcb, 0x06}, cycles: 15},
+		{name: "bit 0,b", data: []byte{0xcb, 0x40}, cycles: 8},
+		{name: "bit 0,(hl)", data: []byte{0xcb, 0x46}, cycles: 12},
+		{name: "res 0,(hl)", data: []byte{0xcb, 0x86}, cycles: 15},
+		{name: "set 0,b", data: []byte{0xcb, 0xc0}, cycles: 8},
+		{name: "set 0,(hl)", data: []byte{0xcb, 0xc6}, cycles: 15},
+
+		// dd and fd
+		{name: "add ix,bc", data: []byte{0xdd, 0x09}, cycles: 15},
+		{name: "ld ix,nn", data: []byte{0xdd, 0x21, 0x34, 0x12}, cycles: 14},
+		{name: "ld (nn),ix", data: []byte{0xdd, 0x22, 0x00, 0x10}, cycles: 20},
+		{name: "inc ix", data: []byte{0xdd, 0x23}, cycles: 10},
+		{name: "inc ixh", data: []byte{0xdd, 0x24}, cycles: 8},
+		{name: "ld ixh,n", data: []byte{0xdd, 0x26, 0x12}, cycles: 11},
+		{name: "ld ix,(nn)", data: []byte{0xdd, 0x2a, 0x00, 0x10}, cycles: 20},
+		{
+			name:   "inc (ix+d)",
+			data:   []byte{0xdd, 0x34, 0x01},
+			init:   func(z *CPU) { z.ix = 0x1000 },
+			cycles: 23,
+		},
+		{
+			name:   "ld (ix+d),n",
+			data:   []byte{0xdd, 0x36, 0x01, 0x12},
+			init:   func(z *CPU) { z.ix = 0x1000 },
+			cycles: 19,
+		},
+		{name: "ld b,ixh", data: []byte{0xdd, 0x44}, cycles: 8},
+		{name: "ld b,(ix+d)", data: []byte{0xdd, 0x46, 0x01}, cycles: 19},
+		{
+			name:   "ld (ix+d),b",
+			data:   []byte{0xdd, 0x70, 0x01},
+			init:   func(z *CPU) { z.ix = 0x1000 },
+			cycles: 19,
+		},
+		{name: "add a,ixh", data: []byte{0xdd, 0x84}, cycles: 8},
+		{name: "add a,(ix+d)", data: []byte{0xdd, 0x86, 0x01}, cycles: 19},
+		{name: "pop ix", data: []byte{0xdd, 0xe1}, cycles: 14},
+		{name: "ex (sp),ix", data: []byte{0xdd, 0xe3}, cycles: 23},
+		{name: "push ix", data: []byte{0xdd, 0xe5}, cycles: 15},
+		{name: "jp (ix)", data: []byte{0xdd, 0xe9}, cycles: 8},
+		{name: "ld sp,ix", data: []byte{0xdd, 0xf9}, cycles: 10},
+		{name: "dd nop", data: []byte{0xdd, 0x00}, cycles: 4},
+		{name: "ld iy,nn", data: []byte{0xfd, 0x21, 0x34, 0x12}, cycles: 14},
+		{name: "ex (sp),iy", data: []byte{0xfd, 0xe3}, cycles: 23},
+		{name: "jp (iy)", data: []byte{0xfd, 0xe9}, cycles: 8},
+
+		// dd cb and fd cb
+		{
+			name:   "rlc (ix+d)",
+			data:   []byte{0xdd, 0xcb, 0x01, 0x06},
+			init:   func(z *CPU) { z.ix = 0x1000 },
+			cycles: 23,
+		},
+		{name: "bit 0,(ix+d)", data: []byte{0xdd, 0xcb, 0x01, 0x46}, cycles: 20},
+		{
+			name:   "set 0,(ix+d)",
+			data:   []byte{0xdd, 0xcb, 0x01, 0xc6},
+			init:   func(z *CPU) { z.ix = 0x1000 },
+			cycles: 23,
+		},
+		{
+			name:   "res 0,(iy+d)",
+			data:   []byte{0xfd, 0xcb, 0x01, 0x86},
+			init:   func(z *CPU) { z.iy = 0x1000 },
+			cycles: 23,
+		},
+		{name: "bit 7,(iy+d)", data: []byte{0xfd, 0xcb, 0x01, 0x7e}, cycles: 20},
+
+		// ed
+		{
+			name:   "in b,(c)",
+			data:   []byte{0xed, 0x40},
+			init:   func(z *CPU) { z.bc = 0x00aa },
+			cycles: 12,
+		},
+		{
+			name:   "out (c),b",
+			data:   []byte{0xed, 0x41},
+			init:   func(z *CPU) { z.bc = 0x00aa },
+			cycles: 12,
+		},
+		{name: "sbc hl,bc", data: []byte{0xed, 0x42}, cycles: 15},
+		{name: "ld (nn),bc", data: []byte{0xed, 0x43, 0x00, 0x10}, cycles: 20},
+		{name: "neg", data: []byte{0xed, 0x44}, cycles: 8},
+		{name: "neg ed 4c", data: []byte{0xed, 0x4c}, cycles: 8},
+		{name: "retn", data: []byte{0xed, 0x45}, cycles: 14},
+		{name: "im 0", data: []byte{0xed, 0x46}, cycles: 8},
+		{name: "ld i,a", data: []byte{0xed, 0x47}, cycles: 9},
+		{name: "adc hl,bc", data: []byte{0xed, 0x4a}, cycles: 15},
+		{name: "ld bc,(nn)", data: []byte{0xed, 0x4b, 0x00, 0x10}, cycles: 20},
+		{name: "reti", data: []byte{0xed, 0x4d}, cycles: 14},
+		{name: "ld a,r", data: []byte{0xed, 0x5f}, cycles: 9},
+		{
+			name:   "rrd",
+			data:   []byte{0xed, 0x67},
+			init:   func(z *CPU) { z.hl = 0x1000 },
+			cycles: 18,
+		},
+		{
+			name:   "ldi",
+			data:   []byte{0xed, 0xa0},
+			init:   func(z *CPU) { z.de = 0x1000; z.bc = 0x0002 },
+			cycles: 16,
+		},
+		{name: "cpi", data: []byte{0xed, 0xa1}, cycles: 16},
+		{
+			name:   "outi",
+			data:   []byte{0xed, 0xa3},
+			init:   func(z *CPU) { z.bc = 0x02aa },
+			cycles: 16,
+		},
+		{
+			name:   "ldir repeat",
+			data:   []byte{0xed, 0xb0},
+			init:   func(z *CPU) { z.de = 0x1000; z.bc = 0x0002 },
+			cycles: 21,
+		},
+		{
+			name:   "ldir last",
+			data:   []byte{0xed, 0xb0},
+			init:   func(z *CPU) { z.de = 0x1000; z.bc = 0x0001 },
+			cycles: 16,
+		},
+		{
+			name:   "cpir repeat",
+			data:   []byte{0xed, 0xb1},
+			init:   func(z *CPU) { z.af = 0x5500; z.bc = 0x0002 },
+			cycles: 21,
+		},
+		{
+			name:   "cpir match",
+			data:   []byte{0xed, 0xb1},
+			init:   func(z *CPU) { z.af = 0xed00; z.bc = 0x0002 },
+			cycles: 16,
+		},
+		{
+			name:   "inir repeat",
+			data:   []byte{0xed, 0xb2},
+			init:   func(z *CPU) { z.bc = 0x02aa; z.hl = 0x1000 },
+			cycles: 21,
+		},
+		{
+			name:   "otir last",
+			data:   []byte{0xed, 0xb3},
+			init:   func(z *CPU) { z.bc = 0x01aa },
+			cycles: 16,
+		},
+		{
+			name:   "lddr repeat",
+			data:   []byte{0xed, 0xb8},
+			init:   func(z *CPU) { z.de = 0x1000; z.bc = 0x0002 },
+			cycles: 21,
+		},
+		{
+			name:   "cpdr last",
+			data:   []byte{0xed, 0xb9},
+			init:   func(z *CPU) { z.af = 0x5500; z.bc = 0x0001 },
+			cycles: 16,
+		},
+	}
+
+	for _, test := range tests {
+		devices := []bus.Device{
+			{
+				Name:  "RAM",
+				Start: 0x0000,
+				Size:  65536,
+				Type:  bus.DeviceRAM,
+				Image: test.data,
+			},
+			{
+				Name:       "Dummy",
+				Start:      0xaa,
+				Size:       1,
+				Type:       bus.DevicePeripheral,
+				Peripheral: "dummy",
+			},
+		}
+		b, err := bus.New(devices, make(chan string))
+		if err != nil {
+			t.Fatalf("%v: bus %v", test.name, err)
+		}
+
+		z, err := New(ModeZ80, b)
+		if err != nil {
+			t.Fatalf("%v: z80 %v", test.name, err)
+		}
+
+		if test.init != nil {
+			test.init(z)
+		}
+
+		err = z.Step()
+		if err != nil {
+			t.Fatalf("%v: step %v", test.name, err)
+		}
+
+		if z.totalCycles != test.cycles {
+			t.Fatalf("%v: invalid cycles got %v expected %v",
+				test.name, z.totalCycles, test.cycles)
+		}
+	}
+}
+
+func TestInstructions8080(t *testing.T) {
+	tests := []struct {
+		name   string
+		mn     string
+		dst    string
+		src    string
+		data   []byte
+		init   func(z *CPU)
+		expect func(z *CPU) bool
+		cycles uint64
+		err    error
+	}{
+		{
+			name:   "ex af,af' is nop",
+			mn:     "nop",
+			data:   []byte{0x08},
+			init:   func(z *CPU) { z.af = 0x1234; z.af_ = 0x5678 },
+			expect: func(z *CPU) bool { return z.pc == 0x0001 && z.af == 0x1234 },
+			cycles: 4,
+		},
+		{
+			name:   "djnz is nop",
+			mn:     "nop",
+			data:   []byte{0x10, 0x10},
+			init:   func(z *CPU) { z.bc = 0x0200 },
+			expect: func(z *CPU) bool { return z.pc == 0x0001 && z.bc == 0x0200 },
+			cycles: 4,
+		},
+		{
+			name:   "cb is jmp",
+			mn:     "jmp",
+			dst:    "$1234",
+			data:   []byte{0xcb, 0x34, 0x12},
+			expect: func(z *CPU) bool { return z.pc == 0x1234 },
+			cycles: 10,
+		},
+		{
+			name: "exx is ret",
+			mn:   "ret",
+			data: []byte{0xd9},
+			init: func(z *CPU) {
+				z.sp = 0xaa55
+				z.bus.Write(0xaa55, 0xff)
+				z.bus.Write(0xaa56, 0xee)
+			},
+			expect: func(z *CPU) bool {
+				return z.pc == 0xeeff && z.sp == 0xaa57
+			},
+			cycles: 10,
+		},
+		{
+			name: "dd is call",
+			mn:   "call",
+			dst:  "$1234",
+			data: []byte{0xdd, 0x34, 0x12},
+			init: func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.pc == 0x1234 && z.sp == 0x5564 &&
+					z.bus.Read(0x5564) == 0x03
+			},
+			cycles: 17,
+		},
+		{
+			name:   "ed is call",
+			mn:     "call",
+			dst:    "$1234",
+			data:   []byte{0xed, 0x34, 0x12},
+			init:   func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool { return z.pc == 0x1234 },
+			cycles: 17,
+		},
+		{
+			name:   "fd is call",
+			mn:     "call",
+			dst:    "$1234",
+			data:   []byte{0xfd, 0x34, 0x12},
+			init:   func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool { return z.pc == 0x1234 },
+			cycles: 17,
+		},
+		{
+			name:   "mov a,b",
+			mn:     "mov",
+			dst:    "a",
+			src:    "b",
+			data:   []byte{0x78},
+			init:   func(z *CPU) { z.bc = 0x1200 },
+			expect: func(z *CPU) bool { return z.af == 0x1200 },
+			cycles: 5,
+		},
+		{
+			name: "mov a,m",
+			mn:   "mov",
+			dst:  "a",
+			src:  "m",
+			data: []byte{0x7e},
+			init: func(z *CPU) {
+				z.hl = 0x1000
+				z.bus.Write(0x1000, 0x34)
+			},
+			expect: func(z *CPU) bool { return z.af == 0x3400 },
+			cycles: 7,
+		},
+		{
+			name:   "mvi m,n",
+			mn:     "mvi",
+			dst:    "m",
+			src:    "$55",
+			data:   []byte{0x36, 0x55},
+			init:   func(z *CPU) { z.hl = 0x1000 },
+			expect: func(z *CPU) bool { return z.bus.Read(0x1000) == 0x55 },
+			cycles: 10,
+		},
+		{
+			name:   "lda nn",
+			mn:     "lda",
+			src:    "$1234",
+			data:   []byte{0x3a, 0x34, 0x12},
+			init:   func(z *CPU) { z.bus.Write(0x1234, 0x56) },
+			expect: func(z *CPU) bool { return z.af == 0x5600 },
+			cycles: 13,
+		},
+		{
+			name:   "add b parity",
+			mn:     "add",
+			dst:    "b",
+			data:   []byte{0x80},
+			init:   func(z *CPU) { z.af = 0x7f00; z.bc = 0x0100 },
+			expect: func(z *CPU) bool { return z.af == 0x8092 },
+			cycles: 4,
+		},
+		{
+			name:   "sub b borrow",
+			mn:     "sub",
+			dst:    "b",
+			data:   []byte{0x90},
+			init:   func(z *CPU) { z.af = 0x0000; z.bc = 0x0100 },
+			expect: func(z *CPU) bool { return z.af == 0xff87 },
+			cycles: 4,
+		},
+		{
+			name:   "sub b half carry",
+			mn:     "sub",
+			dst:    "b",
+			data:   []byte{0x90},
+			init:   func(z *CPU) { z.af = 0x1200; z.bc = 0x0100 },
+			expect: func(z *CPU) bool { return z.af == 0x1116 },
+			cycles: 4,
+		},
+		{
+			name:   "ana b",
+			mn:     "ana",
+			dst:    "b",
+			data:   []byte{0xa0},
+			init:   func(z *CPU) { z.af = 0x08ff; z.bc = 0x0100 },
+			expect: func(z *CPU) bool { return z.af == 0x0056 },
+			cycles: 4,
+		},
+		{
+			name:   "inr b",
+			mn:     "inr",
+			dst:    "b",
+			data:   []byte{0x04},
+			init:   func(z *CPU) { z.af = 0x0001; z.bc = 0x0f00 },
+			expect: func(z *CPU) bool { return z.bc == 0x1000 && z.af == 0x0013 },
+			cycles: 5,
+		},
+		{
+			name:   "dcr b",
+			mn:     "dcr",
+			dst:    "b",
+			data:   []byte{0x05},
+			init:   func(z *CPU) { z.bc = 0x1000 },
+			expect: func(z *CPU) bool { return z.bc == 0x0f00 && z.af == 0x0006 },
+			cycles: 5,
+		},
+		{
+			name:   "dcr m",
+			mn:     "dcr",
+			dst:    "m",
+			data:   []byte{0x35},
+			init:   func(z *CPU) { z.hl = 0x1000; z.bus.Write(0x1000, 0x01) },
+			expect: func(z *CPU) bool { return z.bus.Read(0x1000) == 0x00 && z.af == 0x0056 },
+			cycles: 10,
+		},
+		{
+			name:   "daa",
+			mn:     "daa",
+			data:   []byte{0x27},
+			init:   func(z *CPU) { z.af = 0x9b02 },
+			expect: func(z *CPU) bool { return z.af == 0x0113 },
+			cycles: 4,
+		},
+		{
+			name:   "rlc",
+			mn:     "rlc",
+			data:   []byte{0x07},
+			init:   func(z *CPU) { z.af = 0x80c4 },
+			expect: func(z *CPU) bool { return z.af == 0x01c5 },
+			cycles: 4,
+		},
+		{
+			name:   "dad b",
+			mn:     "dad",
+			dst:    "b",
+			data:   []byte{0x09},
+			init:   func(z *CPU) { z.af = 0x00c4; z.bc = 0x0001; z.hl = 0xffff },
+			expect: func(z *CPU) bool { return z.hl == 0x0000 && z.af == 0x00c5 },
+			cycles: 10,
+		},
+		{
+			name: "push psw",
+			mn:   "push",
+			dst:  "psw",
+			data: []byte{0xf5},
+			init: func(z *CPU) { z.af = 0x12ff; z.sp = 0x5566 },
+			expect: func(z *CPU) bool {
+				return z.sp == 0x5564 && z.bus.Read(0x5564) == 0xd7 &&
+					z.bus.Read(0x5565) == 0x12
+			},
+			cycles: 11,
+		},
+		{
+			name: "pop psw",
+			mn:   "pop",
+			dst:  "psw",
+			data: []byte{0xf1},
+			init: func(z *CPU) {
+				z.sp = 0x5564
+				z.bus.Write(0x5564, 0x00)
+				z.bus.Write(0x5565, 0x34)
+			},
+			expect: func(z *CPU) bool { return z.af == 0x3402 && z.sp == 0x5566 },
+			cycles: 10,
+		},
+		{
+			name: "rnz taken",
+			mn:   "rnz",
+			data: []byte{0xc0},
+			init: func(z *CPU) {
+				z.sp = 0xaa55
+				z.bus.Write(0xaa55, 0xff)
+				z.bus.Write(0xaa56, 0xee)
+			},
+			expect: func(z *CPU) bool { return z.pc == 0xeeff },
+			cycles: 11,
+		},
+		{
+			name:   "rnz not taken",
+			mn:     "rnz",
+			data:   []byte{0xc0},
+			init:   func(z *CPU) { z.af = uint16(FLAG_Z) },
+			expect: func(z *CPU) bool { return z.pc == 0x0001 },
+			cycles: 5,
+		},
+		{
+			name:   "cpo taken",
+			mn:     "cpo",
+			src:    "$1234",
+			data:   []byte{0xe4, 0x34, 0x12},
+			init:   func(z *CPU) { z.sp = 0x5566 },
+			expect: func(z *CPU) bool { return z.pc == 0x1234 && z.sp == 0x5564 },
+			cycles: 17,
+		},
+		{
+			name:   "cpo not taken",
+			mn:     "cpo",
+			src:    "$1234",
+			data:   []byte{0xe4, 0x34, 0x12},
+			init:   func(z *CPU) { z.af = uint16(FLAG_P); z.sp = 0x5566 },
+			expect: func(z *CPU) bool { return z.pc == 0x0003 && z.sp == 0x5566 },
+			cycles: 11,
+		},
+		{
+			name: "xthl",
+			mn:   "xthl",
+			data: []byte{0xe3},
+			init: func(z *CPU) {
+				z.hl = 0x1234
+				z.sp = 0x5564
+				z.bus.Write(0x5564, 0x78)
+				z.bus.Write(0x5565, 0x56)
+			},
+			expect: func(z *CPU) bool {
+				return z.hl == 0x5678 && z.bus.Read(0x5564) == 0x34 &&
+					z.bus.Read(0x5565) == 0x12
+			},
+			cycles: 18,
+		},
+		{
+			name:   "hlt",
+			mn:     "hlt",
+			data:   []byte{0x76},
+			expect: func(z *CPU) bool { return z.pc == 0x0000 && z.halted },
+			cycles: 7,
+			err:    HaltError{PC: 0x0000},
+		},
+	}
+
+	for _, test := range tests {
+		devices := []bus.Device{
+			{
+				Name:  "RAM",
+				Start: 0x0000,
+				Size:  65536,
+				Type:  bus.DeviceRAM,
+				Image: test.data,
+			},
+		}
+		b, err := bus.New(devices, make(chan string))
+		if err != nil {
+			t.Fatalf("%v: bus %v", test.name, err)
+		}
+
+		z, err := New(Mode8080, b)
+		if err != nil {
+			t.Fatalf("%v: z80 %v", test.name, err)
+		}
+
+		if test.init != nil {
+			test.init(z)
+		}
+
+		err = z.Step()
+		if err != test.err {
+			t.Fatalf("%v: step %v", test.name, err)
+		}
+
+		mn, dst, src, _, _, err := z.DisassembleComponents(0)
+		if err != nil {
+			t.Fatalf("%v: disassemble %v", test.name, err)
+		}
+		if mn != test.mn || dst != test.dst || src != test.src {
+			t.Fatalf("%v: invalid disassembly got %v %v,%v expected "+
+				"%v %v,%v", test.name, mn, dst, src, test.mn,
+				test.dst, test.src)
+		}
+
+		if !test.expect(z) {
+			t.Fatalf("%v: failed %v", test.name, z.DumpRegisters())
+		}
+
+		if z.totalCycles != test.cycles {
+			t.Fatalf("%v: invalid cycles got %v expected %v",
+				test.name, z.totalCycles, test.cycles)
+		}
+	}
+
+	// Every byte is a valid 8080 instruction.
+	for o := range opcodes8080 {
+		if len(opcodes8080[o].mnemonic) != 2 ||
+			opcodes8080[o].mnemonic[Mode8080] == "" {
+			t.Fatalf("no 8080 instruction: 0x%02x", o)
+		}
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	devices := []bus.Device{
+		{
+			Name:  "RAM",
+			Start: 0x0000,
+			Size:  65536,
+			Type:  bus.DeviceRAM,
+			Image: []byte{0x00, 0x78}, // nop; ld a,b
+		},
+	}
+	b, err := bus.New(devices, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := New(ModeZ80, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Registers{
+		AF: 0x0102, AF_: 0x0304, BC: 0x0506, BC_: 0x0708,
+		DE: 0x090a, DE_: 0x0b0c, HL: 0x0d0e, HL_: 0x0f10,
+		IX: 0x1112, IY: 0x1314, PC: 0x0001, SP: 0x1516,
+		IFF1: 1, IFF2: 1, IM: 2, I: 0x17, R: 0x18,
+		MEMPTR: 0x191a, Halted: true, Cycles: 1000,
+	}
+	z.SetRegisters(r)
+	if got := z.GetRegisters(); !reflect.DeepEqual(got, r) {
+		t.Fatalf("got %+v expected %+v", got, r)
+	}
+
+	// Resume at pc.
+	z.SetPC(0x0001)
+	err = z.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AF = 0x0502
+	r.PC = 0x0002
+	r.R = 0x19
+	r.Halted = false
+	r.Cycles += 4
+	if got := z.GetRegisters(); !reflect.DeepEqual(got, r) {
+		t.Fatalf("got %+v expected %+v", got, r)
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	devices := []bus.Device{
+		{
+			Name:  "RAM",
+			Start: 0x0000,
+			Size:  65536,
+			Type:  bus.DeviceRAM,
+			// ld a,$42; out ($10),a; ld ($1000),a; ei
+			Image: []byte{0x3e, 0x42, 0xd3, 0x10, 0x32, 0x00, 0x10,
+				0xfb},
+		},
+		{
+			Name:       "dummy",
+			Start:      0x10,
+			Type:       bus.DevicePeripheral,
+			Peripheral: "dummy",
+		},
+	}
+	newMachine := func() *CPU {
+		b, err := bus.New(devices, make(chan string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := New(ModeZ80, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return z
+	}
+
+	z := newMachine()
+	for i := 0; i < 4; i++ {
+		err := z.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	z.mode = Mode8080
+	var buf bytes.Buffer
+	err := z.Save(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	devices[0].Image = nil
+	r := newMachine()
+	err = r.Restore(bytes.NewReader(saved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, expected := r.GetRegisters(), z.GetRegisters()
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %+v expected %+v", got, expected)
+	}
+	if !r.eiDelay || r.mode != Mode8080 {
+		t.Fatalf("eiDelay %v mode %v", r.eiDelay, r.mode)
+	}
+	if x := r.bus.Read(0x1000); x != 0x42 {
+		t.Fatalf("memory: got %02x expected 42", x)
+	}
+	if x := r.bus.IORead(0x10); x != 0x42 {
+		t.Fatalf("device: got %02x expected 42", x)
+	}
+
+	// Damaged and unsupported states are rejected.
+	err = r.Restore(bytes.NewReader(saved[:len(saved)/2]))
+	if err != ErrInvalidState {
+		t.Fatalf("truncated: got %v expected %v", err, ErrInvalidState)
+	}
+	for _, version := range []int{StateVersion - 1, StateVersion + 1} {
+		buf.Reset()
+		enc := gob.NewEncoder(&buf)
+		err = enc.Encode(stateHeader{
+			Magic:   stateMagic,
+			Version: version,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = enc.Encode(machineState{
+			Registers: z.GetRegisters(),
+			Bus:       z.bus.SaveState(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = r.Restore(&buf)
+		if err != ErrStateVersion {
+			t.Fatalf("version %v: got %v expected %v", version,
+				err, ErrStateVersion)
+		}
+	}
+}
+
+func TestStepErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		init     func(z *CPU)
+		expected error
+	}{
+		{
+			name: "invalid opcode",
+			data: []byte{0xed, 0x00},
+			expected: InvalidOpcodeError{
+				PC:    0x0000,
+				Bytes: []byte{0xed, 0x00},
+			},
+		},
+		{
+			name: "invalid im 0 instruction",
+			data: []byte{0x00},
+			init: func(z *CPU) {
+				z.iff1 = 1
+				z.bus.RaiseInterrupt(0xed)
+			},
+			expected: InvalidOpcodeError{
+				PC:    0x0000,
+				Bytes: []byte{0xed, 0xff},
+			},
+		},
+		{
+			name: "read unmapped",
+			data: []byte{0x3a, 0x00, 0x80}, // ld a,($8000)
+			expected: bus.BusFaultError{
+				PC:      0x0000,
+				Address: 0x8000,
+				Access:  bus.AccessRead,
+			},
+		},
+		{
+			name: "write rom",
+			data: []byte{0x00, 0x32, 0x00, 0x10}, // nop; ld ($1000),a
+			init: func(z *CPU) { z.pc = 0x0001 },
+			expected: bus.BusFaultError{
+				PC:      0x0001,
+				Address: 0x1000,
+				Access:  bus.AccessWrite,
+			},
+		},
+		{
+			name: "unmapped port",
+			data: []byte{0xdb, 0x20}, // in a,($20)
+			init: func(z *CPU) { z.af = 0x1200 },
+			expected: bus.UnmappedIOError{
+				PC:     0x0000,
+				Port:   0x1220,
+				Access: bus.AccessRead,
+			},
+		},
+		{
+			name: "watchpoint",
+			data: []byte{0x00, 0x32, 0x10, 0x20}, // nop; ld ($2010),a
+			init: func(z *CPU) {
+				z.pc = 0x0001
+				z.af = 0x5500
+				z.bus.AddWatchpoint(bus.Watchpoint{
+					Start: 0x2000,
+					End:   0x20ff,
+					Write: true,
+				})
+			},
+			expected: bus.WatchpointError{
+				PC: 0x0001,
+				Watchpoint: bus.Watchpoint{
+					ID:    1,
+					Start: 0x2000,
+					End:   0x20ff,
+					Write: true,
+				},
+				Address: 0x2010,
+				Access:  bus.AccessWrite,
+				Value:   0x55,
+			},
+		},
+		{
+			name: "8080 nop at end of memory",
+			init: func(z *CPU) {
+				z.mode = Mode8080
+				z.pc = 0x3fff
+			},
+		},
+		{
+			name: "8080 mvi at end of memory",
+			init: func(z *CPU) {
+				z.mode = Mode8080
+				z.pc = 0x3ffe
+				z.bus.Write(0x3ffe, 0x3e) // mvi a,$00
+			},
+		},
+		{
+			name: "8080 nop before watchpoint",
+			init: func(z *CPU) {
+				z.mode = Mode8080
+				z.pc = 0x2000
+				z.bus.AddWatchpoint(bus.Watchpoint{
+					Start: 0x2002,
+					End:   0x2002,
+					Read:  true,
+				})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		devices := []bus.Device{
+			{
+				Name:  "ROM",
+				Start: 0x0000,
+				Size:  0x2000,
+				Type:  bus.DeviceROM,
+				Image: test.data,
+			},
+			{
+				Name:  "RAM",
+				Start: 0x2000,
+				Size:  0x2000,
+				Type:  bus.DeviceRAM,
+			},
+		}
+		b, err := bus.New(devices, make(chan string))
+		if err != nil {
+			t.Fatalf("%v: bus %v", test.name, err)
+		}
+
+		z, err := New(ModeZ80, b)
+		if err != nil {
+			t.Fatalf("%v: z80 %v", test.name, err)
+		}
+
+		if test.init != nil {
+			test.init(z)
+		}
+
+		err = z.Step()
+		if !reflect.DeepEqual(err, test.expected) {
+			t.Fatalf("%v: got %v expected %v", test.name, err,
+				test.expected)
+		}
+	}
+}
+
+// mapSymbolizer names the addresses in the map.
+type mapSymbolizer map[uint16]string
+
+func (m mapSymbolizer) Name(address uint16) (string, bool) {
+	name, ok := m[address]
+	return name, ok
+}
+
+func TestDisassembleSymbols(t *testing.T) {
+	devices := []bus.Device{
+		{
+			Name:  "RAM",
+			Start: 0x0000,
+			Size:  65536,
+			Type:  bus.DeviceRAM,
+			Image: []byte{
+				0xcd, 0x00, 0x01, // call $0100
+				0x3a, 0x00, 0x80, // ld a,($8000)
+				0x18, 0xf8, // jr $0000
+				0x21, 0x34, 0x12, // ld hl,$1234
+			},
+		},
+	}
+	b, err := bus.New(devices, make(chan string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := New(ModeZ80, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetSymbolizer(mapSymbolizer{
+		0x0000: "start",
+		0x0100: "main",
+		0x8000: "buffer",
+	})
+
+	tests := []struct {
+		mode     CPUMode
+		address  uint16
+		expected string
+	}{
+		{ModeZ80, 0x0000, "call  main"},
+		{ModeZ80, 0x0003, "ld    a,(buffer)"},
+		{ModeZ80, 0x0006, "jr    start"},
+		{ModeZ80, 0x0008, "ld    hl,$1234"},
+		{Mode8080, 0x0003, "lda   buffer"},
+	}
+	for _, test := range tests {
+		z.mode = test.mode
+		s, _, _, err := z.Disassemble(test.address, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s = strings.TrimSpace(s); s != test.expected {
+			t.Fatalf("%04x: got %q expected %q", test.address, s,
+				test.expected)
+		}
+	}
+
+	z.SetSymbolizer(nil)
+	s, _, _, _ := z.Disassemble(0x0000, false)
+	if s = strings.TrimSpace(s); s != "call  $0100" {
+		t.Fatalf("got %q expected %q", s, "call  $0100")
+	}
+}
@@ -2,13 +2,16 @@ package z80
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/marcopeereboom/toyz80/bus"
 )
 
-func newZ80(imageName string) (*z80, chan string, error) {
+func newZ80(imageName string, mode CPUMode) (*CPU, chan string, error) {
 	// Just a bunch of RAM.
 	devices := []bus.Device{
 		{
@@ -25,7 +28,7 @@ func newZ80(imageName string) (*z80, chan string, error) {
 		return nil, nil, err
 	}
 
-	z, err := New(ModeZ80, bus)
+	z, err := New(mode, bus)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -40,8 +43,9 @@ func newZ80(imageName string) (*z80, chan string, error) {
 		return nil, nil, err
 	}
 
-	// Patch memory, halt when 0x0000 is called.
-	z.bus.WriteMemory(0, []byte{0x76 /* halt */})
+	// Patch memory, halt when 0x0000 is called.  The exercisers leave
+	// interrupts enabled so disable them or halt waits forever.
+	z.bus.WriteMemory(0, []byte{0xf3 /* di */, 0x76 /* halt */})
 
 	// Patch memory, print string in callback and return to caller.
 	z.bus.WriteMemory(5, []byte{0xc9 /* ret */})
@@ -49,14 +53,14 @@ func newZ80(imageName string) (*z80, chan string, error) {
 	return z, shutdown, nil
 }
 
-func (z *z80) printChar() error {
+func (z *CPU) printChar(w io.Writer) error {
 	// Emulate CP/M call 5; function is in register C.
 	//
 	// Function 2: print char in register E
 	// Function 9: print $ terminated string pointer in DE
 	switch byte(z.bc) {
 	case 2:
-		fmt.Printf("%c", byte(z.de))
+		fmt.Fprintf(w, "%c", byte(z.de))
 	case 9:
 		// Just panic if we overflow
 		for addr := z.de; ; addr++ {
@@ -64,20 +68,24 @@ func (z *z80) printChar() error {
 			if ch == '$' {
 				break
 			}
-			fmt.Printf("%c", ch)
+			fmt.Fprintf(w, "%c", ch)
 		}
 	}
 
 	return nil
 }
 
-func TestZexDoc(t *testing.T) {
-	z, _, err := newZ80("zex/zexdoc.com")
+// zex runs a zex image until it halts and fails the test if any of the
+// instruction groups reported an error.
+func zex(t *testing.T, imageName string, mode CPUMode) {
+	z, _, err := newZ80(imageName, mode)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	z.SetBreakPoint(0x5, z.printChar)
+	var output strings.Builder
+	w := io.MultiWriter(os.Stdout, &output)
+	z.SetBreakPoint(0x5, func() error { return z.printChar(w) })
 	z.SetPC(0x100)
 	for {
 		err = z.Step()
@@ -89,6 +97,9 @@ func TestZexDoc(t *testing.T) {
 					t.Fatal(err2)
 				}
 			case HaltError:
+				if strings.Contains(output.String(), "ERROR") {
+					t.Fatalf("%v failed", imageName)
+				}
 				return
 			default:
 				t.Fatal(err)
@@ -96,3 +107,22 @@ func TestZexDoc(t *testing.T) {
 		}
 	}
 }
+
+func TestZexDoc(t *testing.T) {
+	zex(t, "zex/zexdoc.com", ModeZ80)
+}
+
+func TestZexAll(t *testing.T) {
+	zex(t, "zex/zexall.com", ModeZ80)
+}
+
+// TestEx8080 runs the 8080 instruction exerciser.  The image is not
+// distributed with toyz80; download 8080EXM.COM and copy it to
+// zex/8080exm.com to run this test.
+func TestEx8080(t *testing.T) {
+	imageName := "zex/8080exm.com"
+	if _, err := os.Stat(imageName); os.IsNotExist(err) {
+		t.Skipf("%v not found", imageName)
+	}
+	zex(t, imageName, Mode8080)
+}